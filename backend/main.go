@@ -2,28 +2,53 @@ package main
 
 import (
 	"context"
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/alexliesenfeld/health"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/exaring/otelpgx"
 	"github.com/gin-gonic/gin"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/johngerving/kubernetes-web-client/backend/pkg/api"
 	"github.com/johngerving/kubernetes-web-client/backend/pkg/controller"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/database"
 	"github.com/johngerving/kubernetes-web-client/backend/pkg/database/repository"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/events"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/jobs"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/leader"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/logging"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/metrics"
 	"github.com/johngerving/kubernetes-web-client/backend/pkg/oauth"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/pubsub"
 	"github.com/johngerving/kubernetes-web-client/backend/pkg/session"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/tracing"
 	_ "github.com/joho/godotenv/autoload"
 )
 
+// Number of workspace events kept for clients resuming an event stream.
+const eventHistorySize = 256
+
+// Time finished jobs are kept for clients to poll.
+const jobRetention = 7 * 24 * time.Hour
+
+// Name of the lock replicas compete for to run background workers.
+const leaderElectionName = "kubernetes-web-client-leader"
+
 func main() {
 	// Get server config
 	serverCfg, err := api.NewConfigFromEnv()
 	if err != nil {
-		log.Fatal(err)
+		fatal("error loading server config", err)
 	}
 
+	// Log JSON lines, including those of libraries using the log package
+	slog.SetDefault(logging.New(os.Stdout, serverCfg.LogLevel))
+
 	// Set Gin mode to release if in production environment
 	if serverCfg.Environment == "production" {
 		gin.SetMode(gin.ReleaseMode)
@@ -31,34 +56,118 @@ func main() {
 		gin.SetMode(gin.DebugMode)
 	}
 
+	// Trace requests through the database and the cluster
+	shutdownTracing, err := tracing.NewProviderFromEnv(context.Background())
+	if err != nil {
+		fatal("error setting up tracing", err)
+	}
+
 	// Get OAuth config and OIDC provider
 	oauth, provider, err := oauth.NewConfigAndProviderFromEnv()
 	if err != nil {
-		log.Fatal(err)
+		fatal("error loading OAuth config", err)
 	}
 
 	// Get cluster Controller
 	controller, err := controller.NewControllerFromEnv()
 	if err != nil {
-		log.Fatal(err)
+		fatal("error creating cluster controller", err)
 	}
+	controller = metrics.InstrumentController(controller)
 
 	// Initialize database connection
 	dbUrl := os.Getenv("DB_URL")
 	if dbUrl == "" {
-		log.Fatalf("Error: Database URL must be specified")
+		fatal("error connecting to database", errors.New("database URL must be specified"))
+	}
+	poolCfg, err := pgxpool.ParseConfig(dbUrl)
+	if err != nil {
+		fatal("error connecting to database", err)
 	}
-	pool, err := pgxpool.New(context.Background(), dbUrl)
+	poolCfg.ConnConfig.Tracer = otelpgx.NewTracer()
+	pool, err := pgxpool.NewWithConfig(context.Background(), poolCfg)
 	if err != nil {
-		log.Fatalf("Failed to initialize database connection: %v", err)
+		fatal("error connecting to database", err)
 	}
 	defer pool.Close() // Close connection when done
 
 	sessionStore := session.NewStore(pool) // New session store
 	repository := repository.New(pool)     // New database repository
 
-	// Set up a health check for the server
-	healthChecker := health.NewChecker(
+	// Send messages between replicas
+	bus := pubsub.NewPostgres(pool)
+	go bus.Run(context.Background())
+
+	// Elect a replica to run background workers
+	elector, err := newLeaderElector(pool, controller)
+	if err != nil {
+		fatal("error creating leader elector", err)
+	}
+	leaders := leader.NewManager(elector)
+	leaders.Register("pubsub-payload-cleanup", bus.RemoveExpiredPayloads)
+
+	// Queue long-running cluster operations
+	queue := jobs.NewQueue(repository)
+	leaders.Register("job-cleanup", queue.RemoveFinished(jobRetention))
+
+	// Share workspace events between replicas
+	broker := events.NewBroker(eventHistorySize)
+	broker.SetNotifier(events.NewBusNotifier(bus, broker))
+
+	metrics.Registry.MustRegister(
+		metrics.NewPoolCollector(pool),
+		metrics.NewSessionCollector(repository),
+		metrics.NewWorkspaceCollector(broker.StatusCounts),
+	)
+
+	// Restart the server only if it stops responding, but take it out of
+	// service while it can't reach what it depends on
+	liveChecker := health.NewChecker(health.WithCacheDuration(1 * time.Second))
+	readyChecker := newReadyChecker(pool, repository, controller, provider)
+
+	// Create the server
+	srv, err := api.NewServer(serverCfg, oauth, provider, sessionStore, pool, repository, liveChecker, readyChecker, controller, broker, leaders, queue)
+	if err != nil {
+		fatal("error creating server", err)
+	}
+
+	// Create main server registry
+	registry := api.MainServerRegistry{}
+
+	// Listen on the server, using the main server registry
+	srv.ListenAndServe(registry)
+
+	// Export the spans of the last requests
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(ctx); err != nil {
+		slog.Error("error flushing traces", "err", err)
+	}
+}
+
+// newLeaderElector returns the Elector chosen by the LEADER_ELECTION
+// environment variable: a Postgres advisory lock ("postgres", the default)
+// or a Kubernetes Lease ("kubernetes").
+func newLeaderElector(pool *pgxpool.Pool, controller controller.Controller) (leader.Elector, error) {
+	switch strings.ToLower(os.Getenv("LEADER_ELECTION")) {
+	case "", "postgres":
+		return leader.NewPostgresElector(pool, leaderElectionName), nil
+	case "kubernetes":
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("unable to get replica identity: %v", err)
+		}
+		return controller.LeaderElector(leaderElectionName, hostname), nil
+	}
+
+	return nil, fmt.Errorf("leader election must be postgres or kubernetes")
+}
+
+// newReadyChecker returns a health.Checker reporting whether the server can
+// reach the database, the cluster and the OIDC provider, and whether the
+// database schema is recent enough.
+func newReadyChecker(pool *pgxpool.Pool, repo *repository.Queries, cluster controller.Controller, provider *oidc.Provider) health.Checker {
+	return health.NewChecker(
 		// Set the time-to-live for our cache to 1 second (default).
 		health.WithCacheDuration(1*time.Second),
 
@@ -72,23 +181,20 @@ func main() {
 			Timeout: 2 * time.Second,
 			Check:   pool.Ping,
 		}),
+		health.WithCheck(database.HealthCheck(repo)),
+		health.WithCheck(controller.HealthCheck(cluster)),
+		health.WithCheck(oauth.HealthCheck(provider)),
 
 		// Set a status listener that will be invoked when the health status changes.
 		// More powerful hooks are also available (see docs).
 		health.WithStatusListener(func(ctx context.Context, state health.CheckerState) {
-			log.Printf("health status changed to %s\n", state.Status)
+			slog.InfoContext(ctx, "health status changed", "status", state.Status)
 		}),
 	)
+}
 
-	// Create the server
-	srv, err := api.NewServer(serverCfg, oauth, provider, sessionStore, repository, healthChecker, controller)
-	if err != nil {
-		log.Fatalf("Error creating server: %v", err)
-	}
-
-	// Create main server registry
-	registry := api.MainServerRegistry{}
-
-	// Listen on the server, using the main server registry
-	srv.ListenAndServe(registry)
+// fatal logs an error the server can't start without and exits.
+func fatal(msg string, err error) {
+	slog.Error(msg, "err", err)
+	os.Exit(1)
 }
@@ -0,0 +1,719 @@
+package api
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/apierror"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/controller/spec"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/database/repository"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/events"
+)
+
+// Version of the manifest of workspace archives. Archives of other versions
+// aren't imported.
+const archiveVersion = 1
+
+// Workspace archives are gzip-compressed tar files starting with
+// archiveManifest, followed by the files of the workspace's volume under
+// archiveFilesDir.
+const (
+	archiveContentType = "application/gzip"
+	archiveManifest    = "manifest.json"
+	archiveFilesDir    = "workspace"
+)
+
+// Maximum size of an archive's manifest.
+const maxManifestSize = 1 << 20
+
+// Commands archiving a workspace's volume and extracting an archive into it,
+// streaming the archive through their standard output and input. Files pods
+// run as root, so extracted files keep neither the owners nor the
+// permissions beyond the umask that the archive gives them.
+var (
+	exportCommand = []string{"tar", "-c", "-f", "-", "-C", workspaceRoot, "."}
+	importCommand = []string{"tar", "-x", "--no-same-owner", "--no-same-permissions", "-f", "-", "-C", workspaceRoot}
+)
+
+// ownScript gives the files extracted into a workspace's volume the owner
+// given as its argument, or the volume's owner if it's empty. Symbolic links
+// are changed rather than followed.
+const ownScript = `chown -R -h "${1:-$(stat -c %u:%g /workspace)}" /workspace`
+
+// setIDBits are the setuid and setgid bits of a file's mode, which are
+// stripped from extracted files.
+const setIDBits = 0o6000
+
+// Imports update their workspace's last activity every importHeartbeat
+// while they run. Imports that haven't for importStaleAfter are taken for
+// ones whose replica stopped, and their workspaces are marked as failed.
+const (
+	importHeartbeat  = time.Minute
+	importStaleAfter = 5 * time.Minute
+)
+
+// errInvalidArchive is returned, wrapped, for archives that can't be
+// imported.
+var errInvalidArchive = errors.New("invalid archive")
+
+// workspaceManifest holds the settings of an exported workspace. Templates
+// are matched by name when the workspace is imported, and their image and
+// egress are kept for reference.
+type workspaceManifest struct {
+	Version     int               `json:"version"`
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	Template    manifestTemplate  `json:"template"`
+	Labels      map[string]string `json:"labels"`
+	Resources   spec.Resources    `json:"resources"` // Empty resources are the defaults
+	ExportedAt  time.Time         `json:"exported_at"`
+}
+
+type manifestTemplate struct {
+	Name   string `json:"name"`
+	Image  string `json:"image"`
+	Egress string `json:"egress"`
+}
+
+// newWorkspaceManifest returns the manifest of a workspace created from a
+// template.
+func newWorkspaceManifest(workspace repository.Workspace, template repository.Template) workspaceManifest {
+	return workspaceManifest{
+		Version:     archiveVersion,
+		Name:        workspace.Name,
+		Description: workspace.Description,
+		Template:    manifestTemplate{Name: template.Name, Image: template.Image, Egress: template.Egress},
+		Labels:      workspaceLabels(workspace.Labels),
+		Resources:   workspaceResources(workspace),
+		ExportedAt:  time.Now().UTC(),
+	}
+}
+
+// valid checks if a workspaceManifest struct is valid. It
+// returns a map[string]string containing any problems.
+func (m *workspaceManifest) valid() (problems map[string]string) {
+	problems = make(map[string]string)
+
+	if m.Version != archiveVersion {
+		problems["version"] = fmt.Sprintf("Archive version must be %d", archiveVersion)
+	}
+
+	// The settings are checked as changes to a workspace, leaving out the
+	// default resources
+	resources := patchResourcesForm{}
+	if m.Resources.CPU != "" {
+		resources.CPU = &m.Resources.CPU
+	}
+	if m.Resources.Memory != "" {
+		resources.Memory = &m.Resources.Memory
+	}
+	if m.Resources.Storage != "" {
+		resources.Storage = &m.Resources.Storage
+	}
+	form := patchWorkspaceForm{Name: &m.Name, Description: &m.Description, Labels: &m.Labels, Resources: &resources}
+	for field, problem := range form.valid() {
+		problems[field] = problem
+	}
+
+	return problems
+}
+
+// importOptions holds the settings of an imported workspace overriding the
+// ones of its archive.
+type importOptions struct {
+	Name     string // Name of the workspace, or empty for the archive's name
+	Team     *int32 // Team to import the workspace in, if any
+	Template *int32 // Template to import the workspace with, or nil for the archive's template
+}
+
+// workspaceImportOptions reads the options of an import from the query
+// string. It returns a map[string]string containing any problems.
+func workspaceImportOptions(c *gin.Context) (options importOptions, problems map[string]string) {
+	problems = make(map[string]string)
+
+	options.Name = c.Query("name")
+
+	if team := c.Query("team"); team != "" {
+		id, err := strconv.ParseInt(team, 10, 32)
+		if err != nil {
+			problems["team"] = "Team must be a team ID"
+		}
+		teamId := int32(id)
+		options.Team = &teamId
+	}
+
+	if template := c.Query("template"); template != "" {
+		id, err := strconv.ParseInt(template, 10, 32)
+		if err != nil {
+			problems["template"] = "Template must be a template ID"
+		}
+		templateId := int32(id)
+		options.Template = &templateId
+	}
+
+	return options, problems
+}
+
+// archivedName returns the name in an archive of a file of a workspace's
+// volume, named relative to the volume's root.
+func archivedName(name string, dir bool) string {
+	name = path.Join(archiveFilesDir, path.Clean("/"+name))
+	if dir {
+		name += "/"
+	}
+	return name
+}
+
+// volumeName returns the name relative to a volume's root of a file in an
+// archive. It returns false if the file isn't one of the archive's files
+// or would be extracted outside of the volume.
+func volumeName(name string) (string, bool) {
+	rel, ok := strings.CutPrefix(name, archiveFilesDir)
+	if !ok || (rel != "" && rel[0] != '/') {
+		return "", false
+	}
+	rel = path.Clean("." + rel)
+	if rel == ".." || strings.HasPrefix(rel, "../") || strings.ContainsRune(rel, 0) {
+		return "", false
+	}
+	return rel, true
+}
+
+// insideSymlink reports whether a file of a volume is in a directory that
+// is one of symlinks, so that extracting it could follow the link out of
+// the volume.
+func insideSymlink(name string, symlinks map[string]bool) bool {
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		if symlinks[dir] {
+			return true
+		}
+	}
+	return false
+}
+
+// writeArchive writes the archive of a workspace to w, given its manifest
+// and files, a tar stream of its volume's contents. Nothing is written
+// until the first file is read, so that failures to archive the volume can
+// still be reported. The archive isn't completed unless files ends
+// cleanly.
+func writeArchive(w io.Writer, manifest workspaceManifest, files io.Reader) error {
+	volume := tar.NewReader(files)
+	hdr, err := volume.Next()
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	zw, err := gzip.NewWriterLevel(w, gzip.BestSpeed)
+	if err != nil {
+		return err
+	}
+	archive := tar.NewWriter(zw)
+
+	err = archive.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     archiveManifest,
+		Mode:     0o644,
+		Size:     int64(len(data)),
+		ModTime:  manifest.ExportedAt,
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := archive.Write(data); err != nil {
+		return err
+	}
+
+	for {
+		archived := *hdr
+		archived.Name = archivedName(hdr.Name, hdr.Typeflag == tar.TypeDir)
+		if hdr.Typeflag == tar.TypeLink {
+			archived.Linkname = archivedName(hdr.Linkname, false)
+		}
+		archived.Format = tar.FormatUnknown
+
+		if err := archive.WriteHeader(&archived); err != nil {
+			return err
+		}
+		if _, err := io.Copy(archive, volume); err != nil {
+			return err
+		}
+
+		hdr, err = volume.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// Failures of the command archiving the volume come after its archive
+	if _, err := io.Copy(io.Discard, files); err != nil {
+		return err
+	}
+
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// readManifest reads the manifest a workspace archive starts with.
+func readManifest(archive *tar.Reader) (workspaceManifest, error) {
+	hdr, err := archive.Next()
+	if err != nil {
+		return workspaceManifest{}, fmt.Errorf("%w: %w", errInvalidArchive, err)
+	}
+	if hdr.Name != archiveManifest {
+		return workspaceManifest{}, fmt.Errorf("%w: archive must start with %v", errInvalidArchive, archiveManifest)
+	}
+	if hdr.Size > maxManifestSize {
+		return workspaceManifest{}, fmt.Errorf("%w: %v is larger than %d bytes", errInvalidArchive, archiveManifest, maxManifestSize)
+	}
+
+	var manifest workspaceManifest
+	if err := json.NewDecoder(archive).Decode(&manifest); err != nil {
+		return workspaceManifest{}, fmt.Errorf("%w: %v is not valid: %w", errInvalidArchive, archiveManifest, err)
+	}
+	return manifest, nil
+}
+
+// copyArchiveFiles writes the files of a workspace archive, following its
+// manifest, to w as a tar stream of a volume's contents, and returns the
+// owner of the archive's root as uid:gid, or "" if the root is missing or
+// owned by root. Archives whose files could be extracted outside of the
+// volume are rejected. Files other than regular files, directories and
+// links, such as devices, are skipped, and files lose their setuid and
+// setgid bits.
+func copyArchiveFiles(w io.Writer, archive *tar.Reader) (string, error) {
+	volume := tar.NewWriter(w)
+	files := archiveReader{archive}
+	symlinks := make(map[string]bool)
+	var owner string
+
+	for {
+		hdr, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("%w: %w", errInvalidArchive, err)
+		}
+
+		name, ok := volumeName(hdr.Name)
+		if !ok || insideSymlink(name, symlinks) || (name == "." && hdr.Typeflag != tar.TypeDir) {
+			return "", fmt.Errorf("%w: %v is outside of the workspace's files", errInvalidArchive, hdr.Name)
+		}
+		if name == "." && hdr.Uid > 0 {
+			owner = fmt.Sprintf("%d:%d", hdr.Uid, hdr.Gid)
+		}
+
+		extracted := *hdr
+		extracted.Name = name
+		extracted.Mode &^= setIDBits
+		extracted.Format = tar.FormatUnknown
+
+		switch hdr.Typeflag {
+		case tar.TypeReg, tar.TypeDir:
+		case tar.TypeSymlink:
+			symlinks[name] = true
+		case tar.TypeLink:
+			target, ok := volumeName(hdr.Linkname)
+			if !ok || target == "." || insideSymlink(target, symlinks) {
+				return "", fmt.Errorf("%w: %v links outside of the workspace's files", errInvalidArchive, hdr.Name)
+			}
+			extracted.Linkname = target
+		default:
+			continue
+		}
+
+		if err := volume.WriteHeader(&extracted); err != nil {
+			return "", err
+		}
+		if _, err := io.Copy(volume, files); err != nil {
+			return "", err
+		}
+	}
+
+	return owner, volume.Close()
+}
+
+// archiveReader reads the contents of the files of an archive, marking the
+// errors reading them as errors of the archive.
+type archiveReader struct {
+	r io.Reader
+}
+
+func (a archiveReader) Read(p []byte) (int, error) {
+	n, err := a.r.Read(p)
+	if err != nil && err != io.EOF {
+		err = fmt.Errorf("%w: %w", errInvalidArchive, err)
+	}
+	return n, err
+}
+
+// exportError returns the error of the command archiving a workspace's
+// volume. tar exits with status 1 when files change while they're archived,
+// which leaves the archive complete, so the workspace's pod can be
+// archived while it runs.
+func exportError(ctx context.Context, err error) error {
+	var exitErr *spec.ExitError
+	if errors.As(err, &exitErr) && exitErr.Code == 1 {
+		slog.WarnContext(ctx, "files of workspace changed while exporting", "stderr", exitErr.Stderr)
+		return nil
+	}
+	return err
+}
+
+// exportWorkspaceHandler downloads an archive of a workspace: a
+// gzip-compressed tar file of a manifest of its settings followed by the
+// files of its volume. The volume is archived by tar where it's mounted
+// and streamed through, so that it's never held in memory.
+func (s *Server) exportWorkspaceHandler(c *gin.Context) {
+	workspaceId := c.MustGet("workspace").(int32)
+
+	workspace, err := s.repository.FindWorkspaceWithId(c.Request.Context(), workspaceId)
+	if err == pgx.ErrNoRows {
+		abortWithError(c, apierror.NotFound("workspace not found"))
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error retrieving workspace", "err", err)
+		abortWithError(c, apierror.From(err, "error exporting workspace"))
+		return
+	}
+
+	// A volume still being populated would be archived partially
+	if workspaceBusy(workspace.State) {
+		abortWithError(c, apierror.Conflict(apierror.CodeNotReady, fmt.Sprintf("workspace is %v", workspace.State)))
+		return
+	}
+
+	template, err := s.repository.FindTemplateWithId(c.Request.Context(), workspace.Template)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error retrieving template", "template", workspace.Template, "err", err)
+		abortWithError(c, apierror.From(err, "error exporting workspace"))
+		return
+	}
+
+	workspaceSpec, err := s.workspaceSpec(c.Request.Context(), workspace)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error retrieving workspace", "err", err)
+		abortWithError(c, apierror.From(err, "error exporting workspace"))
+		return
+	}
+
+	header := http.Header{}
+	header.Set("Content-Type", archiveContentType)
+	header.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": workspace.Name + ".tar.gz"}))
+
+	// The volume's archive is piped from the command into the response
+	ctx := c.Request.Context()
+	files, output := io.Pipe()
+	go func() {
+		err := s.controller.ExecWorkspace(ctx, workspaceSpec, exportCommand, nil, output)
+		output.CloseWithError(exportError(ctx, err))
+	}()
+
+	w := &downloadWriter{ResponseWriter: c.Writer, status: http.StatusOK, header: header}
+	err = writeArchive(w, newWorkspaceManifest(workspace, template), files)
+	// Stop the command if the response failed
+	files.CloseWithError(err)
+	if err != nil && !w.started {
+		slog.ErrorContext(c.Request.Context(), "error exporting workspace", "workspace", workspaceId, "err", err)
+		abortWithError(c, apierror.From(err, "error exporting workspace"))
+		return
+	}
+	if err != nil {
+		// The response can only be cut short
+		slog.ErrorContext(c.Request.Context(), "error exporting workspace", "workspace", workspaceId, "err", err)
+		c.Abort()
+		return
+	}
+}
+
+// importError returns the Error a failed import causes.
+func importError(err error) *apierror.Error {
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		return apierror.New(http.StatusRequestEntityTooLarge, apierror.CodeTooLarge, fmt.Sprintf("imports are limited to %d bytes", maxErr.Limit))
+	}
+	if errors.Is(err, errInvalidArchive) {
+		return apierror.BadRequest(err.Error())
+	}
+	return apierror.From(err, "error importing workspace")
+}
+
+// importWorkspaceHandler creates a new workspace for the user from an
+// archive downloaded from exportWorkspaceHandler, possibly on another
+// cluster. The archive's files are streamed into the workspace's volume
+// before the request returns, with the workspace in the importing state.
+// The rest of its cluster resources are then created by a job, whose ID is
+// returned for the client to poll. Archives larger than MaxUploadSize are
+// rejected, and the workspace's resources are lowered to MaxResources.
+func (s *Server) importWorkspaceHandler(c *gin.Context) {
+	userId := c.MustGet("user").(int32)
+
+	options, problems := workspaceImportOptions(c)
+	if len(problems) > 0 {
+		slog.InfoContext(c.Request.Context(), "workspace import problems", "problems", problems)
+		abortWithError(c, apierror.Invalid(problems))
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, s.config.MaxUploadSize)
+	body, err := gzip.NewReader(c.Request.Body)
+	if err != nil {
+		abortWithError(c, apierror.BadRequest("request body must be a gzip-compressed workspace archive"))
+		return
+	}
+	archive := tar.NewReader(body)
+
+	manifest, err := readManifest(archive)
+	if err != nil {
+		abortWithError(c, importError(err))
+		return
+	}
+	if options.Name != "" {
+		manifest.Name = options.Name
+	}
+	if problems := manifest.valid(); len(problems) > 0 {
+		slog.InfoContext(c.Request.Context(), "workspace import problems", "problems", problems)
+		abortWithError(c, apierror.Invalid(problems))
+		return
+	}
+	manifest.Resources = clampResources(manifest.Resources, s.config.MaxResources)
+
+	// Resolve the team the workspace is imported in
+	var team repository.Team
+	if options.Team != nil {
+		var ok bool
+		team, ok = s.workspaceTeam(c, userId, *options.Team)
+		if !ok {
+			return
+		}
+	}
+
+	template, ok := s.importTemplate(c, options.Template, manifest.Template.Name)
+	if !ok {
+		return
+	}
+
+	labels, err := encodeLabels(manifest.Labels)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error encoding labels of workspace", "err", err)
+		abortWithError(c, apierror.From(err, "error importing workspace"))
+		return
+	}
+
+	tx, err := s.db.Begin(c.Request.Context())
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error starting transaction", "err", err)
+		abortWithError(c, apierror.From(err, "error importing workspace"))
+		return
+	}
+	defer tx.Rollback(context.Background())
+	qtx := s.repository.WithTx(tx)
+
+	if options.Team != nil && !s.reserveTeamWorkspace(c, qtx, team.ID) {
+		return
+	}
+
+	workspace, err := qtx.ImportWorkspace(c.Request.Context(), repository.ImportWorkspaceParams{
+		Name:        manifest.Name,
+		Owner:       userId,
+		Team:        pgtype.Int4{Int32: team.ID, Valid: options.Team != nil},
+		Template:    template.ID,
+		Description: manifest.Description,
+		Labels:      labels,
+		Cpu:         manifest.Resources.CPU,
+		Memory:      manifest.Resources.Memory,
+		Storage:     manifest.Resources.Storage,
+	})
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error importing workspace", "err", err)
+		abortWithError(c, apierror.From(err, "error importing workspace"))
+		return
+	}
+
+	if err := tx.Commit(c.Request.Context()); err != nil {
+		slog.ErrorContext(c.Request.Context(), "error importing workspace", "err", err)
+		abortWithError(c, apierror.From(err, "error importing workspace"))
+		return
+	}
+
+	auditTarget(c, "workspace", workspace.ID)
+	auditDetail(c, "name", workspace.Name)
+	auditDetail(c, "template", workspace.Template)
+
+	s.publishWorkspaceEvent(c.Request.Context(), events.WorkspaceCreated, workspace.ID)
+
+	if err := s.importFiles(c.Request.Context(), workspace, archive); err != nil {
+		slog.ErrorContext(c.Request.Context(), "error importing files of workspace", "workspace", workspace.ID, "err", err)
+
+		// The request may be gone, but the workspace must not stay importing
+		if err := s.setWorkspaceState(context.WithoutCancel(c.Request.Context()), workspace.ID, workspaceFailed, err.Error()); err != nil {
+			slog.ErrorContext(c.Request.Context(), "error marking workspace as failed", "workspace", workspace.ID, "err", err)
+		}
+
+		abortWithError(c, importError(err))
+		return
+	}
+
+	// Create the workspace's pod in the background
+	job, err := s.jobs.Enqueue(c.Request.Context(), createWorkspaceJob, userId, workspaceJob{Workspace: workspace.ID})
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error creating resources of workspace", "workspace", workspace.ID, "err", err)
+		abortWithError(c, apierror.From(err, "error importing workspace"))
+		return
+	}
+
+	c.Header("Location", fmt.Sprintf("%v/operations/%d", apiPrefix, job.ID))
+	c.IndentedJSON(http.StatusAccepted, postWorkspaceResponse{workspaceResponse: newWorkspaceResponse(workspace), Operation: job.ID})
+}
+
+// importTemplate retrieves the template a workspace is imported with: the
+// template with templateId if it's given, otherwise the template named as
+// the archive's template. If the template doesn't exist, it writes an
+// error response and returns false.
+func (s *Server) importTemplate(c *gin.Context, templateId *int32, name string) (repository.Template, bool) {
+	if templateId != nil || name == "" {
+		return s.workspaceTemplate(c, templateId)
+	}
+
+	template, err := s.repository.FindTemplateWithName(c.Request.Context(), name)
+	if err == pgx.ErrNoRows {
+		abortWithError(c, apierror.Invalid(map[string]string{"template": fmt.Sprintf("Template %v doesn't exist; choose the template to import the workspace with", name)}))
+		return repository.Template{}, false
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error retrieving template", "err", err)
+		abortWithError(c, apierror.From(err, "error retrieving template"))
+		return repository.Template{}, false
+	}
+
+	return template, true
+}
+
+// importFiles creates the volume of an imported workspace and extracts the
+// files of its archive into it, giving them the owner of the archive's
+// root. Errors of the archive wrap errInvalidArchive.
+func (s *Server) importFiles(ctx context.Context, workspace repository.Workspace, archive *tar.Reader) error {
+	workspaceSpec, err := s.workspaceSpec(ctx, workspace)
+	if err != nil {
+		return err
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go s.heartbeatImport(ctx, workspace.ID, stop)
+
+	if err := s.controller.CreateWorkspaceVolume(ctx, workspaceSpec); err != nil {
+		return err
+	}
+
+	// The archive's files are piped from the request into the command
+	type copyResult struct {
+		owner string
+		err   error
+	}
+	files, input := io.Pipe()
+	copied := make(chan copyResult, 1)
+	go func() {
+		owner, err := copyArchiveFiles(input, archive)
+		input.CloseWithError(err)
+		copied <- copyResult{owner, err}
+	}()
+
+	err = s.controller.ExecWorkspace(ctx, workspaceSpec, importCommand, files, io.Discard)
+	// Stop copying if the command failed
+	files.CloseWithError(err)
+	result := <-copied
+
+	// A broken archive breaks the command, so it's reported first
+	if errors.Is(result.err, errInvalidArchive) {
+		return result.err
+	}
+	if err != nil {
+		return err
+	}
+	if result.err != nil {
+		return result.err
+	}
+
+	return s.execFiles(ctx, workspaceSpec, ownScript, []string{result.owner}, nil, io.Discard)
+}
+
+// heartbeatImport updates the last activity of a workspace being imported
+// every importHeartbeat until stop is closed, so that failStaleImports
+// leaves it alone.
+func (s *Server) heartbeatImport(ctx context.Context, workspaceId int32, stop <-chan struct{}) {
+	ticker := time.NewTicker(importHeartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := s.repository.TouchWorkspace(ctx, workspaceId); err != nil && ctx.Err() == nil {
+			slog.WarnContext(ctx, "error recording activity in imported workspace", "workspace", workspaceId, "err", err)
+		}
+	}
+}
+
+// failStaleImports periodically marks the workspaces whose import stopped
+// without finishing, because the replica running it stopped, as failed,
+// until the context is canceled. It only needs to run on one replica.
+func (s *Server) failStaleImports(ctx context.Context) {
+	ticker := time.NewTicker(importHeartbeat)
+	defer ticker.Stop()
+
+	for {
+		failed, err := s.repository.FailStaleImports(ctx, repository.FailStaleImportsParams{
+			StateMessage: "import was interrupted",
+			LastActiveAt: pgtype.Timestamptz{Time: time.Now().Add(-importStaleAfter), Valid: true},
+		})
+		if err != nil && ctx.Err() == nil {
+			slog.ErrorContext(ctx, "error failing interrupted imports", "err", err)
+		}
+
+		for _, workspaceId := range failed {
+			slog.WarnContext(ctx, "import of workspace was interrupted", "workspace", workspaceId)
+			s.publishWorkspaceEvent(ctx, events.WorkspaceUpdated, workspaceId)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,305 @@
+package api
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/apierror"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/controller/spec"
+	"github.com/stretchr/testify/require"
+)
+
+// archiveEntry is a file of a tar stream.
+type archiveEntry struct {
+	hdr  tar.Header
+	data string
+}
+
+// tarStream returns a tar stream of entries.
+func tarStream(t *testing.T, entries ...archiveEntry) []byte {
+	var buf bytes.Buffer
+	w := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := e.hdr
+		hdr.Size = int64(len(e.data))
+		if hdr.Mode == 0 {
+			hdr.Mode = 0o644
+		}
+		require.Nil(t, w.WriteHeader(&hdr))
+		_, err := io.WriteString(w, e.data)
+		require.Nil(t, err)
+	}
+	require.Nil(t, w.Close())
+	return buf.Bytes()
+}
+
+// readEntries reads the entries of a tar stream.
+func readEntries(t *testing.T, r *tar.Reader) []archiveEntry {
+	var entries []archiveEntry
+	for {
+		hdr, err := r.Next()
+		if err == io.EOF {
+			return entries
+		}
+		require.Nil(t, err)
+		data, err := io.ReadAll(r)
+		require.Nil(t, err)
+		entries = append(entries, archiveEntry{hdr: tar.Header{Typeflag: hdr.Typeflag, Name: hdr.Name, Linkname: hdr.Linkname, Uid: hdr.Uid}, data: string(data)})
+	}
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	long := "./" + strings.Repeat("a", 150) + ".csv"
+	volume := tarStream(t,
+		archiveEntry{hdr: tar.Header{Typeflag: tar.TypeDir, Name: "./", Uid: 1000}},
+		archiveEntry{hdr: tar.Header{Typeflag: tar.TypeDir, Name: "./data/", Uid: 1000}},
+		archiveEntry{hdr: tar.Header{Typeflag: tar.TypeReg, Name: "./data/a.csv", Uid: 1000}, data: "a,b\n1,2\n"},
+		archiveEntry{hdr: tar.Header{Typeflag: tar.TypeSymlink, Name: "./latest", Linkname: "data/a.csv", Uid: 1000}},
+		archiveEntry{hdr: tar.Header{Typeflag: tar.TypeLink, Name: "./copy.csv", Linkname: "./data/a.csv", Uid: 1000}},
+		archiveEntry{hdr: tar.Header{Typeflag: tar.TypeReg, Name: long, Uid: 1000}, data: "long"},
+	)
+	manifest := workspaceManifest{
+		Version:    archiveVersion,
+		Name:       "thesis",
+		Template:   manifestTemplate{Name: "default", Egress: "internet"},
+		Labels:     map[string]string{"project": "thesis"},
+		Resources:  spec.Resources{CPU: "2"},
+		ExportedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	var archive bytes.Buffer
+	require.Nil(t, writeArchive(&archive, manifest, bytes.NewReader(volume)))
+
+	zr, err := gzip.NewReader(&archive)
+	require.Nil(t, err)
+	tr := tar.NewReader(zr)
+
+	read, err := readManifest(tr)
+	require.Nil(t, err)
+	require.Equal(t, manifest, read)
+	require.Empty(t, read.valid())
+
+	var extracted bytes.Buffer
+	owner, err := copyArchiveFiles(&extracted, tr)
+	require.Nil(t, err)
+	require.Equal(t, "1000:0", owner)
+	require.Equal(t, []archiveEntry{
+		{hdr: tar.Header{Typeflag: tar.TypeDir, Name: ".", Uid: 1000}},
+		{hdr: tar.Header{Typeflag: tar.TypeDir, Name: "data", Uid: 1000}},
+		{hdr: tar.Header{Typeflag: tar.TypeReg, Name: "data/a.csv", Uid: 1000}, data: "a,b\n1,2\n"},
+		{hdr: tar.Header{Typeflag: tar.TypeSymlink, Name: "latest", Linkname: "data/a.csv", Uid: 1000}},
+		{hdr: tar.Header{Typeflag: tar.TypeLink, Name: "copy.csv", Linkname: "data/a.csv", Uid: 1000}},
+		{hdr: tar.Header{Typeflag: tar.TypeReg, Name: long[2:], Uid: 1000}, data: "long"},
+	}, readEntries(t, tar.NewReader(&extracted)))
+}
+
+func TestWriteArchiveFailed(t *testing.T) {
+	manifest := workspaceManifest{Version: archiveVersion, Name: "thesis"}
+
+	// Nothing is written if the volume can't be archived
+	var archive bytes.Buffer
+	err := writeArchive(&archive, manifest, iotest.ErrReader(errors.New("unable to start files pod")))
+	require.NotNil(t, err)
+	require.Zero(t, archive.Len())
+
+	err = writeArchive(&archive, manifest, strings.NewReader(""))
+	require.NotNil(t, err)
+	require.Zero(t, archive.Len())
+
+	// The archive isn't completed if the command fails after its output
+	volume := tarStream(t, archiveEntry{hdr: tar.Header{Typeflag: tar.TypeDir, Name: "./"}})
+	failed := io.MultiReader(bytes.NewReader(volume), iotest.ErrReader(&spec.ExitError{Code: 2}))
+	err = writeArchive(&archive, manifest, failed)
+	require.NotNil(t, err)
+
+	zr, err := gzip.NewReader(&archive)
+	require.Nil(t, err)
+	_, err = io.ReadAll(zr)
+	require.NotNil(t, err, "The archive should be cut short")
+}
+
+func TestReadManifest(t *testing.T) {
+	tests := []struct {
+		description string
+		archive     []byte
+		wantErr     bool
+	}{
+		{"Manifest", tarStream(t, archiveEntry{hdr: tar.Header{Name: archiveManifest}, data: `{"version":1,"name":"thesis"}`}), false},
+		{"Files first", tarStream(t, archiveEntry{hdr: tar.Header{Name: "workspace/a.csv"}}, archiveEntry{hdr: tar.Header{Name: archiveManifest}, data: `{}`}), true},
+		{"Invalid manifest", tarStream(t, archiveEntry{hdr: tar.Header{Name: archiveManifest}, data: `{"version":`}), true},
+		{"Large manifest", tarStream(t, archiveEntry{hdr: tar.Header{Name: archiveManifest}, data: strings.Repeat(" ", maxManifestSize+1)}), true},
+		{"Empty", tarStream(t), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			_, err := readManifest(tar.NewReader(bytes.NewReader(tt.archive)))
+			require.Equal(t, tt.wantErr, err != nil, "%v", err)
+			if err != nil {
+				require.True(t, errors.Is(err, errInvalidArchive))
+			}
+		})
+	}
+}
+
+func TestWorkspaceManifestValid(t *testing.T) {
+	tests := []struct {
+		description string
+		manifest    workspaceManifest
+		wantProblem string
+	}{
+		{"Valid", workspaceManifest{Version: archiveVersion, Name: "thesis", Labels: map[string]string{"project": "thesis"}, Resources: spec.Resources{CPU: "2", Memory: "4Gi"}}, ""},
+		{"Default resources", workspaceManifest{Version: archiveVersion, Name: "thesis"}, ""},
+		{"Version", workspaceManifest{Version: 2, Name: "thesis"}, "version"},
+		{"Name", workspaceManifest{Version: archiveVersion, Name: "a"}, "name"},
+		{"Description", workspaceManifest{Version: archiveVersion, Name: "thesis", Description: strings.Repeat("a", maxDescriptionLength+1)}, "description"},
+		{"Reserved label", workspaceManifest{Version: archiveVersion, Name: "thesis", Labels: map[string]string{"kubernetes.io/name": "a"}}, "labels.kubernetes.io/name"},
+		{"Resource", workspaceManifest{Version: archiveVersion, Name: "thesis", Resources: spec.Resources{Storage: "-1Gi"}}, "resources.storage"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			problems := tt.manifest.valid()
+			if tt.wantProblem == "" {
+				require.Empty(t, problems)
+			} else {
+				require.Contains(t, problems, tt.wantProblem)
+			}
+		})
+	}
+}
+
+func TestVolumeName(t *testing.T) {
+	tests := []struct {
+		name   string
+		want   string
+		wantOk bool
+	}{
+		{"workspace/", ".", true},
+		{"workspace", ".", true},
+		{"workspace/data/a.csv", "data/a.csv", true},
+		{"workspace//data/./b/", "data/b", true},
+		{"workspace/data/../a.csv", "a.csv", true},
+		{"workspace/../etc/passwd", "", false},
+		{"workspace/data/../../a.csv", "", false},
+		{"workspaces/a.csv", "", false},
+		{"/etc/passwd", "", false},
+		{"manifest.json", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			have, ok := volumeName(tt.name)
+			require.Equal(t, tt.wantOk, ok)
+			require.Equal(t, tt.want, have)
+		})
+	}
+}
+
+func TestCopyArchiveFilesUnsafe(t *testing.T) {
+	tests := []struct {
+		description string
+		entries     []archiveEntry
+	}{
+		{"Outside", []archiveEntry{{hdr: tar.Header{Typeflag: tar.TypeReg, Name: "workspace/../../etc/passwd"}}}},
+		{"Not in files", []archiveEntry{{hdr: tar.Header{Typeflag: tar.TypeReg, Name: "a.csv"}}}},
+		{"Through symlink", []archiveEntry{
+			{hdr: tar.Header{Typeflag: tar.TypeSymlink, Name: "workspace/etc", Linkname: "/etc"}},
+			{hdr: tar.Header{Typeflag: tar.TypeReg, Name: "workspace/etc/passwd"}},
+		}},
+		{"Through replaced symlink", []archiveEntry{
+			{hdr: tar.Header{Typeflag: tar.TypeSymlink, Name: "workspace/etc", Linkname: "/etc"}},
+			{hdr: tar.Header{Typeflag: tar.TypeDir, Name: "workspace/etc/"}},
+			{hdr: tar.Header{Typeflag: tar.TypeReg, Name: "workspace/etc/passwd"}},
+		}},
+		{"Hard link outside", []archiveEntry{{hdr: tar.Header{Typeflag: tar.TypeLink, Name: "workspace/passwd", Linkname: "/etc/passwd"}}}},
+		{"Hard link to root", []archiveEntry{{hdr: tar.Header{Typeflag: tar.TypeLink, Name: "workspace/root", Linkname: "workspace/"}}}},
+		{"Root replaced", []archiveEntry{{hdr: tar.Header{Typeflag: tar.TypeSymlink, Name: "workspace/", Linkname: "/"}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			archive := tar.NewReader(bytes.NewReader(tarStream(t, tt.entries...)))
+			_, err := copyArchiveFiles(io.Discard, archive)
+			require.True(t, errors.Is(err, errInvalidArchive), "%v", err)
+		})
+	}
+}
+
+func TestCopyArchiveFilesSkipped(t *testing.T) {
+	archive := tar.NewReader(bytes.NewReader(tarStream(t,
+		archiveEntry{hdr: tar.Header{Typeflag: tar.TypeFifo, Name: "workspace/pipe"}},
+		archiveEntry{hdr: tar.Header{Typeflag: tar.TypeChar, Name: "workspace/null", Devmajor: 1, Devminor: 3}},
+		archiveEntry{hdr: tar.Header{Typeflag: tar.TypeReg, Name: "workspace/a.csv"}, data: "a"},
+	)))
+
+	var extracted bytes.Buffer
+	owner, err := copyArchiveFiles(&extracted, archive)
+	require.Nil(t, err)
+	require.Empty(t, owner, "Archives without a root keep the volume's owner")
+	require.Equal(t, []archiveEntry{
+		{hdr: tar.Header{Typeflag: tar.TypeReg, Name: "a.csv"}, data: "a"},
+	}, readEntries(t, tar.NewReader(&extracted)))
+}
+
+func TestCopyArchiveFilesPermissions(t *testing.T) {
+	archive := tar.NewReader(bytes.NewReader(tarStream(t,
+		archiveEntry{hdr: tar.Header{Typeflag: tar.TypeDir, Name: "workspace/", Mode: 0o755}},
+		archiveEntry{hdr: tar.Header{Typeflag: tar.TypeReg, Name: "workspace/shell", Mode: 0o4755}, data: "#!/bin/sh"},
+		archiveEntry{hdr: tar.Header{Typeflag: tar.TypeDir, Name: "workspace/shared/", Mode: 0o2775}},
+	)))
+
+	var extracted bytes.Buffer
+	owner, err := copyArchiveFiles(&extracted, archive)
+	require.Nil(t, err)
+	require.Empty(t, owner, "Roots owned by root keep the volume's owner")
+
+	modes := make(map[string]int64)
+	r := tar.NewReader(&extracted)
+	for {
+		hdr, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		require.Nil(t, err)
+		modes[hdr.Name] = hdr.Mode
+	}
+	require.Equal(t, map[string]int64{".": 0o755, "shell": 0o755, "shared": 0o775}, modes)
+}
+
+func TestImportError(t *testing.T) {
+	tests := []struct {
+		err        error
+		wantStatus int
+		wantCode   string
+	}{
+		{fmt.Errorf("%w: unexpected EOF", errInvalidArchive), http.StatusBadRequest, apierror.CodeInvalidRequest},
+		{fmt.Errorf("%w: %w", errInvalidArchive, &http.MaxBytesError{Limit: 1 << 30}), http.StatusRequestEntityTooLarge, apierror.CodeTooLarge},
+		{errors.New("unable to start files pod"), http.StatusInternalServerError, apierror.CodeInternal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.err.Error(), func(t *testing.T) {
+			e := importError(tt.err)
+			require.Equal(t, tt.wantStatus, e.Status)
+			require.Equal(t, tt.wantCode, e.Code)
+		})
+	}
+}
+
+func TestExportError(t *testing.T) {
+	require.Nil(t, exportError(context.Background(), nil))
+	require.Nil(t, exportError(context.Background(), &spec.ExitError{Code: 1, Stderr: "tar: ./log: file changed as we read it"}))
+	require.NotNil(t, exportError(context.Background(), &spec.ExitError{Code: 2}))
+	require.NotNil(t, exportError(context.Background(), errors.New("unable to start files pod")))
+}
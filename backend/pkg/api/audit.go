@@ -0,0 +1,305 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/apierror"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/database/repository"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/logging"
+)
+
+// Outcomes of audited actions.
+const (
+	auditSuccess = "success"
+	auditFailure = "failure"
+	auditDenied  = "denied" // The actor wasn't allowed to perform the action
+)
+
+// Context keys handlers use to describe an audited action.
+const (
+	auditTargetKey  = "auditTarget"
+	auditDetailsKey = "auditDetails"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+	ndjsonContentType = "application/x-ndjson"
+)
+
+// auditEvent is an audit event as returned by the API.
+type auditEvent struct {
+	ID        int64           `json:"id"`
+	CreatedAt *time.Time      `json:"created_at"`
+	Actor     *int32          `json:"actor"` // User who acted, if known
+	Action    string          `json:"action"`
+	Target    string          `json:"target"`
+	Ip        string          `json:"ip"`
+	UserAgent string          `json:"user_agent"`
+	RequestID string          `json:"request_id"`
+	Outcome   string          `json:"outcome"`
+	Details   json.RawMessage `json:"details"`
+}
+
+func newAuditEvent(e repository.AuditEvent) auditEvent {
+	return auditEvent{
+		ID:        e.ID,
+		CreatedAt: optionalTime(e.CreatedAt),
+		Actor:     optionalInt32(e.Actor),
+		Action:    e.Action,
+		Target:    e.Target,
+		Ip:        e.Ip,
+		UserAgent: e.UserAgent,
+		RequestID: e.RequestID,
+		Outcome:   e.Outcome,
+		Details:   json.RawMessage(e.Details),
+	}
+}
+
+// auditMiddleware records the request as an audit event with the given
+// action once it has been handled. The target defaults to the kind of
+// resource in the :id param, like "workspace:3", and handlers can set it
+// with auditTarget. Other path params are added to the event's details
+// unless the handler has set them.
+func (s *Server) auditMiddleware(action string, kind string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		target := c.GetString(auditTargetKey)
+		if target == "" && kind != "" && c.Param("id") != "" {
+			target = kind + ":" + c.Param("id")
+		}
+
+		for _, param := range c.Params {
+			if _, ok := c.GetStringMap(auditDetailsKey)[param.Key]; !ok && param.Key != "id" {
+				auditDetail(c, param.Key, param.Value)
+			}
+		}
+
+		var actor int32
+		if userId, ok := c.Get("user"); ok {
+			actor = userId.(int32)
+		}
+
+		details, _ := c.Get(auditDetailsKey)
+		s.recordAudit(c, actor, action, target, auditOutcome(c.Writer.Status()), details)
+	}
+}
+
+// auditTarget sets the target of the request's audit event.
+func auditTarget(c *gin.Context, kind string, id any) {
+	c.Set(auditTargetKey, fmt.Sprintf("%v:%v", kind, id))
+}
+
+// auditDetail adds a detail to the request's audit event.
+func auditDetail(c *gin.Context, key string, value any) {
+	details := c.GetStringMap(auditDetailsKey)
+	if details == nil {
+		details = make(map[string]any)
+		c.Set(auditDetailsKey, details)
+	}
+	details[key] = value
+}
+
+// auditOutcome returns the outcome of an audited request from its
+// response status.
+func auditOutcome(status int) string {
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return auditDenied
+	case status >= 400:
+		return auditFailure
+	default:
+		return auditSuccess
+	}
+}
+
+// recordAudit records an audit event for a request. An actor of 0 means
+// the actor is unknown. Failing to record the event doesn't fail the
+// request, so errors are only logged.
+func (s *Server) recordAudit(c *gin.Context, actor int32, action string, target string, outcome string, details any) {
+	if details == nil {
+		details = map[string]any{}
+	}
+	encoded, err := json.Marshal(details)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error encoding details of audit event", "action", action, "err", err)
+		encoded = []byte("{}")
+	}
+
+	// Record the event even if the client has gone away
+	ctx := context.WithoutCancel(c.Request.Context())
+	err = s.repository.CreateAuditEvent(ctx, repository.CreateAuditEventParams{
+		Actor:     pgtype.Int4{Int32: actor, Valid: actor != 0},
+		Action:    action,
+		Target:    target,
+		Ip:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		RequestID: logging.RequestID(ctx),
+		Outcome:   outcome,
+		Details:   encoded,
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "error recording audit event", "action", action, "err", err)
+	}
+}
+
+// adminMiddleware only lets administrators through.
+func (s *Server) adminMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId := c.MustGet("user").(int32)
+
+		admin, ok := s.userIsAdmin(c, userId)
+		if !ok {
+			return
+		}
+
+		if !admin {
+			abortWithError(c, apierror.Forbidden())
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// userIsAdmin returns whether a user is an administrator. If the user can't
+// be retrieved, it writes an error response and returns false.
+func (s *Server) userIsAdmin(c *gin.Context, userId int32) (admin bool, ok bool) {
+	user, err := s.repository.FindUserWithId(c.Request.Context(), userId)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error retrieving user", "err", err)
+		abortWithError(c, apierror.From(err, "error retrieving user"))
+		return false, false
+	}
+
+	return user.Admin, true
+}
+
+// auditFilter reads the filters and cursor of an audit event query from
+// the query string. It returns a map[string]string containing any
+// problems.
+func auditFilter(c *gin.Context) (params repository.ListAuditEventsParams, problems map[string]string) {
+	problems = make(map[string]string)
+
+	if actor := c.Query("actor"); actor != "" {
+		id, err := strconv.ParseInt(actor, 10, 32)
+		if err != nil {
+			problems["actor"] = "Actor must be a user ID"
+		}
+		params.Actor = pgtype.Int4{Int32: int32(id), Valid: true}
+	}
+
+	for key, field := range map[string]*pgtype.Text{"action": &params.Action, "target": &params.Target, "outcome": &params.Outcome} {
+		if value := c.Query(key); value != "" {
+			*field = pgtype.Text{String: value, Valid: true}
+		}
+	}
+
+	for key, field := range map[string]*pgtype.Timestamptz{"since": &params.Since, "until": &params.Until} {
+		if value := c.Query(key); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				problems[key] = "Time must be in RFC 3339 format"
+			}
+			*field = pgtype.Timestamptz{Time: t, Valid: true}
+		}
+	}
+
+	if cursor := c.Query("cursor"); cursor != "" {
+		id, err := strconv.ParseInt(cursor, 10, 64)
+		if err != nil {
+			problems["cursor"] = "Invalid cursor"
+		}
+		params.Cursor = pgtype.Int8{Int64: id, Valid: true}
+	}
+
+	params.MaxEvents = defaultAuditLimit
+	if limit := c.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxAuditLimit {
+			problems["limit"] = fmt.Sprintf("Limit must be between 1 and %d", maxAuditLimit)
+		}
+		params.MaxEvents = int32(n)
+	}
+
+	return params, problems
+}
+
+// getAuditHandler lists audit events, newest first. Events can be filtered
+// by actor, action, target, outcome and time, and are paginated with the
+// returned cursor. With ?format=ndjson or an Accept header asking for
+// NDJSON, every matching event is exported instead, one per line.
+func (s *Server) getAuditHandler(c *gin.Context) {
+	params, problems := auditFilter(c)
+	if len(problems) > 0 {
+		abortWithError(c, apierror.Invalid(problems))
+		return
+	}
+
+	if c.Query("format") == "ndjson" || c.GetHeader("Accept") == ndjsonContentType {
+		s.exportAudit(c, params)
+		return
+	}
+
+	events, err := s.repository.ListAuditEvents(c.Request.Context(), params)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error retrieving audit events", "err", err)
+		abortWithError(c, apierror.From(err, "error retrieving audit events"))
+		return
+	}
+
+	response := make([]auditEvent, len(events))
+	for i, e := range events {
+		response[i] = newAuditEvent(e)
+	}
+
+	// A full page may be followed by more events
+	var next *int64
+	if len(events) > 0 && len(events) == int(params.MaxEvents) {
+		next = &events[len(events)-1].ID
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"events": response, "next_cursor": next})
+}
+
+// exportAudit writes every audit event matching params as NDJSON, a page
+// at a time.
+func (s *Server) exportAudit(c *gin.Context, params repository.ListAuditEventsParams) {
+	params.MaxEvents = maxAuditLimit
+
+	c.Header("Content-Type", ndjsonContentType)
+	c.Header("Content-Disposition", `attachment; filename="audit.ndjson"`)
+	c.Status(http.StatusOK)
+
+	encoder := json.NewEncoder(c.Writer)
+	for {
+		events, err := s.repository.ListAuditEvents(c.Request.Context(), params)
+		if err != nil {
+			// The response has started, so the export is cut short
+			slog.ErrorContext(c.Request.Context(), "error exporting audit events", "err", err)
+			return
+		}
+
+		for _, e := range events {
+			if err := encoder.Encode(newAuditEvent(e)); err != nil {
+				slog.ErrorContext(c.Request.Context(), "error writing audit events", "err", err)
+				return
+			}
+		}
+		flush(c)
+
+		if len(events) < int(params.MaxEvents) {
+			return
+		}
+		params.Cursor = pgtype.Int8{Int64: events[len(events)-1].ID, Valid: true}
+	}
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/database/repository"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditOutcome(t *testing.T) {
+	tests := []struct {
+		status int
+		want   string
+	}{
+		{http.StatusOK, auditSuccess},
+		{http.StatusAccepted, auditSuccess},
+		{http.StatusTemporaryRedirect, auditSuccess},
+		{http.StatusUnauthorized, auditDenied},
+		{http.StatusForbidden, auditDenied},
+		{http.StatusNotFound, auditFailure},
+		{http.StatusInternalServerError, auditFailure},
+	}
+
+	for _, tt := range tests {
+		require.Equal(t, tt.want, auditOutcome(tt.status), "status %d", tt.status)
+	}
+}
+
+func TestAuditDetails(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+	auditTarget(c, "workspace", int32(3))
+	auditDetail(c, "name", "foo")
+	auditDetail(c, "role", "editor")
+
+	require.Equal(t, "workspace:3", c.GetString(auditTargetKey))
+	require.Equal(t, map[string]any{"name": "foo", "role": "editor"}, c.GetStringMap(auditDetailsKey))
+}
+
+func TestAuditFilter(t *testing.T) {
+	since := time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		query    string
+		want     repository.ListAuditEventsParams
+		problems []string
+	}{
+		{
+			name:  "defaults",
+			query: "",
+			want:  repository.ListAuditEventsParams{MaxEvents: defaultAuditLimit},
+		},
+		{
+			name:  "filters",
+			query: "actor=2&action=workspace.create&outcome=denied&since=2024-10-01T00:00:00Z&cursor=40&limit=10",
+			want: repository.ListAuditEventsParams{
+				Actor:     pgtype.Int4{Int32: 2, Valid: true},
+				Action:    pgtype.Text{String: "workspace.create", Valid: true},
+				Outcome:   pgtype.Text{String: "denied", Valid: true},
+				Since:     pgtype.Timestamptz{Time: since, Valid: true},
+				Cursor:    pgtype.Int8{Int64: 40, Valid: true},
+				MaxEvents: 10,
+			},
+		},
+		{
+			name:     "invalid",
+			query:    "actor=me&until=yesterday&cursor=x&limit=5000",
+			problems: []string{"actor", "until", "cursor", "limit"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodGet, "/admin/audit?"+tt.query, nil)
+
+			params, problems := auditFilter(c)
+			if tt.problems != nil {
+				require.Len(t, problems, len(tt.problems))
+				for _, key := range tt.problems {
+					require.Contains(t, problems, key)
+				}
+				return
+			}
+			require.Empty(t, problems)
+			require.Equal(t, tt.want, params)
+		})
+	}
+}
@@ -1,20 +1,31 @@
 package api
 
 import (
-	"context"
 	"crypto/rand"
 	"encoding/base64"
-	"log"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"strconv"
 
 	"github.com/coreos/go-oidc/v3/oidc"
 	"github.com/gin-gonic/gin"
 	"github.com/jackc/pgx/v5"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/apierror"
 	"github.com/johngerving/kubernetes-web-client/backend/pkg/database/repository"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/logging"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/metrics"
 )
 
 var maxOauthStateCookieAge int = 60 * 60 * 24 * 365 // Set max age for OAuth state to a year
 
+// idTokenClaims holds the profile claims read from an OIDC ID token.
+type idTokenClaims struct {
+	Email   string `json:"email"`
+	Name    string `json:"name"`
+	Picture string `json:"picture"`
+}
+
 func (s *Server) authMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Get user data from the session
@@ -22,12 +33,115 @@ func (s *Server) authMiddleware() gin.HandlerFunc {
 
 		if userId == 0 {
 			// Respond with unauthorized
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "unauthorized"})
+			abortWithError(c, apierror.Unauthorized())
 			return
 		}
 
 		// If found in the session, pass the user data along
 		c.Set("user", userId)
+		c.Request = c.Request.WithContext(logging.WithUser(c.Request.Context(), userId))
+		c.Next()
+	}
+}
+
+// Roles users hold in workspaces and teams, from least to most privileged.
+const (
+	roleViewer = "viewer"
+	roleEditor = "editor"
+	roleOwner  = "owner"
+)
+
+var workspaceRoleRanks = map[string]int{roleViewer: 1, roleEditor: 2, roleOwner: 3}
+
+// Role needed to copy all of a workspace's files into a workspace or archive
+// of one's own, by cloning or exporting it. Viewers can already read every
+// file, so they may copy them too.
+const roleCopy = roleViewer
+
+// roleAllows reports whether a user with role have may perform
+// actions that require role want.
+func roleAllows(have string, want string) bool {
+	return workspaceRoleRanks[have] >= workspaceRoleRanks[want]
+}
+
+// workspaceMiddleware authorizes access to the workspace in the :id param,
+// requiring the user to hold at least the given role. The workspace ID and the
+// user's role are passed along as "workspace" and "role".
+func (s *Server) workspaceMiddleware(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId := c.MustGet("user").(int32)
+
+		// Get the workspace ID
+		workspaceId, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			slog.InfoContext(c.Request.Context(), "error in id param", "err", err)
+			abortWithError(c, apierror.BadRequest("invalid ID param"))
+			return
+		}
+
+		have, err := s.repository.FindWorkspaceRole(c.Request.Context(), repository.FindWorkspaceRoleParams{
+			Workspace: int32(workspaceId),
+			Member:    userId,
+		})
+		if err == pgx.ErrNoRows {
+			// Don't reveal whether workspaces the user can't see exist
+			abortWithError(c, apierror.NotFound("workspace not found"))
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "error retrieving role of user in workspace", "workspace", workspaceId, "err", err)
+			abortWithError(c, apierror.From(err, "error retrieving workspace"))
+			return
+		}
+
+		if !roleAllows(have, role) {
+			abortWithError(c, apierror.Forbidden())
+			return
+		}
+
+		c.Set("workspace", int32(workspaceId))
+		c.Request = c.Request.WithContext(logging.WithWorkspace(c.Request.Context(), int32(workspaceId)))
+		c.Set("role", have)
+		c.Next()
+	}
+}
+
+// teamMiddleware authorizes access to the team in the :id param, requiring
+// the user to hold at least the given role. The team ID and the user's role
+// are passed along as "team" and "role".
+func (s *Server) teamMiddleware(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId := c.MustGet("user").(int32)
+
+		// Get the team ID
+		teamId, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			slog.InfoContext(c.Request.Context(), "error in id param", "err", err)
+			abortWithError(c, apierror.BadRequest("invalid ID param"))
+			return
+		}
+
+		have, err := s.repository.FindTeamRole(c.Request.Context(), repository.FindTeamRoleParams{
+			Team:   int32(teamId),
+			Member: userId,
+		})
+		if err == pgx.ErrNoRows {
+			abortWithError(c, apierror.NotFound("team not found"))
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "error retrieving role of user in team", "team", teamId, "err", err)
+			abortWithError(c, apierror.From(err, "error retrieving team"))
+			return
+		}
+
+		if !roleAllows(have, role) {
+			abortWithError(c, apierror.Forbidden())
+			return
+		}
+
+		c.Set("team", int32(teamId))
+		c.Set("role", have)
 		c.Next()
 	}
 }
@@ -69,14 +183,16 @@ func (s *Server) authCallbackHandler(c *gin.Context) {
 
 	// Redirect if state is invalid
 	if c.Request.FormValue("state") != oauthState {
-		log.Println("error: invalid OAuth state")
+		slog.WarnContext(c.Request.Context(), "invalid OAuth state")
+		s.recordLogin(c, 0, auditDenied, gin.H{"reason": "invalid OAuth state"})
 		c.Redirect(http.StatusTemporaryRedirect, "/auth")
 		return
 	}
 
-	oauth2Token, err := s.oauth.Exchange(context.Background(), c.Request.URL.Query().Get("code"))
+	oauth2Token, err := s.oauth.Exchange(c.Request.Context(), c.Request.URL.Query().Get("code"))
 	if err != nil {
-		log.Printf("error retrieving OAuth code: %v", err)
+		slog.WarnContext(c.Request.Context(), "error exchanging OAuth code", "err", err)
+		s.recordLogin(c, 0, auditDenied, gin.H{"reason": "code exchange failed"})
 		c.Redirect(http.StatusTemporaryRedirect, "/auth")
 		return
 	}
@@ -84,56 +200,71 @@ func (s *Server) authCallbackHandler(c *gin.Context) {
 	// Extract ID token from OAuth token
 	rawIDToken, ok := oauth2Token.Extra("id_token").(string)
 	if !ok {
-		log.Printf("error extracting OAuth ID token: %v", err)
+		slog.WarnContext(c.Request.Context(), "OAuth token has no ID token")
+		s.recordLogin(c, 0, auditDenied, gin.H{"reason": "missing ID token"})
 		c.Redirect(http.StatusTemporaryRedirect, "/auth")
 		return
 	}
 
 	// Parse and verify ID Token payload
-	idToken, err := verifier.Verify(context.Background(), rawIDToken)
+	idToken, err := verifier.Verify(c.Request.Context(), rawIDToken)
 	if err != nil {
-		log.Printf("error parsing ID token payload: %v", err)
+		slog.WarnContext(c.Request.Context(), "error verifying ID token", "err", err)
+		s.recordLogin(c, 0, auditDenied, gin.H{"reason": "invalid ID token"})
 		c.Redirect(http.StatusTemporaryRedirect, "/auth")
 		return
 	}
 
-	// Extract custom claims
-	var claims repository.User
+	// Extract profile claims
+	var claims idTokenClaims
 	if err := idToken.Claims(&claims); err != nil {
-		log.Printf("error extracting OIDC claims: %v", err)
+		slog.WarnContext(c.Request.Context(), "error extracting OIDC claims", "err", err)
+		s.recordLogin(c, 0, auditDenied, gin.H{"reason": "invalid claims"})
 		c.Redirect(http.StatusTemporaryRedirect, "/auth")
 		return
 	}
 
-	// Check if user already exists in database
-	user, err := s.repository.FindUserWithEmail(context.Background(), claims.Email)
-
-	if err == pgx.ErrNoRows {
-		// If the user isn't in the database, add them
-		err = s.repository.CreateUser(context.Background(), claims.Email)
-		if err != nil {
-			log.Printf("error adding user to database: %v", err)
-			c.IndentedJSON(http.StatusInternalServerError, gin.H{"message": "unable to add user to database"})
-			return
-		}
-	} else if err != nil {
-		// Some other error occurred in getting user information
-		log.Printf("error retrieving user information: %v", err)
-		c.IndentedJSON(http.StatusInternalServerError, gin.H{"message": "unable to retrieve user information"})
+	// Users are identified by issuer and subject, which never change for an account. The
+	// profile claims are refreshed on every login.
+	user, err := s.repository.UpsertUser(c.Request.Context(), repository.UpsertUserParams{
+		Issuer:  idToken.Issuer,
+		Subject: idToken.Subject,
+		Email:   claims.Email,
+		Name:    claims.Name,
+		Picture: claims.Picture,
+	})
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error saving user information", "err", err)
+		s.recordLogin(c, 0, auditFailure, gin.H{"issuer": idToken.Issuer, "subject": idToken.Subject})
+		abortWithError(c, apierror.From(err, "unable to save user information"))
 		return
 	}
 
 	// Create a new session to store the user information
 	s.sessionStore.Put(c.Request.Context(), "user", int(user.ID))
+	s.recordLogin(c, user.ID, auditSuccess, nil)
 
 	// Redirect to app URL
 	c.Redirect(http.StatusPermanentRedirect, s.config.FrontendURL)
 }
 
+// recordLogin records a login attempt in the audit log and the login
+// metrics. An actor of 0 means the login didn't get as far as a user.
+func (s *Server) recordLogin(c *gin.Context, actor int32, outcome string, details any) {
+	var target string
+	if actor != 0 {
+		target = fmt.Sprintf("user:%d", actor)
+	}
+	s.recordAudit(c, actor, "auth.login", target, outcome, details)
+	metrics.LoginsTotal.WithLabelValues(outcome).Inc()
+}
+
 // authLogoutHandler logs the user out.
 func (s *Server) authLogoutHandler(c *gin.Context) {
 	// Remove the user's session from the store
-	s.sessionStore.Pop(c.Request.Context(), "user")
+	if userId := int32(s.sessionStore.PopInt(c.Request.Context(), "user")); userId != 0 {
+		s.recordAudit(c, userId, "auth.logout", fmt.Sprintf("user:%d", userId), auditSuccess, nil)
+	}
 
 	// Redirect to app URL
 	c.Redirect(http.StatusPermanentRedirect, s.config.FrontendURL)
@@ -2,21 +2,39 @@ package api
 
 import (
 	"fmt"
+	"log/slog"
 	"os"
 	"strconv"
 	"strings"
 
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/controller/spec"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/logging"
 	_ "github.com/joho/godotenv/autoload"
+	"k8s.io/apimachinery/pkg/api/resource"
 )
 
 type Config struct {
-	Environment string
-	Port        int
-	BackendURL  string
-	FrontendURL string
-	Domain      string
+	Environment  string
+	Port         int
+	BackendURL   string
+	FrontendURL  string
+	Domain       string
+	LogLevel     slog.Level // Least severe level logged
+	MetricsPort  int        // Port serving Prometheus metrics, separate from the API
+	WorkspaceURL string     // URL workspaces are reached at, with {id} standing for the workspace's ID, or "" if they aren't exposed
+
+	MaxUploadSize int64          // Bytes a request uploading files to a workspace, or importing one, may hold
+	MaxResources  spec.Resources // Largest resources a workspace may run with
 }
 
+// defaultMaxUploadSize is the MaxUploadSize when MAX_UPLOAD_SIZE is not
+// set.
+const defaultMaxUploadSize = 1 << 30
+
+// defaultMaxResources are the MaxResources whose MAX_WORKSPACE_CPU,
+// MAX_WORKSPACE_MEMORY or MAX_WORKSPACE_STORAGE is not set.
+var defaultMaxResources = spec.Resources{CPU: "8", Memory: "32Gi", Storage: "500Gi"}
+
 // NewConfigFromEnv reads in environment variables and returns
 // a Config struct instance. If an environment variable is not found,
 // an error occurs.
@@ -40,6 +58,14 @@ func NewConfigFromEnv() (*Config, error) {
 		}
 	}
 
+	metricsPort := 9090
+	if portString := os.Getenv("METRICS_PORT"); portString != "" {
+		metricsPort, err = strconv.Atoi(portString)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load metrics port %v: %v", portString, err)
+		}
+	}
+
 	apiUrl := os.Getenv("API_URL")
 	if apiUrl == "" {
 		return nil, fmt.Errorf("API URL must be specified")
@@ -55,6 +81,52 @@ func NewConfigFromEnv() (*Config, error) {
 		return nil, fmt.Errorf("domain must be specified")
 	}
 
+	logLevel := slog.LevelInfo
+	if level := os.Getenv("LOG_LEVEL"); level != "" {
+		logLevel, err = logging.ParseLevel(level)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load log level %v: %v", level, err)
+		}
+	}
+
+	workspaceUrl := os.Getenv("WORKSPACE_URL")
+	if workspaceUrl != "" && !strings.Contains(workspaceUrl, "{id}") {
+		return nil, fmt.Errorf("workspace URL must contain {id}")
+	}
+
+	maxUploadSize := int64(defaultMaxUploadSize)
+	if size := os.Getenv("MAX_UPLOAD_SIZE"); size != "" {
+		quantity, err := resource.ParseQuantity(size)
+		if err != nil || quantity.Sign() <= 0 {
+			return nil, fmt.Errorf("invalid max upload size %v", size)
+		}
+		maxUploadSize = quantity.Value()
+	}
+
+	// Workspaces without resources of their own run with the defaults, so
+	// the maximums can't be below them
+	maxResources := defaultMaxResources
+	maximums := []struct {
+		env      string
+		max      *string
+		fallback string
+	}{
+		{"MAX_WORKSPACE_CPU", &maxResources.CPU, spec.DefaultResources.CPU},
+		{"MAX_WORKSPACE_MEMORY", &maxResources.Memory, spec.DefaultResources.Memory},
+		{"MAX_WORKSPACE_STORAGE", &maxResources.Storage, spec.DefaultResources.Storage},
+	}
+	for _, m := range maximums {
+		value := os.Getenv(m.env)
+		if value == "" {
+			continue
+		}
+		quantity, err := resource.ParseQuantity(value)
+		if err != nil || quantity.Cmp(resource.MustParse(m.fallback)) < 0 {
+			return nil, fmt.Errorf("invalid %v %v", strings.ToLower(strings.ReplaceAll(m.env, "_", " ")), value)
+		}
+		*m.max = value
+	}
+
 	// Create config, including the oauthConfig
 	cfg := Config{
 		Environment: env,
@@ -62,6 +134,13 @@ func NewConfigFromEnv() (*Config, error) {
 		BackendURL:  apiUrl,
 		FrontendURL: appUrl,
 		Domain:      domain,
+		LogLevel:    logLevel,
+		MetricsPort: metricsPort,
+
+		WorkspaceURL: workspaceUrl,
+
+		MaxUploadSize: maxUploadSize,
+		MaxResources:  maxResources,
 	}
 
 	return &cfg, nil
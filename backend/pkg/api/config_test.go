@@ -2,8 +2,10 @@ package api
 
 import (
 	"fmt"
+	"log/slog"
 	"testing"
 
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/controller/spec"
 	"github.com/stretchr/testify/require"
 )
 
@@ -15,15 +17,18 @@ func TestNewConfigFromEnv(t *testing.T) {
 		apiUrl      string
 		appUrl      string
 		domain      string
+		logLevel    string
 		wantConfig  *Config
 		wantErr     error
 	}{
-		{"Normal config", "Production", "8090", "foo.com/api", "foo.com", "foo.com", &Config{"production", 8090, "foo.com/api", "foo.com", "foo.com"}, nil},
-		{"Missing ENV variable", "", "8090", "foo.com/api", "foo.com", "foo.com", &Config{"development", 8090, "foo.com/api", "foo.com", "foo.com"}, nil},
-		{"Missing PORT variable", "production", "", "foo.com/api", "foo.com", "foo.com", &Config{"production", 8080, "foo.com/api", "foo.com", "foo.com"}, nil},
-		{"Missing API_URL variable", "production", "8090", "", "foo.com", "foo.com", nil, fmt.Errorf("API URL must be specified")},
-		{"Missing APP_URL variable", "production", "8090", "foo.com/api", "", "foo.com", nil, fmt.Errorf("app URL must be specified")},
-		{"Missing DOMAIN variable", "production", "8090", "foo.com/api", "foo.com", "", nil, fmt.Errorf("domain must be specified")},
+		{"Normal config", "Production", "8090", "foo.com/api", "foo.com", "foo.com", "", &Config{"production", 8090, "foo.com/api", "foo.com", "foo.com", slog.LevelInfo, 9090, "", defaultMaxUploadSize, defaultMaxResources}, nil},
+		{"Missing ENV variable", "", "8090", "foo.com/api", "foo.com", "foo.com", "", &Config{"development", 8090, "foo.com/api", "foo.com", "foo.com", slog.LevelInfo, 9090, "", defaultMaxUploadSize, defaultMaxResources}, nil},
+		{"Missing PORT variable", "production", "", "foo.com/api", "foo.com", "foo.com", "", &Config{"production", 8080, "foo.com/api", "foo.com", "foo.com", slog.LevelInfo, 9090, "", defaultMaxUploadSize, defaultMaxResources}, nil},
+		{"Debug LOG_LEVEL variable", "production", "8090", "foo.com/api", "foo.com", "foo.com", "debug", &Config{"production", 8090, "foo.com/api", "foo.com", "foo.com", slog.LevelDebug, 9090, "", defaultMaxUploadSize, defaultMaxResources}, nil},
+		{"Missing API_URL variable", "production", "8090", "", "foo.com", "foo.com", "", nil, fmt.Errorf("API URL must be specified")},
+		{"Missing APP_URL variable", "production", "8090", "foo.com/api", "", "foo.com", "", nil, fmt.Errorf("app URL must be specified")},
+		{"Missing DOMAIN variable", "production", "8090", "foo.com/api", "foo.com", "", "", nil, fmt.Errorf("domain must be specified")},
+		{"Invalid LOG_LEVEL variable", "production", "8090", "foo.com/api", "foo.com", "foo.com", "verbose", nil, fmt.Errorf(`unable to load log level verbose: slog: level string "verbose": unknown name`)},
 	}
 
 	for _, test := range tests {
@@ -33,6 +38,7 @@ func TestNewConfigFromEnv(t *testing.T) {
 			t.Setenv("API_URL", test.apiUrl)
 			t.Setenv("APP_URL", test.appUrl)
 			t.Setenv("DOMAIN", test.domain)
+			t.Setenv("LOG_LEVEL", test.logLevel)
 
 			haveConfig, haveErr := NewConfigFromEnv()
 
@@ -48,3 +54,54 @@ func TestNewConfigFromEnv(t *testing.T) {
 		})
 	}
 }
+
+func TestNewConfigFromEnvWorkspaceURL(t *testing.T) {
+	t.Setenv("API_URL", "foo.com/api")
+	t.Setenv("APP_URL", "foo.com")
+	t.Setenv("DOMAIN", "foo.com")
+
+	t.Setenv("WORKSPACE_URL", "https://{id}.workspaces.foo.com")
+	cfg, err := NewConfigFromEnv()
+	require.Nil(t, err)
+	require.Equal(t, "https://{id}.workspaces.foo.com", cfg.WorkspaceURL)
+
+	t.Setenv("WORKSPACE_URL", "https://workspaces.foo.com")
+	_, err = NewConfigFromEnv()
+	require.Equal(t, fmt.Errorf("workspace URL must contain {id}"), err)
+}
+
+func TestNewConfigFromEnvMaxUploadSize(t *testing.T) {
+	t.Setenv("API_URL", "foo.com/api")
+	t.Setenv("APP_URL", "foo.com")
+	t.Setenv("DOMAIN", "foo.com")
+
+	t.Setenv("MAX_UPLOAD_SIZE", "5Gi")
+	cfg, err := NewConfigFromEnv()
+	require.Nil(t, err)
+	require.Equal(t, int64(5<<30), cfg.MaxUploadSize)
+
+	t.Setenv("MAX_UPLOAD_SIZE", "lots")
+	_, err = NewConfigFromEnv()
+	require.Equal(t, fmt.Errorf("invalid max upload size lots"), err)
+}
+
+func TestNewConfigFromEnvMaxResources(t *testing.T) {
+	t.Setenv("API_URL", "foo.com/api")
+	t.Setenv("APP_URL", "foo.com")
+	t.Setenv("DOMAIN", "foo.com")
+
+	t.Setenv("MAX_WORKSPACE_CPU", "4")
+	t.Setenv("MAX_WORKSPACE_STORAGE", "1Ti")
+	cfg, err := NewConfigFromEnv()
+	require.Nil(t, err)
+	require.Equal(t, spec.Resources{CPU: "4", Memory: defaultMaxResources.Memory, Storage: "1Ti"}, cfg.MaxResources)
+
+	t.Setenv("MAX_WORKSPACE_MEMORY", "lots")
+	_, err = NewConfigFromEnv()
+	require.Equal(t, fmt.Errorf("invalid max workspace memory lots"), err)
+
+	// Workspaces without resources of their own would exceed the maximum
+	t.Setenv("MAX_WORKSPACE_MEMORY", "1Gi")
+	_, err = NewConfigFromEnv()
+	require.Equal(t, fmt.Errorf("invalid max workspace memory 1Gi"), err)
+}
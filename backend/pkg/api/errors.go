@@ -0,0 +1,16 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/apierror"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/logging"
+)
+
+// abortWithError responds to the request with the problem e describes and
+// stops the request's remaining handlers. The cause of e is left out of the
+// response, so handlers log it first.
+func abortWithError(c *gin.Context, e *apierror.Error) {
+	c.Abort()
+	c.Header("Content-Type", apierror.ContentType)
+	c.IndentedJSON(e.Status, e.Problem(logging.RequestID(c.Request.Context())))
+}
@@ -0,0 +1,51 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/apierror"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAbortWithError(t *testing.T) {
+	router := gin.New()
+	router.Use(requestIDMiddleware())
+	router.NoRoute(noRouteHandler)
+	router.GET("/teams", func(c *gin.Context) {
+		abortWithError(c, apierror.Forbidden())
+	}, func(c *gin.Context) {
+		t.Error("handler ran after the request was aborted")
+	})
+
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+		wantCode   string
+	}{
+		{"handler error", "/teams", http.StatusForbidden, apierror.CodeForbidden},
+		{"no route", "/nowhere", http.StatusNotFound, apierror.CodeNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.Header.Set(requestIDHeader, "abc")
+			router.ServeHTTP(w, req)
+
+			require.Equal(t, tt.wantStatus, w.Code)
+			require.Equal(t, apierror.ContentType, w.Header().Get("Content-Type"))
+
+			var problem apierror.Problem
+			require.Nil(t, json.Unmarshal(w.Body.Bytes(), &problem))
+			require.Equal(t, tt.wantCode, problem.Code)
+			require.Equal(t, tt.wantStatus, problem.Status)
+			require.Equal(t, "abc", problem.RequestID)
+		})
+	}
+}
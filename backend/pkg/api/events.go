@@ -0,0 +1,129 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/controller/spec"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/events"
+)
+
+// Interval between comments sent to keep idle event streams open.
+const eventsHeartbeat = 15 * time.Second
+
+// Time clients wait before reconnecting to a closed event stream.
+const eventsRetry = 3 * time.Second
+
+// getEventsHandler streams changes to the user's workspaces as Server-Sent
+// Events. Clients reconnecting with a Last-Event-ID header receive the events
+// they missed. Event IDs are only known to the replica that assigned them, so
+// a client reconnecting to another replica receives the latest status of each
+// of its workspaces instead, and should refetch its workspaces to catch up on
+// other changes.
+func (s *Server) getEventsHandler(c *gin.Context) {
+	userId := c.MustGet("user").(int32)
+
+	sub := s.broker.Subscribe(userId, c.GetHeader("Last-Event-ID"))
+	defer sub.Close()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // Keep proxies from buffering the stream
+	c.Status(http.StatusOK)
+
+	fmt.Fprintf(c.Writer, "retry: %d\n\n", eventsRetry.Milliseconds())
+	if err := flush(c); err != nil {
+		slog.ErrorContext(c.Request.Context(), "error starting event stream", "err", err)
+		return
+	}
+
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+
+	// gin's Stream relies on http.CloseNotifier, which the session
+	// middleware's writer doesn't implement, so the request's context is
+	// watched instead.
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(c.Writer, ": heartbeat\n\n")
+		case event, ok := <-sub.Events():
+			if !ok {
+				// The subscription fell behind; the client resumes from its last event
+				return
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				slog.ErrorContext(c.Request.Context(), "error encoding event", "event", event.ID, "err", err)
+				continue
+			}
+			fmt.Fprintf(c.Writer, "id: %s\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+		}
+
+		if err := flush(c); err != nil {
+			return
+		}
+	}
+}
+
+// flush sends what has been written of a response to the client. The session
+// middleware's writer doesn't implement http.Flusher, so the writer under
+// gin's is flushed through an http.ResponseController instead.
+func flush(c *gin.Context) error {
+	w := http.ResponseWriter(c.Writer)
+	if u, ok := w.(interface{ Unwrap() http.ResponseWriter }); ok {
+		c.Writer.WriteHeaderNow()
+		w = u.Unwrap()
+	}
+	return http.NewResponseController(w).Flush()
+}
+
+// publishWorkspaceEvent sends an event to every user with a role on a
+// workspace. Failures are logged, since the change itself has succeeded.
+func (s *Server) publishWorkspaceEvent(ctx context.Context, eventType string, workspaceId int32) {
+	users, err := s.repository.ListWorkspaceUsers(ctx, workspaceId)
+	if err != nil {
+		slog.ErrorContext(ctx, "error retrieving users of workspace", "workspace", workspaceId, "err", err)
+		return
+	}
+
+	s.publishEvent(events.Event{Type: eventType, Workspace: workspaceId, Users: users})
+}
+
+// publishEvent sends an event to the subscribers of every replica.
+func (s *Server) publishEvent(event events.Event) {
+	if err := s.broker.Publish(event); err != nil {
+		slog.Error("error publishing event", "err", err)
+	}
+}
+
+// watchWorkspaces delivers status changes of workspace pods to the users of
+// the workspaces until the context is canceled. Every replica watches the
+// cluster, so status events are only delivered locally.
+func (s *Server) watchWorkspaces(ctx context.Context) {
+	err := s.controller.WatchWorkspaces(ctx, func(status spec.WorkspaceStatus) {
+		users, err := s.repository.ListWorkspaceUsers(ctx, status.ID)
+		if err != nil {
+			slog.ErrorContext(ctx, "error retrieving users of workspace", "workspace", status.ID, "err", err)
+			return
+		}
+
+		s.broker.Deliver(events.Event{
+			Type:      events.WorkspaceStatus,
+			Workspace: status.ID,
+			Status:    status.Phase,
+			Users:     users,
+		})
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "error watching workspaces", "err", err)
+	}
+}
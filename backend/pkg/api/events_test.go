@@ -0,0 +1,57 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/events"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetEventsHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	broker := events.NewBroker(16)
+	s := &Server{broker: broker}
+
+	router := gin.New()
+	router.GET("/user/events", func(c *gin.Context) {
+		c.Set("user", int32(1))
+	}, s.getEventsHandler)
+
+	// An event the client has already seen, and one it missed
+	sub := broker.Subscribe(1, "")
+	broker.Deliver(events.Event{Type: events.WorkspaceStatus, Workspace: 4, Status: "Pending", Users: []int32{1}})
+	seen := <-sub.Events()
+	sub.Close()
+	broker.Deliver(events.Event{Type: events.WorkspaceStatus, Workspace: 4, Status: "Running", Users: []int32{1}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodGet, "/user/events", nil).WithContext(ctx)
+	req.Header.Set("Last-Event-ID", seen.ID)
+	w := httptest.NewRecorder()
+
+	done := make(chan struct{})
+	go func() {
+		router.ServeHTTP(w, req)
+		close(done)
+	}()
+
+	// Stop the stream once the client disconnects
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	<-done
+
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+
+	body := w.Body.String()
+	require.True(t, strings.HasPrefix(body, "retry: 3000\n\n"))
+	require.Contains(t, body, "event: workspace.status\ndata: {\"type\":\"workspace.status\",\"workspace\":4,\"status\":\"Running\"}\n\n")
+	require.NotContains(t, body, "Pending")
+}
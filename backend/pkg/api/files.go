@@ -0,0 +1,664 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/apierror"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/controller/spec"
+)
+
+// Where a workspace's volume is mounted for commands on its files.
+const workspaceRoot = "/workspace"
+
+// Maximum number of entries listed in a directory.
+const maxListedFiles = 1000
+
+// Exit statuses of the scripts files are served with, telling why they
+// failed.
+const (
+	exitNotFound     = 3
+	exitExists       = 4
+	exitNotDirectory = 5
+)
+
+// filesPrelude is run before every script on a workspace's files. inside
+// exits with exitNotFound unless a path resolves to the workspace's volume,
+// so that symbolic links can't reach out of it. chown gives a new file the
+// volume's owner, since files pods run as root.
+const filesPrelude = `inside() { case "$(realpath "$1" 2>/dev/null)" in /workspace|/workspace/*) ;; *) exit 3;; esac; }
+own() { chown "$(stat -c %u:%g /workspace)" "$1" 2>/dev/null || :; }
+`
+
+// Scripts run on a workspace's files, given cleaned paths under
+// workspaceRoot as their arguments. Each prints the stat of the file it
+// returns, formatted as statFormat, and the list script prints one per
+// entry, with the entry's name.
+const (
+	statFormat = `%f %s %Y`
+
+	statScript = `inside "$1"
+stat -L -c '` + statFormat + `' "$1"`
+	listScript = `inside "$1"
+[ -d "$1" ] || exit 5
+cd "$1" && find . -mindepth 1 -maxdepth 1 -exec stat -c '` + statFormat + ` %n' {} + | head -n "$2"`
+	readScript = `inside "$1"
+[ -f "$1" ] || exit 5
+if [ "$#" -eq 1 ]; then cat "$1"; else tail -c "+$2" "$1" | head -c "$3"; fi`
+	writeScript = `inside "$1"
+[ -d "$1" ] || exit 5
+cat > "$2" || exit 1
+own "$2"`
+	commitScript = `[ -d "$2" ] && { rm -f "$1"; exit 4; }
+mv -f "$1" "$2" && stat -c '` + statFormat + `' "$2"`
+	mkdirScript = `inside "$(dirname "$1")"
+[ -d "$(dirname "$1")" ] || exit 5
+[ -e "$1" ] || [ -L "$1" ] && exit 4
+mkdir "$1" && own "$1" && stat -c '` + statFormat + `' "$1"`
+	moveScript = `inside "$(dirname "$1")"
+[ -e "$1" ] || [ -L "$1" ] || exit 3
+inside "$(dirname "$2")"
+[ -d "$(dirname "$2")" ] || exit 5
+[ -e "$2" ] || [ -L "$2" ] && exit 4
+mv "$1" "$2" && stat -c '` + statFormat + `' "$2"`
+	deleteScript = `inside "$(dirname "$1")"
+[ -e "$1" ] || [ -L "$1" ] || exit 3
+rm -rf "$1"`
+)
+
+// Types of files, from the type bits of their mode.
+const (
+	fileRegular   = "file"
+	fileDirectory = "directory"
+	fileSymlink   = "symlink"
+	fileOther     = "other"
+)
+
+type moveFileForm struct {
+	Path string `json:"path"`
+}
+
+// valid checks if a moveFileForm struct is valid, given the path of the
+// file it moves. It returns a map[string]string containing any problems.
+func (f *moveFileForm) valid(from string) (problems map[string]string) {
+	problems = make(map[string]string)
+
+	to, ok := filePath(f.Path)
+	switch {
+	case f.Path == "":
+		problems["path"] = "Path must be specified"
+	case !ok:
+		problems["path"] = "Path must not contain NUL characters"
+	case to == workspaceRoot || to == from:
+		problems["path"] = "Path must differ from the file's path and the workspace's root"
+	case strings.HasPrefix(to, from+"/"):
+		problems["path"] = "Path must not be inside the moved directory"
+	}
+
+	return problems
+}
+
+// filePath returns the path in a workspace's volume that a path from a
+// request refers to. Paths are cleaned as if rooted at the volume, so that
+// they can't refer to anything outside of it. It returns false for paths
+// no file can have.
+func filePath(p string) (string, bool) {
+	if strings.ContainsRune(p, 0) {
+		return "", false
+	}
+	return path.Join(workspaceRoot, path.Clean("/"+p)), true
+}
+
+// relativePath returns a path in a workspace's volume as clients see it,
+// rooted at the volume.
+func relativePath(p string) string {
+	if p == workspaceRoot {
+		return "/"
+	}
+	return strings.TrimPrefix(p, workspaceRoot)
+}
+
+// fileType returns the type of a file from its mode, in hexadecimal.
+func fileType(mode string) (string, error) {
+	bits, err := strconv.ParseUint(mode, 16, 32)
+	if err != nil {
+		return "", fmt.Errorf("invalid mode %v", mode)
+	}
+	switch bits & 0o170000 {
+	case 0o100000:
+		return fileRegular, nil
+	case 0o040000:
+		return fileDirectory, nil
+	case 0o120000:
+		return fileSymlink, nil
+	}
+	return fileOther, nil
+}
+
+// parseFileStat returns the file at path p from its stat, formatted as
+// statFormat.
+func parseFileStat(stat string, p string) (fileResponse, error) {
+	fields := strings.Fields(stat)
+	if len(fields) != 3 {
+		return fileResponse{}, fmt.Errorf("invalid stat %q", stat)
+	}
+
+	kind, err := fileType(fields[0])
+	if err != nil {
+		return fileResponse{}, err
+	}
+	size, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return fileResponse{}, fmt.Errorf("invalid size %v", fields[1])
+	}
+	modified, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return fileResponse{}, fmt.Errorf("invalid modification time %v", fields[2])
+	}
+
+	return fileResponse{
+		Name:       path.Base(p),
+		Path:       relativePath(p),
+		Type:       kind,
+		Size:       size,
+		ModifiedAt: time.Unix(modified, 0).UTC(),
+	}, nil
+}
+
+// parseListing returns the entries of the directory at path p from the
+// output of listScript, sorted by name. Lines that don't parse, such as
+// parts of names with newlines, are skipped.
+func parseListing(output string, p string) []fileResponse {
+	files := []fileResponse{}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.SplitN(line, " ", 4)
+		if len(fields) != 4 || !strings.HasPrefix(fields[3], "./") {
+			continue
+		}
+		file, err := parseFileStat(strings.Join(fields[:3], " "), path.Join(p, strings.TrimPrefix(fields[3], "./")))
+		if err != nil {
+			continue
+		}
+		files = append(files, file)
+	}
+
+	slices.SortFunc(files, func(a, b fileResponse) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return files
+}
+
+// byteRange is the part of a file a Range header requests.
+type byteRange struct {
+	start  int64
+	length int64
+}
+
+// parseRange returns the range of a file of the given size requested by a
+// Range header. It returns nil if the whole file should be sent, since
+// there is no header or it asks for several ranges, and an error if the
+// range can't be satisfied.
+func parseRange(header string, size int64) (*byteRange, error) {
+	ranges, found := strings.CutPrefix(header, "bytes=")
+	if header == "" || strings.Contains(ranges, ",") {
+		return nil, nil
+	}
+	if !found {
+		return nil, fmt.Errorf("invalid range %v", header)
+	}
+
+	first, last, found := strings.Cut(strings.TrimSpace(ranges), "-")
+	if !found {
+		return nil, fmt.Errorf("invalid range %v", header)
+	}
+
+	if first == "" {
+		// The last bytes of the file
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 || size == 0 {
+			return nil, fmt.Errorf("invalid range %v", header)
+		}
+		n = min(n, size)
+		return &byteRange{start: size - n, length: n}, nil
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return nil, fmt.Errorf("invalid range %v", header)
+	}
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return nil, fmt.Errorf("invalid range %v", header)
+		}
+		end = min(end, size-1)
+	}
+	return &byteRange{start: start, length: end - start + 1}, nil
+}
+
+// validUploadName reports whether a file can be uploaded with the name a
+// client gave it.
+func validUploadName(name string) bool {
+	return name != "" && name != "." && name != ".." && len(name) <= 255 &&
+		!strings.ContainsAny(name, "/\x00")
+}
+
+// filesError returns the Error a failed script on a workspace's files
+// causes, mapping the exit statuses of the scripts.
+func filesError(err error, detail string) *apierror.Error {
+	var exitErr *spec.ExitError
+	if errors.As(err, &exitErr) {
+		switch exitErr.Code {
+		case exitNotFound:
+			return apierror.NotFound("file not found")
+		case exitExists:
+			return apierror.Conflict(apierror.CodeAlreadyExists, "file already exists")
+		case exitNotDirectory:
+			return apierror.BadRequest("path is not a directory")
+		}
+	}
+
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		return apierror.New(http.StatusRequestEntityTooLarge, apierror.CodeTooLarge, fmt.Sprintf("uploads are limited to %d bytes", maxErr.Limit))
+	}
+
+	return apierror.From(err, detail)
+}
+
+// uploadReader reads a file being uploaded, keeping the error that ended
+// it. Commands see any end of their input as the end of the file, so the
+// error has to be checked once they're done.
+type uploadReader struct {
+	r   io.Reader
+	err error
+}
+
+func (u *uploadReader) Read(p []byte) (int, error) {
+	n, err := u.r.Read(p)
+	if err != nil && err != io.EOF {
+		u.err = err
+	}
+	return n, err
+}
+
+// execFiles runs a script on a workspace's files, with args as its
+// positional parameters.
+func (s *Server) execFiles(ctx context.Context, workspace spec.Workspace, script string, args []string, stdin io.Reader, stdout io.Writer) error {
+	command := append([]string{"sh", "-c", filesPrelude + script, "sh"}, args...)
+	return s.controller.ExecWorkspace(ctx, workspace, command, stdin, stdout)
+}
+
+// filesWorkspace returns the spec of the workspace whose files are
+// requested, aborting the request if the workspace's volume is still being
+// populated.
+func (s *Server) filesWorkspace(c *gin.Context) (spec.Workspace, bool) {
+	workspaceId := c.MustGet("workspace").(int32)
+
+	workspace, err := s.repository.FindWorkspaceWithId(c.Request.Context(), workspaceId)
+	if err == pgx.ErrNoRows {
+		abortWithError(c, apierror.NotFound("workspace not found"))
+		return spec.Workspace{}, false
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error retrieving workspace", "err", err)
+		abortWithError(c, apierror.From(err, "error retrieving workspace"))
+		return spec.Workspace{}, false
+	}
+	if workspaceBusy(workspace.State) {
+		abortWithError(c, apierror.Conflict(apierror.CodeNotReady, fmt.Sprintf("workspace is %v", workspace.State)))
+		return spec.Workspace{}, false
+	}
+
+	workspaceSpec, err := s.workspaceSpec(c.Request.Context(), workspace)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error retrieving workspace", "err", err)
+		abortWithError(c, apierror.From(err, "error retrieving workspace"))
+		return spec.Workspace{}, false
+	}
+	return workspaceSpec, true
+}
+
+// getFileHandler lists a directory of a workspace's volume, or downloads a
+// file. Downloads honor single Range headers.
+func (s *Server) getFileHandler(c *gin.Context) {
+	p, ok := filePath(c.Param("path"))
+	if !ok {
+		abortWithError(c, apierror.NotFound("file not found"))
+		return
+	}
+
+	workspace, ok := s.filesWorkspace(c)
+	if !ok {
+		return
+	}
+
+	var stat strings.Builder
+	if err := s.execFiles(c.Request.Context(), workspace, statScript, []string{p}, nil, &stat); err != nil {
+		slog.ErrorContext(c.Request.Context(), "error reading workspace file", "path", p, "err", err)
+		abortWithError(c, filesError(err, "error reading file"))
+		return
+	}
+	file, err := parseFileStat(stat.String(), p)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error reading workspace file", "path", p, "err", err)
+		abortWithError(c, apierror.Internal("error reading file", err))
+		return
+	}
+
+	switch file.Type {
+	case fileDirectory:
+		s.listFiles(c, workspace, p)
+	case fileRegular:
+		s.downloadFile(c, workspace, file, p)
+	default:
+		abortWithError(c, apierror.BadRequest("path is not a file or directory"))
+	}
+}
+
+// listFiles responds with the entries of a directory of a workspace's
+// volume. Directories with more than maxListedFiles entries are truncated.
+func (s *Server) listFiles(c *gin.Context, workspace spec.Workspace, p string) {
+	var output strings.Builder
+	err := s.execFiles(c.Request.Context(), workspace, listScript, []string{p, fmt.Sprint(maxListedFiles + 1)}, nil, &output)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error listing workspace files", "path", p, "err", err)
+		abortWithError(c, filesError(err, "error listing files"))
+		return
+	}
+
+	files := parseListing(output.String(), p)
+	truncated := len(files) > maxListedFiles
+	if truncated {
+		files = files[:maxListedFiles]
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"path": relativePath(p), "files": files, "truncated": truncated})
+}
+
+// downloadFile streams a file of a workspace's volume, or the part of it a
+// Range header requests.
+func (s *Server) downloadFile(c *gin.Context, workspace spec.Workspace, file fileResponse, p string) {
+	requested, err := parseRange(c.GetHeader("Range"), file.Size)
+	if err != nil {
+		c.Header("Content-Range", fmt.Sprintf("bytes */%d", file.Size))
+		abortWithError(c, apierror.New(http.StatusRequestedRangeNotSatisfiable, apierror.CodeInvalidRequest, "range not satisfiable"))
+		return
+	}
+
+	status := http.StatusOK
+	length := file.Size
+	args := []string{p}
+	if requested != nil {
+		status = http.StatusPartialContent
+		length = requested.length
+		// tail counts bytes from 1
+		args = append(args, fmt.Sprint(requested.start+1), fmt.Sprint(requested.length))
+	}
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/octet-stream")
+	header.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": file.Name}))
+	header.Set("Content-Length", fmt.Sprint(length))
+	header.Set("Accept-Ranges", "bytes")
+	header.Set("Last-Modified", file.ModifiedAt.Format(http.TimeFormat))
+	if requested != nil {
+		header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", requested.start, requested.start+requested.length-1, file.Size))
+	}
+
+	// The response starts with the first bytes of the file, so that failures
+	// before then are still reported
+	w := &downloadWriter{ResponseWriter: c.Writer, status: status, header: header}
+	err = s.execFiles(c.Request.Context(), workspace, readScript, args, nil, w)
+	if err != nil && !w.started {
+		slog.ErrorContext(c.Request.Context(), "error downloading workspace file", "path", p, "err", err)
+		abortWithError(c, filesError(err, "error downloading file"))
+		return
+	}
+	if err != nil {
+		// The response can only be cut short
+		slog.ErrorContext(c.Request.Context(), "error downloading workspace file", "path", p, "err", err)
+		c.Abort()
+		return
+	}
+	// Empty files have no first bytes
+	w.start()
+}
+
+// downloadWriter starts a download's response with its status and headers
+// once the first bytes of the file are written to it.
+type downloadWriter struct {
+	gin.ResponseWriter
+	status  int
+	header  http.Header
+	started bool
+}
+
+func (w *downloadWriter) Write(data []byte) (int, error) {
+	w.start()
+	return w.ResponseWriter.Write(data)
+}
+
+// start writes the response's status and headers, unless they're written.
+func (w *downloadWriter) start() {
+	if w.started {
+		return
+	}
+	w.started = true
+	for key, values := range w.header {
+		w.Header()[key] = values
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+// postFilesHandler uploads the files of a multipart form into a directory of
+// a workspace's volume, replacing files with the same names. Files are
+// streamed into the volume, each into a temporary file renamed once it's
+// complete, so that interrupted uploads leave no partial files. Forms
+// larger than MaxUploadSize are rejected.
+func (s *Server) postFilesHandler(c *gin.Context) {
+	dir, ok := filePath(c.Param("path"))
+	if !ok {
+		abortWithError(c, apierror.NotFound("directory not found"))
+		return
+	}
+
+	workspace, ok := s.filesWorkspace(c)
+	if !ok {
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, s.config.MaxUploadSize)
+	form, err := c.Request.MultipartReader()
+	if err != nil {
+		abortWithError(c, apierror.BadRequest("request must be a multipart form"))
+		return
+	}
+
+	uploaded := []fileResponse{}
+	for {
+		part, err := form.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			slog.InfoContext(c.Request.Context(), "error reading upload", "err", err)
+			abortWithError(c, filesError(err, "error reading upload"))
+			return
+		}
+
+		name := part.FileName()
+		if name == "" {
+			// Fields other than files are ignored
+			continue
+		}
+		if !validUploadName(name) {
+			abortWithError(c, apierror.Invalid(map[string]string{part.FormName(): "File name is invalid"}))
+			return
+		}
+
+		file, err := s.uploadFile(c.Request.Context(), workspace, dir, name, part)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "error uploading workspace file", "path", path.Join(dir, name), "err", err)
+			abortWithError(c, filesError(err, "error uploading file"))
+			return
+		}
+		uploaded = append(uploaded, file)
+	}
+
+	auditDetail(c, "files", len(uploaded))
+	c.IndentedJSON(http.StatusOK, uploaded)
+}
+
+// uploadFile streams a file into a directory of a workspace's volume
+// through a temporary file, which is removed if the upload fails.
+func (s *Server) uploadFile(ctx context.Context, workspace spec.Workspace, dir string, name string, r io.Reader) (fileResponse, error) {
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return fileResponse{}, err
+	}
+	tmp := path.Join(dir, ".upload-"+hex.EncodeToString(suffix))
+	target := path.Join(dir, name)
+
+	upload := &uploadReader{r: r}
+	err := s.execFiles(ctx, workspace, writeScript, []string{dir, tmp}, upload, io.Discard)
+	if err == nil {
+		err = upload.err
+	}
+
+	var stat strings.Builder
+	if err == nil {
+		err = s.execFiles(ctx, workspace, commitScript, []string{tmp, target}, nil, &stat)
+	}
+	if err != nil {
+		// The request may be gone, but the temporary file must not stay
+		if err := s.execFiles(context.WithoutCancel(ctx), workspace, "rm -f \"$1\"", []string{tmp}, nil, io.Discard); err != nil {
+			slog.WarnContext(ctx, "error removing partial upload", "path", tmp, "err", err)
+		}
+		return fileResponse{}, err
+	}
+
+	return parseFileStat(stat.String(), target)
+}
+
+// putFileHandler creates a directory in a workspace's volume. Its parent
+// must exist.
+func (s *Server) putFileHandler(c *gin.Context) {
+	p, ok := filePath(c.Param("path"))
+	if !ok {
+		abortWithError(c, apierror.NotFound("file not found"))
+		return
+	}
+	if p == workspaceRoot {
+		abortWithError(c, apierror.Conflict(apierror.CodeAlreadyExists, "file already exists"))
+		return
+	}
+
+	workspace, ok := s.filesWorkspace(c)
+	if !ok {
+		return
+	}
+
+	var stat strings.Builder
+	if err := s.execFiles(c.Request.Context(), workspace, mkdirScript, []string{p}, nil, &stat); err != nil {
+		slog.ErrorContext(c.Request.Context(), "error creating workspace directory", "path", p, "err", err)
+		abortWithError(c, filesError(err, "error creating directory"))
+		return
+	}
+
+	s.respondWithFile(c, stat.String(), p)
+}
+
+// patchFileHandler moves a file or directory of a workspace's volume to
+// another path in the volume, whose parent must exist. Existing files
+// aren't replaced.
+func (s *Server) patchFileHandler(c *gin.Context) {
+	from, ok := filePath(c.Param("path"))
+	if !ok {
+		abortWithError(c, apierror.NotFound("file not found"))
+		return
+	}
+	if from == workspaceRoot {
+		abortWithError(c, apierror.BadRequest("the workspace's root can't be moved"))
+		return
+	}
+
+	moveParams := moveFileForm{}
+	c.ShouldBind(&moveParams)
+
+	if problems := moveParams.valid(from); len(problems) > 0 {
+		slog.InfoContext(c.Request.Context(), "move file param problems", "problems", problems)
+		abortWithError(c, apierror.Invalid(problems))
+		return
+	}
+	to, _ := filePath(moveParams.Path)
+	auditDetail(c, "to", relativePath(to))
+
+	workspace, ok := s.filesWorkspace(c)
+	if !ok {
+		return
+	}
+
+	var stat strings.Builder
+	if err := s.execFiles(c.Request.Context(), workspace, moveScript, []string{from, to}, nil, &stat); err != nil {
+		slog.ErrorContext(c.Request.Context(), "error moving workspace file", "path", from, "to", to, "err", err)
+		abortWithError(c, filesError(err, "error moving file"))
+		return
+	}
+
+	s.respondWithFile(c, stat.String(), to)
+}
+
+// deleteFileHandler removes a file or directory of a workspace's volume,
+// along with everything in it.
+func (s *Server) deleteFileHandler(c *gin.Context) {
+	p, ok := filePath(c.Param("path"))
+	if !ok {
+		abortWithError(c, apierror.NotFound("file not found"))
+		return
+	}
+	if p == workspaceRoot {
+		abortWithError(c, apierror.BadRequest("the workspace's root can't be removed"))
+		return
+	}
+
+	workspace, ok := s.filesWorkspace(c)
+	if !ok {
+		return
+	}
+
+	if err := s.execFiles(c.Request.Context(), workspace, deleteScript, []string{p}, nil, io.Discard); err != nil {
+		slog.ErrorContext(c.Request.Context(), "error removing workspace file", "path", p, "err", err)
+		abortWithError(c, filesError(err, "error removing file"))
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+// respondWithFile responds with the file at path p from its stat.
+func (s *Server) respondWithFile(c *gin.Context, stat string, p string) {
+	file, err := parseFileStat(stat, p)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error reading workspace file", "path", p, "err", err)
+		abortWithError(c, apierror.Internal("error reading file", err))
+		return
+	}
+	c.IndentedJSON(http.StatusOK, file)
+}
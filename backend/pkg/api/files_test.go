@@ -0,0 +1,145 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/apierror"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/controller/spec"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilePath(t *testing.T) {
+	tests := []struct {
+		param  string
+		want   string
+		wantOk bool
+	}{
+		{"/", "/workspace", true},
+		{"", "/workspace", true},
+		{"/data/a.csv", "/workspace/data/a.csv", true},
+		{"/data//results/", "/workspace/data/results", true},
+		{"/../../etc/passwd", "/workspace/etc/passwd", true},
+		{"/data/../../..", "/workspace", true},
+		{"/data/a\x00.csv", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.param, func(t *testing.T) {
+			have, ok := filePath(tt.param)
+			require.Equal(t, tt.wantOk, ok)
+			require.Equal(t, tt.want, have)
+		})
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		header  string
+		want    *byteRange
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"bytes=0-99", &byteRange{start: 0, length: 100}, false},
+		{"bytes=100-", &byteRange{start: 100, length: 900}, false},
+		{"bytes=900-5000", &byteRange{start: 900, length: 100}, false},
+		{"bytes=-100", &byteRange{start: 900, length: 100}, false},
+		{"bytes=-5000", &byteRange{start: 0, length: 1000}, false},
+		{"bytes=0-9,20-29", nil, false},
+		{"bytes=1000-", nil, true},
+		{"bytes=10-5", nil, true},
+		{"bytes=-0", nil, true},
+		{"bytes=abc", nil, true},
+		{"lines=0-9", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			have, err := parseRange(tt.header, 1000)
+			require.Equal(t, tt.wantErr, err != nil, "%v", err)
+			require.Equal(t, tt.want, have)
+		})
+	}
+}
+
+func TestParseListing(t *testing.T) {
+	output := "41ed 4096 1704067200 ./results\n" +
+		"81a4 11 1704067200 ./a b.csv\n" +
+		"a1ff 8 1704067200 ./latest\n" +
+		"garbage\n"
+
+	files := parseListing(output, "/workspace/data")
+	modified := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	require.Equal(t, []fileResponse{
+		{Name: "a b.csv", Path: "/data/a b.csv", Type: fileRegular, Size: 11, ModifiedAt: modified},
+		{Name: "latest", Path: "/data/latest", Type: fileSymlink, Size: 8, ModifiedAt: modified},
+		{Name: "results", Path: "/data/results", Type: fileDirectory, Size: 4096, ModifiedAt: modified},
+	}, files)
+
+	require.Equal(t, []fileResponse{}, parseListing("", "/workspace"))
+}
+
+func TestParseFileStat(t *testing.T) {
+	file, err := parseFileStat("41ed 4096 1704067200\n", "/workspace")
+	require.Nil(t, err)
+	require.Equal(t, "/", file.Path)
+	require.Equal(t, fileDirectory, file.Type)
+
+	_, err = parseFileStat("", "/workspace")
+	require.NotNil(t, err)
+}
+
+func TestMoveFileFormValid(t *testing.T) {
+	tests := []struct {
+		description string
+		path        string
+		wantProblem bool
+	}{
+		{"Normal move", "/archive/a.csv", false},
+		{"Rename", "/data/b.csv", false},
+		{"Missing path", "", true},
+		{"Same path", "/data/../data/a.csv", true},
+		{"Root", "/..", true},
+		{"Into itself", "/data/a.csv/b", true},
+		{"NUL", "/data/\x00", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			form := moveFileForm{Path: tt.path}
+			problems := form.valid("/workspace/data/a.csv")
+			require.Equal(t, tt.wantProblem, len(problems) > 0, "%v", problems)
+		})
+	}
+}
+
+func TestValidUploadName(t *testing.T) {
+	require.True(t, validUploadName("data.csv"))
+	require.True(t, validUploadName(".env"))
+	require.False(t, validUploadName(""))
+	require.False(t, validUploadName(".."))
+	require.False(t, validUploadName("a/b"))
+}
+
+func TestFilesError(t *testing.T) {
+	tests := []struct {
+		err        error
+		wantStatus int
+		wantCode   string
+	}{
+		{&spec.ExitError{Code: exitNotFound}, http.StatusNotFound, apierror.CodeNotFound},
+		{&spec.ExitError{Code: exitExists}, http.StatusConflict, apierror.CodeAlreadyExists},
+		{&spec.ExitError{Code: exitNotDirectory}, http.StatusBadRequest, apierror.CodeInvalidRequest},
+		{&spec.ExitError{Code: 1, Stderr: "No space left on device"}, http.StatusInternalServerError, apierror.CodeInternal},
+		{&http.MaxBytesError{Limit: 1 << 30}, http.StatusRequestEntityTooLarge, apierror.CodeTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.err.Error(), func(t *testing.T) {
+			e := filesError(tt.err, "error uploading file")
+			require.Equal(t, tt.wantStatus, e.Status)
+			require.Equal(t, tt.wantCode, e.Code)
+		})
+	}
+}
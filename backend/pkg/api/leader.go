@@ -0,0 +1,25 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/alexliesenfeld/health"
+)
+
+// leaderHealth adds whether the replica leads, and the workers it runs
+// while leading, to the info of health check results.
+func (s *Server) leaderHealth(next health.MiddlewareFunc) health.MiddlewareFunc {
+	return func(r *http.Request) health.CheckerResult {
+		result := next(r)
+
+		info := map[string]interface{}{}
+		for key, value := range result.Info {
+			info[key] = value
+		}
+		info["leader"] = s.leader.IsLeader()
+		info["workers"] = s.leader.Workers()
+		result.Info = info
+
+		return result
+	}
+}
@@ -0,0 +1,41 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alexliesenfeld/health"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/leader"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLeaderHealth(t *testing.T) {
+	manager := leader.NewManager(leader.Always{})
+	manager.Register("foo", func(ctx context.Context) { <-ctx.Done() })
+	s := &Server{leader: manager}
+
+	checker := health.NewChecker(health.WithInfo(map[string]interface{}{"version": "1"}))
+	handler := health.NewHandler(checker, health.WithMiddleware(s.leaderHealth))
+
+	info := func() map[string]interface{} {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+
+		var result health.CheckerResult
+		require.Nil(t, json.Unmarshal(w.Body.Bytes(), &result))
+		return result.Info
+	}
+
+	require.Equal(t, map[string]interface{}{"version": "1", "leader": false, "workers": []interface{}{"foo"}}, info())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go manager.Run(ctx)
+	require.Eventually(t, manager.IsLeader, time.Second, time.Millisecond)
+
+	require.Equal(t, true, info()["leader"])
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/apierror"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/logging"
+)
+
+// Header carrying the ID of a request, honored on requests and set on
+// responses.
+const requestIDHeader = "X-Request-ID"
+
+// Longest incoming request ID that is honored.
+const maxRequestIDLength = 128
+
+// requestIDMiddleware passes along the request's ID in its context, taking
+// it from the X-Request-ID header or generating one, and echoes it in the
+// response.
+func requestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+
+		c.Header(requestIDHeader, id)
+		c.Request = c.Request.WithContext(logging.WithRequestID(c.Request.Context(), id))
+		c.Next()
+	}
+}
+
+// validRequestID reports whether an incoming request ID is safe to log:
+// short and made of letters, digits, and the punctuation used in UUIDs
+// and trace IDs.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9':
+		case r == '-', r == '_', r == '.', r == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID generates a random request ID.
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// loggerMiddleware logs each request once it has been handled. Only the
+// path is logged, since query strings and headers may hold secrets such
+// as OAuth codes and session cookies.
+func loggerMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		if status >= 500 {
+			level = slog.LevelError
+		}
+
+		slog.LogAttrs(c.Request.Context(), level, "request",
+			slog.String("method", c.Request.Method),
+			slog.String("path", c.Request.URL.Path),
+			slog.String("route", c.FullPath()),
+			slog.Int("status", status),
+			slog.Int("bytes", c.Writer.Size()),
+			slog.Duration("duration", time.Since(start)),
+			slog.String("ip", c.ClientIP()),
+		)
+	}
+}
+
+// recoveryMiddleware turns panics into 500 responses. Unlike gin's
+// default recovery, it doesn't dump request headers into the log.
+func recoveryMiddleware() gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(io.Discard, func(c *gin.Context, err any) {
+		slog.ErrorContext(c.Request.Context(), "panic handling request", "err", err)
+		abortWithError(c, apierror.Internal("internal server error", nil))
+	})
+}
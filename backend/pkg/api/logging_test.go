@@ -0,0 +1,98 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/logging"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidRequestID(t *testing.T) {
+	tests := []struct {
+		id   string
+		want bool
+	}{
+		{"", false},
+		{"3fa85f64-5717-4562-b3fc-2c963f66afa6", true},
+		{"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", true},
+		{"abc def", false},
+		{"abc\n{\"level\":\"ERROR\"}", false},
+		{strings.Repeat("a", maxRequestIDLength), true},
+		{strings.Repeat("a", maxRequestIDLength+1), false},
+	}
+
+	for _, tt := range tests {
+		require.Equal(t, tt.want, validRequestID(tt.id), "ID %q", tt.id)
+	}
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	tests := []struct {
+		name     string
+		incoming string
+		honored  bool
+	}{
+		{"honors incoming ID", "abc-123", true},
+		{"generates missing ID", "", false},
+		{"replaces invalid ID", "abc 123", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.Use(requestIDMiddleware())
+
+			var seen string
+			router.GET("/", func(c *gin.Context) {
+				seen = logging.RequestID(c.Request.Context())
+			})
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.incoming != "" {
+				r.Header.Set(requestIDHeader, tt.incoming)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+
+			require.NotEmpty(t, seen)
+			require.Equal(t, seen, w.Header().Get(requestIDHeader))
+			if tt.honored {
+				require.Equal(t, tt.incoming, seen)
+			} else {
+				require.NotEqual(t, tt.incoming, seen)
+			}
+		})
+	}
+}
+
+func TestLoggerMiddleware(t *testing.T) {
+	var buf bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(logging.New(&buf, slog.LevelInfo))
+
+	router := gin.New()
+	router.Use(requestIDMiddleware(), loggerMiddleware())
+	router.GET("/auth/callback", func(c *gin.Context) {
+		c.Status(http.StatusTemporaryRedirect)
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/auth/callback?code=secret-code&state=secret-state", nil)
+	r.Header.Set(requestIDHeader, "abc")
+	r.Header.Set("Cookie", "session=secret-session")
+	router.ServeHTTP(httptest.NewRecorder(), r)
+
+	var line map[string]any
+	require.Nil(t, json.Unmarshal(buf.Bytes(), &line))
+	require.Equal(t, "request", line["msg"])
+	require.Equal(t, "abc", line["request_id"])
+	require.Equal(t, "/auth/callback", line["path"])
+	require.Equal(t, float64(http.StatusTemporaryRedirect), line["status"])
+	require.NotContains(t, buf.String(), "secret")
+}
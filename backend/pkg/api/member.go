@@ -0,0 +1,241 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/apierror"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/database/repository"
+)
+
+type postMemberForm struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+// valid checks if a postMemberForm struct is valid. It
+// returns a map[string]string containing any problems.
+func (f *postMemberForm) valid() (problems map[string]string) {
+	problems = make(map[string]string)
+
+	if f.Email == "" {
+		problems["email"] = "Email must be specified"
+	}
+
+	if _, ok := workspaceRoleRanks[f.Role]; !ok {
+		problems["role"] = "Role must be one of viewer, editor, or owner"
+	}
+
+	return problems
+}
+
+// validForWorkspace checks if a postMemberForm struct is valid for
+// adding a collaborator to a workspace. It returns a map[string]string
+// containing any problems.
+func (f *postMemberForm) validForWorkspace() (problems map[string]string) {
+	problems = f.valid()
+
+	if !workspaceMemberRoles[f.Role] {
+		problems["role"] = "Role must be one of viewer or editor"
+	}
+
+	return problems
+}
+
+type putMemberForm struct {
+	Role string `json:"role"`
+}
+
+// valid checks if a putMemberForm struct is valid. It
+// returns a map[string]string containing any problems.
+func (f *putMemberForm) valid() (problems map[string]string) {
+	problems = make(map[string]string)
+
+	if _, ok := workspaceRoleRanks[f.Role]; !ok {
+		problems["role"] = "Role must be one of viewer, editor, or owner"
+	}
+
+	return problems
+}
+
+// validForWorkspace checks if a putMemberForm struct is valid for
+// changing the role of a workspace collaborator. It returns a
+// map[string]string containing any problems.
+func (f *putMemberForm) validForWorkspace() (problems map[string]string) {
+	problems = f.valid()
+
+	if !workspaceMemberRoles[f.Role] {
+		problems["role"] = "Role must be one of viewer or editor"
+	}
+
+	return problems
+}
+
+// Roles collaborators of a workspace can be given. Only the workspace's
+// owner and the owners of its team hold the owner role, so collaborators
+// can't grant it to each other or remove one another.
+var workspaceMemberRoles = map[string]bool{roleViewer: true, roleEditor: true}
+
+// getMembersHandler lists the owner and collaborators
+// of a workspace.
+func (s *Server) getMembersHandler(c *gin.Context) {
+	workspaceId := c.MustGet("workspace").(int32)
+
+	members, err := s.repository.ListWorkspaceMembers(c.Request.Context(), workspaceId)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error retrieving members of workspace", "err", err)
+		abortWithError(c, apierror.From(err, "error retrieving members"))
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, newMemberResponses(members))
+}
+
+// postMemberHandler invites a user to a workspace by
+// their email address.
+func (s *Server) postMemberHandler(c *gin.Context) {
+	workspaceId := c.MustGet("workspace").(int32)
+
+	memberParams := postMemberForm{}
+	c.ShouldBind(&memberParams)
+
+	if problems := memberParams.validForWorkspace(); len(problems) > 0 {
+		slog.InfoContext(c.Request.Context(), "member param problems", "problems", problems)
+		abortWithError(c, apierror.Invalid(problems))
+		return
+	}
+
+	// Find the invited user
+	user, ok := s.invitedUser(c, memberParams.Email)
+	if !ok {
+		return
+	}
+
+	s.putMember(c, workspaceId, user.ID, memberParams.Role)
+}
+
+// invitedUser finds the user invited by their email address, writing the
+// error response if no user or several users have it. Users are identified
+// by their identity provider, so accounts with different providers may share
+// an email address; those are added by their ID instead.
+func (s *Server) invitedUser(c *gin.Context, email string) (repository.User, bool) {
+	users, err := s.repository.ListUsersWithEmail(c.Request.Context(), email)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error retrieving user with email", "email", email, "err", err)
+		abortWithError(c, apierror.From(err, "error retrieving user"))
+		return repository.User{}, false
+	}
+
+	switch len(users) {
+	case 0:
+		abortWithError(c, apierror.NotFound("user not found"))
+		return repository.User{}, false
+	case 1:
+		return users[0], true
+	default:
+		abortWithError(c, ambiguousEmail())
+		return repository.User{}, false
+	}
+}
+
+// ambiguousEmail returns the Error of invitations by an email address
+// several users have.
+func ambiguousEmail() *apierror.Error {
+	return apierror.Conflict(apierror.CodeAmbiguous, "several users have this email; add the member by their user ID")
+}
+
+// putMemberHandler changes the role of a workspace
+// member.
+func (s *Server) putMemberHandler(c *gin.Context) {
+	workspaceId := c.MustGet("workspace").(int32)
+
+	memberId, err := strconv.Atoi(c.Param("member"))
+	if err != nil {
+		slog.InfoContext(c.Request.Context(), "error in member param", "err", err)
+		abortWithError(c, apierror.BadRequest("invalid member param"))
+		return
+	}
+
+	memberParams := putMemberForm{}
+	c.ShouldBind(&memberParams)
+
+	if problems := memberParams.validForWorkspace(); len(problems) > 0 {
+		slog.InfoContext(c.Request.Context(), "member param problems", "problems", problems)
+		abortWithError(c, apierror.Invalid(problems))
+		return
+	}
+
+	s.putMember(c, workspaceId, int32(memberId), memberParams.Role)
+}
+
+// putMember adds a user to a workspace with the given role, or
+// updates their role if they are already a member.
+func (s *Server) putMember(c *gin.Context, workspaceId int32, memberId int32, role string) {
+	auditDetail(c, "member", memberId)
+	auditDetail(c, "role", role)
+
+	workspace, err := s.repository.FindWorkspaceWithId(c.Request.Context(), workspaceId)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error retrieving workspace", "err", err)
+		abortWithError(c, apierror.From(err, "error retrieving workspace"))
+		return
+	}
+
+	// The owner's role comes from the workspace itself
+	if workspace.Owner == memberId {
+		abortWithError(c, apierror.BadRequest("cannot change the role of the workspace owner"))
+		return
+	}
+
+	member, err := s.repository.UpsertWorkspaceMember(c.Request.Context(), repository.UpsertWorkspaceMemberParams{
+		Workspace: workspaceId,
+		Member:    memberId,
+		Role:      role,
+	})
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error adding member to workspace", "member", memberId, "err", err)
+		abortWithError(c, apierror.From(err, "error adding member"))
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, newWorkspaceMemberResponse(member))
+}
+
+// deleteMemberHandler removes a member from a workspace. Owners
+// may remove anyone; other members may only remove themselves.
+func (s *Server) deleteMemberHandler(c *gin.Context) {
+	userId := c.MustGet("user").(int32)
+	workspaceId := c.MustGet("workspace").(int32)
+	role := c.MustGet("role").(string)
+
+	memberId, err := strconv.Atoi(c.Param("member"))
+	if err != nil {
+		slog.InfoContext(c.Request.Context(), "error in member param", "err", err)
+		abortWithError(c, apierror.BadRequest("invalid member param"))
+		return
+	}
+
+	if int32(memberId) != userId && !roleAllows(role, roleOwner) {
+		abortWithError(c, apierror.Forbidden())
+		return
+	}
+
+	_, err = s.repository.DeleteWorkspaceMember(c.Request.Context(), repository.DeleteWorkspaceMemberParams{
+		Workspace: workspaceId,
+		Member:    int32(memberId),
+	})
+	if err == pgx.ErrNoRows {
+		abortWithError(c, apierror.NotFound("member not found"))
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error removing member from workspace", "member", memberId, "err", err)
+		abortWithError(c, apierror.From(err, "error removing member"))
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
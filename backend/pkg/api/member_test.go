@@ -0,0 +1,86 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsMemberParamsValid(t *testing.T) {
+	tests := []struct {
+		testDescription string            // Test description
+		email           string            // Email of invited user
+		role            string            // Role of invited user
+		want            map[string]string // List of problems
+	}{
+		{"Normal member params", "test@example.com", "editor", map[string]string{}},
+		{"Empty email", "", "viewer", map[string]string{"email": "Email must be specified"}},
+		{"Invalid role", "test@example.com", "admin", map[string]string{"role": "Role must be one of viewer, editor, or owner"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.testDescription, func(t *testing.T) {
+			params := postMemberForm{
+				Email: test.email,
+				Role:  test.role,
+			}
+
+			have := params.valid()
+
+			require.Equal(t, test.want, have)
+		})
+	}
+}
+
+func TestIsWorkspaceMemberParamsValid(t *testing.T) {
+	tests := []struct {
+		testDescription string            // Test description
+		email           string            // Email of invited user
+		role            string            // Role of invited user
+		want            map[string]string // List of problems
+	}{
+		{"Normal member params", "test@example.com", "editor", map[string]string{}},
+		{"Viewer", "test@example.com", "viewer", map[string]string{}},
+		{"Owner role", "test@example.com", "owner", map[string]string{"role": "Role must be one of viewer or editor"}},
+		{"Invalid role", "", "admin", map[string]string{"email": "Email must be specified", "role": "Role must be one of viewer or editor"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.testDescription, func(t *testing.T) {
+			params := postMemberForm{
+				Email: test.email,
+				Role:  test.role,
+			}
+
+			require.Equal(t, test.want, params.validForWorkspace())
+
+			roleParams := putMemberForm{Role: test.role}
+			delete(test.want, "email")
+
+			require.Equal(t, test.want, roleParams.validForWorkspace())
+		})
+	}
+}
+
+func TestRoleAllows(t *testing.T) {
+	tests := []struct {
+		testDescription string // Test description
+		have            string // Role the user holds
+		want            string // Role required
+		allowed         bool
+	}{
+		{"Owner can do everything", roleOwner, roleViewer, true},
+		{"Editor can edit", roleEditor, roleEditor, true},
+		{"Editor cannot delete", roleEditor, roleOwner, false},
+		{"Viewer cannot edit", roleViewer, roleEditor, false},
+		{"Unknown role is denied", "", roleViewer, false},
+		{"Viewer can clone and export", roleViewer, roleCopy, true},
+		{"Non-member cannot clone or export", "", roleCopy, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.testDescription, func(t *testing.T) {
+			require.Equal(t, test.allowed, roleAllows(test.have, test.want))
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package api
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/metrics"
+)
+
+// Route label of requests that match no route, so that arbitrary paths
+// don't each get their own series.
+const unmatchedRoute = "unmatched"
+
+// metricsMiddleware counts and times requests by route template and
+// status.
+func metricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = unmatchedRoute
+		}
+		status := strconv.Itoa(c.Writer.Status())
+
+		metrics.RequestsTotal.WithLabelValues(c.Request.Method, route, status).Inc()
+		metrics.RequestDuration.WithLabelValues(c.Request.Method, route, status).Observe(time.Since(start).Seconds())
+	}
+}
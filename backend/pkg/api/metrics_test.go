@@ -0,0 +1,27 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsMiddleware(t *testing.T) {
+	router := gin.New()
+	router.Use(metricsMiddleware())
+	router.GET("/user/workspaces/:id/members", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	for _, path := range []string{"/user/workspaces/1/members", "/user/workspaces/2/members", "/nowhere/3"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	require.Equal(t, float64(2), testutil.ToFloat64(metrics.RequestsTotal.WithLabelValues(http.MethodGet, "/user/workspaces/:id/members", "200")))
+	require.Equal(t, float64(1), testutil.ToFloat64(metrics.RequestsTotal.WithLabelValues(http.MethodGet, unmatchedRoute, "404")))
+}
@@ -0,0 +1,195 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+	"github.com/gin-gonic/gin"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/apierror"
+)
+
+// openapiSpec is the OpenAPI 3 document describing every route of
+// MainServerRegistry. Requests are validated against it.
+//
+//go:embed openapi.yaml
+var openapiSpec []byte
+
+// Responses larger than this aren't validated, to bound the memory spent
+// recording them.
+const maxValidatedResponse = 1 << 20
+
+// Extension marking operations whose request bodies are streamed by their
+// handlers, such as uploads. Their bodies aren't validated, since
+// validating reads the whole body into memory.
+const streamedExtension = "x-streamed"
+
+// loadOpenAPI parses and validates the OpenAPI document, returning it and
+// a router finding the operation of a request.
+func loadOpenAPI() (*openapi3.T, routers.Router, error) {
+	doc, err := openapi3.NewLoader().LoadFromData(openapiSpec)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to load OpenAPI document: %v", err)
+	}
+	if err := doc.Validate(context.Background()); err != nil {
+		return nil, nil, fmt.Errorf("invalid OpenAPI document: %v", err)
+	}
+
+	router, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to route OpenAPI document: %v", err)
+	}
+
+	return doc, router, nil
+}
+
+// getOpenAPIHandler serves the OpenAPI document as JSON.
+func (s *Server) getOpenAPIHandler(c *gin.Context) {
+	c.IndentedJSON(http.StatusOK, s.openapi)
+}
+
+// openapiMiddleware rejects requests that don't match the OpenAPI document.
+// Requests are matched to the document's operations as if their paths began
+// with prefix. Sessions are checked by authMiddleware, not here, and the
+// bodies of streamed operations by their handlers. If
+// validateResponses is set, JSON responses are checked against the document
+// too, and mismatches are passed to onInvalid once the response is written.
+func openapiMiddleware(router routers.Router, prefix string, validateResponses bool, onInvalid func(c *gin.Context, err error)) gin.HandlerFunc {
+	options := &openapi3filter.Options{
+		AuthenticationFunc:    openapi3filter.NoopAuthenticationFunc,
+		IncludeResponseStatus: true,
+	}
+	streamedOptions := *options
+	streamedOptions.ExcludeRequestBody = true
+
+	return func(c *gin.Context) {
+		route, pathParams, err := router.FindRoute(prefixedRequest(c.Request, prefix))
+		if err != nil {
+			// Routes missing from the document are caught by TestOpenAPIRoutes
+			c.Next()
+			return
+		}
+
+		input := &openapi3filter.RequestValidationInput{
+			Request:    c.Request,
+			PathParams: pathParams,
+			Route:      route,
+			Options:    options,
+		}
+		if streamed, _ := route.Operation.Extensions[streamedExtension].(bool); streamed {
+			input.Options = &streamedOptions
+		}
+		if err := openapi3filter.ValidateRequest(c.Request.Context(), input); err != nil {
+			abortWithError(c, requestProblem(err))
+			return
+		}
+
+		if !validateResponses {
+			c.Next()
+			return
+		}
+
+		w := &recordingWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		c.Next()
+
+		if w.skipped {
+			return
+		}
+		response := &openapi3filter.ResponseValidationInput{
+			RequestValidationInput: input,
+			Status:                 w.Status(),
+			Header:                 w.Header(),
+			Options:                options,
+		}
+		if err := openapi3filter.ValidateResponse(c.Request.Context(), response.SetBodyBytes(w.body.Bytes())); err != nil {
+			onInvalid(c, err)
+		}
+	}
+}
+
+// prefixedRequest returns a shallow copy of r whose path begins with prefix,
+// for finding r's operation. r's body stays with r.
+func prefixedRequest(r *http.Request, prefix string) *http.Request {
+	if prefix == "" {
+		return r
+	}
+
+	prefixed := *r
+	u := *r.URL
+	u.Path = prefix + u.Path
+	u.RawPath = ""
+	prefixed.URL = &u
+	prefixed.Body = http.NoBody
+	return &prefixed
+}
+
+// logInvalidResponse logs a response that doesn't match the OpenAPI
+// document.
+func logInvalidResponse(c *gin.Context, err error) {
+	slog.WarnContext(c.Request.Context(), "response does not match OpenAPI document", "route", c.FullPath(), "err", err)
+}
+
+// requestProblem describes why a request doesn't match the OpenAPI
+// document, without the schema dumps of the validator's errors.
+func requestProblem(err error) *apierror.Error {
+	var requestErr *openapi3filter.RequestError
+	if errors.As(err, &requestErr) && requestErr.Parameter != nil {
+		return apierror.BadRequest(fmt.Sprintf("invalid %v param", requestErr.Parameter.Name))
+	}
+
+	var schemaErr *openapi3.SchemaError
+	if errors.As(err, &schemaErr) {
+		if field := strings.Join(schemaErr.JSONPointer(), "."); field != "" {
+			return apierror.Invalid(map[string]string{field: schemaErr.Reason})
+		}
+		return apierror.BadRequest(schemaErr.Reason)
+	}
+
+	if requestErr != nil {
+		return apierror.BadRequest(requestErr.Reason)
+	}
+	return apierror.BadRequest("invalid request")
+}
+
+// recordingWriter keeps a copy of a JSON or problem response for
+// validation. Other responses, like event streams, pass through unrecorded.
+type recordingWriter struct {
+	gin.ResponseWriter
+	body    bytes.Buffer
+	skipped bool // Whether the response was too large or not JSON to validate
+}
+
+func (w *recordingWriter) Write(data []byte) (int, error) {
+	w.record(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	w.record([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *recordingWriter) record(data []byte) {
+	if w.skipped {
+		return
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	if mediaType != "application/json" && mediaType != apierror.ContentType || w.body.Len()+len(data) > maxValidatedResponse {
+		w.skipped = true
+		w.body.Reset()
+		return
+	}
+	w.body.Write(data)
+}
@@ -0,0 +1,280 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/apierror"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/controller/spec"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/database/repository"
+	"github.com/stretchr/testify/require"
+)
+
+var ginParam = regexp.MustCompile(`[:*](\w+)`)
+
+func TestOpenAPIRoutes(t *testing.T) {
+	doc, router, err := loadOpenAPI()
+	require.Nil(t, err)
+
+	s := &Server{router: gin.New(), config: &Config{}, openapi: doc, openapiRouter: router}
+	MainServerRegistry{}.RegisterHandlers(s)
+
+	registered := make(map[string]bool)
+	for _, route := range s.router.Routes() {
+		path := ginParam.ReplaceAllString(route.Path, "{$1}")
+		registered[route.Method+" "+path] = true
+
+		// Deprecated aliases are described by the routes they alias
+		item := doc.Paths.Value(path)
+		if item == nil {
+			item = doc.Paths.Value(apiPrefix + path)
+		}
+		require.NotNil(t, item, "route %v %v is missing from the OpenAPI document", route.Method, path)
+		require.NotNil(t, item.GetOperation(route.Method), "route %v %v is missing from the OpenAPI document", route.Method, path)
+	}
+
+	for path, item := range doc.Paths.Map() {
+		for method := range item.Operations() {
+			require.True(t, registered[method+" "+path], "operation %v %v isn't registered", method, path)
+		}
+	}
+}
+
+func TestOpenAPIResponses(t *testing.T) {
+	_, router, err := loadOpenAPI()
+	require.Nil(t, err)
+
+	now := pgtype.Timestamptz{Time: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), Valid: true}
+	workspace := newWorkspaceResponse(repository.Workspace{ID: 1, Name: "workspace", Owner: 1, Team: pgtype.Int4{Int32: 2, Valid: true}, Template: 1, Labels: []byte(`{"project":"thesis"}`), Cpu: "2", State: workspaceReady})
+	operation := int64(1)
+
+	// Each response is a value of the type its handler writes, so changing
+	// a handler's response type without the document fails here.
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		status  int
+		body    any
+		wantErr bool
+	}{
+		{"user", http.MethodGet, "/user", http.StatusOK, newUserResponse(repository.User{ID: 1, Email: "a@b.c", Admin: true}), false},
+		{"error", http.MethodGet, "/user", http.StatusNotFound, apierror.NotFound("user not found").Problem("abc"), false},
+		{"workspaces", http.MethodGet, "/user/workspaces", http.StatusOK, gin.H{
+			"workspaces":  newUserWorkspaceResponses([]repository.ListUserWorkspacesRow{{ID: 1, Role: roleOwner, State: workspaceCreating}}),
+			"next_cursor": "eyJpZCI6MX0",
+		}, false},
+		{"no workspaces", http.MethodGet, "/user/workspaces", http.StatusOK, gin.H{"workspaces": newUserWorkspaceResponses(nil), "next_cursor": nil}, false},
+		{"created workspace", http.MethodPost, "/user/workspaces", http.StatusAccepted, postWorkspaceResponse{workspaceResponse: workspace, Operation: 1}, false},
+		{"workspace problems", http.MethodPost, "/user/workspaces", http.StatusBadRequest, apierror.Invalid((&postWorkspaceForm{}).valid()).Problem(""), false},
+		{"workspace exists", http.MethodPost, "/user/workspaces", http.StatusConflict, apierror.From(&pgconn.PgError{Code: pgerrcode.UniqueViolation, ConstraintName: "workspaces_owner_name_key"}, "error creating workspace").Problem(""), false},
+		{"workspace detail", http.MethodGet, "/user/workspaces/1", http.StatusOK, workspaceDetailResponse{
+			userWorkspaceResponse: userWorkspaceResponse{workspaceResponse: workspace, Role: roleEditor, Shared: true},
+			Status:                newWorkspaceStatusResponse(spec.WorkspaceState{Phase: "Running", VolumePhase: "Bound", Capacity: "10Gi", Usage: &spec.Usage{CPU: "250m", Memory: "512Mi"}}),
+			URLs:                  map[string]string{"web": "https://1.workspaces.example.com"},
+		}, false},
+		{"workspace detail without status", http.MethodGet, "/user/workspaces/1", http.StatusOK, workspaceDetailResponse{
+			userWorkspaceResponse: userWorkspaceResponse{workspaceResponse: workspace, Role: roleOwner},
+			URLs:                  map[string]string{},
+		}, false},
+		{"updated workspace", http.MethodPatch, "/user/workspaces/1", http.StatusOK, patchWorkspaceResponse{workspaceResponse: workspace}, false},
+		{"resized workspace", http.MethodPatch, "/user/workspaces/1", http.StatusAccepted, patchWorkspaceResponse{workspaceResponse: workspace, Operation: &operation}, false},
+		{"workspace busy", http.MethodPatch, "/user/workspaces/1", http.StatusConflict, apierror.Conflict(apierror.CodeNotReady, "workspace is resizing").Problem(""), false},
+		{"cloned workspace", http.MethodPost, "/user/workspaces/1/clone", http.StatusAccepted, postWorkspaceResponse{workspaceResponse: newWorkspaceResponse(repository.Workspace{ID: 2, Name: "clone", Owner: 1, Template: 1, State: workspaceCloning, Source: pgtype.Int4{Int32: 1, Valid: true}}), Operation: 2}, false},
+		{"failed clone", http.MethodGet, "/user/workspaces/2", http.StatusOK, workspaceDetailResponse{
+			userWorkspaceResponse: userWorkspaceResponse{workspaceResponse: newWorkspaceResponse(repository.Workspace{ID: 2, State: workspaceFailed, StateMessage: "unable to copy source volume"}), Role: roleOwner},
+			URLs:                  map[string]string{},
+		}, false},
+		{"source not ready", http.MethodPost, "/user/workspaces/1/clone", http.StatusConflict, apierror.Conflict(apierror.CodeNotReady, "workspace is still being created").Problem(""), false},
+		{"export not ready", http.MethodGet, "/user/workspaces/1/export", http.StatusConflict, apierror.Conflict(apierror.CodeNotReady, "workspace is importing").Problem(""), false},
+		{"imported workspace", http.MethodPost, "/user/workspaces/import?team=2", http.StatusAccepted, postWorkspaceResponse{workspaceResponse: newWorkspaceResponse(repository.Workspace{ID: 3, Name: "imported", Owner: 1, Template: 1, State: workspaceImporting}), Operation: 3}, false},
+		{"invalid archive", http.MethodPost, "/user/workspaces/import", http.StatusBadRequest, apierror.BadRequest("invalid archive: archive must start with manifest.json").Problem(""), false},
+		{"archive too large", http.MethodPost, "/user/workspaces/import", http.StatusRequestEntityTooLarge, importError(&http.MaxBytesError{Limit: 1 << 30}).Problem(""), false},
+		{"snapshots", http.MethodGet, "/user/workspaces/1/snapshots", http.StatusOK, newSnapshotResponses([]repository.Snapshot{
+			{ID: 2, Workspace: 1, Name: "Scheduled 2024-01-01 00:00", Scheduled: true, State: snapshotReady, Size: "10Gi", CreatedAt: now},
+			{ID: 1, Workspace: 1, Name: "before upgrade", State: snapshotFailed, StateMessage: "unsupported by the cluster", CreatedAt: now},
+		}), false},
+		{"no snapshots", http.MethodGet, "/user/workspaces/1/snapshots", http.StatusOK, newSnapshotResponses(nil), false},
+		{"created snapshot", http.MethodPost, "/user/workspaces/1/snapshots", http.StatusAccepted, postSnapshotResponse{snapshotResponse: newSnapshotResponse(repository.Snapshot{ID: 1, Workspace: 1, Name: "before upgrade", State: snapshotPending, CreatedAt: now}), Operation: 3}, false},
+		{"restored snapshot", http.MethodPost, "/user/workspaces/1/snapshots/1/restore", http.StatusAccepted, gin.H{"operation": int64(4)}, false},
+		{"snapshot not ready", http.MethodPost, "/user/workspaces/1/snapshots/1/restore", http.StatusConflict, apierror.Conflict(apierror.CodeNotReady, "snapshot is pending").Problem(""), false},
+		{"workspace not settled", http.MethodPost, "/user/workspaces/1/snapshots/1/restore", http.StatusConflict, apierror.Conflict(apierror.CodeNotReady, "workspace is resizing").Problem(""), false},
+		{"deleted snapshot", http.MethodDelete, "/user/workspaces/1/snapshots/1", http.StatusAccepted, gin.H{"operation": int64(5)}, false},
+		{"snapshot restoring", http.MethodDelete, "/user/workspaces/1/snapshots/1", http.StatusConflict, apierror.Conflict(apierror.CodeNotReady, "snapshot is restoring").Problem(""), false},
+		{"files", http.MethodGet, "/user/workspaces/1/files/data", http.StatusOK, gin.H{"path": "/data", "files": parseListing("81a4 11 1704067200 ./a.csv\n41ed 4096 1704067200 ./results\n", "/workspace/data"), "truncated": false}, false},
+		{"uploaded files", http.MethodPost, "/user/workspaces/1/files/data", http.StatusOK, []fileResponse{{Name: "a.csv", Path: "/data/a.csv", Type: fileRegular, Size: 11, ModifiedAt: now.Time}}, false},
+		{"file too large", http.MethodPost, "/user/workspaces/1/files/data", http.StatusRequestEntityTooLarge, filesError(&http.MaxBytesError{Limit: 1 << 30}, "").Problem(""), false},
+		{"created directory", http.MethodPut, "/user/workspaces/1/files/results", http.StatusOK, fileResponse{Name: "results", Path: "/results", Type: fileDirectory, ModifiedAt: now.Time}, false},
+		{"moved file", http.MethodPatch, "/user/workspaces/1/files/data", http.StatusOK, fileResponse{Name: "b.csv", Path: "/b.csv", Type: fileRegular, Size: 11, ModifiedAt: now.Time}, false},
+		{"file exists", http.MethodPatch, "/user/workspaces/1/files/data", http.StatusConflict, filesError(&spec.ExitError{Code: exitExists}, "").Problem(""), false},
+		{"deleted workspace", http.MethodDelete, "/user/workspaces/1", http.StatusAccepted, gin.H{"operation": int64(1)}, false},
+		{"operation", http.MethodGet, "/operations/1", http.StatusOK, newOperationResponse(repository.FindUserJobRow{ID: 1, Status: "pending", CreatedAt: now}), false},
+		{"workspace members", http.MethodGet, "/user/workspaces/1/members", http.StatusOK, newMemberResponses([]repository.ListWorkspaceMembersRow{{ID: 1, Role: roleViewer}}), false},
+		{"workspace member", http.MethodPut, "/user/workspaces/1/members/2", http.StatusOK, newWorkspaceMemberResponse(repository.WorkspaceMember{Workspace: 1, Member: 2, Role: roleEditor}), false},
+		{"templates", http.MethodGet, "/templates", http.StatusOK, newTemplateResponses([]repository.Template{{ID: 1, Egress: "internet"}, {ID: 2, Egress: "none", SnapshotInterval: 60, SnapshotRetention: 24}}), false},
+		{"teams", http.MethodGet, "/teams", http.StatusOK, newUserTeamResponses([]repository.ListUserTeamsRow{{ID: 1, Role: roleOwner}}), false},
+		{"team", http.MethodPut, "/teams/1", http.StatusOK, newTeamResponse(repository.Team{ID: 1, CpuQuota: "4"}), false},
+		{"team members", http.MethodGet, "/teams/1/members", http.StatusOK, newTeamMemberResponses([]repository.ListTeamMembersRow{{ID: 1, Role: roleOwner}}), false},
+		{"team member", http.MethodPost, "/teams/1/members", http.StatusOK, newTeamMemberResponse(repository.TeamMember{Team: 1, Member: 2, Role: roleViewer}), false},
+		{"ambiguous member email", http.MethodPost, "/user/workspaces/1/members", http.StatusConflict, ambiguousEmail().Problem(""), false},
+		{"audit events", http.MethodGet, "/admin/audit", http.StatusOK, gin.H{"events": []auditEvent{
+			newAuditEvent(repository.AuditEvent{ID: 1, CreatedAt: now, Outcome: auditSuccess, Details: []byte(`{"name":"workspace"}`)}),
+			newAuditEvent(repository.AuditEvent{ID: 2, CreatedAt: now, Actor: pgtype.Int4{Int32: 1, Valid: true}, Outcome: auditDenied}),
+		}, "next_cursor": nil}, false},
+		{"unknown field", http.MethodGet, "/user", http.StatusOK, gin.H{"id": 1, "unknown": true}, true},
+		{"wrong role", http.MethodGet, "/teams", http.StatusOK, newUserTeamResponses([]repository.ListUserTeamsRow{{ID: 1, Role: "admin"}}), true},
+		{"undocumented status", http.MethodGet, "/templates", http.StatusTeapot, apierror.New(http.StatusTeapot, apierror.CodeInternal, "teapot").Problem(""), true},
+		{"message error", http.MethodGet, "/user", http.StatusNotFound, gin.H{"message": "user not found"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := json.Marshal(tt.body)
+			require.Nil(t, err)
+
+			req := httptest.NewRequest(tt.method, apiPrefix+tt.path, nil)
+			route, pathParams, err := router.FindRoute(req)
+			require.Nil(t, err)
+
+			contentType := "application/json; charset=utf-8"
+			if tt.status >= 400 {
+				contentType = apierror.ContentType
+			}
+
+			input := &openapi3filter.ResponseValidationInput{
+				RequestValidationInput: &openapi3filter.RequestValidationInput{Request: req, PathParams: pathParams, Route: route},
+				Status:                 tt.status,
+				Header:                 http.Header{"Content-Type": []string{contentType}},
+				Options:                &openapi3filter.Options{IncludeResponseStatus: true},
+			}
+			err = openapi3filter.ValidateResponse(context.Background(), input.SetBodyBytes(body))
+			if tt.wantErr {
+				require.NotNil(t, err)
+			} else {
+				require.Nil(t, err)
+			}
+		})
+	}
+}
+
+func TestOpenAPIMiddleware(t *testing.T) {
+	_, router, err := loadOpenAPI()
+	require.Nil(t, err)
+
+	var invalid []error
+	// Routes are registered as deprecated aliases, to check they're
+	// validated as the routes they alias
+	r := gin.New()
+	r.Use(openapiMiddleware(router, apiPrefix, true, func(c *gin.Context, err error) {
+		invalid = append(invalid, err)
+	}))
+	r.POST("/user/workspaces", func(c *gin.Context) {
+		var form postWorkspaceForm
+		if err := c.ShouldBind(&form); err != nil || form.Name == "" {
+			c.Status(http.StatusInternalServerError) // The body was lost in validation
+			return
+		}
+		c.IndentedJSON(http.StatusAccepted, postWorkspaceResponse{workspaceResponse: newWorkspaceResponse(repository.Workspace{ID: 1, Name: form.Name, State: workspaceCreating}), Operation: 1})
+	})
+	r.GET("/user/workspaces/:id/members", func(c *gin.Context) {
+		c.IndentedJSON(http.StatusOK, []gin.H{{"id": 1}})
+	})
+	r.GET("/user/events", func(c *gin.Context) {
+		c.Header("Content-Type", "text/event-stream")
+		c.String(http.StatusOK, "retry: 3000\n\n")
+	})
+	r.POST("/user/workspaces/:id/files/*path", func(c *gin.Context) {
+		c.IndentedJSON(http.StatusOK, []fileResponse{})
+	})
+	r.POST("/user/workspaces/import", func(c *gin.Context) {
+		c.IndentedJSON(http.StatusAccepted, postWorkspaceResponse{workspaceResponse: newWorkspaceResponse(repository.Workspace{ID: 1, Name: c.Query("name"), State: workspaceImporting}), Operation: 1})
+	})
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		body        string
+		wantStatus  int
+		wantProblem *apierror.Problem
+		wantInvalid bool
+	}{
+		{"valid", http.MethodPost, "/user/workspaces", `{"name":"workspace","template":null}`, http.StatusAccepted, nil, false},
+		{"wrong type", http.MethodPost, "/user/workspaces", `{"name":1}`, http.StatusBadRequest, &apierror.Problem{
+			Code:   apierror.CodeValidationFailed,
+			Errors: []apierror.FieldError{{Field: "name", Message: "value must be a string"}},
+		}, false},
+		{"missing field", http.MethodPost, "/user/workspaces", `{"team":1}`, http.StatusBadRequest, &apierror.Problem{
+			Code:   apierror.CodeValidationFailed,
+			Errors: []apierror.FieldError{{Field: "name", Message: `property "name" is missing`}},
+		}, false},
+		{"invalid param", http.MethodGet, "/user/workspaces/abc/members", "", http.StatusBadRequest, &apierror.Problem{
+			Code:   apierror.CodeInvalidRequest,
+			Detail: "invalid id param",
+		}, false},
+		{"invalid response", http.MethodGet, "/user/workspaces/1/members", "", http.StatusOK, nil, true},
+		{"stream", http.MethodGet, "/user/events", "", http.StatusOK, nil, false},
+		// Streamed bodies are left to their handlers, so even a body of the
+		// wrong type passes
+		{"streamed body", http.MethodPost, "/user/workspaces/1/files/data", `{"name":"workspace"}`, http.StatusOK, nil, false},
+		{"streamed import", http.MethodPost, "/user/workspaces/import?name=imported", `{"name":"workspace"}`, http.StatusAccepted, nil, false},
+		{"invalid import param", http.MethodPost, "/user/workspaces/import?team=abc", `{}`, http.StatusBadRequest, &apierror.Problem{
+			Code:   apierror.CodeInvalidRequest,
+			Detail: "invalid team param",
+		}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			invalid = nil
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.body != "" {
+				req.Header.Set("Content-Type", "application/json")
+			}
+			r.ServeHTTP(w, req)
+
+			require.Equal(t, tt.wantStatus, w.Code)
+			if tt.wantProblem != nil {
+				var problem apierror.Problem
+				require.Nil(t, json.Unmarshal(w.Body.Bytes(), &problem))
+				require.Equal(t, tt.wantProblem.Code, problem.Code)
+				require.Equal(t, tt.wantProblem.Errors, problem.Errors)
+				if tt.wantProblem.Detail != "" {
+					require.Equal(t, tt.wantProblem.Detail, problem.Detail)
+				}
+			}
+			require.Equal(t, tt.wantInvalid, len(invalid) > 0, "%v", invalid)
+		})
+	}
+}
+
+func TestGetOpenAPIHandler(t *testing.T) {
+	doc, _, err := loadOpenAPI()
+	require.Nil(t, err)
+
+	r := gin.New()
+	r.GET("/openapi.json", (&Server{openapi: doc}).getOpenAPIHandler)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var served map[string]any
+	require.Nil(t, json.NewDecoder(bytes.NewReader(w.Body.Bytes())).Decode(&served))
+	require.Equal(t, "3.0.3", served["openapi"])
+	require.Contains(t, served["paths"], "/api/v1/user/workspaces")
+}
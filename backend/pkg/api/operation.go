@@ -0,0 +1,328 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/apierror"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/controller/spec"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/database/repository"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/events"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/jobs"
+)
+
+// Kinds of jobs run by the job queue.
+const (
+	createWorkspaceJob = "workspace.create"
+	deleteWorkspaceJob = "workspace.delete"
+	resizeWorkspaceJob = "workspace.resize"
+	labelWorkspaceJob  = "workspace.label"
+	cloneWorkspaceJob  = "workspace.clone"
+	createSnapshotJob  = "snapshot.create"
+	restoreSnapshotJob = "snapshot.restore"
+	deleteSnapshotJob  = "snapshot.delete"
+)
+
+// workspaceJob is the payload of a job creating, resizing or labeling a
+// workspace's resources.
+type workspaceJob struct {
+	Workspace int32 `json:"workspace"`
+}
+
+// workspaceTarget is the target of jobs acting on a workspace, so that they
+// run one at a time.
+func workspaceTarget(workspaceId int32) string {
+	return fmt.Sprintf("workspace/%d", workspaceId)
+}
+
+func (j workspaceJob) JobTarget() string {
+	return workspaceTarget(j.Workspace)
+}
+
+// clonedWorkspaceJob is the payload of a job creating the resources of a
+// workspace cloned from another. It has the workspace of a workspaceJob, so
+// failures are handled alike.
+type clonedWorkspaceJob struct {
+	Workspace int32 `json:"workspace"`
+	Source    int32 `json:"source"`
+}
+
+func (j clonedWorkspaceJob) JobTarget() string {
+	return workspaceTarget(j.Workspace)
+}
+
+// deletedWorkspaceJob is the payload of a job removing a workspace's
+// resources. The workspace's row is gone by the time the job runs, so it
+// carries the workspace's spec.
+type deletedWorkspaceJob struct {
+	Workspace spec.Workspace `json:"workspace"`
+}
+
+func (j deletedWorkspaceJob) JobTarget() string {
+	return workspaceTarget(j.Workspace.ID)
+}
+
+// getOperationHandler gets the status of a job the user started.
+func (s *Server) getOperationHandler(c *gin.Context) {
+	userId := c.MustGet("user").(int32)
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		abortWithError(c, apierror.NotFound("operation not found"))
+		return
+	}
+
+	operation, err := s.repository.FindUserJob(c.Request.Context(), repository.FindUserJobParams{
+		ID:    id,
+		Owner: pgtype.Int4{Int32: userId, Valid: true},
+	})
+	if err == pgx.ErrNoRows {
+		abortWithError(c, apierror.NotFound("operation not found"))
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error retrieving operation", "operation", id, "err", err)
+		abortWithError(c, apierror.From(err, "error retrieving operation"))
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, newOperationResponse(operation))
+}
+
+// createWorkspace creates the cluster resources of the workspace in a
+// workspaceJob. Creating them is idempotent, so the job can be retried.
+func (s *Server) createWorkspace(ctx context.Context, payload []byte) error {
+	var job workspaceJob
+	if err := json.Unmarshal(payload, &job); err != nil {
+		return jobs.Permanent(fmt.Errorf("invalid payload: %v", err))
+	}
+
+	workspace, err := s.repository.FindWorkspaceWithId(ctx, job.Workspace)
+	if err == pgx.ErrNoRows {
+		// The workspace was deleted before its resources were created
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to retrieve workspace %v: %v", job.Workspace, err)
+	}
+
+	workspaceSpec, err := s.workspaceSpec(ctx, workspace)
+	if err != nil {
+		return err
+	}
+
+	if err := s.controller.CreateWorkspaceVolume(ctx, workspaceSpec); err != nil {
+		return err
+	}
+	if err := s.controller.CreateWorkspacePod(ctx, workspaceSpec); err != nil {
+		return err
+	}
+	if deleted, err := s.removeIfDeleted(ctx, workspaceSpec); deleted || err != nil {
+		return err
+	}
+
+	return s.setWorkspaceState(ctx, job.Workspace, workspaceReady, "")
+}
+
+// resizeWorkspace applies the resources and labels of the workspace in a
+// workspaceJob to its cluster resources, restarting its pod. Resizing is
+// idempotent, so the job can be retried.
+func (s *Server) resizeWorkspace(ctx context.Context, payload []byte) error {
+	var job workspaceJob
+	if err := json.Unmarshal(payload, &job); err != nil {
+		return jobs.Permanent(fmt.Errorf("invalid payload: %v", err))
+	}
+
+	workspace, err := s.repository.FindWorkspaceWithId(ctx, job.Workspace)
+	if err == pgx.ErrNoRows {
+		// The workspace was deleted before it was resized
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to retrieve workspace %v: %v", job.Workspace, err)
+	}
+
+	workspaceSpec, err := s.workspaceSpec(ctx, workspace)
+	if err != nil {
+		return err
+	}
+
+	if err := s.controller.ResizeWorkspace(ctx, workspaceSpec); err != nil {
+		return err
+	}
+	if err := s.controller.LabelWorkspace(ctx, workspaceSpec); err != nil {
+		return err
+	}
+
+	return s.setWorkspaceState(ctx, job.Workspace, workspaceReady, "")
+}
+
+// labelWorkspace applies the labels of the workspace in a workspaceJob to
+// its cluster resources. Labeling is idempotent, so the job can be retried.
+func (s *Server) labelWorkspace(ctx context.Context, payload []byte) error {
+	var job workspaceJob
+	if err := json.Unmarshal(payload, &job); err != nil {
+		return jobs.Permanent(fmt.Errorf("invalid payload: %v", err))
+	}
+
+	workspace, err := s.repository.FindWorkspaceWithId(ctx, job.Workspace)
+	if err == pgx.ErrNoRows {
+		// The workspace was deleted before it was labeled
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to retrieve workspace %v: %v", job.Workspace, err)
+	}
+
+	workspaceSpec, err := s.workspaceSpec(ctx, workspace)
+	if err != nil {
+		return err
+	}
+
+	return s.controller.LabelWorkspace(ctx, workspaceSpec)
+}
+
+// cloneWorkspace creates the cluster resources of the workspace in a
+// clonedWorkspaceJob, populating its volume from the source workspace's
+// volume. Cloning is idempotent, so the job can be retried.
+func (s *Server) cloneWorkspace(ctx context.Context, payload []byte) error {
+	var job clonedWorkspaceJob
+	if err := json.Unmarshal(payload, &job); err != nil {
+		return jobs.Permanent(fmt.Errorf("invalid payload: %v", err))
+	}
+
+	workspace, err := s.repository.FindWorkspaceWithId(ctx, job.Workspace)
+	if err == pgx.ErrNoRows {
+		// The workspace was deleted before its resources were created
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to retrieve workspace %v: %v", job.Workspace, err)
+	}
+
+	source, err := s.repository.FindWorkspaceWithId(ctx, job.Source)
+	if err == pgx.ErrNoRows {
+		return jobs.Permanent(fmt.Errorf("source workspace %v was deleted", job.Source))
+	}
+	if err != nil {
+		return fmt.Errorf("unable to retrieve workspace %v: %v", job.Source, err)
+	}
+
+	workspaceSpec, err := s.workspaceSpec(ctx, workspace)
+	if err != nil {
+		return err
+	}
+	sourceSpec, err := s.workspaceSpec(ctx, source)
+	if err != nil {
+		return err
+	}
+
+	err = s.controller.CloneWorkspaceVolume(ctx, sourceSpec, workspaceSpec)
+	if errors.Is(err, spec.ErrUnsupported) {
+		return jobs.Permanent(err)
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.controller.CreateWorkspacePod(ctx, workspaceSpec); err != nil {
+		return err
+	}
+	if deleted, err := s.removeIfDeleted(ctx, workspaceSpec); deleted || err != nil {
+		return err
+	}
+
+	return s.setWorkspaceState(ctx, job.Workspace, workspaceReady, "")
+}
+
+// failWorkspace marks the workspace of a dead workspaceJob as failed with
+// the error of the job's last attempt.
+func (s *Server) failWorkspace(ctx context.Context, payload []byte, jobErr error) {
+	var job workspaceJob
+	if err := json.Unmarshal(payload, &job); err != nil {
+		return
+	}
+
+	if err := s.setWorkspaceState(ctx, job.Workspace, workspaceFailed, jobErr.Error()); err != nil {
+		slog.ErrorContext(ctx, "error marking workspace as failed", "workspace", job.Workspace, "err", err)
+	}
+}
+
+// setWorkspaceState records the state of a workspace and its details,
+// telling its users it changed.
+func (s *Server) setWorkspaceState(ctx context.Context, workspaceId int32, state string, message string) error {
+	err := s.repository.SetWorkspaceState(ctx, repository.SetWorkspaceStateParams{ID: workspaceId, State: state, StateMessage: message})
+	if err != nil {
+		return fmt.Errorf("unable to update state of workspace %v: %v", workspaceId, err)
+	}
+
+	s.publishWorkspaceEvent(ctx, events.WorkspaceUpdated, workspaceId)
+	return nil
+}
+
+// deleteWorkspace removes the cluster resources of the workspace in a
+// deletedWorkspaceJob. Removing them is idempotent, so the job can be
+// retried.
+func (s *Server) deleteWorkspace(ctx context.Context, payload []byte) error {
+	var job deletedWorkspaceJob
+	if err := json.Unmarshal(payload, &job); err != nil {
+		return jobs.Permanent(fmt.Errorf("invalid payload: %v", err))
+	}
+
+	return s.controller.DeleteWorkspace(ctx, job.Workspace)
+}
+
+// removeIfDeleted removes the cluster resources a job created for a
+// workspace if the workspace was deleted while they were created, since
+// they'd outlive the job removing the workspace's resources. It reports
+// whether the workspace is gone.
+func (s *Server) removeIfDeleted(ctx context.Context, workspace spec.Workspace) (bool, error) {
+	_, err := s.repository.FindWorkspaceWithId(ctx, workspace.ID)
+	if err == pgx.ErrNoRows {
+		return true, s.controller.DeleteWorkspace(ctx, workspace)
+	}
+	if err != nil {
+		return false, fmt.Errorf("unable to retrieve workspace %v: %v", workspace.ID, err)
+	}
+	return false, nil
+}
+
+// workspaceSpec describes the cluster resources of a workspace, placing them
+// in the namespace of the workspace's team if it has one.
+func (s *Server) workspaceSpec(ctx context.Context, workspace repository.Workspace) (spec.Workspace, error) {
+	return workspaceSpecWith(ctx, s.repository, workspace)
+}
+
+// workspaceSpecWith is workspaceSpec reading with given queries, such as
+// queries in a transaction.
+func workspaceSpecWith(ctx context.Context, queries *repository.Queries, workspace repository.Workspace) (spec.Workspace, error) {
+	workspaceSpec := spec.Workspace{
+		ID:        workspace.ID,
+		Owner:     workspace.Owner,
+		Resources: workspaceResources(workspace),
+		Labels:    workspaceLabels(workspace.Labels),
+	}
+
+	if workspace.Team.Valid {
+		team, err := queries.FindTeamWithId(ctx, workspace.Team.Int32)
+		if err != nil {
+			return spec.Workspace{}, fmt.Errorf("unable to retrieve team %v: %v", workspace.Team.Int32, err)
+		}
+		workspaceSpec.Namespace = team.Namespace
+	}
+
+	template, err := queries.FindTemplateWithId(ctx, workspace.Template)
+	if err != nil {
+		return spec.Workspace{}, fmt.Errorf("unable to retrieve template %v: %v", workspace.Template, err)
+	}
+	workspaceSpec.Template = templateSpec(template)
+
+	return workspaceSpec, nil
+}
@@ -12,24 +12,92 @@ type HandlerRegistry interface {
 
 type MainServerRegistry struct{}
 
-// RegisterHandlers registers the routes of the main server API.
+// RegisterHandlers registers the routes of the main server API. The API's
+// routes are served under /api/v1, and at the paths from before the API was
+// versioned, which are deprecated. Health checks and the API's description
+// aren't versioned.
 func (r MainServerRegistry) RegisterHandlers(s *Server) {
-	unAuthed := s.router.Group("")
+	// Check requests against the API's description, and responses too
+	// outside production
+	validateResponses := s.config.Environment != "production"
+	validate := openapiMiddleware(s.openapiRouter, "", validateResponses, logInvalidResponse)
+
+	unversioned := s.router.Group("")
 	{
-		unAuthed.GET("/health", gin.WrapF(health.NewHandler(s.healthChecker))) // Create a handler for a health check and make it an endpoint
-		unAuthed.POST("/auth/login", s.authLoginHandler)
-		unAuthed.GET("/auth/callback", s.authCallbackHandler)
+		unversioned.Use(validate)
+
+		ready := gin.WrapF(health.NewHandler(s.readyChecker, health.WithMiddleware(s.leaderHealth)))
+		unversioned.GET("/livez", gin.WrapF(health.NewHandler(s.liveChecker)))
+		unversioned.GET("/readyz", ready)
+		unversioned.GET("/health", ready) // Kept for clients from before /readyz
+		unversioned.GET("/openapi.json", s.getOpenAPIHandler)
 	}
 
-	authed := s.router.Group("")
+	v1 := s.router.Group(apiPrefix, versionMiddleware())
+	registerAPIRoutes(s, v1, validate)
+
+	// Kept for clients from before /api/v1, which are validated as the
+	// routes they alias
+	deprecated := s.router.Group("", deprecatedMiddleware())
+	registerAPIRoutes(s, deprecated, openapiMiddleware(s.openapiRouter, apiPrefix, validateResponses, logInvalidResponse))
+}
+
+// registerAPIRoutes registers the routes of the API in a group, checking
+// requests with validate.
+func registerAPIRoutes(s *Server, group *gin.RouterGroup, validate gin.HandlerFunc) {
+	unAuthed := group.Group("")
 	{
-		authed.Use(s.authMiddleware())
+		unAuthed.Use(validate)
 
+		unAuthed.POST("/auth/login", s.authLoginHandler)
+		unAuthed.GET("/auth/callback", s.authCallbackHandler)
 		unAuthed.POST("/auth/logout", s.authLogoutHandler)
+	}
+
+	authed := group.Group("")
+	{
+		authed.Use(s.authMiddleware(), validate)
 
 		authed.GET("/user", s.userHandler)
-		authed.POST("/user/workspaces", s.postWorkspaceHandler)
-		authed.DELETE("/user/workspaces/:id", s.deleteWorkspaceHandler)
+		authed.DELETE("/user", s.auditMiddleware("user.delete", "user"), s.deleteUserHandler)
+		authed.POST("/user/workspaces", s.auditMiddleware("workspace.create", "workspace"), s.postWorkspaceHandler)
+		authed.GET("/user/workspaces/:id", s.workspaceMiddleware(roleViewer), s.getWorkspaceHandler)
+		authed.PATCH("/user/workspaces/:id", s.auditMiddleware("workspace.update", "workspace"), s.workspaceMiddleware(roleEditor), s.patchWorkspaceHandler)
+		authed.DELETE("/user/workspaces/:id", s.auditMiddleware("workspace.delete", "workspace"), s.workspaceMiddleware(roleOwner), s.deleteWorkspaceHandler)
+		authed.POST("/user/workspaces/:id/clone", s.auditMiddleware("workspace.clone", "workspace"), s.workspaceMiddleware(roleCopy), s.cloneWorkspaceHandler)
+		authed.GET("/user/workspaces/:id/export", s.auditMiddleware("workspace.export", "workspace"), s.workspaceMiddleware(roleCopy), s.exportWorkspaceHandler)
+		authed.POST("/user/workspaces/import", s.auditMiddleware("workspace.import", "workspace"), s.importWorkspaceHandler)
+		authed.GET("/user/workspaces/:id/snapshots", s.workspaceMiddleware(roleViewer), s.getSnapshotsHandler)
+		authed.POST("/user/workspaces/:id/snapshots", s.auditMiddleware("workspace.snapshot.create", "workspace"), s.workspaceMiddleware(roleEditor), s.postSnapshotHandler)
+		authed.POST("/user/workspaces/:id/snapshots/:snapshot/restore", s.auditMiddleware("workspace.snapshot.restore", "workspace"), s.workspaceMiddleware(roleEditor), s.restoreSnapshotHandler)
+		authed.DELETE("/user/workspaces/:id/snapshots/:snapshot", s.auditMiddleware("workspace.snapshot.delete", "workspace"), s.workspaceMiddleware(roleEditor), s.deleteSnapshotHandler)
+		authed.GET("/user/workspaces/:id/files/*path", s.workspaceMiddleware(roleViewer), s.getFileHandler)
+		authed.POST("/user/workspaces/:id/files/*path", s.auditMiddleware("workspace.file.upload", "workspace"), s.workspaceMiddleware(roleEditor), s.postFilesHandler)
+		authed.PUT("/user/workspaces/:id/files/*path", s.auditMiddleware("workspace.file.mkdir", "workspace"), s.workspaceMiddleware(roleEditor), s.putFileHandler)
+		authed.PATCH("/user/workspaces/:id/files/*path", s.auditMiddleware("workspace.file.move", "workspace"), s.workspaceMiddleware(roleEditor), s.patchFileHandler)
+		authed.DELETE("/user/workspaces/:id/files/*path", s.auditMiddleware("workspace.file.delete", "workspace"), s.workspaceMiddleware(roleEditor), s.deleteFileHandler)
 		authed.GET("/user/workspaces", s.getWorkspacesHandler)
+		authed.GET("/user/events", s.getEventsHandler)
+		authed.GET("/operations/:id", s.getOperationHandler)
+
+		authed.GET("/user/workspaces/:id/members", s.workspaceMiddleware(roleViewer), s.getMembersHandler)
+		authed.POST("/user/workspaces/:id/members", s.auditMiddleware("workspace.member.add", "workspace"), s.workspaceMiddleware(roleOwner), s.postMemberHandler)
+		authed.PUT("/user/workspaces/:id/members/:member", s.auditMiddleware("workspace.member.update", "workspace"), s.workspaceMiddleware(roleOwner), s.putMemberHandler)
+		authed.DELETE("/user/workspaces/:id/members/:member", s.auditMiddleware("workspace.member.remove", "workspace"), s.workspaceMiddleware(roleViewer), s.deleteMemberHandler)
+
+		authed.GET("/templates", s.getTemplatesHandler)
+
+		authed.GET("/teams", s.getTeamsHandler)
+		authed.POST("/teams", s.auditMiddleware("team.create", "team"), s.postTeamHandler)
+		authed.GET("/teams/:id", s.teamMiddleware(roleViewer), s.getTeamHandler)
+		authed.PUT("/teams/:id", s.auditMiddleware("team.update", "team"), s.teamMiddleware(roleOwner), s.putTeamHandler)
+		authed.DELETE("/teams/:id", s.auditMiddleware("team.delete", "team"), s.teamMiddleware(roleOwner), s.deleteTeamHandler)
+
+		authed.GET("/teams/:id/members", s.teamMiddleware(roleViewer), s.getTeamMembersHandler)
+		authed.POST("/teams/:id/members", s.auditMiddleware("team.member.add", "team"), s.teamMiddleware(roleOwner), s.postTeamMemberHandler)
+		authed.PUT("/teams/:id/members/:member", s.auditMiddleware("team.member.update", "team"), s.teamMiddleware(roleOwner), s.putTeamMemberHandler)
+		authed.DELETE("/teams/:id/members/:member", s.auditMiddleware("team.member.remove", "team"), s.teamMiddleware(roleViewer), s.deleteTeamMemberHandler)
+
+		authed.GET("/admin/audit", s.auditMiddleware("audit.list", ""), s.adminMiddleware(), s.getAuditHandler)
 	}
 }
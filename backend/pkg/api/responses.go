@@ -0,0 +1,395 @@
+package api
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/controller/spec"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/database/repository"
+)
+
+// The types below are the resources the API responds with. They are part of
+// the API, so they are kept apart from the repository's models, which
+// follow the database schema.
+
+// userResponse is a user as returned by the API.
+type userResponse struct {
+	ID      int32  `json:"id"`
+	Issuer  string `json:"issuer"`
+	Subject string `json:"subject"`
+	Email   string `json:"email"`
+	Name    string `json:"name"`
+	Picture string `json:"picture"`
+	Admin   bool   `json:"admin"`
+}
+
+func newUserResponse(u repository.User) userResponse {
+	return userResponse{
+		ID:      u.ID,
+		Issuer:  u.Issuer,
+		Subject: u.Subject,
+		Email:   u.Email,
+		Name:    u.Name,
+		Picture: u.Picture,
+		Admin:   u.Admin,
+	}
+}
+
+// workspaceResponse is a workspace as returned by the API.
+type workspaceResponse struct {
+	ID           int32             `json:"id"`
+	Name         string            `json:"name"`
+	Owner        int32             `json:"owner"`
+	Team         *int32            `json:"team"` // Team the workspace belongs to, if any
+	Template     int32             `json:"template"`
+	Description  string            `json:"description"`
+	Labels       map[string]string `json:"labels"`
+	Resources    resourcesResponse `json:"resources"`
+	State        string            `json:"state"`         // Progress of the operations on the workspace's cluster resources
+	StateMessage string            `json:"state_message"` // Why the last operation failed, if it did
+	Source       *int32            `json:"source"`        // Workspace this one was cloned from, if any
+	CreatedAt    time.Time         `json:"created_at"`
+	LastActiveAt time.Time         `json:"last_active_at"` // Last time the workspace was opened or changed
+}
+
+func newWorkspaceResponse(w repository.Workspace) workspaceResponse {
+	return workspaceResponse{
+		ID:           w.ID,
+		Name:         w.Name,
+		Owner:        w.Owner,
+		Team:         optionalInt32(w.Team),
+		Template:     w.Template,
+		Description:  w.Description,
+		Labels:       workspaceLabels(w.Labels),
+		Resources:    newResourcesResponse(workspaceResources(w)),
+		State:        w.State,
+		StateMessage: w.StateMessage,
+		Source:       optionalInt32(w.Source),
+		CreatedAt:    w.CreatedAt.Time,
+		LastActiveAt: w.LastActiveAt.Time,
+	}
+}
+
+// resourcesResponse is the resources a workspace runs with, including the
+// defaults of those it doesn't set.
+type resourcesResponse struct {
+	CPU     string `json:"cpu"`
+	Memory  string `json:"memory"`
+	Storage string `json:"storage"`
+}
+
+func newResourcesResponse(r spec.Resources) resourcesResponse {
+	r = r.WithDefaults()
+	return resourcesResponse{
+		CPU:     r.CPU,
+		Memory:  r.Memory,
+		Storage: r.Storage,
+	}
+}
+
+// workspaceDetailResponse is a workspace with the user's role in it, the
+// state of its cluster resources and the URLs it's reached at.
+type workspaceDetailResponse struct {
+	userWorkspaceResponse
+	Status *workspaceStatusResponse `json:"status"` // State of the workspace's resources, or nil if the cluster is unreachable
+	URLs   map[string]string        `json:"urls"`
+}
+
+// workspaceStatusResponse is the observed state of a workspace's cluster
+// resources.
+type workspaceStatusResponse struct {
+	Phase    string         `json:"phase"`    // Phase of the pod, or "" if it doesn't exist
+	Volume   string         `json:"volume"`   // Phase of the volume claim, or "" if it doesn't exist
+	Capacity string         `json:"capacity"` // Storage provisioned for the volume, or "" until it's bound
+	Usage    *usageResponse `json:"usage"`    // Resources the pod is using, if the cluster reports them
+}
+
+// usageResponse is the resources a workspace's pod is using.
+type usageResponse struct {
+	CPU    string `json:"cpu"`
+	Memory string `json:"memory"`
+}
+
+func newWorkspaceStatusResponse(state spec.WorkspaceState) *workspaceStatusResponse {
+	status := &workspaceStatusResponse{
+		Phase:    state.Phase,
+		Volume:   state.VolumePhase,
+		Capacity: state.Capacity,
+	}
+	if state.Usage != nil {
+		status.Usage = &usageResponse{CPU: state.Usage.CPU, Memory: state.Usage.Memory}
+	}
+	return status
+}
+
+// userWorkspaceResponse is a workspace, with the user's role in it.
+type userWorkspaceResponse struct {
+	workspaceResponse
+	Role   string `json:"role"`
+	Shared bool   `json:"shared"` // Whether the workspace is shared with the user rather than owned by them
+}
+
+func newUserWorkspaceResponses(rows []repository.ListUserWorkspacesRow) []userWorkspaceResponse {
+	workspaces := make([]userWorkspaceResponse, len(rows))
+	for i, w := range rows {
+		workspaces[i] = userWorkspaceResponse{
+			workspaceResponse: newWorkspaceResponse(repository.Workspace{
+				ID:           w.ID,
+				Name:         w.Name,
+				Owner:        w.Owner,
+				Team:         w.Team,
+				Template:     w.Template,
+				Description:  w.Description,
+				Labels:       w.Labels,
+				Cpu:          w.Cpu,
+				Memory:       w.Memory,
+				Storage:      w.Storage,
+				State:        w.State,
+				StateMessage: w.StateMessage,
+				Source:       w.Source,
+				CreatedAt:    w.CreatedAt,
+				LastActiveAt: w.LastActiveAt,
+			}),
+			Role:   w.Role,
+			Shared: w.Shared,
+		}
+	}
+	return workspaces
+}
+
+// memberResponse is a member of a workspace or team, with their role.
+type memberResponse struct {
+	ID      int32  `json:"id"`
+	Email   string `json:"email"`
+	Name    string `json:"name"`
+	Picture string `json:"picture"`
+	Role    string `json:"role"`
+}
+
+func newMemberResponses(rows []repository.ListWorkspaceMembersRow) []memberResponse {
+	members := make([]memberResponse, len(rows))
+	for i, m := range rows {
+		members[i] = memberResponse{
+			ID:      m.ID,
+			Email:   m.Email,
+			Name:    m.Name,
+			Picture: m.Picture,
+			Role:    m.Role,
+		}
+	}
+	return members
+}
+
+func newTeamMemberResponses(rows []repository.ListTeamMembersRow) []memberResponse {
+	members := make([]repository.ListWorkspaceMembersRow, len(rows))
+	for i, m := range rows {
+		members[i] = repository.ListWorkspaceMembersRow(m)
+	}
+	return newMemberResponses(members)
+}
+
+// workspaceMemberResponse is the role of a user in a workspace.
+type workspaceMemberResponse struct {
+	Workspace int32  `json:"workspace"`
+	Member    int32  `json:"member"`
+	Role      string `json:"role"`
+}
+
+func newWorkspaceMemberResponse(m repository.WorkspaceMember) workspaceMemberResponse {
+	return workspaceMemberResponse{
+		Workspace: m.Workspace,
+		Member:    m.Member,
+		Role:      m.Role,
+	}
+}
+
+// teamResponse is a team as returned by the API.
+type teamResponse struct {
+	ID            int32  `json:"id"`
+	Name          string `json:"name"`
+	Namespace     string `json:"namespace"`      // The team's dedicated namespace, or "" if it has none
+	MaxWorkspaces int32  `json:"max_workspaces"` // Most workspaces the team may own, or 0 for no limit
+	CPUQuota      string `json:"cpu_quota"`
+	MemoryQuota   string `json:"memory_quota"`
+	StorageQuota  string `json:"storage_quota"`
+}
+
+func newTeamResponse(t repository.Team) teamResponse {
+	return teamResponse{
+		ID:            t.ID,
+		Name:          t.Name,
+		Namespace:     t.Namespace,
+		MaxWorkspaces: t.MaxWorkspaces,
+		CPUQuota:      t.CpuQuota,
+		MemoryQuota:   t.MemoryQuota,
+		StorageQuota:  t.StorageQuota,
+	}
+}
+
+// userTeamResponse is a team, with the user's role in it.
+type userTeamResponse struct {
+	teamResponse
+	Role string `json:"role"`
+}
+
+func newUserTeamResponses(rows []repository.ListUserTeamsRow) []userTeamResponse {
+	teams := make([]userTeamResponse, len(rows))
+	for i, t := range rows {
+		teams[i] = userTeamResponse{
+			teamResponse: teamResponse{
+				ID:            t.ID,
+				Name:          t.Name,
+				Namespace:     t.Namespace,
+				MaxWorkspaces: t.MaxWorkspaces,
+				CPUQuota:      t.CpuQuota,
+				MemoryQuota:   t.MemoryQuota,
+				StorageQuota:  t.StorageQuota,
+			},
+			Role: t.Role,
+		}
+	}
+	return teams
+}
+
+// teamMemberResponse is the role of a user in a team.
+type teamMemberResponse struct {
+	Team   int32  `json:"team"`
+	Member int32  `json:"member"`
+	Role   string `json:"role"`
+}
+
+func newTeamMemberResponse(m repository.TeamMember) teamMemberResponse {
+	return teamMemberResponse{
+		Team:   m.Team,
+		Member: m.Member,
+		Role:   m.Role,
+	}
+}
+
+// templateResponse is a template workspaces are created from.
+type templateResponse struct {
+	ID             int32                   `json:"id"`
+	Name           string                  `json:"name"`
+	Image          string                  `json:"image"`
+	Egress         string                  `json:"egress"`          // Egress policy of workspaces made from the template
+	SnapshotPolicy *snapshotPolicyResponse `json:"snapshot_policy"` // Scheduled snapshots of the template's workspaces, if any
+}
+
+// snapshotPolicyResponse is when snapshots of a template's workspaces are
+// taken, and how many are kept.
+type snapshotPolicyResponse struct {
+	Interval  int32 `json:"interval"`  // Minutes between snapshots
+	Retention int32 `json:"retention"` // Number of scheduled snapshots kept
+}
+
+func newTemplateResponses(rows []repository.Template) []templateResponse {
+	templates := make([]templateResponse, len(rows))
+	for i, t := range rows {
+		templates[i] = templateResponse{
+			ID:     t.ID,
+			Name:   t.Name,
+			Image:  t.Image,
+			Egress: t.Egress,
+		}
+		if t.SnapshotInterval > 0 {
+			templates[i].SnapshotPolicy = &snapshotPolicyResponse{Interval: t.SnapshotInterval, Retention: t.SnapshotRetention}
+		}
+	}
+	return templates
+}
+
+// snapshotResponse is a snapshot of a workspace's volume.
+type snapshotResponse struct {
+	ID           int32     `json:"id"`
+	Workspace    int32     `json:"workspace"`
+	Name         string    `json:"name"`
+	Scheduled    bool      `json:"scheduled"` // Whether the snapshot was taken by its template's snapshot policy
+	State        string    `json:"state"`
+	StateMessage string    `json:"state_message"` // Why taking the snapshot failed, if it did
+	Size         string    `json:"size"`          // Size of a volume restored from the snapshot, once it's ready
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+func newSnapshotResponse(s repository.Snapshot) snapshotResponse {
+	return snapshotResponse{
+		ID:           s.ID,
+		Workspace:    s.Workspace,
+		Name:         s.Name,
+		Scheduled:    s.Scheduled,
+		State:        s.State,
+		StateMessage: s.StateMessage,
+		Size:         s.Size,
+		CreatedAt:    s.CreatedAt.Time,
+	}
+}
+
+func newSnapshotResponses(rows []repository.Snapshot) []snapshotResponse {
+	snapshots := make([]snapshotResponse, len(rows))
+	for i, s := range rows {
+		snapshots[i] = newSnapshotResponse(s)
+	}
+	return snapshots
+}
+
+// operationResponse is the progress of a long-running cluster operation.
+type operationResponse struct {
+	ID          int64      `json:"id"`
+	Kind        string     `json:"kind"`
+	Status      string     `json:"status"`
+	Attempts    int32      `json:"attempts"`
+	MaxAttempts int32      `json:"max_attempts"`
+	LastError   string     `json:"last_error"`
+	CreatedAt   *time.Time `json:"created_at"`
+	UpdatedAt   *time.Time `json:"updated_at"`
+}
+
+func newOperationResponse(o repository.FindUserJobRow) operationResponse {
+	return operationResponse{
+		ID:          o.ID,
+		Kind:        o.Kind,
+		Status:      o.Status,
+		Attempts:    o.Attempts,
+		MaxAttempts: o.MaxAttempts,
+		LastError:   o.LastError,
+		CreatedAt:   optionalTime(o.CreatedAt),
+		UpdatedAt:   optionalTime(o.UpdatedAt),
+	}
+}
+
+// workspaceLabels decodes the labels of a workspace, stored as a JSON object.
+func workspaceLabels(data []byte) map[string]string {
+	labels := make(map[string]string)
+	json.Unmarshal(data, &labels)
+	return labels
+}
+
+// workspaceResources returns the resources a workspace sets.
+func workspaceResources(w repository.Workspace) spec.Resources {
+	return spec.Resources{CPU: w.Cpu, Memory: w.Memory, Storage: w.Storage}
+}
+
+// optionalInt32 returns a pointer to the value of v, or nil if v is NULL.
+func optionalInt32(v pgtype.Int4) *int32 {
+	if !v.Valid {
+		return nil
+	}
+	return &v.Int32
+}
+
+// optionalTime returns a pointer to the value of v, or nil if v is NULL.
+func optionalTime(v pgtype.Timestamptz) *time.Time {
+	if !v.Valid {
+		return nil
+	}
+	return &v.Time
+}
+
+// fileResponse is a file or directory of a workspace's volume.
+type fileResponse struct {
+	Name       string    `json:"name"`
+	Path       string    `json:"path"` // Path from the root of the volume, starting with /
+	Type       string    `json:"type"` // file, directory, symlink or other
+	Size       int64     `json:"size"` // Size in bytes
+	ModifiedAt time.Time `json:"modified_at"`
+}
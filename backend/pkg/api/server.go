@@ -3,8 +3,9 @@ package api
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"os"
 	"os/signal"
 	"syscall"
 	"time"
@@ -12,38 +13,89 @@ import (
 	"github.com/alexedwards/scs/v2"
 	"github.com/alexliesenfeld/health"
 	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/routers"
 	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/johngerving/kubernetes-web-client/backend/pkg/controller"
 	"github.com/johngerving/kubernetes-web-client/backend/pkg/database/repository"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/events"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/jobs"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/leader"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/metrics"
 	"golang.org/x/oauth2"
 )
 
 type Server struct {
-	router        *gin.Engine           // Gin router
-	config        *Config               // General app config
-	oauth         *oauth2.Config        // OAuth config
-	provider      *oidc.Provider        // OIDC provider
-	sessionStore  *scs.SessionManager   // Session store
-	repository    *repository.Queries   // Database
-	healthChecker health.Checker        // Health checker
-	controller    controller.Controller // Workload controller
+	router       *gin.Engine           // Gin router
+	config       *Config               // General app config
+	oauth        *oauth2.Config        // OAuth config
+	provider     *oidc.Provider        // OIDC provider
+	sessionStore *scs.SessionManager   // Session store
+	db           *pgxpool.Pool         // Database connection pool, for transactions
+	repository   *repository.Queries   // Database
+	liveChecker  health.Checker        // Checks whether the server should be restarted
+	readyChecker health.Checker        // Checks whether the server can serve requests
+	controller   controller.Controller // Workload controller
+	broker       *events.Broker        // Workspace event broker
+	leader       *leader.Manager       // Runs background workers on the leading replica
+	jobs         *jobs.Queue           // Queue of long-running cluster operations
+
+	openapi       *openapi3.T    // Description of the API
+	openapiRouter routers.Router // Finds the operations of requests in openapi
 }
 
-// NewServer takes a Config, oauth2.Config, oidc.Provider, scs.SessionManager, repository.Queries, and kube.Client
-// and returns a Server.
-func NewServer(config *Config, oauth *oauth2.Config, provider *oidc.Provider, sessionStore *scs.SessionManager, repo *repository.Queries, healthChecker health.Checker, controller controller.Controller) (*Server, error) {
+// NewServer takes a Config, oauth2.Config, oidc.Provider, scs.SessionManager, pgxpool.Pool, repository.Queries,
+// liveness and readiness health.Checkers, kube.Client, events.Broker, leader.Manager, and jobs.Queue and returns a Server.
+func NewServer(config *Config, oauth *oauth2.Config, provider *oidc.Provider, sessionStore *scs.SessionManager, db *pgxpool.Pool, repo *repository.Queries, liveChecker health.Checker, readyChecker health.Checker, controller controller.Controller, broker *events.Broker, leader *leader.Manager, jobs *jobs.Queue) (*Server, error) {
+
+	doc, openapiRouter, err := loadOpenAPI()
+	if err != nil {
+		return nil, err
+	}
 
 	srv := &Server{
-		router:        gin.Default(),
-		config:        config,
-		oauth:         oauth,
-		provider:      provider,
-		sessionStore:  sessionStore,
-		repository:    repo,
-		healthChecker: healthChecker,
-		controller:    controller,
+		router:       gin.New(),
+		config:       config,
+		oauth:        oauth,
+		provider:     provider,
+		sessionStore: sessionStore,
+		db:           db,
+		repository:   repo,
+		liveChecker:  liveChecker,
+		readyChecker: readyChecker,
+		controller:   controller,
+		broker:       broker,
+		leader:       leader,
+		jobs:         jobs,
+
+		openapi:       doc,
+		openapiRouter: openapiRouter,
 	}
 
+	srv.router.Use(tracingMiddleware(), requestIDMiddleware(), metricsMiddleware(), loggerMiddleware(), recoveryMiddleware())
+	srv.router.NoRoute(noRouteHandler)
+
+	jobs.Handle(createWorkspaceJob, srv.createWorkspace)
+	jobs.Handle(deleteWorkspaceJob, srv.deleteWorkspace)
+	jobs.Handle(resizeWorkspaceJob, srv.resizeWorkspace)
+	jobs.Handle(labelWorkspaceJob, srv.labelWorkspace)
+	jobs.Handle(cloneWorkspaceJob, srv.cloneWorkspace)
+	jobs.Handle(createSnapshotJob, srv.createSnapshot)
+	jobs.Handle(restoreSnapshotJob, srv.restoreSnapshot)
+	jobs.Handle(deleteSnapshotJob, srv.deleteSnapshot)
+	jobs.HandleDead(createWorkspaceJob, srv.failWorkspace)
+	jobs.HandleDead(resizeWorkspaceJob, srv.failWorkspace)
+	jobs.HandleDead(cloneWorkspaceJob, srv.failWorkspace)
+	jobs.HandleDead(restoreSnapshotJob, srv.failRestore)
+	jobs.HandleDead(createSnapshotJob, srv.failSnapshot)
+
+	// Take scheduled snapshots from the leading replica
+	leader.Register("snapshot-scheduler", srv.scheduleSnapshots)
+
+	// Fail the imports of replicas that stopped while importing
+	leader.Register("import-cleanup", srv.failStaleImports)
+
 	return srv, nil
 }
 
@@ -56,6 +108,15 @@ func (s *Server) ListenAndServe(registry HandlerRegistry) *http.Server {
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
+	// Push status changes of workspaces to their users
+	go s.watchWorkspaces(ctx)
+
+	// Run background workers while this replica leads
+	go s.leader.Run(ctx)
+
+	// Run queued jobs. Every replica works the queue.
+	go s.jobs.Work(ctx)
+
 	// Create an HTTP server listening on the port provided in environment variables
 	// using the router we defined
 	srv := &http.Server{
@@ -63,30 +124,45 @@ func (s *Server) ListenAndServe(registry HandlerRegistry) *http.Server {
 		Handler: s.sessionStore.LoadAndSave(s.router),
 	}
 
-	// Initialize the server in a goroutine so that
-	// it won't block the graceful shutdown handling below
-	go func() {
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("listen: %s\n", err)
-		}
-	}()
+	// Serve metrics on their own port, so they aren't exposed through the ingress
+	metricsMux := http.NewServeMux()
+	metricsMux.Handle("/metrics", metrics.Handler())
+	metricsSrv := &http.Server{
+		Addr:    fmt.Sprintf(":%d", s.config.MetricsPort),
+		Handler: metricsMux,
+	}
+
+	// Initialize the servers in goroutines so that
+	// they won't block the graceful shutdown handling below
+	for _, server := range []*http.Server{srv, metricsSrv} {
+		go func() {
+			if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				slog.Error("error listening", "addr", server.Addr, "err", err)
+				os.Exit(1)
+			}
+		}()
+	}
 
 	// Listen for interrupt signal
 	<-ctx.Done()
 
 	// Restore default behavior on the interrupt signal and notify user of shutdown
 	stop()
-	log.Println("Shutting down server gracefully")
+	slog.Info("shutting down server gracefully")
 
 	// Context is used to inform the server it has 5 seconds to finish
 	// the request it is currently handling
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 	if err := srv.Shutdown(ctx); err != nil {
-		log.Fatal("Server forced to shutdown: ", err)
+		slog.Error("server forced to shut down", "err", err)
+		os.Exit(1)
+	}
+	if err := metricsSrv.Shutdown(ctx); err != nil {
+		slog.Error("metrics server forced to shut down", "err", err)
 	}
 
-	log.Println("Server exiting")
+	slog.Info("server exiting")
 
 	return srv
 }
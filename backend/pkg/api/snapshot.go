@@ -0,0 +1,575 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/apierror"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/controller/spec"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/database/repository"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/events"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/jobs"
+)
+
+// States of a snapshot, tracking the job taking it.
+const (
+	snapshotPending   = "pending"
+	snapshotReady     = "ready"
+	snapshotRestoring = "restoring" // A workspace's volume is being restored from the snapshot, so it can't be removed
+	snapshotFailed    = "failed"
+)
+
+// Interval at which workspaces are checked for scheduled snapshots that are
+// due.
+const snapshotScheduleInterval = time.Minute
+
+// snapshotJob is the payload of a job taking a snapshot. Its workspace
+// orders the job among the workspace's other jobs.
+type snapshotJob struct {
+	Workspace int32 `json:"workspace"`
+	Snapshot  int32 `json:"snapshot"`
+}
+
+func (j snapshotJob) JobTarget() string {
+	return workspaceTarget(j.Workspace)
+}
+
+// restoredSnapshotJob is the payload of a job restoring a workspace's
+// volume from a snapshot. It has the workspace of a workspaceJob, so
+// failures are handled alike.
+type restoredSnapshotJob struct {
+	Workspace int32 `json:"workspace"`
+	Snapshot  int32 `json:"snapshot"`
+}
+
+func (j restoredSnapshotJob) JobTarget() string {
+	return workspaceTarget(j.Workspace)
+}
+
+// deletedSnapshotJob is the payload of a job removing a snapshot from the
+// cluster. The snapshot's row is gone by the time the job runs, so it
+// carries the spec of the snapshot's workspace.
+type deletedSnapshotJob struct {
+	Workspace spec.Workspace `json:"workspace"`
+	Snapshot  int32          `json:"snapshot"`
+}
+
+func (j deletedSnapshotJob) JobTarget() string {
+	return workspaceTarget(j.Workspace.ID)
+}
+
+type postSnapshotForm struct {
+	Name string `json:"name"`
+}
+
+// valid checks if a postSnapshotForm struct is valid. It
+// returns a map[string]string containing any problems.
+func (f *postSnapshotForm) valid() (problems map[string]string) {
+	problems = make(map[string]string)
+
+	if len(f.Name) <= 2 {
+		problems["name"] = "Name must be at least 2 characters long"
+	} else if len(f.Name) > 50 {
+		problems["name"] = "Name must be at most 50 characters long"
+	}
+
+	return problems
+}
+
+// workspaceBusy reports whether a workspace's volume is being populated,
+// so that it can't be snapshotted or restored yet.
+func workspaceBusy(state string) bool {
+	return state == workspaceCreating || state == workspaceCloning || state == workspaceRestoring || state == workspaceImporting
+}
+
+// postSnapshotResponse is a snapshot being taken, along with the operation
+// taking it.
+type postSnapshotResponse struct {
+	snapshotResponse
+	Operation int64 `json:"operation"` // ID of the job polled at /api/v1/operations/:id
+}
+
+// getSnapshotsHandler lists the snapshots of a workspace, latest first.
+func (s *Server) getSnapshotsHandler(c *gin.Context) {
+	workspaceId := c.MustGet("workspace").(int32)
+
+	snapshots, err := s.repository.ListWorkspaceSnapshots(c.Request.Context(), workspaceId)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error retrieving snapshots of workspace", "err", err)
+		abortWithError(c, apierror.From(err, "error retrieving snapshots"))
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, newSnapshotResponses(snapshots))
+}
+
+// postSnapshotHandler takes a snapshot of a workspace's volume. The snapshot
+// is taken by a job, whose ID is returned for the client to poll.
+func (s *Server) postSnapshotHandler(c *gin.Context) {
+	userId := c.MustGet("user").(int32)
+	workspaceId := c.MustGet("workspace").(int32)
+
+	snapshotParams := postSnapshotForm{}
+	c.ShouldBind(&snapshotParams)
+
+	if problems := snapshotParams.valid(); len(problems) > 0 {
+		slog.InfoContext(c.Request.Context(), "snapshot param problems", "problems", problems)
+		abortWithError(c, apierror.Invalid(problems))
+		return
+	}
+
+	workspace, err := s.repository.FindWorkspaceWithId(c.Request.Context(), workspaceId)
+	if err == pgx.ErrNoRows {
+		abortWithError(c, apierror.NotFound("workspace not found"))
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error retrieving workspace", "err", err)
+		abortWithError(c, apierror.From(err, "error creating snapshot"))
+		return
+	}
+	if workspaceBusy(workspace.State) {
+		abortWithError(c, apierror.Conflict(apierror.CodeNotReady, fmt.Sprintf("workspace is %v", workspace.State)))
+		return
+	}
+
+	// Add the snapshot and the job taking it in one transaction
+	tx, err := s.db.Begin(c.Request.Context())
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error starting transaction", "err", err)
+		abortWithError(c, apierror.From(err, "error creating snapshot"))
+		return
+	}
+	defer tx.Rollback(context.Background())
+	qtx := s.repository.WithTx(tx)
+
+	snapshot, err := qtx.CreateSnapshot(c.Request.Context(), repository.CreateSnapshotParams{
+		Workspace: workspaceId,
+		Name:      snapshotParams.Name,
+	})
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error creating snapshot", "err", err)
+		abortWithError(c, apierror.From(err, "error creating snapshot"))
+		return
+	}
+
+	auditDetail(c, "snapshot", snapshot.ID)
+	auditDetail(c, "name", snapshot.Name)
+
+	job, err := s.jobs.EnqueueTx(c.Request.Context(), qtx, createSnapshotJob, userId, snapshotJob{Workspace: workspaceId, Snapshot: snapshot.ID})
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error taking snapshot", "snapshot", snapshot.ID, "err", err)
+		abortWithError(c, apierror.From(err, "error creating snapshot"))
+		return
+	}
+
+	if err := tx.Commit(c.Request.Context()); err != nil {
+		slog.ErrorContext(c.Request.Context(), "error creating snapshot", "err", err)
+		abortWithError(c, apierror.From(err, "error creating snapshot"))
+		return
+	}
+
+	c.Header("Location", fmt.Sprintf("%v/operations/%d", apiPrefix, job.ID))
+	c.IndentedJSON(http.StatusAccepted, postSnapshotResponse{snapshotResponse: newSnapshotResponse(snapshot), Operation: job.ID})
+}
+
+// restoreSnapshotHandler replaces a workspace's volume with a volume
+// restored from one of its snapshots. The volume is restored by a job,
+// whose ID is returned for the client to poll. The workspace is restarted,
+// and changes made since the snapshot are lost.
+func (s *Server) restoreSnapshotHandler(c *gin.Context) {
+	userId := c.MustGet("user").(int32)
+	workspaceId := c.MustGet("workspace").(int32)
+
+	snapshotId, err := strconv.Atoi(c.Param("snapshot"))
+	if err != nil {
+		abortWithError(c, apierror.NotFound("snapshot not found"))
+		return
+	}
+
+	// Restore the snapshot in one transaction, so that the workspace can't be
+	// restored twice at once
+	tx, err := s.db.Begin(c.Request.Context())
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error starting transaction", "err", err)
+		abortWithError(c, apierror.From(err, "error restoring snapshot"))
+		return
+	}
+	defer tx.Rollback(context.Background())
+	qtx := s.repository.WithTx(tx)
+
+	// The snapshot is kept until the workspace is restored from it
+	params := repository.StartSnapshotRestoreParams{ID: int32(snapshotId), Workspace: workspaceId}
+	snapshot, err := qtx.StartSnapshotRestore(c.Request.Context(), params)
+	if err == pgx.ErrNoRows {
+		s.snapshotUnavailable(c, qtx, repository.FindWorkspaceSnapshotParams(params), "error restoring snapshot")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error retrieving snapshot", "err", err)
+		abortWithError(c, apierror.From(err, "error restoring snapshot"))
+		return
+	}
+
+	auditDetail(c, "snapshot", snapshot.ID)
+
+	// The workspace is only restored if no job is acting on it, checked as
+	// its state changes so that concurrent requests can't both pass
+	_, err = qtx.StartWorkspaceRestore(c.Request.Context(), workspaceId)
+	if err == pgx.ErrNoRows {
+		workspace, err := qtx.FindWorkspaceWithId(c.Request.Context(), workspaceId)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "error retrieving workspace", "err", err)
+			abortWithError(c, apierror.From(err, "error restoring snapshot"))
+			return
+		}
+		abortWithError(c, apierror.Conflict(apierror.CodeNotReady, fmt.Sprintf("workspace is %v", workspace.State)))
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error updating state of workspace", "err", err)
+		abortWithError(c, apierror.From(err, "error restoring snapshot"))
+		return
+	}
+
+	job, err := s.jobs.EnqueueTx(c.Request.Context(), qtx, restoreSnapshotJob, userId, restoredSnapshotJob{Workspace: workspaceId, Snapshot: snapshot.ID})
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error restoring snapshot", "snapshot", snapshot.ID, "err", err)
+		abortWithError(c, apierror.From(err, "error restoring snapshot"))
+		return
+	}
+
+	if err := tx.Commit(c.Request.Context()); err != nil {
+		slog.ErrorContext(c.Request.Context(), "error restoring snapshot", "err", err)
+		abortWithError(c, apierror.From(err, "error restoring snapshot"))
+		return
+	}
+
+	s.publishWorkspaceEvent(c.Request.Context(), events.WorkspaceUpdated, workspaceId)
+
+	c.Header("Location", fmt.Sprintf("%v/operations/%d", apiPrefix, job.ID))
+	c.IndentedJSON(http.StatusAccepted, gin.H{"operation": job.ID})
+}
+
+// deleteSnapshotHandler deletes a snapshot of a workspace. The snapshot is
+// removed from the cluster by a job, whose ID is returned for the client to
+// poll.
+func (s *Server) deleteSnapshotHandler(c *gin.Context) {
+	userId := c.MustGet("user").(int32)
+	workspaceId := c.MustGet("workspace").(int32)
+
+	snapshotId, err := strconv.Atoi(c.Param("snapshot"))
+	if err != nil {
+		abortWithError(c, apierror.NotFound("snapshot not found"))
+		return
+	}
+
+	// Remove the snapshot and add the job removing it in one transaction
+	tx, err := s.db.Begin(c.Request.Context())
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error starting transaction", "err", err)
+		abortWithError(c, apierror.From(err, "error removing snapshot"))
+		return
+	}
+	defer tx.Rollback(context.Background())
+	qtx := s.repository.WithTx(tx)
+
+	// Snapshots being restored are kept
+	params := repository.DeleteWorkspaceSnapshotParams{ID: int32(snapshotId), Workspace: workspaceId}
+	snapshot, err := qtx.DeleteWorkspaceSnapshot(c.Request.Context(), params)
+	if err == pgx.ErrNoRows {
+		s.snapshotUnavailable(c, qtx, repository.FindWorkspaceSnapshotParams(params), "error removing snapshot")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error deleting snapshot", "err", err)
+		abortWithError(c, apierror.From(err, "error removing snapshot"))
+		return
+	}
+
+	auditDetail(c, "snapshot", snapshot.ID)
+
+	var job repository.Job
+	workspace, err := qtx.FindWorkspaceWithId(c.Request.Context(), workspaceId)
+	if err == nil {
+		var workspaceSpec spec.Workspace
+		workspaceSpec, err = workspaceSpecWith(c.Request.Context(), qtx, workspace)
+		if err == nil {
+			job, err = s.jobs.EnqueueTx(c.Request.Context(), qtx, deleteSnapshotJob, userId, deletedSnapshotJob{Workspace: workspaceSpec, Snapshot: snapshot.ID})
+		}
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error removing snapshot from cluster", "err", err)
+		abortWithError(c, apierror.From(err, "error removing snapshot"))
+		return
+	}
+
+	if err := tx.Commit(c.Request.Context()); err != nil {
+		slog.ErrorContext(c.Request.Context(), "error deleting snapshot", "err", err)
+		abortWithError(c, apierror.From(err, "error removing snapshot"))
+		return
+	}
+
+	c.Header("Location", fmt.Sprintf("%v/operations/%d", apiPrefix, job.ID))
+	c.IndentedJSON(http.StatusAccepted, gin.H{"operation": job.ID})
+}
+
+// snapshotUnavailable writes the error response of a snapshot that couldn't
+// be changed: 404 if it doesn't exist, otherwise 409 with its state.
+func (s *Server) snapshotUnavailable(c *gin.Context, qtx *repository.Queries, params repository.FindWorkspaceSnapshotParams, detail string) {
+	snapshot, err := qtx.FindWorkspaceSnapshot(c.Request.Context(), params)
+	if err == pgx.ErrNoRows {
+		abortWithError(c, apierror.NotFound("snapshot not found"))
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error retrieving snapshot", "err", err)
+		abortWithError(c, apierror.From(err, detail))
+		return
+	}
+
+	abortWithError(c, apierror.Conflict(apierror.CodeNotReady, fmt.Sprintf("snapshot is %v", snapshot.State)))
+}
+
+// createSnapshot takes the snapshot in a snapshotJob. Taking it is
+// idempotent, so the job can be retried. Once a scheduled snapshot is taken,
+// scheduled snapshots past the retention of the workspace's template, and
+// earlier scheduled snapshots that failed, are removed.
+func (s *Server) createSnapshot(ctx context.Context, payload []byte) error {
+	var job snapshotJob
+	if err := json.Unmarshal(payload, &job); err != nil {
+		return jobs.Permanent(fmt.Errorf("invalid payload: %v", err))
+	}
+
+	snapshot, err := s.repository.FindSnapshotWithId(ctx, job.Snapshot)
+	if err == pgx.ErrNoRows {
+		// The snapshot was deleted before it was taken
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to retrieve snapshot %v: %v", job.Snapshot, err)
+	}
+
+	workspace, err := s.repository.FindWorkspaceWithId(ctx, snapshot.Workspace)
+	if err == pgx.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to retrieve workspace %v: %v", snapshot.Workspace, err)
+	}
+
+	workspaceSpec, err := s.workspaceSpec(ctx, workspace)
+	if err != nil {
+		return err
+	}
+
+	taken, err := s.controller.SnapshotWorkspace(ctx, workspaceSpec, snapshot.ID)
+	if errors.Is(err, spec.ErrUnsupported) {
+		return jobs.Permanent(err)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = s.repository.SetSnapshotState(ctx, repository.SetSnapshotStateParams{ID: snapshot.ID, State: snapshotReady, Size: taken.Size})
+	if err != nil {
+		return fmt.Errorf("unable to update snapshot %v: %v", snapshot.ID, err)
+	}
+
+	if snapshot.Scheduled {
+		return s.expireSnapshots(ctx, workspace, workspaceSpec, snapshot)
+	}
+	return nil
+}
+
+// expireSnapshots removes the scheduled snapshots of a workspace past the
+// retention of the workspace's template, latest first, along with jobs
+// removing them from the cluster. Only ready snapshots, and those being
+// restored from, count toward the retention; scheduled snapshots that failed
+// before the taken one are removed regardless.
+func (s *Server) expireSnapshots(ctx context.Context, workspace repository.Workspace, workspaceSpec spec.Workspace, taken repository.Snapshot) error {
+	template, err := s.repository.FindTemplateWithId(ctx, workspace.Template)
+	if err != nil {
+		return fmt.Errorf("unable to retrieve template %v: %v", workspace.Template, err)
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to start transaction: %v", err)
+	}
+	defer tx.Rollback(context.Background())
+	qtx := s.repository.WithTx(tx)
+
+	expired, err := qtx.DeleteExpiredSnapshots(ctx, repository.DeleteExpiredSnapshotsParams{
+		Workspace: workspace.ID,
+		Retention: template.SnapshotRetention,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to remove expired snapshots of workspace %v: %v", workspace.ID, err)
+	}
+	failed, err := qtx.DeleteFailedSnapshots(ctx, repository.DeleteFailedSnapshotsParams{
+		Workspace: workspace.ID,
+		CreatedAt: taken.CreatedAt,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to remove failed snapshots of workspace %v: %v", workspace.ID, err)
+	}
+
+	// Failed snapshots may have been created in the cluster too
+	expired = append(expired, failed...)
+
+	for _, snapshot := range expired {
+		_, err := s.jobs.EnqueueTx(ctx, qtx, deleteSnapshotJob, workspace.Owner, deletedSnapshotJob{Workspace: workspaceSpec, Snapshot: snapshot.ID})
+		if err != nil {
+			return fmt.Errorf("unable to remove expired snapshot %v: %v", snapshot.ID, err)
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// failSnapshot marks the snapshot of a dead snapshotJob as failed with the
+// error of the job's last attempt.
+func (s *Server) failSnapshot(ctx context.Context, payload []byte, jobErr error) {
+	var job snapshotJob
+	if err := json.Unmarshal(payload, &job); err != nil {
+		return
+	}
+
+	err := s.repository.SetSnapshotState(ctx, repository.SetSnapshotStateParams{ID: job.Snapshot, State: snapshotFailed, StateMessage: jobErr.Error()})
+	if err != nil {
+		slog.ErrorContext(ctx, "error marking snapshot as failed", "snapshot", job.Snapshot, "err", err)
+	}
+}
+
+// restoreSnapshot restores the workspace's volume in a restoredSnapshotJob
+// from its snapshot, and creates the workspace's pod again. Restoring is
+// idempotent, so the job can be retried.
+func (s *Server) restoreSnapshot(ctx context.Context, payload []byte) error {
+	var job restoredSnapshotJob
+	if err := json.Unmarshal(payload, &job); err != nil {
+		return jobs.Permanent(fmt.Errorf("invalid payload: %v", err))
+	}
+
+	workspace, err := s.repository.FindWorkspaceWithId(ctx, job.Workspace)
+	if err == pgx.ErrNoRows {
+		// The workspace was deleted before it was restored
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to retrieve workspace %v: %v", job.Workspace, err)
+	}
+
+	workspaceSpec, err := s.workspaceSpec(ctx, workspace)
+	if err != nil {
+		return err
+	}
+
+	err = s.controller.RestoreWorkspaceSnapshot(ctx, workspaceSpec, job.Snapshot)
+	if errors.Is(err, spec.ErrUnsupported) {
+		return jobs.Permanent(err)
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.controller.CreateWorkspacePod(ctx, workspaceSpec); err != nil {
+		return err
+	}
+	if deleted, err := s.removeIfDeleted(ctx, workspaceSpec); deleted || err != nil {
+		return err
+	}
+
+	if err := s.repository.FinishSnapshotRestore(ctx, job.Snapshot); err != nil {
+		return fmt.Errorf("unable to update snapshot %v: %v", job.Snapshot, err)
+	}
+
+	return s.setWorkspaceState(ctx, job.Workspace, workspaceReady, "")
+}
+
+// failRestore marks the workspace of a dead restoredSnapshotJob as failed
+// with the error of the job's last attempt, and lets its snapshot be
+// removed again.
+func (s *Server) failRestore(ctx context.Context, payload []byte, jobErr error) {
+	var job restoredSnapshotJob
+	if err := json.Unmarshal(payload, &job); err != nil {
+		return
+	}
+
+	if err := s.repository.FinishSnapshotRestore(ctx, job.Snapshot); err != nil {
+		slog.ErrorContext(ctx, "error updating restored snapshot", "snapshot", job.Snapshot, "err", err)
+	}
+
+	s.failWorkspace(ctx, payload, jobErr)
+}
+
+// deleteSnapshot removes the snapshot in a deletedSnapshotJob from the
+// cluster. Removing it is idempotent, so the job can be retried.
+func (s *Server) deleteSnapshot(ctx context.Context, payload []byte) error {
+	var job deletedSnapshotJob
+	if err := json.Unmarshal(payload, &job); err != nil {
+		return jobs.Permanent(fmt.Errorf("invalid payload: %v", err))
+	}
+
+	return s.controller.DeleteWorkspaceSnapshot(ctx, job.Workspace, job.Snapshot)
+}
+
+// scheduleSnapshots periodically takes snapshots of the workspaces whose
+// template's snapshot policy has one due, until the context is canceled. It
+// only needs to run on one replica.
+func (s *Server) scheduleSnapshots(ctx context.Context) {
+	ticker := time.NewTicker(snapshotScheduleInterval)
+	defer ticker.Stop()
+
+	for {
+		due, err := s.repository.ListDueSnapshotWorkspaces(ctx)
+		if err != nil && ctx.Err() == nil {
+			slog.ErrorContext(ctx, "error retrieving workspaces due a snapshot", "err", err)
+		}
+
+		for _, workspace := range due {
+			if err := s.scheduleSnapshot(ctx, workspace.ID, workspace.Owner); err != nil && ctx.Err() == nil {
+				slog.ErrorContext(ctx, "error scheduling snapshot", "workspace", workspace.ID, "err", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// scheduleSnapshot adds a scheduled snapshot of a workspace, along with the
+// job taking it on behalf of the workspace's owner.
+func (s *Server) scheduleSnapshot(ctx context.Context, workspaceId int32, owner int32) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to start transaction: %v", err)
+	}
+	defer tx.Rollback(context.Background())
+	qtx := s.repository.WithTx(tx)
+
+	snapshot, err := qtx.CreateSnapshot(ctx, repository.CreateSnapshotParams{
+		Workspace: workspaceId,
+		Name:      "Scheduled " + time.Now().UTC().Format("2006-01-02 15:04"),
+		Scheduled: true,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to create snapshot: %v", err)
+	}
+
+	if _, err := s.jobs.EnqueueTx(ctx, qtx, createSnapshotJob, owner, snapshotJob{Workspace: workspaceId, Snapshot: snapshot.ID}); err != nil {
+		return fmt.Errorf("unable to take snapshot %v: %v", snapshot.ID, err)
+	}
+
+	return tx.Commit(ctx)
+}
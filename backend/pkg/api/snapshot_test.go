@@ -0,0 +1,14 @@
+package api
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostSnapshotFormValid(t *testing.T) {
+	require.Equal(t, map[string]string{}, (&postSnapshotForm{Name: "before upgrade"}).valid())
+	require.Equal(t, map[string]string{"name": "Name must be at least 2 characters long"}, (&postSnapshotForm{}).valid())
+	require.Equal(t, map[string]string{"name": "Name must be at most 50 characters long"}, (&postSnapshotForm{Name: strings.Repeat("a", 51)}).valid())
+}
@@ -0,0 +1,403 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/apierror"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/controller/spec"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/database/repository"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+type putTeamForm struct {
+	Name          string `json:"name"`
+	MaxWorkspaces int32  `json:"max_workspaces"`
+	CPUQuota      string `json:"cpu_quota"`
+	MemoryQuota   string `json:"memory_quota"`
+	StorageQuota  string `json:"storage_quota"`
+}
+
+// valid checks if a putTeamForm struct is valid. It
+// returns a map[string]string containing any problems.
+func (f *putTeamForm) valid() (problems map[string]string) {
+	problems = make(map[string]string)
+
+	if len(f.Name) < 2 || len(f.Name) > 50 {
+		problems["name"] = "Name must be between 2 and 50 characters long"
+	}
+
+	if f.MaxWorkspaces < 0 {
+		problems["max_workspaces"] = "Maximum workspaces cannot be negative"
+	}
+
+	quotas := map[string]string{"cpu_quota": f.CPUQuota, "memory_quota": f.MemoryQuota, "storage_quota": f.StorageQuota}
+	for field, quota := range quotas {
+		if quota == "" {
+			continue
+		}
+		if _, err := resource.ParseQuantity(quota); err != nil {
+			problems[field] = "Quota must be a Kubernetes resource quantity"
+		}
+	}
+
+	return problems
+}
+
+type postTeamForm struct {
+	putTeamForm
+	DedicatedNamespace bool `json:"dedicated_namespace"` // Whether to place the team's workspaces in their own namespace
+}
+
+// valid checks if a postTeamForm struct is valid. It
+// returns a map[string]string containing any problems.
+func (f *postTeamForm) valid() (problems map[string]string) {
+	problems = f.putTeamForm.valid()
+
+	if _, ok := problems["name"]; !ok && f.DedicatedNamespace && teamSlug(f.Name) == "" {
+		problems["name"] = "Name must contain a letter or number to create a namespace"
+	}
+
+	return problems
+}
+
+var invalidNamespaceChars = regexp.MustCompile("[^a-z0-9-]+")
+
+// teamSlug generates the slug a team's dedicated namespace is named with
+// from the team's name. It returns "" if the name has no usable characters.
+func teamSlug(name string) string {
+	slug := invalidNamespaceChars.ReplaceAllString(strings.ToLower(name), "-")
+	return strings.Trim(slug, "-")
+}
+
+// teamLimits are the workspace limit and quotas of a team, which only
+// administrators may change.
+type teamLimits struct {
+	MaxWorkspaces int32
+	CPUQuota      string
+	MemoryQuota   string
+	StorageQuota  string
+}
+
+// limits returns the workspace limit and quotas the form sets.
+func (f *putTeamForm) limits() teamLimits {
+	return teamLimits{MaxWorkspaces: f.MaxWorkspaces, CPUQuota: f.CPUQuota, MemoryQuota: f.MemoryQuota, StorageQuota: f.StorageQuota}
+}
+
+// newTeamLimits returns the workspace limit and quotas of a team.
+func newTeamLimits(team repository.Team) teamLimits {
+	return teamLimits{MaxWorkspaces: team.MaxWorkspaces, CPUQuota: team.CpuQuota, MemoryQuota: team.MemoryQuota, StorageQuota: team.StorageQuota}
+}
+
+// limitsForbidden returns the Error of users other than administrators
+// changing the workspace limit or quotas of a team.
+func limitsForbidden() *apierror.Error {
+	return apierror.New(http.StatusForbidden, apierror.CodeForbidden, "only administrators may change the workspace limit and quotas of teams")
+}
+
+// teamNamespaceSpec returns the spec of a team's dedicated namespace.
+func teamNamespaceSpec(team repository.Team) spec.Namespace {
+	return spec.Namespace{
+		Name: team.Namespace,
+		Quota: spec.Quota{
+			CPU:     team.CpuQuota,
+			Memory:  team.MemoryQuota,
+			Storage: team.StorageQuota,
+		},
+	}
+}
+
+// getTeamsHandler lists the teams a user is a member of.
+func (s *Server) getTeamsHandler(c *gin.Context) {
+	userId := c.MustGet("user").(int32)
+
+	teams, err := s.repository.ListUserTeams(c.Request.Context(), userId)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error retrieving teams", "err", err)
+		abortWithError(c, apierror.From(err, "error retrieving teams"))
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, newUserTeamResponses(teams))
+}
+
+// postTeamHandler creates a new team owned by the user.
+func (s *Server) postTeamHandler(c *gin.Context) {
+	userId := c.MustGet("user").(int32)
+
+	teamParams := postTeamForm{}
+	c.ShouldBind(&teamParams)
+
+	if problems := teamParams.valid(); len(problems) > 0 {
+		slog.InfoContext(c.Request.Context(), "team param problems", "problems", problems)
+		abortWithError(c, apierror.Invalid(problems))
+		return
+	}
+
+	if teamParams.limits() != (teamLimits{}) {
+		admin, ok := s.userIsAdmin(c, userId)
+		if !ok {
+			return
+		}
+		if !admin {
+			abortWithError(c, limitsForbidden())
+			return
+		}
+	}
+
+	var namespace string
+	if teamParams.DedicatedNamespace {
+		namespace = s.controller.TeamNamespace(teamSlug(teamParams.Name))
+	}
+
+	team, err := s.repository.CreateTeam(c.Request.Context(), repository.CreateTeamParams{
+		Name:          teamParams.Name,
+		Namespace:     namespace,
+		MaxWorkspaces: teamParams.MaxWorkspaces,
+		CpuQuota:      teamParams.CPUQuota,
+		MemoryQuota:   teamParams.MemoryQuota,
+		StorageQuota:  teamParams.StorageQuota,
+		Owner:         userId,
+	})
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error creating team", "err", err)
+		abortWithError(c, apierror.From(err, "error creating team"))
+		return
+	}
+
+	auditTarget(c, "team", team.ID)
+	auditDetail(c, "name", team.Name)
+
+	if team.Namespace != "" {
+		err = s.controller.EnsureNamespace(c.Request.Context(), teamNamespaceSpec(team))
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "error creating namespace for team", "team", team.ID, "err", err)
+
+			// Don't leave a team behind without its namespace
+			if _, err := s.repository.DeleteTeamWithId(c.Request.Context(), team.ID); err != nil {
+				slog.ErrorContext(c.Request.Context(), "error removing team", "team", team.ID, "err", err)
+			}
+			abortWithError(c, apierror.From(err, "error creating team namespace"))
+			return
+		}
+	}
+
+	c.IndentedJSON(http.StatusOK, newTeamResponse(team))
+}
+
+// getTeamHandler gets a team with a given ID.
+func (s *Server) getTeamHandler(c *gin.Context) {
+	teamId := c.MustGet("team").(int32)
+
+	team, err := s.repository.FindTeamWithId(c.Request.Context(), teamId)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error retrieving team", "team", teamId, "err", err)
+		abortWithError(c, apierror.From(err, "error retrieving team"))
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, newTeamResponse(team))
+}
+
+// putTeamHandler updates the name and quotas of a team. Only
+// administrators may change the team's workspace limit and quotas.
+func (s *Server) putTeamHandler(c *gin.Context) {
+	userId := c.MustGet("user").(int32)
+	teamId := c.MustGet("team").(int32)
+
+	teamParams := putTeamForm{}
+	c.ShouldBind(&teamParams)
+
+	if problems := teamParams.valid(); len(problems) > 0 {
+		slog.InfoContext(c.Request.Context(), "team param problems", "problems", problems)
+		abortWithError(c, apierror.Invalid(problems))
+		return
+	}
+
+	current, err := s.repository.FindTeamWithId(c.Request.Context(), teamId)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error retrieving team", "team", teamId, "err", err)
+		abortWithError(c, apierror.From(err, "error retrieving team"))
+		return
+	}
+
+	if teamParams.limits() != newTeamLimits(current) {
+		admin, ok := s.userIsAdmin(c, userId)
+		if !ok {
+			return
+		}
+		if !admin {
+			abortWithError(c, limitsForbidden())
+			return
+		}
+	}
+
+	team, err := s.repository.UpdateTeam(c.Request.Context(), repository.UpdateTeamParams{
+		ID:            teamId,
+		Name:          teamParams.Name,
+		MaxWorkspaces: teamParams.MaxWorkspaces,
+		CpuQuota:      teamParams.CPUQuota,
+		MemoryQuota:   teamParams.MemoryQuota,
+		StorageQuota:  teamParams.StorageQuota,
+	})
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error updating team", "team", teamId, "err", err)
+		abortWithError(c, apierror.From(err, "error updating team"))
+		return
+	}
+
+	// Apply the new quotas to the team's namespace
+	if team.Namespace != "" {
+		err = s.controller.EnsureNamespace(c.Request.Context(), teamNamespaceSpec(team))
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "error updating namespace of team", "team", team.ID, "err", err)
+			abortWithError(c, apierror.From(err, "error updating team namespace"))
+			return
+		}
+	}
+
+	c.IndentedJSON(http.StatusOK, newTeamResponse(team))
+}
+
+// deleteTeamHandler deletes a team with a given ID, and its
+// dedicated namespace if it has one. Teams that still own
+// workspaces cannot be deleted.
+func (s *Server) deleteTeamHandler(c *gin.Context) {
+	teamId := c.MustGet("team").(int32)
+
+	team, err := s.repository.DeleteTeamWithId(c.Request.Context(), teamId)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error deleting team", "team", teamId, "err", err)
+		abortWithError(c, apierror.From(err, "error removing team"))
+		return
+	}
+
+	// The team is already gone, so a leftover namespace is only logged
+	if team.Namespace != "" {
+		if err := s.controller.DeleteTeamNamespace(c.Request.Context(), team.Namespace); err != nil {
+			slog.ErrorContext(c.Request.Context(), "error deleting namespace of team", "team", teamId, "err", err)
+		}
+	}
+
+	c.Status(http.StatusOK)
+}
+
+// getTeamMembersHandler lists the members of a team.
+func (s *Server) getTeamMembersHandler(c *gin.Context) {
+	teamId := c.MustGet("team").(int32)
+
+	members, err := s.repository.ListTeamMembers(c.Request.Context(), teamId)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error retrieving members of team", "team", teamId, "err", err)
+		abortWithError(c, apierror.From(err, "error retrieving members"))
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, newTeamMemberResponses(members))
+}
+
+// postTeamMemberHandler adds a user to a team by their
+// email address.
+func (s *Server) postTeamMemberHandler(c *gin.Context) {
+	teamId := c.MustGet("team").(int32)
+
+	memberParams := postMemberForm{}
+	c.ShouldBind(&memberParams)
+
+	if problems := memberParams.valid(); len(problems) > 0 {
+		slog.InfoContext(c.Request.Context(), "member param problems", "problems", problems)
+		abortWithError(c, apierror.Invalid(problems))
+		return
+	}
+
+	user, ok := s.invitedUser(c, memberParams.Email)
+	if !ok {
+		return
+	}
+
+	s.putTeamMember(c, teamId, user.ID, memberParams.Role)
+}
+
+// putTeamMemberHandler changes the role of a team member.
+func (s *Server) putTeamMemberHandler(c *gin.Context) {
+	teamId := c.MustGet("team").(int32)
+
+	memberId, err := strconv.Atoi(c.Param("member"))
+	if err != nil {
+		slog.InfoContext(c.Request.Context(), "error in member param", "err", err)
+		abortWithError(c, apierror.BadRequest("invalid member param"))
+		return
+	}
+
+	memberParams := putMemberForm{}
+	c.ShouldBind(&memberParams)
+
+	if problems := memberParams.valid(); len(problems) > 0 {
+		slog.InfoContext(c.Request.Context(), "member param problems", "problems", problems)
+		abortWithError(c, apierror.Invalid(problems))
+		return
+	}
+
+	s.putTeamMember(c, teamId, int32(memberId), memberParams.Role)
+}
+
+// putTeamMember adds a user to a team with the given role, or
+// updates their role if they are already a member.
+func (s *Server) putTeamMember(c *gin.Context, teamId int32, memberId int32, role string) {
+	auditDetail(c, "member", memberId)
+	auditDetail(c, "role", role)
+
+	member, err := s.repository.UpsertTeamMember(c.Request.Context(), repository.UpsertTeamMemberParams{
+		Team:   teamId,
+		Member: memberId,
+		Role:   role,
+	})
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error adding member to team", "member", memberId, "team", teamId, "err", err)
+		abortWithError(c, apierror.From(err, "error adding member"))
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, newTeamMemberResponse(member))
+}
+
+// deleteTeamMemberHandler removes a member from a team. Owners
+// may remove anyone; other members may only remove themselves.
+func (s *Server) deleteTeamMemberHandler(c *gin.Context) {
+	userId := c.MustGet("user").(int32)
+	teamId := c.MustGet("team").(int32)
+	role := c.MustGet("role").(string)
+
+	memberId, err := strconv.Atoi(c.Param("member"))
+	if err != nil {
+		slog.InfoContext(c.Request.Context(), "error in member param", "err", err)
+		abortWithError(c, apierror.BadRequest("invalid member param"))
+		return
+	}
+
+	if int32(memberId) != userId && !roleAllows(role, roleOwner) {
+		abortWithError(c, apierror.Forbidden())
+		return
+	}
+
+	_, err = s.repository.DeleteTeamMember(c.Request.Context(), repository.DeleteTeamMemberParams{
+		Team:   teamId,
+		Member: int32(memberId),
+	})
+	if err == pgx.ErrNoRows {
+		abortWithError(c, apierror.NotFound("member not found"))
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error removing member from team", "member", memberId, "team", teamId, "err", err)
+		abortWithError(c, apierror.From(err, "error removing member"))
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/database/repository"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsTeamParamsValid(t *testing.T) {
+	tests := []struct {
+		testDescription string            // Test description
+		params          postTeamForm      // Team params
+		want            map[string]string // List of problems
+	}{
+		{"Normal team params", postTeamForm{putTeamForm: putTeamForm{Name: "research", CPUQuota: "8", MemoryQuota: "16Gi"}}, map[string]string{}},
+		{"Empty name", postTeamForm{}, map[string]string{"name": "Name must be between 2 and 50 characters long"}},
+		{"Negative workspace limit", postTeamForm{putTeamForm: putTeamForm{Name: "research", MaxWorkspaces: -1}}, map[string]string{"max_workspaces": "Maximum workspaces cannot be negative"}},
+		{"Invalid quota", postTeamForm{putTeamForm: putTeamForm{Name: "research", StorageQuota: "lots"}}, map[string]string{"storage_quota": "Quota must be a Kubernetes resource quantity"}},
+		{"Namespace from unusable name", postTeamForm{putTeamForm: putTeamForm{Name: "!!!"}, DedicatedNamespace: true}, map[string]string{"name": "Name must contain a letter or number to create a namespace"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.testDescription, func(t *testing.T) {
+			have := test.params.valid()
+
+			require.Equal(t, test.want, have)
+		})
+	}
+}
+
+func TestTeamSlug(t *testing.T) {
+	tests := []struct {
+		testDescription string // Test description
+		name            string // Team name
+		want            string // Slug of the namespace's name
+	}{
+		{"Simple name", "research", "research"},
+		{"Name with spaces and capitals", "Data Science", "data-science"},
+		{"Name with symbols", "--ML & AI--", "ml-ai"},
+		{"Name without letters or numbers", "!!!", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.testDescription, func(t *testing.T) {
+			require.Equal(t, test.want, teamSlug(test.name))
+		})
+	}
+}
+
+func TestTeamLimits(t *testing.T) {
+	team := repository.Team{ID: 1, Name: "research", MaxWorkspaces: 10, CpuQuota: "8"}
+
+	// Renaming a team leaves its limits unchanged
+	form := putTeamForm{Name: "science", MaxWorkspaces: 10, CPUQuota: "8"}
+	require.Equal(t, newTeamLimits(team), form.limits())
+
+	form.MemoryQuota = "16Gi"
+	require.NotEqual(t, newTeamLimits(team), form.limits())
+
+	require.Equal(t, teamLimits{}, (&putTeamForm{Name: "research"}).limits())
+}
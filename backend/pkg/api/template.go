@@ -0,0 +1,61 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/apierror"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/controller/spec"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/database/repository"
+)
+
+// defaultTemplate is the name of the template workspaces are created
+// from when none is given.
+const defaultTemplate = "default"
+
+// templateSpec converts a template to the settings passed to the controller.
+func templateSpec(template repository.Template) spec.Template {
+	return spec.Template{
+		Image:  template.Image,
+		Egress: template.Egress,
+	}
+}
+
+// getTemplatesHandler lists the templates workspaces can be
+// created from.
+func (s *Server) getTemplatesHandler(c *gin.Context) {
+	templates, err := s.repository.ListTemplates(c.Request.Context())
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error retrieving templates", "err", err)
+		abortWithError(c, apierror.From(err, "error retrieving templates"))
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, newTemplateResponses(templates))
+}
+
+// workspaceTemplate retrieves the template a workspace is created from,
+// falling back to the default template if templateId is nil. If the template
+// doesn't exist, it writes an error response and returns false.
+func (s *Server) workspaceTemplate(c *gin.Context, templateId *int32) (repository.Template, bool) {
+	var template repository.Template
+	var err error
+	if templateId != nil {
+		template, err = s.repository.FindTemplateWithId(c.Request.Context(), *templateId)
+	} else {
+		template, err = s.repository.FindTemplateWithName(c.Request.Context(), defaultTemplate)
+	}
+	if err == pgx.ErrNoRows {
+		abortWithError(c, apierror.NotFound("template not found"))
+		return repository.Template{}, false
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error retrieving template", "err", err)
+		abortWithError(c, apierror.From(err, "error retrieving template"))
+		return repository.Template{}, false
+	}
+
+	return template, true
+}
@@ -0,0 +1,20 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/tracing"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+)
+
+// Paths of health checks, which are polled constantly and so aren't traced.
+var untracedPaths = map[string]bool{"/health": true, "/livez": true, "/readyz": true}
+
+// tracingMiddleware starts a span for each request, continuing the trace
+// of the caller if it sent W3C trace context.
+func tracingMiddleware() gin.HandlerFunc {
+	return otelgin.Middleware(tracing.ServiceName, otelgin.WithFilter(func(r *http.Request) bool {
+		return !untracedPaths[r.URL.Path]
+	}))
+}
@@ -0,0 +1,32 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTracingMiddleware(t *testing.T) {
+	defer otel.SetTextMapPropagator(otel.GetTextMapPropagator())
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	router := gin.New()
+	router.Use(tracingMiddleware())
+
+	var traceID string
+	router.GET("/user", func(c *gin.Context) {
+		traceID = trace.SpanContextFromContext(c.Request.Context()).TraceID().String()
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/user", nil)
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	router.ServeHTTP(httptest.NewRecorder(), r)
+
+	require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", traceID)
+}
@@ -1,29 +1,55 @@
 package api
 
 import (
-	"context"
-	"log"
+	"log/slog"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jackc/pgx/v5"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/apierror"
 )
 
 // userHandler gets the information of the user based on their session.
 func (s *Server) userHandler(c *gin.Context) {
 	userId := c.MustGet("user").(int32)
 
-	user, err := s.repository.FindUserWithId(context.Background(), userId)
+	user, err := s.repository.FindUserWithId(c.Request.Context(), userId)
 	if err == pgx.ErrNoRows {
-		log.Printf("error: user with ID %v does not exist in database", userId)
-		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "user not found"})
+		slog.WarnContext(c.Request.Context(), "user does not exist in database")
+		abortWithError(c, apierror.NotFound("user not found"))
 		return
 	}
 	if err != nil {
-		log.Printf("error retrieving user with ID %v from database: %v", userId, err)
-		c.IndentedJSON(http.StatusInternalServerError, gin.H{"message": "error retrieving user"})
+		slog.ErrorContext(c.Request.Context(), "error retrieving user", "err", err)
+		abortWithError(c, apierror.From(err, "error retrieving user"))
 		return
 	}
 
-	c.IndentedJSON(http.StatusOK, user)
+	c.IndentedJSON(http.StatusOK, newUserResponse(user))
+}
+
+// deleteUserHandler deletes the user's account and, if users have
+// their own namespaces, the user's namespace. Users must delete
+// the workspaces they own first. Jobs they queued keep running.
+func (s *Server) deleteUserHandler(c *gin.Context) {
+	userId := c.MustGet("user").(int32)
+	auditTarget(c, "user", userId)
+
+	_, err := s.repository.DeleteUserWithId(c.Request.Context(), userId)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error deleting user", "err", err)
+		abortWithError(c, apierror.From(err, "error removing user"))
+		return
+	}
+
+	// The account is already gone, so a leftover namespace is only logged
+	if err := s.controller.DeleteUserNamespace(c.Request.Context(), userId); err != nil {
+		slog.ErrorContext(c.Request.Context(), "error deleting namespace of user", "err", err)
+	}
+
+	if err := s.sessionStore.Destroy(c.Request.Context()); err != nil {
+		slog.ErrorContext(c.Request.Context(), "error destroying session of user", "err", err)
+	}
+
+	c.Status(http.StatusOK)
 }
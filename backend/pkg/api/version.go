@@ -0,0 +1,52 @@
+package api
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/apierror"
+)
+
+// Version of the API. Its routes are served under apiPrefix.
+const apiVersion = "v1"
+
+const apiPrefix = "/api/" + apiVersion
+
+// Header naming the version of the API that served a response.
+const apiVersionHeader = "API-Version"
+
+// Matches paths under a version of the API, capturing the version.
+var versionedPath = regexp.MustCompile(`^/api/(v[0-9]+)(/|$)`)
+
+// versionMiddleware names the version of the API in responses.
+func versionMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header(apiVersionHeader, apiVersion)
+		c.Next()
+	}
+}
+
+// deprecatedMiddleware marks responses of the routes from before the API
+// was versioned as deprecated, linking to the same route under apiPrefix.
+func deprecatedMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Deprecation", "true")
+		c.Header("Link", fmt.Sprintf(`<%v%v>; rel="successor-version"`, apiPrefix, c.Request.URL.Path))
+		c.Header(apiVersionHeader, apiVersion)
+		c.Next()
+	}
+}
+
+// noRouteHandler responds to requests for routes that don't exist,
+// telling clients asking for another version of the API which version is
+// served.
+func noRouteHandler(c *gin.Context) {
+	if match := versionedPath.FindStringSubmatch(c.Request.URL.Path); match != nil && match[1] != apiVersion {
+		c.Header(apiVersionHeader, apiVersion)
+		abortWithError(c, apierror.NotFound(fmt.Sprintf("API version %v is not supported; use %v", match[1], apiVersion)))
+		return
+	}
+
+	abortWithError(c, apierror.NotFound("route not found"))
+}
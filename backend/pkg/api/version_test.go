@@ -0,0 +1,56 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/apierror"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVersionNegotiation(t *testing.T) {
+	doc, router, err := loadOpenAPI()
+	require.Nil(t, err)
+
+	s := &Server{router: gin.New(), config: &Config{}, sessionStore: scs.New(), openapi: doc, openapiRouter: router}
+	s.router.NoRoute(noRouteHandler)
+	MainServerRegistry{}.RegisterHandlers(s)
+	handler := s.sessionStore.LoadAndSave(s.router)
+
+	tests := []struct {
+		name           string
+		path           string
+		wantStatus     int
+		wantVersion    string
+		wantDeprecated bool
+		wantLink       string
+	}{
+		{"current version", "/api/v1/user", http.StatusUnauthorized, "v1", false, ""},
+		{"deprecated alias", "/user", http.StatusUnauthorized, "v1", true, `</api/v1/user>; rel="successor-version"`},
+		{"unsupported version", "/api/v2/user", http.StatusNotFound, "v1", false, ""},
+		{"unversioned", "/openapi.json", http.StatusOK, "", false, ""},
+		{"no route", "/nowhere", http.StatusNotFound, "", false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			require.Equal(t, tt.wantStatus, w.Code)
+			require.Equal(t, tt.wantVersion, w.Header().Get(apiVersionHeader))
+			require.Equal(t, tt.wantDeprecated, w.Header().Get("Deprecation") != "")
+			require.Equal(t, tt.wantLink, w.Header().Get("Link"))
+
+			if tt.wantStatus >= 400 {
+				var problem apierror.Problem
+				require.Nil(t, json.Unmarshal(w.Body.Bytes(), &problem))
+				require.NotEmpty(t, problem.Code)
+			}
+		})
+	}
+}
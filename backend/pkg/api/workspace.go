@@ -2,21 +2,69 @@ package api
 
 import (
 	"context"
-	"errors"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
+	"maps"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/jackc/pgerrcode"
 	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/apierror"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/controller/spec"
 	"github.com/johngerving/kubernetes-web-client/backend/pkg/database/repository"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/events"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// States of a workspace, tracking the jobs acting on its cluster resources.
+const (
+	workspaceCreating  = "creating"
+	workspaceReady     = "ready"
+	workspaceResizing  = "resizing"
+	workspaceCloning   = "cloning"   // The workspace's volume is being populated from its source
+	workspaceRestoring = "restoring" // The workspace's volume is being restored from a snapshot
+	workspaceImporting = "importing" // The workspace's volume is being populated from an archive
+	workspaceFailed    = "failed"    // The last job on the workspace ran out of attempts
+)
+
+var workspaceStates = []string{workspaceCreating, workspaceReady, workspaceResizing, workspaceCloning, workspaceRestoring, workspaceImporting, workspaceFailed}
+
+// workspaceSettled returns whether no job is acting on a workspace in a
+// state, so the workspace may be changed.
+func workspaceSettled(state string) bool {
+	return state == workspaceReady || state == workspaceFailed
+}
+
+// Sorts of a list of workspaces. Sorts starting with "-" are descending.
+var workspaceSorts = []string{"name", "-name", "created_at", "-created_at", "last_active", "-last_active"}
+
+const (
+	defaultWorkspaceSort  = "created_at"
+	defaultWorkspaceLimit = 50
+	maxWorkspaceLimit     = 200
+)
+
+// Limits of a workspace's description and labels.
+const (
+	maxDescriptionLength = 1000
+	maxWorkspaceLabels   = 64
 )
 
 type postWorkspaceForm struct {
-	Name string `json:"name"`
+	Name     string            `json:"name"`
+	Team     *int32            `json:"team"`     // Team to create the workspace in, if any
+	Template *int32            `json:"template"` // Template to create the workspace from, or nil for the default template
+	Labels   map[string]string `json:"labels"`
 }
 
 // valid checks if a postWorkspaceForm struct is valid. It
@@ -28,11 +76,225 @@ func (f *postWorkspaceForm) valid() (problems map[string]string) {
 		problems["name"] = "Name must be at least 2 characters long"
 	}
 
+	labelProblems(f.Labels, problems)
+
+	return problems
+}
+
+// cloneWorkspaceForm holds the name of a workspace cloned from another.
+type cloneWorkspaceForm struct {
+	Name string `json:"name"`
+}
+
+// valid checks if a cloneWorkspaceForm struct is valid. It
+// returns a map[string]string containing any problems.
+func (f *cloneWorkspaceForm) valid() (problems map[string]string) {
+	problems = make(map[string]string)
+
+	if len(f.Name) <= 2 {
+		problems["name"] = "Name must be at least 2 characters long"
+	}
+
 	return problems
 }
 
-// postWorkspaceHandler creates a new workspace for
-// the user.
+// patchWorkspaceForm holds changes to a workspace. Fields that are nil are
+// left unchanged.
+type patchWorkspaceForm struct {
+	Name        *string             `json:"name"`
+	Description *string             `json:"description"`
+	Labels      *map[string]string  `json:"labels"` // Replaces all of the workspace's labels
+	Resources   *patchResourcesForm `json:"resources"`
+}
+
+// patchResourcesForm holds changes to the resources of a workspace. Fields
+// that are nil are left unchanged.
+type patchResourcesForm struct {
+	CPU     *string `json:"cpu"`
+	Memory  *string `json:"memory"`
+	Storage *string `json:"storage"`
+}
+
+// valid checks if a patchWorkspaceForm struct is valid. It
+// returns a map[string]string containing any problems.
+func (f *patchWorkspaceForm) valid() (problems map[string]string) {
+	problems = make(map[string]string)
+
+	if f.Name != nil && len(*f.Name) <= 2 {
+		problems["name"] = "Name must be at least 2 characters long"
+	}
+
+	if f.Description != nil && len(*f.Description) > maxDescriptionLength {
+		problems["description"] = fmt.Sprintf("Description must be at most %d characters long", maxDescriptionLength)
+	}
+
+	if f.Labels != nil {
+		labelProblems(*f.Labels, problems)
+	}
+
+	if f.Resources != nil {
+		quantities := map[string]*string{"resources.cpu": f.Resources.CPU, "resources.memory": f.Resources.Memory, "resources.storage": f.Resources.Storage}
+		for field, quantity := range quantities {
+			if quantity == nil {
+				continue
+			}
+			if q, err := resource.ParseQuantity(*quantity); err != nil || q.Sign() <= 0 {
+				problems[field] = "Resource must be a positive Kubernetes resource quantity"
+			}
+		}
+	}
+
+	return problems
+}
+
+// resourceLimitProblems adds the problems of resources larger than the
+// largest a workspace may run with to problems. Resources and maximums that
+// are empty aren't checked.
+func resourceLimitProblems(resources spec.Resources, max spec.Resources, problems map[string]string) {
+	limits := []struct {
+		field    string
+		quantity string
+		max      string
+	}{
+		{"resources.cpu", resources.CPU, max.CPU},
+		{"resources.memory", resources.Memory, max.Memory},
+		{"resources.storage", resources.Storage, max.Storage},
+	}
+	for _, l := range limits {
+		if exceeds(l.quantity, l.max) {
+			problems[l.field] = fmt.Sprintf("Resource must be at most %v", l.max)
+		}
+	}
+}
+
+// clampResources returns resources lowered to the largest a workspace may
+// run with. Resources and maximums that are empty are left unchanged.
+func clampResources(resources spec.Resources, max spec.Resources) spec.Resources {
+	if exceeds(resources.CPU, max.CPU) {
+		resources.CPU = max.CPU
+	}
+	if exceeds(resources.Memory, max.Memory) {
+		resources.Memory = max.Memory
+	}
+	if exceeds(resources.Storage, max.Storage) {
+		resources.Storage = max.Storage
+	}
+	return resources
+}
+
+// exceeds returns whether a resource quantity is larger than a maximum.
+// Quantities and maximums that are empty or invalid never exceed.
+func exceeds(quantity string, max string) bool {
+	if quantity == "" || max == "" {
+		return false
+	}
+	q, err := resource.ParseQuantity(quantity)
+	if err != nil {
+		return false
+	}
+	m, err := resource.ParseQuantity(max)
+	return err == nil && q.Cmp(m) > 0
+}
+
+// requested returns the resources the form changes.
+func (f *patchResourcesForm) requested() spec.Resources {
+	var resources spec.Resources
+	if f == nil {
+		return resources
+	}
+	if f.CPU != nil {
+		resources.CPU = *f.CPU
+	}
+	if f.Memory != nil {
+		resources.Memory = *f.Memory
+	}
+	if f.Storage != nil {
+		resources.Storage = *f.Storage
+	}
+	return resources
+}
+
+// labelProblems adds the problems of a workspace's labels to problems.
+// Labels follow the syntax of Kubernetes labels, since they're put on the
+// workspace's pod and volume claim, and can't use the keys the controller
+// labels them with.
+func labelProblems(labels map[string]string, problems map[string]string) {
+	if len(labels) > maxWorkspaceLabels {
+		problems["labels"] = fmt.Sprintf("Workspaces can have at most %d labels", maxWorkspaceLabels)
+	}
+
+	for key, value := range labels {
+		field := "labels." + key
+		if key == "" {
+			problems["labels"] = "Label keys must not be empty"
+		} else if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			problems[field] = "Invalid label key: " + strings.Join(errs, "; ")
+		} else if spec.ReservedLabel(key) {
+			problems[field] = fmt.Sprintf("Label key %v is reserved", key)
+		} else if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+			problems[field] = "Invalid label value: " + strings.Join(errs, "; ")
+		}
+	}
+}
+
+// encodeLabels encodes a workspace's labels as they're stored.
+func encodeLabels(labels map[string]string) ([]byte, error) {
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	return json.Marshal(labels)
+}
+
+// apply returns the update of a workspace making the form's changes.
+func (f *patchWorkspaceForm) apply(workspace repository.Workspace) (repository.UpdateWorkspaceParams, error) {
+	update := repository.UpdateWorkspaceParams{
+		ID:          workspace.ID,
+		Name:        workspace.Name,
+		Description: workspace.Description,
+		Labels:      workspace.Labels,
+		Cpu:         workspace.Cpu,
+		Memory:      workspace.Memory,
+		Storage:     workspace.Storage,
+	}
+
+	if f.Name != nil {
+		update.Name = *f.Name
+	}
+	if f.Description != nil {
+		update.Description = *f.Description
+	}
+	if f.Labels != nil {
+		labels, err := encodeLabels(*f.Labels)
+		if err != nil {
+			return repository.UpdateWorkspaceParams{}, err
+		}
+		update.Labels = labels
+	}
+	if f.Resources != nil {
+		if f.Resources.CPU != nil {
+			update.Cpu = *f.Resources.CPU
+		}
+		if f.Resources.Memory != nil {
+			update.Memory = *f.Resources.Memory
+		}
+		if f.Resources.Storage != nil {
+			update.Storage = *f.Resources.Storage
+		}
+	}
+
+	return update, nil
+}
+
+// postWorkspaceResponse is a created workspace, along with the operation
+// creating its cluster resources.
+type postWorkspaceResponse struct {
+	workspaceResponse
+	Operation int64 `json:"operation"` // ID of the job polled at /api/v1/operations/:id
+}
+
+// postWorkspaceHandler creates a new workspace for the user. The workspace's
+// cluster resources are created by a job, whose ID is returned for the
+// client to poll.
 func (s *Server) postWorkspaceHandler(c *gin.Context) {
 	// Get user id
 	userId := c.MustGet("user").(int32)
@@ -42,77 +304,701 @@ func (s *Server) postWorkspaceHandler(c *gin.Context) {
 	c.ShouldBind(&workspaceParams)
 
 	if problems := workspaceParams.valid(); len(problems) > 0 {
-		log.Printf("workspace param problems: %v\n", problems)
-		c.IndentedJSON(http.StatusBadRequest, problems)
+		slog.InfoContext(c.Request.Context(), "workspace param problems", "problems", problems)
+		abortWithError(c, apierror.Invalid(problems))
+		return
+	}
+
+	// Resolve the team the workspace is created in
+	var team repository.Team
+	if workspaceParams.Team != nil {
+		var ok bool
+		team, ok = s.workspaceTeam(c, userId, *workspaceParams.Team)
+		if !ok {
+			return
+		}
+	}
+
+	template, ok := s.workspaceTemplate(c, workspaceParams.Template)
+	if !ok {
+		return
+	}
+
+	// Add the workspace and the job creating its resources in one transaction
+	tx, err := s.db.Begin(c.Request.Context())
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error starting transaction", "err", err)
+		abortWithError(c, apierror.From(err, "error creating workspace"))
+		return
+	}
+	defer tx.Rollback(context.Background())
+	qtx := s.repository.WithTx(tx)
+
+	if workspaceParams.Team != nil && !s.reserveTeamWorkspace(c, qtx, team.ID) {
+		return
+	}
+
+	labels, err := encodeLabels(workspaceParams.Labels)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error encoding labels of workspace", "err", err)
+		abortWithError(c, apierror.From(err, "error creating workspace"))
 		return
 	}
 
 	// Add workspace to db
-	workspace, err := s.repository.CreateWorkspace(context.Background(), repository.CreateWorkspaceParams{
-		Name:  workspaceParams.Name,
-		Owner: userId,
+	workspace, err := qtx.CreateWorkspace(c.Request.Context(), repository.CreateWorkspaceParams{
+		Name:     workspaceParams.Name,
+		Owner:    userId,
+		Team:     pgtype.Int4{Int32: team.ID, Valid: workspaceParams.Team != nil},
+		Template: template.ID,
+		Labels:   labels,
+	})
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error creating workspace", "err", err)
+		abortWithError(c, apierror.From(err, "error creating workspace"))
+		return
+	}
+
+	auditTarget(c, "workspace", workspace.ID)
+	auditDetail(c, "name", workspace.Name)
+	auditDetail(c, "template", workspace.Template)
+
+	// Create the workspace's cluster resources in the background
+	job, err := s.jobs.EnqueueTx(c.Request.Context(), qtx, createWorkspaceJob, userId, workspaceJob{Workspace: workspace.ID})
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error creating resources of workspace", "workspace", workspace.ID, "err", err)
+		abortWithError(c, apierror.From(err, "error creating workspace"))
+		return
+	}
+
+	if err := tx.Commit(c.Request.Context()); err != nil {
+		slog.ErrorContext(c.Request.Context(), "error creating workspace", "err", err)
+		abortWithError(c, apierror.From(err, "error creating workspace"))
+		return
+	}
+
+	s.publishWorkspaceEvent(c.Request.Context(), events.WorkspaceCreated, workspace.ID)
+
+	c.Header("Location", fmt.Sprintf("%v/operations/%d", apiPrefix, job.ID))
+	c.IndentedJSON(http.StatusAccepted, postWorkspaceResponse{workspaceResponse: newWorkspaceResponse(workspace), Operation: job.ID})
+}
+
+// cloneWorkspaceHandler creates a new workspace for the user with the
+// template, settings and labels of another, its volume populated from the
+// other's volume. The cluster resources of the clone are created by a job,
+// whose ID is returned for the client to poll. Viewers of the other
+// workspace may clone it, since they may export it too, and the clone's
+// files are copied across namespaces when the clone lands in another one.
+func (s *Server) cloneWorkspaceHandler(c *gin.Context) {
+	userId := c.MustGet("user").(int32)
+	sourceId := c.MustGet("workspace").(int32)
+
+	cloneParams := cloneWorkspaceForm{}
+	c.ShouldBind(&cloneParams)
+
+	if problems := cloneParams.valid(); len(problems) > 0 {
+		slog.InfoContext(c.Request.Context(), "workspace param problems", "problems", problems)
+		abortWithError(c, apierror.Invalid(problems))
+		return
+	}
+
+	source, err := s.repository.FindWorkspaceWithId(c.Request.Context(), sourceId)
+	if err == pgx.ErrNoRows {
+		abortWithError(c, apierror.NotFound("workspace not found"))
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error retrieving workspace", "err", err)
+		abortWithError(c, apierror.From(err, "error cloning workspace"))
+		return
+	}
+
+	// A volume still being populated can't be copied
+	if workspaceBusy(source.State) {
+		abortWithError(c, apierror.Conflict(apierror.CodeNotReady, fmt.Sprintf("workspace is %v", source.State)))
+		return
+	}
+
+	// The clone belongs to the source's team, so it counts against the team's quota
+	if source.Team.Valid {
+		if _, ok := s.workspaceTeam(c, userId, source.Team.Int32); !ok {
+			return
+		}
+	}
+
+	// Add the clone and the job creating its resources in one transaction
+	tx, err := s.db.Begin(c.Request.Context())
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error starting transaction", "err", err)
+		abortWithError(c, apierror.From(err, "error cloning workspace"))
+		return
+	}
+	defer tx.Rollback(context.Background())
+	qtx := s.repository.WithTx(tx)
+
+	if source.Team.Valid && !s.reserveTeamWorkspace(c, qtx, source.Team.Int32) {
+		return
+	}
+
+	workspace, err := qtx.CloneWorkspace(c.Request.Context(), repository.CloneWorkspaceParams{
+		Name:   cloneParams.Name,
+		Owner:  userId,
+		Source: sourceId,
 	})
+	if err == pgx.ErrNoRows {
+		abortWithError(c, apierror.NotFound("workspace not found"))
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error cloning workspace", "err", err)
+		abortWithError(c, apierror.From(err, "error cloning workspace"))
+		return
+	}
+
+	auditTarget(c, "workspace", workspace.ID)
+	auditDetail(c, "name", workspace.Name)
+	auditDetail(c, "source", sourceId)
+
+	// Populate the clone's volume and create its pod in the background
+	job, err := s.jobs.EnqueueTx(c.Request.Context(), qtx, cloneWorkspaceJob, userId, clonedWorkspaceJob{Workspace: workspace.ID, Source: sourceId})
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error creating resources of workspace", "workspace", workspace.ID, "err", err)
+		abortWithError(c, apierror.From(err, "error cloning workspace"))
+		return
+	}
+
+	if err := tx.Commit(c.Request.Context()); err != nil {
+		slog.ErrorContext(c.Request.Context(), "error cloning workspace", "err", err)
+		abortWithError(c, apierror.From(err, "error cloning workspace"))
+		return
+	}
+
+	s.publishWorkspaceEvent(c.Request.Context(), events.WorkspaceCreated, workspace.ID)
+
+	c.Header("Location", fmt.Sprintf("%v/operations/%d", apiPrefix, job.ID))
+	c.IndentedJSON(http.StatusAccepted, postWorkspaceResponse{workspaceResponse: newWorkspaceResponse(workspace), Operation: job.ID})
+}
+
+// patchWorkspaceResponse is an updated workspace, along with the operation
+// applying its changes to its cluster resources if its resources or labels
+// changed.
+type patchWorkspaceResponse struct {
+	workspaceResponse
+	Operation *int64 `json:"operation,omitempty"` // ID of the job polled at /api/v1/operations/:id
+}
+
+// getWorkspaceHandler gets a workspace with the user's role in it, the state
+// of its cluster resources and the URLs it's reached at. The workspace is
+// returned without its state if the cluster can't be reached.
+func (s *Server) getWorkspaceHandler(c *gin.Context) {
+	userId := c.MustGet("user").(int32)
+	workspaceId := c.MustGet("workspace").(int32)
+	role := c.MustGet("role").(string)
+
+	workspace, err := s.repository.FindWorkspaceWithId(c.Request.Context(), workspaceId)
+	if err == pgx.ErrNoRows {
+		abortWithError(c, apierror.NotFound("workspace not found"))
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error retrieving workspace", "err", err)
+		abortWithError(c, apierror.From(err, "error retrieving workspace"))
+		return
+	}
+
+	// Opening a workspace counts as activity in it
+	if err := s.repository.TouchWorkspace(c.Request.Context(), workspace.ID); err != nil {
+		slog.WarnContext(c.Request.Context(), "error recording activity in workspace", "err", err)
+	}
+
+	response := workspaceDetailResponse{
+		userWorkspaceResponse: userWorkspaceResponse{
+			workspaceResponse: newWorkspaceResponse(workspace),
+			Role:              role,
+			Shared:            workspace.Owner != userId,
+		},
+		URLs: s.workspaceURLs(workspace.ID),
+	}
+
+	var state spec.WorkspaceState
+	workspaceSpec, err := s.workspaceSpec(c.Request.Context(), workspace)
+	if err == nil {
+		state, err = s.controller.InspectWorkspace(c.Request.Context(), workspaceSpec)
+	}
+	if err != nil {
+		slog.WarnContext(c.Request.Context(), "error inspecting resources of workspace", "err", err)
+	} else {
+		response.Status = newWorkspaceStatusResponse(state)
+	}
+
+	c.IndentedJSON(http.StatusOK, response)
+}
+
+// workspaceURLs returns the URLs a workspace is reached at, by name.
+func (s *Server) workspaceURLs(workspaceId int32) map[string]string {
+	urls := make(map[string]string)
+	if s.config.WorkspaceURL != "" {
+		urls["web"] = strings.ReplaceAll(s.config.WorkspaceURL, "{id}", fmt.Sprint(workspaceId))
+	}
+	return urls
+}
+
+// patchWorkspaceHandler changes a workspace's name, description, labels or
+// resources. Changed resources and labels are applied to the workspace's
+// cluster resources by a job, restarting the workspace if its resources
+// changed, whose ID is returned for the client to poll.
+func (s *Server) patchWorkspaceHandler(c *gin.Context) {
+	userId := c.MustGet("user").(int32)
+	workspaceId := c.MustGet("workspace").(int32)
+
+	workspaceParams := patchWorkspaceForm{}
+	c.ShouldBind(&workspaceParams)
+
+	problems := workspaceParams.valid()
+	resourceLimitProblems(workspaceParams.Resources.requested(), s.config.MaxResources, problems)
+	if len(problems) > 0 {
+		slog.InfoContext(c.Request.Context(), "workspace param problems", "problems", problems)
+		abortWithError(c, apierror.Invalid(problems))
+		return
+	}
+
+	// Update the workspace and add the job resizing it in one transaction
+	tx, err := s.db.Begin(c.Request.Context())
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error starting transaction", "err", err)
+		abortWithError(c, apierror.From(err, "error updating workspace"))
+		return
+	}
+	defer tx.Rollback(context.Background())
+	qtx := s.repository.WithTx(tx)
+
+	current, err := qtx.FindWorkspaceWithId(c.Request.Context(), workspaceId)
+	if err == pgx.ErrNoRows {
+		abortWithError(c, apierror.NotFound("workspace not found"))
+		return
+	}
 	if err != nil {
-		log.Printf("error creating workspace: %v\n", err)
+		slog.ErrorContext(c.Request.Context(), "error retrieving workspace", "err", err)
+		abortWithError(c, apierror.From(err, "error updating workspace"))
+		return
+	}
 
-		// Check if row already exists in database
-		var e *pgconn.PgError
-		if errors.As(err, &e) && e.Code == pgerrcode.UniqueViolation {
-			c.IndentedJSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("workspace named %v already exists", workspaceParams.Name)})
+	// A job acting on the workspace would undo or be undone by the changes
+	if !workspaceSettled(current.State) {
+		abortWithError(c, apierror.Conflict(apierror.CodeNotReady, fmt.Sprintf("workspace is %v", current.State)))
+		return
+	}
+
+	update, err := workspaceParams.apply(current)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error encoding labels of workspace", "err", err)
+		abortWithError(c, apierror.From(err, "error updating workspace"))
+		return
+	}
+
+	// Volumes can only grow
+	currentStorage, _ := resource.ParseQuantity(workspaceResources(current).WithDefaults().Storage)
+	storage, _ := resource.ParseQuantity(spec.Resources{Storage: update.Storage}.WithDefaults().Storage)
+	if storage.Cmp(currentStorage) < 0 {
+		abortWithError(c, apierror.Invalid(map[string]string{"resources.storage": "Storage can't be smaller than the workspace's current storage"}))
+		return
+	}
+
+	// The workspace's state may have changed since it was retrieved
+	workspace, err := qtx.UpdateWorkspace(c.Request.Context(), update)
+	if err == pgx.ErrNoRows {
+		abortWithError(c, apierror.Conflict(apierror.CodeNotReady, "workspace is busy"))
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error updating workspace", "err", err)
+		abortWithError(c, apierror.From(err, "error updating workspace"))
+		return
+	}
+
+	if workspace.Name != current.Name {
+		auditDetail(c, "name", workspace.Name)
+	}
+
+	// Apply changed resources to the workspace's cluster resources in the background
+	var operation *int64
+	resources := workspaceResources(workspace).WithDefaults()
+	if resources != workspaceResources(current).WithDefaults() {
+		auditDetail(c, "resources", resources)
+
+		err := qtx.SetWorkspaceState(c.Request.Context(), repository.SetWorkspaceStateParams{ID: workspace.ID, State: workspaceResizing, StateMessage: ""})
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "error updating state of workspace", "err", err)
+			abortWithError(c, apierror.From(err, "error updating workspace"))
+			return
 		}
-		c.IndentedJSON(http.StatusInternalServerError, gin.H{"message": "error creating workspace"})
+		workspace.State = workspaceResizing
+		workspace.StateMessage = ""
+
+		job, err := s.jobs.EnqueueTx(c.Request.Context(), qtx, resizeWorkspaceJob, userId, workspaceJob{Workspace: workspace.ID})
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "error resizing resources of workspace", "err", err)
+			abortWithError(c, apierror.From(err, "error updating workspace"))
+			return
+		}
+		operation = &job.ID
+	} else if labels := workspaceLabels(workspace.Labels); !maps.Equal(labels, workspaceLabels(current.Labels)) {
+		// Resizing relabels the workspace's resources too, so labels are only
+		// applied on their own when the resources are unchanged
+		auditDetail(c, "labels", labels)
+
+		job, err := s.jobs.EnqueueTx(c.Request.Context(), qtx, labelWorkspaceJob, userId, workspaceJob{Workspace: workspace.ID})
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "error labeling resources of workspace", "err", err)
+			abortWithError(c, apierror.From(err, "error updating workspace"))
+			return
+		}
+		operation = &job.ID
+	}
+
+	if err := tx.Commit(c.Request.Context()); err != nil {
+		slog.ErrorContext(c.Request.Context(), "error updating workspace", "err", err)
+		abortWithError(c, apierror.From(err, "error updating workspace"))
 		return
 	}
 
-	c.IndentedJSON(http.StatusOK, workspace)
+	s.publishWorkspaceEvent(c.Request.Context(), events.WorkspaceUpdated, workspace.ID)
+
+	response := patchWorkspaceResponse{workspaceResponse: newWorkspaceResponse(workspace), Operation: operation}
+	if response.Operation != nil {
+		c.Header("Location", fmt.Sprintf("%v/operations/%d", apiPrefix, *response.Operation))
+		c.IndentedJSON(http.StatusAccepted, response)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, response)
+}
+
+// workspaceTeam retrieves a team a user wants to create a workspace in,
+// checking that the user may create workspaces in it. If not, it writes an
+// error response and returns false. The team's workspace quota is checked
+// by reserveTeamWorkspace when the workspace is added.
+func (s *Server) workspaceTeam(c *gin.Context, userId int32, teamId int32) (repository.Team, bool) {
+	role, err := s.repository.FindTeamRole(c.Request.Context(), repository.FindTeamRoleParams{
+		Team:   teamId,
+		Member: userId,
+	})
+	if err == pgx.ErrNoRows {
+		abortWithError(c, apierror.NotFound("team not found"))
+		return repository.Team{}, false
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error retrieving role of user in team", "team", teamId, "err", err)
+		abortWithError(c, apierror.From(err, "error retrieving team"))
+		return repository.Team{}, false
+	}
+	if !roleAllows(role, roleEditor) {
+		abortWithError(c, apierror.Forbidden())
+		return repository.Team{}, false
+	}
+
+	team, err := s.repository.FindTeamWithId(c.Request.Context(), teamId)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error retrieving team", "team", teamId, "err", err)
+		abortWithError(c, apierror.From(err, "error retrieving team"))
+		return repository.Team{}, false
+	}
+
+	return team, true
 }
 
-// deleteWorkspaceHandler deletes a workspace with a
-// given ID.
+// reserveTeamWorkspace checks, in the transaction adding a workspace to a
+// team, that the team's workspace quota isn't exhausted. The team is locked
+// until the transaction ends, so that workspaces added at the same time
+// can't exceed the quota together. If the quota is exhausted, it writes an
+// error response and returns false.
+func (s *Server) reserveTeamWorkspace(c *gin.Context, qtx *repository.Queries, teamId int32) bool {
+	team, err := qtx.LockTeam(c.Request.Context(), teamId)
+	if err == pgx.ErrNoRows {
+		abortWithError(c, apierror.NotFound("team not found"))
+		return false
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error retrieving team", "team", teamId, "err", err)
+		abortWithError(c, apierror.From(err, "error retrieving team"))
+		return false
+	}
+
+	if team.MaxWorkspaces > 0 {
+		count, err := qtx.CountTeamWorkspaces(c.Request.Context(), pgtype.Int4{Int32: teamId, Valid: true})
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "error counting workspaces of team", "team", teamId, "err", err)
+			abortWithError(c, apierror.From(err, "error retrieving team"))
+			return false
+		}
+		if count >= int64(team.MaxWorkspaces) {
+			abortWithError(c, apierror.Conflict(apierror.CodeQuotaExceeded, "team workspace quota exceeded"))
+			return false
+		}
+	}
+
+	return true
+}
+
+// deleteWorkspaceHandler deletes a workspace with a given ID. The
+// workspace's cluster resources are removed by a job, whose ID is returned
+// for the client to poll.
 func (s *Server) deleteWorkspaceHandler(c *gin.Context) {
 	userId := c.MustGet("user").(int32)
+	workspaceId := c.MustGet("workspace").(int32)
 
-	// Get the workspace ID
-	idParam := c.Param("id")
-	workspaceId, err := strconv.Atoi(idParam)
+	// Remove the workspace and add the job removing its resources in one transaction
+	tx, err := s.db.Begin(c.Request.Context())
 	if err != nil {
-		log.Printf("error in id param: %v\n", err)
-		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "invalid ID param"})
+		slog.ErrorContext(c.Request.Context(), "error starting transaction", "err", err)
+		abortWithError(c, apierror.From(err, "error removing workspace"))
 		return
 	}
+	defer tx.Rollback(context.Background())
+	qtx := s.repository.WithTx(tx)
 
-	// Create params for database delete
-	params := repository.DeleteWorkspaceWithIdParams{
-		Owner: userId,
-		ID:    int32(workspaceId),
+	// Find who to notify before the workspace's roles are gone
+	users, err := qtx.ListWorkspaceUsers(c.Request.Context(), workspaceId)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error retrieving users of workspace", "err", err)
+		abortWithError(c, apierror.From(err, "error removing workspace"))
+		return
 	}
 
-	_, err = s.repository.DeleteWorkspaceWithId(context.Background(), params)
+	workspace, err := qtx.DeleteWorkspaceWithId(c.Request.Context(), workspaceId)
 	if err == pgx.ErrNoRows {
-		log.Printf("row with ID %v owned by user with ID %v does not exist: %v", workspaceId, userId, err)
-		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "workspace not found"})
+		slog.WarnContext(c.Request.Context(), "workspace does not exist", "err", err)
+		abortWithError(c, apierror.NotFound("workspace not found"))
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error deleting workspace", "err", err)
+		abortWithError(c, apierror.From(err, "error removing workspace"))
 		return
 	}
+
+	// Remove the workspace's cluster resources in the background
+	var job repository.Job
+	workspaceSpec, err := workspaceSpecWith(c.Request.Context(), qtx, workspace)
+	if err == nil {
+		job, err = s.jobs.EnqueueTx(c.Request.Context(), qtx, deleteWorkspaceJob, userId, deletedWorkspaceJob{Workspace: workspaceSpec})
+	}
 	if err != nil {
-		log.Printf("error deleting workspace with ID %v: %v\n", workspaceId, err)
-		c.IndentedJSON(http.StatusInternalServerError, gin.H{"message": "error removing workspace"})
+		slog.ErrorContext(c.Request.Context(), "error removing resources of workspace", "err", err)
+		abortWithError(c, apierror.From(err, "error removing workspace"))
+		return
+	}
+
+	if err := tx.Commit(c.Request.Context()); err != nil {
+		slog.ErrorContext(c.Request.Context(), "error deleting workspace", "err", err)
+		abortWithError(c, apierror.From(err, "error removing workspace"))
 		return
 	}
 
-	c.Status(http.StatusOK)
+	s.publishEvent(events.Event{Type: events.WorkspaceDeleted, Workspace: workspaceId, Users: users})
+
+	c.Header("Location", fmt.Sprintf("%v/operations/%d", apiPrefix, job.ID))
+	c.IndentedJSON(http.StatusAccepted, gin.H{"operation": job.ID})
+}
+
+// workspaceFilter reads the filters, sort and cursor of a workspace query
+// from the query string. It returns a map[string]string containing any
+// problems.
+func workspaceFilter(c *gin.Context, userId int32) (params repository.ListUserWorkspacesParams, problems map[string]string) {
+	problems = make(map[string]string)
+	params.Member = userId
+
+	if state := c.Query("state"); state != "" {
+		if !slices.Contains(workspaceStates, state) {
+			problems["state"] = fmt.Sprintf("State must be one of %v", strings.Join(workspaceStates, ", "))
+		}
+		params.State = pgtype.Text{String: state, Valid: true}
+	}
+
+	if template := c.Query("template"); template != "" {
+		id, err := strconv.ParseInt(template, 10, 32)
+		if err != nil {
+			problems["template"] = "Template must be a template ID"
+		}
+		params.Template = pgtype.Int4{Int32: int32(id), Valid: true}
+	}
+
+	if shared := c.Query("shared"); shared != "" {
+		b, err := strconv.ParseBool(shared)
+		if err != nil {
+			problems["shared"] = "Shared must be true or false"
+		}
+		params.Shared = pgtype.Bool{Bool: b, Valid: true}
+	}
+
+	if search := c.Query("search"); search != "" {
+		params.Search = pgtype.Text{String: likeEscaper.Replace(search), Valid: true}
+	}
+
+	var err error
+	params.Labels, params.Requirements, err = labelSelector(c.Query("selector"))
+	if err != nil {
+		problems["selector"] = fmt.Sprintf("Invalid label selector: %v", err)
+	}
+
+	params.Sort = defaultWorkspaceSort
+	if sort := c.Query("sort"); sort != "" {
+		if !slices.Contains(workspaceSorts, sort) {
+			problems["sort"] = fmt.Sprintf("Sort must be one of %v", strings.Join(workspaceSorts, ", "))
+		}
+		params.Sort = sort
+	}
+
+	if cursor := c.Query("cursor"); cursor != "" {
+		after, err := decodeWorkspaceCursor(cursor)
+		if err != nil || after.Sort != params.Sort {
+			problems["cursor"] = "Invalid cursor"
+		}
+		params.AfterID = pgtype.Int4{Int32: after.ID, Valid: true}
+		params.AfterName = pgtype.Text{String: after.Name, Valid: true}
+		params.AfterTime = pgtype.Timestamptz{Time: after.Time, Valid: true}
+	}
+
+	params.MaxWorkspaces = defaultWorkspaceLimit
+	if limit := c.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxWorkspaceLimit {
+			problems["limit"] = fmt.Sprintf("Limit must be between 1 and %d", maxWorkspaceLimit)
+		}
+		params.MaxWorkspaces = int32(n)
+	}
+
+	return params, problems
+}
+
+// likeEscaper escapes the wildcards of a LIKE pattern, so a name search
+// matches them literally.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// labelRequirement is a requirement of a label selector, as matched by
+// ListUserWorkspaces.
+type labelRequirement struct {
+	Key      string   `json:"key"`
+	Operator string   `json:"operator"` // One of in, notin, exists and !
+	Allowed  []string `json:"allowed"`  // Values of the label allowed by in, or disallowed by notin
 }
 
-// getWorkspacesHandler gets a list of a user's
-// workspaces.
+// labelSelector parses a Kubernetes label selector into the labels every
+// workspace it selects has, and its requirements, encoded as JSON.
+func labelSelector(selector string) (equal []byte, requirements []byte, err error) {
+	parsed, err := labels.Parse(selector)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	reqs, _ := parsed.Requirements()
+	equalLabels := make(map[string]string)
+	labelReqs := make([]labelRequirement, 0, len(reqs))
+	for _, r := range reqs {
+		values := r.Values().List()
+
+		var operator string
+		switch r.Operator() {
+		case selection.Equals, selection.DoubleEquals:
+			// Equal labels can be matched with the labels index
+			equalLabels[r.Key()] = values[0]
+			operator = "in"
+		case selection.In:
+			operator = "in"
+		case selection.NotEquals, selection.NotIn:
+			operator = "notin"
+		case selection.Exists:
+			operator = "exists"
+		case selection.DoesNotExist:
+			operator = "!"
+		default:
+			return nil, nil, fmt.Errorf("operator %v isn't supported", r.Operator())
+		}
+
+		labelReqs = append(labelReqs, labelRequirement{Key: r.Key(), Operator: operator, Allowed: values})
+	}
+
+	if equal, err = json.Marshal(equalLabels); err != nil {
+		return nil, nil, err
+	}
+	if requirements, err = json.Marshal(labelReqs); err != nil {
+		return nil, nil, err
+	}
+	return equal, requirements, nil
+}
+
+// workspaceCursor is the position of the last workspace of a page in the
+// sort the page was listed in. Clients get it as opaque base64url-encoded
+// JSON.
+type workspaceCursor struct {
+	Sort string    `json:"sort"`
+	Name string    `json:"name,omitempty"` // Name of the workspace, when sorting by name
+	Time time.Time `json:"time"`           // Time the workspace was created or last active, when sorting by either
+	ID   int32     `json:"id"`
+}
+
+// newWorkspaceCursor returns the cursor of a workspace in a sort.
+func newWorkspaceCursor(sort string, w repository.ListUserWorkspacesRow) workspaceCursor {
+	cursor := workspaceCursor{Sort: sort, ID: w.ID}
+	switch strings.TrimPrefix(sort, "-") {
+	case "name":
+		cursor.Name = w.Name
+	case "created_at":
+		cursor.Time = w.CreatedAt.Time
+	case "last_active":
+		cursor.Time = w.LastActiveAt.Time
+	}
+	return cursor
+}
+
+func (c workspaceCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeWorkspaceCursor(cursor string) (workspaceCursor, error) {
+	var decoded workspaceCursor
+
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return decoded, err
+	}
+	err = json.Unmarshal(data, &decoded)
+	return decoded, err
+}
+
+// getWorkspacesHandler gets a page of the workspaces a user owns or has
+// been added to. Workspaces can be filtered by state, template, labels,
+// whether they're shared with the user and name, sorted by name, creation
+// or activity, and are paginated with the returned cursor, which is also
+// linked to in the Link header.
 func (s *Server) getWorkspacesHandler(c *gin.Context) {
 	userId := c.MustGet("user").(int32)
 
-	workspaces, err := s.repository.ListUserWorkspaces(context.Background(), userId)
+	params, problems := workspaceFilter(c, userId)
+	if len(problems) > 0 {
+		abortWithError(c, apierror.Invalid(problems))
+		return
+	}
+
+	workspaces, err := s.repository.ListUserWorkspaces(c.Request.Context(), params)
 	if err != nil {
-		log.Printf("error retrieving workspaces: %v\n", err)
-		c.IndentedJSON(http.StatusInternalServerError, gin.H{"message": "error retrieving workspacse"})
+		slog.ErrorContext(c.Request.Context(), "error retrieving workspaces", "err", err)
+		abortWithError(c, apierror.From(err, "error retrieving workspaces"))
 		return
 	}
 
-	c.IndentedJSON(http.StatusOK, workspaces)
+	// A full page may be followed by more workspaces
+	var next *string
+	if len(workspaces) > 0 && len(workspaces) == int(params.MaxWorkspaces) {
+		cursor := newWorkspaceCursor(params.Sort, workspaces[len(workspaces)-1]).encode()
+		next = &cursor
+
+		nextURL := *c.Request.URL
+		query := nextURL.Query()
+		query.Set("cursor", cursor)
+		nextURL.RawQuery = query.Encode()
+		c.Writer.Header().Add("Link", fmt.Sprintf(`<%v>; rel="next"`, nextURL.RequestURI()))
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"workspaces": newUserWorkspaceResponses(workspaces), "next_cursor": next})
 }
@@ -1,8 +1,17 @@
 package api
 
 import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/controller/spec"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/database/repository"
 	"github.com/stretchr/testify/require"
 )
 
@@ -28,3 +37,243 @@ func TestIsWorkspaceParamsValid(t *testing.T) {
 		})
 	}
 }
+
+func TestCloneWorkspaceFormValid(t *testing.T) {
+	require.Equal(t, map[string]string{}, (&cloneWorkspaceForm{Name: "thesis-copy"}).valid())
+	require.Equal(t, map[string]string{"name": "Name must be at least 2 characters long"}, (&cloneWorkspaceForm{}).valid())
+}
+
+func TestPatchWorkspaceFormValid(t *testing.T) {
+	tests := []struct {
+		description string
+		form        patchWorkspaceForm
+		want        map[string]string
+	}{
+		{"No changes", patchWorkspaceForm{}, map[string]string{}},
+		{"Valid changes", patchWorkspaceForm{
+			Name:        ptr("renamed"),
+			Description: ptr("My thesis"),
+			Labels:      &map[string]string{"project": "thesis"},
+			Resources:   &patchResourcesForm{CPU: ptr("500m"), Memory: ptr("4Gi"), Storage: ptr("20Gi")},
+		}, map[string]string{}},
+		{"Short name", patchWorkspaceForm{Name: ptr("")}, map[string]string{"name": "Name must be at least 2 characters long"}},
+		{"Long description", patchWorkspaceForm{Description: ptr(strings.Repeat("a", maxDescriptionLength+1))}, map[string]string{"description": "Description must be at most 1000 characters long"}},
+		{"Empty label key", patchWorkspaceForm{Labels: &map[string]string{"": "thesis"}}, map[string]string{"labels": "Label keys must not be empty"}},
+		{"Invalid resources", patchWorkspaceForm{Resources: &patchResourcesForm{CPU: ptr("lots"), Memory: ptr("0")}}, map[string]string{
+			"resources.cpu":    "Resource must be a positive Kubernetes resource quantity",
+			"resources.memory": "Resource must be a positive Kubernetes resource quantity",
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			require.Equal(t, test.want, test.form.valid())
+		})
+	}
+}
+
+func TestLabelProblems(t *testing.T) {
+	tests := []struct {
+		description string
+		labels      map[string]string
+		want        []string // Fields with problems
+	}{
+		{"No labels", nil, nil},
+		{"Valid labels", map[string]string{"project": "thesis", "example.com/cost-center": "ml-123", "archived": ""}, nil},
+		{"Invalid key", map[string]string{"my project": "thesis"}, []string{"labels.my project"}},
+		{"Invalid prefix", map[string]string{"Example.com/project": "thesis"}, []string{"labels.Example.com/project"}},
+		{"Invalid value", map[string]string{"project": "my thesis"}, []string{"labels.project"}},
+		{"Long value", map[string]string{"project": strings.Repeat("a", 64)}, []string{"labels.project"}},
+		{"Reserved keys", map[string]string{"workspace": "1", "app.kubernetes.io/name": "x", "kubernetes-web-client/user": "1"}, []string{
+			"labels.workspace", "labels.app.kubernetes.io/name", "labels.kubernetes-web-client/user",
+		}},
+		// Workspace pods could otherwise be selected as the app's pods
+		{"Selector keys", map[string]string{"k8s-app": "api", "app": "api", "pod-template-hash": "abc"}, []string{
+			"labels.k8s-app", "labels.app", "labels.pod-template-hash",
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			problems := make(map[string]string)
+			labelProblems(test.labels, problems)
+
+			require.Len(t, problems, len(test.want))
+			for _, field := range test.want {
+				require.Contains(t, problems, field)
+			}
+		})
+	}
+
+	problems := make(map[string]string)
+	labels := make(map[string]string)
+	for i := range maxWorkspaceLabels + 1 {
+		labels[fmt.Sprintf("label-%d", i)] = ""
+	}
+	labelProblems(labels, problems)
+	require.Equal(t, map[string]string{"labels": "Workspaces can have at most 64 labels"}, problems)
+}
+
+func TestResourceLimitProblems(t *testing.T) {
+	max := spec.Resources{CPU: "4", Memory: "16Gi", Storage: "100Gi"}
+
+	tests := []struct {
+		description string
+		resources   spec.Resources
+		max         spec.Resources
+		want        map[string]string
+	}{
+		{"Within maximums", spec.Resources{CPU: "4", Memory: "8Gi", Storage: "50Gi"}, max, map[string]string{}},
+		{"Defaults", spec.Resources{}, max, map[string]string{}},
+		{"Above maximums", spec.Resources{CPU: "4500m", Storage: "1Ti"}, max, map[string]string{
+			"resources.cpu":     "Resource must be at most 4",
+			"resources.storage": "Resource must be at most 100Gi",
+		}},
+		{"No maximums", spec.Resources{CPU: "64"}, spec.Resources{}, map[string]string{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			problems := make(map[string]string)
+			resourceLimitProblems(test.resources, test.max, problems)
+			require.Equal(t, test.want, problems)
+		})
+	}
+
+	clamped := clampResources(spec.Resources{CPU: "16", Memory: "8Gi", Storage: "2Ti"}, max)
+	require.Equal(t, spec.Resources{CPU: "4", Memory: "8Gi", Storage: "100Gi"}, clamped)
+	require.Equal(t, spec.Resources{}, clampResources(spec.Resources{}, max))
+
+	require.Equal(t, spec.Resources{Memory: "4Gi"}, (&patchResourcesForm{Memory: ptr("4Gi")}).requested())
+	require.Equal(t, spec.Resources{}, (*patchResourcesForm)(nil).requested())
+}
+
+func TestWorkspaceSettled(t *testing.T) {
+	for _, state := range workspaceStates {
+		require.Equal(t, state == workspaceReady || state == workspaceFailed, workspaceSettled(state), state)
+	}
+}
+
+func TestPatchWorkspaceFormApply(t *testing.T) {
+	workspace := repository.Workspace{ID: 1, Name: "workspace", Description: "My thesis", Labels: []byte(`{"project":"thesis"}`), Cpu: "2"}
+
+	// Fields left out are unchanged
+	form := patchWorkspaceForm{Name: ptr("renamed"), Resources: &patchResourcesForm{Storage: ptr("20Gi")}}
+	update, err := form.apply(workspace)
+	require.Nil(t, err)
+	require.Equal(t, repository.UpdateWorkspaceParams{
+		ID:          1,
+		Name:        "renamed",
+		Description: "My thesis",
+		Labels:      []byte(`{"project":"thesis"}`),
+		Cpu:         "2",
+		Storage:     "20Gi",
+	}, update)
+
+	// Labels are replaced
+	form = patchWorkspaceForm{Labels: &map[string]string{}}
+	update, err = form.apply(workspace)
+	require.Nil(t, err)
+	require.JSONEq(t, `{}`, string(update.Labels))
+}
+
+func TestWorkspaceFilter(t *testing.T) {
+	created := time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC)
+	cursor := workspaceCursor{Sort: "-created_at", Time: created, ID: 4}.encode()
+
+	tests := []struct {
+		name     string
+		query    string
+		want     repository.ListUserWorkspacesParams
+		problems []string
+	}{
+		{
+			name:  "defaults",
+			query: "",
+			want: repository.ListUserWorkspacesParams{
+				Member:        1,
+				Labels:        []byte(`{}`),
+				Requirements:  []byte(`[]`),
+				Sort:          defaultWorkspaceSort,
+				MaxWorkspaces: defaultWorkspaceLimit,
+			},
+		},
+		{
+			name:  "filters",
+			query: "state=ready&template=2&shared=false&search=50%25_off&selector=project%3Dthesis,!archived&sort=-created_at&cursor=" + cursor + "&limit=10",
+			want: repository.ListUserWorkspacesParams{
+				Member:        1,
+				State:         pgtype.Text{String: "ready", Valid: true},
+				Template:      pgtype.Int4{Int32: 2, Valid: true},
+				Shared:        pgtype.Bool{Bool: false, Valid: true},
+				Search:        pgtype.Text{String: `50\%\_off`, Valid: true},
+				Labels:        []byte(`{"project":"thesis"}`),
+				Requirements:  []byte(`[{"key":"archived","operator":"!","allowed":[]},{"key":"project","operator":"in","allowed":["thesis"]}]`),
+				AfterID:       pgtype.Int4{Int32: 4, Valid: true},
+				Sort:          "-created_at",
+				AfterName:     pgtype.Text{Valid: true},
+				AfterTime:     pgtype.Timestamptz{Time: created, Valid: true},
+				MaxWorkspaces: 10,
+			},
+		},
+		{
+			name:     "invalid",
+			query:    "state=running&template=x&shared=maybe&selector=a%3E1&sort=size&limit=500",
+			problems: []string{"state", "template", "shared", "selector", "sort", "limit"},
+		},
+		{
+			name:     "cursor of another sort",
+			query:    "sort=name&cursor=" + cursor,
+			problems: []string{"cursor"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodGet, "/user/workspaces?"+tt.query, nil)
+
+			params, problems := workspaceFilter(c, 1)
+			if tt.problems != nil {
+				require.Len(t, problems, len(tt.problems))
+				for _, key := range tt.problems {
+					require.Contains(t, problems, key)
+				}
+				return
+			}
+			require.Empty(t, problems)
+			require.Equal(t, tt.want, params)
+		})
+	}
+}
+
+func TestWorkspaceCursor(t *testing.T) {
+	active := time.Date(2024, 10, 1, 12, 30, 0, 0, time.UTC)
+	row := repository.ListUserWorkspacesRow{ID: 3, Name: "thesis", LastActiveAt: pgtype.Timestamptz{Time: active, Valid: true}}
+
+	tests := []struct {
+		sort string
+		want workspaceCursor
+	}{
+		{"name", workspaceCursor{Sort: "name", Name: "thesis", ID: 3}},
+		{"-last_active", workspaceCursor{Sort: "-last_active", Time: active, ID: 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.sort, func(t *testing.T) {
+			cursor := newWorkspaceCursor(tt.sort, row)
+			require.Equal(t, tt.want, cursor)
+
+			decoded, err := decodeWorkspaceCursor(cursor.encode())
+			require.NoError(t, err)
+			require.Equal(t, tt.want, decoded)
+		})
+	}
+
+	_, err := decodeWorkspaceCursor("not a cursor")
+	require.Error(t, err)
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
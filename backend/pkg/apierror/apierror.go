@@ -0,0 +1,221 @@
+// Package apierror describes the errors the API responds with. Every error
+// response is an RFC 7807 problem with a stable code clients can switch
+// on, the problems with each invalid field of the request, if any, and the
+// ID of the request.
+package apierror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/controller/spec"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// ContentType is the media type of problem responses.
+const ContentType = "application/problem+json"
+
+// Codes of API errors. They are part of the API, so existing codes must
+// not change.
+const (
+	CodeInvalidRequest   = "invalid_request"   // The request is malformed
+	CodeValidationFailed = "validation_failed" // Fields of the request are invalid
+	CodeUnauthorized     = "unauthorized"      // The request has no session
+	CodeForbidden        = "forbidden"         // The user may not perform the action
+	CodeNotFound         = "not_found"         // The resource doesn't exist or isn't visible to the user
+	CodeAlreadyExists    = "already_exists"    // A resource with the same unique fields exists
+	CodeInUse            = "in_use"            // The resource is still referenced by others
+	CodeAmbiguous        = "ambiguous"         // Several resources match what identifies the one meant
+	CodeQuotaExceeded    = "quota_exceeded"
+	CodeNotReady         = "not_ready"   // The resource is still being set up
+	CodeTooLarge         = "too_large"   // The request exceeds a size limit
+	CodeUnavailable      = "unavailable" // The cluster or database didn't respond in time
+	CodeInternal         = "internal"
+)
+
+// Error is an error the API responds with.
+type Error struct {
+	Status int               // HTTP status of the response
+	Code   string            // One of the Code constants
+	Detail string            // Explanation of this occurrence of the error, shown to users
+	Fields map[string]string // Problems with fields of the request, by field name
+	Err    error             // Cause of the error, logged but never sent to clients
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%v: %v", e.Detail, e.Err)
+	}
+	return e.Detail
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// New returns an Error with the given status, code and detail.
+func New(status int, code string, detail string) *Error {
+	return &Error{Status: status, Code: code, Detail: detail}
+}
+
+// BadRequest returns an Error for a malformed request.
+func BadRequest(detail string) *Error {
+	return New(http.StatusBadRequest, CodeInvalidRequest, detail)
+}
+
+// Invalid returns an Error for a request with invalid fields, given the
+// problems with each field.
+func Invalid(fields map[string]string) *Error {
+	e := New(http.StatusBadRequest, CodeValidationFailed, "request has invalid fields")
+	e.Fields = fields
+	return e
+}
+
+// Unauthorized returns an Error for a request without a session.
+func Unauthorized() *Error {
+	return New(http.StatusUnauthorized, CodeUnauthorized, "unauthorized")
+}
+
+// Forbidden returns an Error for an action the user may not perform.
+func Forbidden() *Error {
+	return New(http.StatusForbidden, CodeForbidden, "forbidden")
+}
+
+// NotFound returns an Error for a resource that doesn't exist.
+func NotFound(detail string) *Error {
+	return New(http.StatusNotFound, CodeNotFound, detail)
+}
+
+// Conflict returns an Error for a request conflicting with the state of a
+// resource, such as one creating a resource that already exists.
+func Conflict(code string, detail string) *Error {
+	return New(http.StatusConflict, code, detail)
+}
+
+// Internal returns an Error for a failure of the server, caused by err.
+func Internal(detail string, err error) *Error {
+	e := New(http.StatusInternalServerError, CodeInternal, detail)
+	e.Err = err
+	return e
+}
+
+// constraint describes the Error a violated database constraint causes.
+type constraint struct {
+	detail  string
+	field   string // Field of the request holding the conflicting value, if any
+	problem string // Problem with the field
+}
+
+// Constraints of the database schema whose violations clients can act on,
+// by name.
+var constraints = map[string]constraint{
+	"workspaces_owner_name_key": {"workspace already exists", "name", "Name is already taken by another workspace"},
+	"teams_name_key":            {"team already exists", "name", "Name is already taken by another team"},
+	"teams_namespace_idx":       {"team namespace already exists", "name", "Name is too similar to a team with a namespace"},
+	"workspaces_owner_fkey":     {"user still owns workspaces", "", ""},
+	"workspaces_team_fkey":      {"team still owns workspaces", "", ""},
+}
+
+// From returns the Error err causes. API errors are returned as they are.
+// Violated database constraints, and cluster and timeout errors, are
+// mapped to the Errors clients can act on. Anything else is an internal
+// error described by detail.
+func From(err error, detail string) *Error {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+
+	var e *Error
+	var pgErr *pgconn.PgError
+	var notReadyErr *spec.NotReadyError
+	switch {
+	case errors.As(err, &pgErr):
+		e = fromPg(pgErr, detail)
+	case errors.As(err, &notReadyErr):
+		e = Conflict(CodeNotReady, notReadyErr.Error())
+	case errors.Is(err, context.DeadlineExceeded), k8serrors.IsTimeout(err), k8serrors.IsServerTimeout(err),
+		k8serrors.IsServiceUnavailable(err), k8serrors.IsTooManyRequests(err):
+		e = New(http.StatusServiceUnavailable, CodeUnavailable, detail)
+	case k8serrors.IsAlreadyExists(err), k8serrors.IsConflict(err):
+		e = Conflict(CodeAlreadyExists, detail)
+	case k8serrors.IsInvalid(err):
+		e = BadRequest(detail)
+	default:
+		e = Internal(detail, err)
+	}
+
+	e.Err = err
+	return e
+}
+
+// fromPg maps a database error to an Error.
+func fromPg(err *pgconn.PgError, detail string) *Error {
+	c, known := constraints[err.ConstraintName]
+	if known {
+		detail = c.detail
+	}
+
+	var e *Error
+	switch err.Code {
+	case pgerrcode.UniqueViolation:
+		e = Conflict(CodeAlreadyExists, detail)
+	case pgerrcode.ForeignKeyViolation:
+		e = Conflict(CodeInUse, detail)
+	case pgerrcode.CheckViolation, pgerrcode.StringDataRightTruncationDataException:
+		e = BadRequest(detail)
+	case pgerrcode.QueryCanceled, pgerrcode.LockNotAvailable, pgerrcode.TooManyConnections:
+		e = New(http.StatusServiceUnavailable, CodeUnavailable, detail)
+	default:
+		return Internal(detail, err)
+	}
+
+	if known && c.field != "" {
+		e.Fields = map[string]string{c.field: c.problem}
+	}
+	return e
+}
+
+// FieldError is a problem with a field of a request.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Problem is the RFC 7807 problem details body of an error response.
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail"`
+	Code      string       `json:"code"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"` // Problems with fields, sorted by field
+}
+
+// Problem returns the body of the response to a request with the given
+// ID failing with e. The cause of e is left out.
+func (e *Error) Problem(requestID string) Problem {
+	p := Problem{
+		Type:      "about:blank", // The code identifies the problem
+		Title:     http.StatusText(e.Status),
+		Status:    e.Status,
+		Detail:    e.Detail,
+		Code:      e.Code,
+		RequestID: requestID,
+	}
+
+	for field, message := range e.Fields {
+		p.Errors = append(p.Errors, FieldError{Field: field, Message: message})
+	}
+	sort.Slice(p.Errors, func(i, j int) bool {
+		return p.Errors[i].Field < p.Errors[j].Field
+	})
+
+	return p
+}
@@ -0,0 +1,142 @@
+package apierror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/controller/spec"
+	"github.com/stretchr/testify/require"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestFrom(t *testing.T) {
+	pods := schema.GroupResource{Resource: "pods"}
+
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantCode   string
+		wantDetail string
+		wantFields map[string]string
+	}{
+		{
+			name:       "API error",
+			err:        fmt.Errorf("wrapped: %w", NotFound("team not found")),
+			wantStatus: http.StatusNotFound,
+			wantCode:   CodeNotFound,
+			wantDetail: "team not found",
+		},
+		{
+			name:       "unique violation",
+			err:        &pgconn.PgError{Code: pgerrcode.UniqueViolation, ConstraintName: "teams_name_key"},
+			wantStatus: http.StatusConflict,
+			wantCode:   CodeAlreadyExists,
+			wantDetail: "team already exists",
+			wantFields: map[string]string{"name": "Name is already taken by another team"},
+		},
+		{
+			name:       "unknown unique violation",
+			err:        &pgconn.PgError{Code: pgerrcode.UniqueViolation, ConstraintName: "other_key"},
+			wantStatus: http.StatusConflict,
+			wantCode:   CodeAlreadyExists,
+			wantDetail: "error creating team",
+		},
+		{
+			name:       "foreign key violation",
+			err:        fmt.Errorf("wrapped: %w", &pgconn.PgError{Code: pgerrcode.ForeignKeyViolation, ConstraintName: "workspaces_team_fkey"}),
+			wantStatus: http.StatusConflict,
+			wantCode:   CodeInUse,
+			wantDetail: "team still owns workspaces",
+		},
+		{
+			name:       "other database error",
+			err:        &pgconn.PgError{Code: pgerrcode.UndefinedTable},
+			wantStatus: http.StatusInternalServerError,
+			wantCode:   CodeInternal,
+			wantDetail: "error creating team",
+		},
+		{
+			name:       "timeout",
+			err:        context.DeadlineExceeded,
+			wantStatus: http.StatusServiceUnavailable,
+			wantCode:   CodeUnavailable,
+			wantDetail: "error creating team",
+		},
+		{
+			name:       "cluster unavailable",
+			err:        k8serrors.NewServiceUnavailable("down"),
+			wantStatus: http.StatusServiceUnavailable,
+			wantCode:   CodeUnavailable,
+			wantDetail: "error creating team",
+		},
+		{
+			name:       "cluster conflict",
+			err:        k8serrors.NewAlreadyExists(pods, "workspace-1"),
+			wantStatus: http.StatusConflict,
+			wantCode:   CodeAlreadyExists,
+			wantDetail: "error creating team",
+		},
+		{
+			name:       "workspace not ready",
+			err:        fmt.Errorf("wrapped: %w", &spec.NotReadyError{State: "starting"}),
+			wantStatus: http.StatusConflict,
+			wantCode:   CodeNotReady,
+			wantDetail: "workspace is starting",
+		},
+		{
+			name:       "other error",
+			err:        errors.New("broken"),
+			wantStatus: http.StatusInternalServerError,
+			wantCode:   CodeInternal,
+			wantDetail: "error creating team",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := From(tt.err, "error creating team")
+
+			require.Equal(t, tt.wantStatus, e.Status)
+			require.Equal(t, tt.wantCode, e.Code)
+			require.Equal(t, tt.wantDetail, e.Detail)
+			require.Equal(t, tt.wantFields, e.Fields)
+		})
+	}
+}
+
+func TestFromKeepsCause(t *testing.T) {
+	err := &pgconn.PgError{Code: pgerrcode.UniqueViolation}
+	require.ErrorIs(t, From(err, "error creating team"), err)
+
+	notFound := NotFound("team not found")
+	require.Same(t, notFound, From(fmt.Errorf("wrapped: %w", notFound), "error retrieving team"))
+}
+
+func TestProblem(t *testing.T) {
+	problem := Invalid(map[string]string{"role": "Role is invalid", "email": "Email must be specified"}).Problem("abc")
+
+	require.Equal(t, Problem{
+		Type:      "about:blank",
+		Title:     "Bad Request",
+		Status:    http.StatusBadRequest,
+		Detail:    "request has invalid fields",
+		Code:      CodeValidationFailed,
+		RequestID: "abc",
+		Errors: []FieldError{
+			{Field: "email", Message: "Email must be specified"},
+			{Field: "role", Message: "Role is invalid"},
+		},
+	}, problem)
+
+	// Causes never reach clients
+	problem = Internal("error retrieving team", errors.New("connection refused")).Problem("")
+	require.Equal(t, "error retrieving team", problem.Detail)
+	require.Empty(t, problem.Errors)
+}
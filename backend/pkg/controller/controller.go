@@ -1,19 +1,51 @@
 package controller
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os"
 	"strings"
+	"time"
 
+	"github.com/alexliesenfeld/health"
 	"github.com/johngerving/kubernetes-web-client/backend/pkg/controller/kube"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/controller/spec"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/leader"
 	_ "github.com/joho/godotenv/autoload"
 )
 
 type Controller interface {
-	GetWorkspacePodStatus(username string)
-	GetWorkspaceVolumeStatus(username string)
-	CreateWorkspacePod(username string)
-	CreateWorkspaceVolume(username string)
+	GetWorkspacePodStatus(ctx context.Context, workspace spec.Workspace) (string, error)
+	GetWorkspaceVolumeStatus(ctx context.Context, workspace spec.Workspace) (string, error)
+	CreateWorkspacePod(ctx context.Context, workspace spec.Workspace) error
+	CreateWorkspaceVolume(ctx context.Context, workspace spec.Workspace) error
+	CloneWorkspaceVolume(ctx context.Context, source spec.Workspace, workspace spec.Workspace) error
+	ResizeWorkspace(ctx context.Context, workspace spec.Workspace) error
+	LabelWorkspace(ctx context.Context, workspace spec.Workspace) error
+	SnapshotWorkspace(ctx context.Context, workspace spec.Workspace, snapshot int32) (spec.Snapshot, error)
+	RestoreWorkspaceSnapshot(ctx context.Context, workspace spec.Workspace, snapshot int32) error
+	DeleteWorkspaceSnapshot(ctx context.Context, workspace spec.Workspace, snapshot int32) error
+	InspectWorkspace(ctx context.Context, workspace spec.Workspace) (spec.WorkspaceState, error)
+	ExecWorkspace(ctx context.Context, workspace spec.Workspace, command []string, stdin io.Reader, stdout io.Writer) error // Runs a command with the workspace's volume at /workspace
+	DeleteWorkspace(ctx context.Context, workspace spec.Workspace) error
+	EnsureWorkspaceNetworkPolicy(ctx context.Context, workspace spec.Workspace) error
+	EnsureNamespace(ctx context.Context, namespace spec.Namespace) error
+	TeamNamespace(slug string) string // Name of the dedicated namespace of a team, given a slug of its name
+	DeleteUserNamespace(ctx context.Context, user int32) error
+	DeleteTeamNamespace(ctx context.Context, name string) error
+	WatchWorkspaces(ctx context.Context, handler func(spec.WorkspaceStatus)) error
+	LeaderElector(name string, identity string) leader.Elector
+	Ping(ctx context.Context) error // Checks that the cluster is reachable
+}
+
+// HealthCheck checks that a Controller's cluster is reachable.
+func HealthCheck(c Controller) health.Check {
+	return health.Check{
+		Name:    "cluster",
+		Timeout: 2 * time.Second,
+		Check:   c.Ping,
+	}
 }
 
 // NewControllerFromEnv creates a new Controller interface instance
@@ -0,0 +1,326 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/controller/spec"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// Annotation on the volume claim of a clone until the source's data has been
+// copied into it.
+const cloneSourceKey = "kubernetes-web-client/clone-source"
+
+// Interval at which the progress of a clone is checked.
+const clonePollInterval = 2 * time.Second
+
+// Commands streaming a workspace's files into its clone in another
+// namespace, run with the volumes at /workspace. Owners and modes are kept,
+// as the copy Job's cp -a keeps them.
+var (
+	streamSourceCommand = []string{"tar", "-c", "-f", "-", "-C", "/workspace", "."}
+	streamTargetCommand = []string{"tar", "-x", "-p", "-f", "-", "-C", "/workspace"}
+)
+
+// cloneName returns the name of the snapshot or copy Job populating a
+// workspace cloned from another.
+func cloneName(workspace spec.Workspace) string {
+	return workspaceName(workspace) + "-clone"
+}
+
+// CloneWorkspaceVolume creates a workspace's volume claim populated with the
+// data of the source workspace's volume, returning once the data is in
+// place. If the source's storage class has a CSI driver with a
+// VolumeSnapshotClass, the claim is restored from a snapshot of the source.
+// Otherwise the data is copied by a Job mounting both claims. Claims can't
+// be read across namespaces, so a workspace in another namespace than its
+// source, such as a clone of a workspace shared by another user in
+// NamespaceModeUser, has its files streamed from the source's pod into its
+// files pod instead. Steps that are already done are skipped, so it can be
+// retried.
+func (k *KubeController) CloneWorkspaceVolume(ctx context.Context, source spec.Workspace, workspace spec.Workspace) error {
+	namespace := k.namespace(workspace)
+	if err := k.ensureWorkspaceNamespace(ctx, workspace); err != nil {
+		return err
+	}
+
+	claims := k.clientset.CoreV1().PersistentVolumeClaims(namespace)
+
+	pvc, err := claims.Get(ctx, workspaceName(workspace), metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		pvc, err = k.createCloneVolume(ctx, source, workspace)
+		if err != nil {
+			return err
+		}
+	} else if err != nil {
+		return fmt.Errorf("unable to get workspace volume: %v", err)
+	}
+
+	if pvc.Spec.DataSource != nil && pvc.Spec.DataSource.Kind == "VolumeSnapshot" {
+		// The snapshot is kept by the cluster until the claim is restored
+		// from it, so it can already be removed
+		err := k.dynamic.Resource(volumeSnapshotResource).Namespace(namespace).Delete(ctx, cloneName(workspace), metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("unable to delete clone snapshot: %v", err)
+		}
+		return nil
+	}
+
+	if _, copying := pvc.Annotations[cloneSourceKey]; !copying {
+		return nil
+	}
+	copyVolume := k.copyWorkspaceVolume
+	if k.namespace(source) != namespace {
+		copyVolume = k.streamWorkspaceVolume
+	}
+	if err := copyVolume(ctx, source, workspace); err != nil {
+		return err
+	}
+
+	delete(pvc.Annotations, cloneSourceKey)
+	if _, err := claims.Update(ctx, pvc, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("unable to update workspace volume: %v", err)
+	}
+	return nil
+}
+
+// createCloneVolume creates the volume claim of a workspace cloned from
+// another, restored from a snapshot of the source's claim if its storage
+// class supports snapshots and both are in the same namespace, or empty and
+// annotated to be copied into.
+func (k *KubeController) createCloneVolume(ctx context.Context, source spec.Workspace, workspace spec.Workspace) (*v1.PersistentVolumeClaim, error) {
+	namespace := k.namespace(workspace)
+
+	sourcePVC, err := k.clientset.CoreV1().PersistentVolumeClaims(k.namespace(source)).Get(ctx, workspaceName(source), metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to get source volume: %v", err)
+	}
+
+	// Snapshots can only be restored in their own namespace
+	var class string
+	if k.namespace(source) == namespace {
+		class, err = k.snapshotClass(ctx, sourcePVC)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	size, err := volumeSize(workspace)
+	if err != nil {
+		return nil, err
+	}
+	// Restored and copied claims must hold all of the source's data
+	if sourceSize := sourcePVC.Spec.Resources.Requests[v1.ResourceStorage]; sourceSize.Cmp(size) > 0 {
+		size = sourceSize
+	}
+
+	pvc := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        workspaceName(workspace),
+			Labels:      podLabels(workspace),
+			Annotations: podAnnotations(workspace),
+		},
+		Spec: v1.PersistentVolumeClaimSpec{
+			AccessModes:      []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+			StorageClassName: sourcePVC.Spec.StorageClassName,
+			Resources: v1.VolumeResourceRequirements{
+				Requests: v1.ResourceList{v1.ResourceStorage: size},
+			},
+		},
+	}
+
+	if class != "" {
+		if err := k.snapshotWorkspaceVolume(ctx, source, workspace, class); err != nil {
+			return nil, err
+		}
+		pvc.Spec.DataSource = &v1.TypedLocalObjectReference{
+			APIGroup: &volumeSnapshotResource.Group,
+			Kind:     "VolumeSnapshot",
+			Name:     cloneName(workspace),
+		}
+	} else {
+		pvc.Annotations[cloneSourceKey] = fmt.Sprint(source.ID)
+	}
+
+	created, err := k.clientset.CoreV1().PersistentVolumeClaims(namespace).Create(ctx, pvc, metav1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to create workspace volume: %v", err)
+	}
+	return created, nil
+}
+
+// snapshotWorkspaceVolume takes the snapshot a clone of a workspace is
+// restored from, returning once it's ready to use.
+func (k *KubeController) snapshotWorkspaceVolume(ctx context.Context, source spec.Workspace, workspace spec.Workspace, class string) error {
+	err := k.createSnapshot(ctx, k.namespace(workspace), cloneName(workspace), workspaceName(source), class, workspaceSelector(workspace))
+	if err != nil {
+		return fmt.Errorf("unable to snapshot source volume: %v", err)
+	}
+	if _, err := k.waitForSnapshot(ctx, k.namespace(workspace), cloneName(workspace)); err != nil {
+		return fmt.Errorf("unable to snapshot source volume: %v", err)
+	}
+	return nil
+}
+
+// copyWorkspaceVolume copies the data of a workspace's volume into the
+// volume of its clone with a Job, returning once the Job succeeded. A
+// failed Job is removed, so that the copy starts over when it's retried.
+func (k *KubeController) copyWorkspaceVolume(ctx context.Context, source spec.Workspace, workspace spec.Workspace) error {
+	namespace := k.namespace(workspace)
+	jobs := k.clientset.BatchV1().Jobs(namespace)
+
+	job, err := k.copyJob(ctx, source, workspace)
+	if err != nil {
+		return err
+	}
+	_, err = jobs.Create(ctx, job, metav1.CreateOptions{})
+	if err != nil && !errors.IsAlreadyExists(err) {
+		return fmt.Errorf("unable to create copy job: %v", err)
+	}
+
+	var failed string
+	err = wait.PollUntilContextCancel(ctx, clonePollInterval, true, func(ctx context.Context) (bool, error) {
+		job, err := jobs.Get(ctx, job.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, fmt.Errorf("unable to get copy job: %v", err)
+		}
+		for _, condition := range job.Status.Conditions {
+			if condition.Status != v1.ConditionTrue {
+				continue
+			}
+			switch condition.Type {
+			case batchv1.JobComplete:
+				return true, nil
+			case batchv1.JobFailed:
+				failed = condition.Message
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Finished jobs are removed along with their pods
+	propagation := metav1.DeletePropagationBackground
+	err = jobs.Delete(ctx, job.Name, metav1.DeleteOptions{PropagationPolicy: &propagation})
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("unable to delete copy job: %v", err)
+	}
+
+	if failed != "" {
+		return fmt.Errorf("unable to copy source volume: %v", failed)
+	}
+	return nil
+}
+
+// streamWorkspaceVolume copies the data of a workspace's volume into the
+// volume of its clone in another namespace, streaming an archive of the
+// source's files into the clone's files pod. Files copied by an earlier
+// attempt are overwritten, so the copy can be retried.
+func (k *KubeController) streamWorkspaceVolume(ctx context.Context, source spec.Workspace, workspace spec.Workspace) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	reader, writer := io.Pipe()
+	archived := make(chan error, 1)
+	go func() {
+		err := k.ExecWorkspace(ctx, source, streamSourceCommand, nil, writer)
+		writer.CloseWithError(err)
+		archived <- err
+	}()
+
+	err := k.ExecWorkspace(ctx, workspace, streamTargetCommand, reader, io.Discard)
+	select {
+	case archiveErr := <-archived:
+		// The clone's side fails along with the source's side, which is
+		// what went wrong
+		if archiveErr != nil {
+			return fmt.Errorf("unable to read source volume: %v", archiveErr)
+		}
+	default:
+		// The clone's side stopped first, so the source's side is stopped
+		reader.CloseWithError(io.ErrClosedPipe)
+		<-archived
+	}
+	if err != nil {
+		return fmt.Errorf("unable to copy source volume: %v", err)
+	}
+	return nil
+}
+
+// copyJob returns the Job copying a workspace's volume into the volume of
+// its clone. Claims can only be mounted on one node, so while the source
+// workspace runs the Job is scheduled on its node.
+func (k *KubeController) copyJob(ctx context.Context, source spec.Workspace, workspace spec.Workspace) (*batchv1.Job, error) {
+	var affinity *v1.Affinity
+	_, err := k.clientset.CoreV1().Pods(k.namespace(source)).Get(ctx, workspaceName(source), metav1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, fmt.Errorf("unable to get source workspace pod: %v", err)
+	}
+	if err == nil {
+		affinity = &v1.Affinity{
+			PodAffinity: &v1.PodAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: []v1.PodAffinityTerm{
+					{
+						LabelSelector: &metav1.LabelSelector{MatchLabels: workspaceSelector(source)},
+						TopologyKey:   v1.LabelHostname,
+					},
+				},
+			},
+		}
+	}
+
+	backoffLimit := int32(2)
+	ttl := int32(time.Hour / time.Second)
+
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   cloneName(workspace),
+			Labels: workspaceLabels(workspace),
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:            &backoffLimit,
+			TTLSecondsAfterFinished: &ttl,
+			Template: v1.PodTemplateSpec{
+				Spec: v1.PodSpec{
+					RestartPolicy: v1.RestartPolicyNever,
+					Affinity:      affinity,
+					Containers: []v1.Container{
+						{
+							Name:    "copy",
+							Image:   k.CopyImage,
+							Command: []string{"cp", "-a", "/source/.", "/target/"},
+							VolumeMounts: []v1.VolumeMount{
+								{Name: "source", MountPath: "/source", ReadOnly: true},
+								{Name: "target", MountPath: "/target"},
+							},
+						},
+					},
+					Volumes: []v1.Volume{
+						{
+							Name: "source",
+							VolumeSource: v1.VolumeSource{
+								PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: workspaceName(source), ReadOnly: true},
+							},
+						},
+						{
+							Name: "target",
+							VolumeSource: v1.VolumeSource{
+								PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: workspaceName(workspace)},
+							},
+						},
+					},
+				},
+			},
+		},
+	}, nil
+}
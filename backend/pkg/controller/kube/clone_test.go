@@ -0,0 +1,179 @@
+package kube
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/controller/spec"
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestCloneWorkspaceVolumeCopy(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	controller := &KubeController{clientset: clientset, Namespace: "default", Image: "foo/bar", CopyImage: defaultCopyImage}
+
+	// Copy jobs finish as soon as they're created
+	var copied int
+	clientset.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		job := action.(k8stesting.CreateAction).GetObject().(*batchv1.Job)
+		job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: v1.ConditionTrue}}
+		copied++
+		return false, nil, nil
+	})
+
+	source := spec.Workspace{ID: 1}
+	workspace := spec.Workspace{ID: 2, Labels: map[string]string{"project": "thesis"}}
+	require.Nil(t, controller.CreateWorkspaceVolume(context.Background(), source))
+	require.Nil(t, controller.CreateWorkspacePod(context.Background(), source))
+
+	require.Nil(t, controller.CloneWorkspaceVolume(context.Background(), source, workspace))
+	require.Equal(t, 1, copied)
+
+	pvc, err := clientset.CoreV1().PersistentVolumeClaims("default").Get(context.Background(), workspaceName(workspace), metav1.GetOptions{})
+	require.Nil(t, err)
+	require.Nil(t, pvc.Spec.DataSource)
+	require.NotContains(t, pvc.Annotations, cloneSourceKey, "The claim should be marked as copied")
+	require.Equal(t, "thesis", pvc.Labels["project"])
+
+	// The finished job is removed
+	_, err = clientset.BatchV1().Jobs("default").Get(context.Background(), cloneName(workspace), metav1.GetOptions{})
+	require.NotNil(t, err)
+
+	// Cloning again should not copy again
+	require.Nil(t, controller.CloneWorkspaceVolume(context.Background(), source, workspace))
+	require.Equal(t, 1, copied)
+}
+
+func TestCloneWorkspaceVolumeCopyFailed(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	controller := &KubeController{clientset: clientset, Namespace: "default", Image: "foo/bar", CopyImage: defaultCopyImage}
+
+	clientset.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		job := action.(k8stesting.CreateAction).GetObject().(*batchv1.Job)
+		job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: v1.ConditionTrue, Message: "no space left on device"}}
+		return false, nil, nil
+	})
+
+	source := spec.Workspace{ID: 1}
+	workspace := spec.Workspace{ID: 2}
+	require.Nil(t, controller.CreateWorkspaceVolume(context.Background(), source))
+
+	err := controller.CloneWorkspaceVolume(context.Background(), source, workspace)
+	require.ErrorContains(t, err, "no space left on device")
+
+	// The claim is still marked to be copied into when the clone is retried
+	pvc, err := clientset.CoreV1().PersistentVolumeClaims("default").Get(context.Background(), workspaceName(workspace), metav1.GetOptions{})
+	require.Nil(t, err)
+	require.Equal(t, "1", pvc.Annotations[cloneSourceKey])
+}
+
+func TestCloneWorkspaceVolumeSnapshot(t *testing.T) {
+	clientset, dynamic := newSnapshotClients()
+	controller := &KubeController{clientset: clientset, dynamic: dynamic, Namespace: "default", Image: "foo/bar"}
+
+	source := spec.Workspace{ID: 1}
+	workspace := spec.Workspace{ID: 2}
+	createSnapshotVolume(t, clientset, source)
+
+	require.Nil(t, controller.CloneWorkspaceVolume(context.Background(), source, workspace))
+
+	pvc, err := clientset.CoreV1().PersistentVolumeClaims("default").Get(context.Background(), workspaceName(workspace), metav1.GetOptions{})
+	require.Nil(t, err)
+	require.NotNil(t, pvc.Spec.DataSource)
+	require.Equal(t, "VolumeSnapshot", pvc.Spec.DataSource.Kind)
+	require.Equal(t, cloneName(workspace), pvc.Spec.DataSource.Name)
+	require.Equal(t, snapshotStorageClass, *pvc.Spec.StorageClassName)
+	require.NotContains(t, pvc.Annotations, cloneSourceKey)
+
+	// The snapshot was taken with the driver's default class, and removed once restored
+	var created *unstructured.Unstructured
+	for _, action := range dynamic.Actions() {
+		if create, ok := action.(k8stesting.CreateAction); ok && action.GetResource() == volumeSnapshotResource {
+			created = create.GetObject().(*unstructured.Unstructured)
+		}
+	}
+	require.NotNil(t, created)
+	class, _, _ := unstructured.NestedString(created.Object, "spec", "volumeSnapshotClassName")
+	require.Equal(t, "hostpath-default", class)
+	claim, _, _ := unstructured.NestedString(created.Object, "spec", "source", "persistentVolumeClaimName")
+	require.Equal(t, workspaceName(source), claim)
+
+	_, err = dynamic.Resource(volumeSnapshotResource).Namespace("default").Get(context.Background(), cloneName(workspace), metav1.GetOptions{})
+	require.NotNil(t, err)
+}
+
+// newStreamController returns a controller in NamespaceModeUser whose
+// commands write archive as the source's files, and record what the
+// clone's side read.
+func newStreamController(archive string, archiveErr error) (*KubeController, *fake.Clientset, *[]string) {
+	controller, clientset, _ := newExecController()
+	controller.NamespaceMode = NamespaceModeUser
+
+	var mu sync.Mutex
+	var extracted []string
+	controller.exec = func(ctx context.Context, namespace string, pod string, container string, command []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
+		if stdin == nil {
+			io.WriteString(stdout, archive)
+			return archiveErr
+		}
+		data, err := io.ReadAll(stdin)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		extracted = append(extracted, string(data))
+		return nil
+	}
+	return controller, clientset, &extracted
+}
+
+func TestCloneWorkspaceVolumeAcrossNamespaces(t *testing.T) {
+	controller, clientset, extracted := newStreamController("archive", nil)
+
+	// Another user's workspace is shared with the user cloning it
+	source := spec.Workspace{ID: 1, Owner: 1}
+	workspace := spec.Workspace{ID: 2, Owner: 2}
+	require.Nil(t, controller.CreateWorkspaceVolume(context.Background(), source))
+	require.Nil(t, controller.CreateWorkspacePod(context.Background(), source))
+
+	require.Nil(t, controller.CloneWorkspaceVolume(context.Background(), source, workspace))
+	require.Equal(t, []string{"archive"}, *extracted)
+
+	_, err := clientset.CoreV1().Namespaces().Get(context.Background(), controller.namespace(workspace), metav1.GetOptions{})
+	require.Nil(t, err, "The cloning user's namespace should be created")
+	pvc, err := clientset.CoreV1().PersistentVolumeClaims(controller.namespace(workspace)).Get(context.Background(), workspaceName(workspace), metav1.GetOptions{})
+	require.Nil(t, err)
+	require.Nil(t, pvc.Spec.DataSource)
+	require.NotContains(t, pvc.Annotations, cloneSourceKey, "The claim should be marked as copied")
+
+	// Cloning again should not copy again
+	require.Nil(t, controller.CloneWorkspaceVolume(context.Background(), source, workspace))
+	require.Len(t, *extracted, 1)
+}
+
+func TestCloneWorkspaceVolumeAcrossNamespacesFailed(t *testing.T) {
+	controller, clientset, _ := newStreamController("partial", errors.New("connection reset"))
+
+	source := spec.Workspace{ID: 1, Owner: 1}
+	workspace := spec.Workspace{ID: 2, Owner: 2}
+	require.Nil(t, controller.CreateWorkspaceVolume(context.Background(), source))
+
+	err := controller.CloneWorkspaceVolume(context.Background(), source, workspace)
+	require.ErrorContains(t, err, "connection reset")
+
+	// The claim is still marked to be copied into when the clone is retried
+	pvc, err := clientset.CoreV1().PersistentVolumeClaims(controller.namespace(workspace)).Get(context.Background(), workspaceName(workspace), metav1.GetOptions{})
+	require.Nil(t, err)
+	require.Equal(t, "1", pvc.Annotations[cloneSourceKey])
+}
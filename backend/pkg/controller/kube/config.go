@@ -3,16 +3,38 @@ package kube
 import (
 	"fmt"
 	"os"
+	"strings"
+
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/controller/spec"
 )
 
 type KubeConfig struct {
-	Host      string
-	Port      string
-	Token     string
-	Cert      string
-	Namespace string
+	Host          string
+	Port          string
+	Token         string
+	Cert          string
+	Namespace     string
+	Image         string
+	NamespaceMode string     // Where workspaces are placed: NamespaceModeShared or NamespaceModeUser
+	UserQuota     spec.Quota // Quota of each user's namespace in NamespaceModeUser
+	CopyImage     string     // Container image of the Jobs copying volumes and the pods serving files of stopped workspaces
 }
 
+// Namespace modes
+const (
+	NamespaceModeShared = "shared" // All workspaces share the API's namespace
+	NamespaceModeUser   = "user"   // Each user's workspaces are isolated in their own namespace
+)
+
+// defaultWorkspaceImage is the container image workspaces run when
+// WORKSPACE_IMAGE is not set.
+const defaultWorkspaceImage = "codercom/code-server:latest"
+
+// defaultCopyImage is the container image of the Jobs copying volumes and
+// the pods serving files of stopped workspaces when COPY_IMAGE is not set.
+// It needs cp, sleep and the shell utilities files are served with.
+const defaultCopyImage = "busybox:1.36"
+
 func NewKubeConfigFromEnv() (*KubeConfig, error) {
 	host := os.Getenv("KUBERNETES_SERVICE_HOST")
 	if host == "" {
@@ -39,12 +61,41 @@ func NewKubeConfigFromEnv() (*KubeConfig, error) {
 		return nil, fmt.Errorf("could not retrieve Kubernetes namespace")
 	}
 
+	image := os.Getenv("WORKSPACE_IMAGE")
+	if image == "" {
+		image = defaultWorkspaceImage
+	}
+
+	copyImage := os.Getenv("COPY_IMAGE")
+	if copyImage == "" {
+		copyImage = defaultCopyImage
+	}
+
+	namespaceMode := strings.ToLower(os.Getenv("NAMESPACE_MODE"))
+	if namespaceMode == "" {
+		namespaceMode = NamespaceModeShared
+	}
+	if namespaceMode != NamespaceModeShared && namespaceMode != NamespaceModeUser {
+		return nil, fmt.Errorf("namespace mode must be %v or %v", NamespaceModeShared, NamespaceModeUser)
+	}
+
+	userQuota := spec.Quota{
+		CPU:     os.Getenv("USER_CPU_QUOTA"),
+		Memory:  os.Getenv("USER_MEMORY_QUOTA"),
+		Storage: os.Getenv("USER_STORAGE_QUOTA"),
+	}
+
 	cfg := &KubeConfig{
 		Host:      host,
 		Port:      port,
 		Token:     token,
 		Cert:      cert,
 		Namespace: namespace,
+		Image:     image,
+
+		NamespaceMode: namespaceMode,
+		UserQuota:     userQuota,
+		CopyImage:     copyImage,
 	}
 
 	return cfg, nil
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"testing"
 
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/controller/spec"
 	"github.com/stretchr/testify/require"
 )
 
@@ -15,15 +16,21 @@ func TestNewConfigFromEnv(t *testing.T) {
 		token       string
 		cert        string
 		namespace   string
+		image       string
+		mode        string
+		cpuQuota    string
 		wantConfig  *KubeConfig
 		wantErr     error
 	}{
-		{"Normal config", "127.0.0.1", "6443", "abcdefg", "hijklmnop", "default", &KubeConfig{"127.0.0.1", "6443", "abcdefg", "hijklmnop", "default"}, nil},
-		{"Missing KUBERNETES_SERVICE_HOST variable", "", "6443", "abcdefg", "hijklmnop", "default", nil, fmt.Errorf("could not retrieve Kubernetes service host")},
-		{"Missing KUBERNETES_SERVICE_PORT_HTTPS variable", "127.0.0.1", "", "abcdefg", "hijklmnop", "default", nil, fmt.Errorf("could not retrieve Kubernetes service port")},
-		{"Missing KUBE_TOKEN variable", "127.0.0.1", "6443", "", "hijklmnop", "default", nil, fmt.Errorf("kubernetes token must be specified")},
-		{"Missing KUBE_CERT variable", "127.0.0.1", "6443", "abcdefg", "", "default", nil, fmt.Errorf("kubernetes CA cert must be specified")},
-		{"Missing POD_NAMESPACE variable", "127.0.0.1", "6443", "abcdefg", "hijklmnop", "", nil, fmt.Errorf("could not retrieve Kubernetes namespace")},
+		{"Normal config", "127.0.0.1", "6443", "abcdefg", "hijklmnop", "default", "foo/bar", "", "", &KubeConfig{"127.0.0.1", "6443", "abcdefg", "hijklmnop", "default", "foo/bar", NamespaceModeShared, spec.Quota{}, defaultCopyImage}, nil},
+		{"Missing WORKSPACE_IMAGE variable", "127.0.0.1", "6443", "abcdefg", "hijklmnop", "default", "", "", "", &KubeConfig{"127.0.0.1", "6443", "abcdefg", "hijklmnop", "default", defaultWorkspaceImage, NamespaceModeShared, spec.Quota{}, defaultCopyImage}, nil},
+		{"User namespace mode", "127.0.0.1", "6443", "abcdefg", "hijklmnop", "default", "foo/bar", "User", "4", &KubeConfig{"127.0.0.1", "6443", "abcdefg", "hijklmnop", "default", "foo/bar", NamespaceModeUser, spec.Quota{CPU: "4"}, defaultCopyImage}, nil},
+		{"Invalid NAMESPACE_MODE variable", "127.0.0.1", "6443", "abcdefg", "hijklmnop", "default", "foo/bar", "cluster", "", nil, fmt.Errorf("namespace mode must be shared or user")},
+		{"Missing KUBERNETES_SERVICE_HOST variable", "", "6443", "abcdefg", "hijklmnop", "default", "foo/bar", "", "", nil, fmt.Errorf("could not retrieve Kubernetes service host")},
+		{"Missing KUBERNETES_SERVICE_PORT_HTTPS variable", "127.0.0.1", "", "abcdefg", "hijklmnop", "default", "foo/bar", "", "", nil, fmt.Errorf("could not retrieve Kubernetes service port")},
+		{"Missing KUBE_TOKEN variable", "127.0.0.1", "6443", "", "hijklmnop", "default", "foo/bar", "", "", nil, fmt.Errorf("kubernetes token must be specified")},
+		{"Missing KUBE_CERT variable", "127.0.0.1", "6443", "abcdefg", "", "default", "foo/bar", "", "", nil, fmt.Errorf("kubernetes CA cert must be specified")},
+		{"Missing POD_NAMESPACE variable", "127.0.0.1", "6443", "abcdefg", "hijklmnop", "", "foo/bar", "", "", nil, fmt.Errorf("could not retrieve Kubernetes namespace")},
 	}
 
 	for _, test := range tests {
@@ -33,6 +40,10 @@ func TestNewConfigFromEnv(t *testing.T) {
 			t.Setenv("KUBE_TOKEN", test.token)
 			t.Setenv("KUBE_CERT", test.cert)
 			t.Setenv("POD_NAMESPACE", test.namespace)
+			t.Setenv("WORKSPACE_IMAGE", test.image)
+			t.Setenv("NAMESPACE_MODE", test.mode)
+			t.Setenv("USER_CPU_QUOTA", test.cpuQuota)
+			t.Setenv("COPY_IMAGE", "")
 
 			haveConfig, haveErr := NewKubeConfigFromEnv()
 
@@ -3,14 +3,26 @@ package kube
 import (
 	"encoding/base64"
 	"fmt"
+	"net/http"
 
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/controller/spec"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 )
 
 type KubeController struct {
-	clientset *kubernetes.Clientset
-	Namespace string
+	clientset     kubernetes.Interface
+	Namespace     string     // Default namespace for workspace resources
+	Image         string     // Container image workspaces run
+	NamespaceMode string     // Where workspaces are placed when they have no namespace of their own
+	UserQuota     spec.Quota // Quota of each user's namespace in NamespaceModeUser
+	CopyImage     string     // Container image of the Jobs copying volumes and the pods serving files of stopped workspaces
+
+	metrics rest.Interface    // Client of the cluster's metrics API, or nil to not report usage
+	dynamic dynamic.Interface // Client of custom resources such as VolumeSnapshots, or nil to not use them
+	exec    execFunc          // Runs commands in pods
 }
 
 // NewKubeController creates a KubeControl using a kube.KubeConfig
@@ -29,6 +41,10 @@ func NewKubeController(cfg *KubeConfig) (*KubeController, error) {
 			Insecure: false,
 			CAData:   caData, // Pass in CA data for TLS verification
 		},
+		// Trace calls to the Kubernetes API as part of the calling request
+		WrapTransport: func(rt http.RoundTripper) http.RoundTripper {
+			return otelhttp.NewTransport(rt)
+		},
 	}
 
 	// Create a clientset from the config
@@ -37,15 +53,24 @@ func NewKubeController(cfg *KubeConfig) (*KubeController, error) {
 		return nil, fmt.Errorf("unable to create Kubernetes clientset: %v", err)
 	}
 
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create Kubernetes dynamic client: %v", err)
+	}
+
 	kubeClient := &KubeController{
 		clientset: clientset,
 		Namespace: cfg.Namespace,
+		Image:     cfg.Image,
+
+		NamespaceMode: cfg.NamespaceMode,
+		UserQuota:     cfg.UserQuota,
+		CopyImage:     cfg.CopyImage,
+
+		metrics: clientset.CoreV1().RESTClient(),
+		dynamic: dynamicClient,
+		exec:    podExec(config, clientset),
 	}
 
 	return kubeClient, nil
 }
-
-func (c KubeController) GetWorkspacePodStatus(username string)    {}
-func (c KubeController) GetWorkspaceVolumeStatus(username string) {}
-func (c KubeController) CreateWorkspacePod(username string)       {}
-func (c KubeController) CreateWorkspaceVolume(username string)    {}
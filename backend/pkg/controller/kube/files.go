@@ -0,0 +1,301 @@
+package kube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/controller/spec"
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+	utilexec "k8s.io/client-go/util/exec"
+)
+
+// Time without running commands after which a files pod stops itself, so
+// that a stopped workspace's volume isn't held longer than needed.
+const filesPodIdle = 10 * time.Minute
+
+// Time after which a files pod is stopped even if it's running commands.
+const filesPodDeadline = 24 * time.Hour
+
+// Time after it starts that a files pod is given new commands. Older pods
+// are replaced, so that commands have the rest of the pod's deadline to
+// finish.
+const filesPodReuse = 12 * time.Hour
+
+// filesPodScript keeps a files pod running while commands run in it and for
+// filesPodIdle afterwards. Commands leave a marker named after their process
+// ID, see filesCommand, so that the pod notices them even if they're killed.
+const filesPodScript = `touch /tmp/active
+while [ -n "$(find /tmp/active -mmin -%d)" ]; do
+	for marker in /tmp/exec.*; do
+		[ -e "$marker" ] || continue
+		if kill -0 "${marker#/tmp/exec.}" 2>/dev/null; then touch /tmp/active; else rm -f "$marker"; fi
+	done
+	sleep 10
+done`
+
+// Time allowed for a files pod to start.
+const filesPodTimeout = 2 * time.Minute
+
+// Bytes of a command's standard error kept for its ExitError.
+const maxExecStderr = 4096
+
+// execFunc runs a command in a container of a pod, streaming stdin to the
+// command and its output to stdout and stderr. stdin may be nil.
+type execFunc func(ctx context.Context, namespace string, pod string, container string, command []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) error
+
+// podExec returns an execFunc running commands through the pods' exec
+// subresource.
+func podExec(config *rest.Config, clientset kubernetes.Interface) execFunc {
+	return func(ctx context.Context, namespace string, pod string, container string, command []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
+		req := clientset.CoreV1().RESTClient().Post().
+			Namespace(namespace).
+			Resource("pods").
+			Name(pod).
+			SubResource("exec").
+			VersionedParams(&v1.PodExecOptions{
+				Container: container,
+				Command:   command,
+				Stdin:     stdin != nil,
+				Stdout:    true,
+				Stderr:    true,
+			}, scheme.ParameterCodec)
+
+		executor, err := remotecommand.NewSPDYExecutor(config, "POST", req.URL())
+		if err != nil {
+			return err
+		}
+		return executor.StreamWithContext(ctx, remotecommand.StreamOptions{Stdin: stdin, Stdout: stdout, Stderr: stderr})
+	}
+}
+
+// filesPodName returns the name of the pod serving the files of a stopped
+// workspace.
+func filesPodName(workspace spec.Workspace) string {
+	return workspaceName(workspace) + "-files"
+}
+
+// ExecWorkspace runs a command with access to a workspace's volume, mounted
+// at /workspace, streaming stdin to the command and its output to stdout.
+// The command runs in the workspace's pod while it's running. If the
+// workspace has no pod, it runs in a files pod mounting the volume, which is
+// created if needed and stops itself once it has been idle for a while.
+// Commands return a *spec.NotReadyError while the workspace's pod is
+// starting or stopping, and a *spec.ExitError if they exit with a non-zero
+// status.
+func (k *KubeController) ExecWorkspace(ctx context.Context, workspace spec.Workspace, command []string, stdin io.Reader, stdout io.Writer) error {
+	namespace := k.namespace(workspace)
+
+	pod, container, err := k.execPod(ctx, workspace)
+	if err != nil {
+		return err
+	}
+
+	if container == "files" {
+		command = filesCommand(command)
+	}
+
+	stderr := &truncatedBuffer{max: maxExecStderr}
+	err = k.exec(ctx, namespace, pod, container, command, stdin, stdout, stderr)
+
+	var exitErr utilexec.ExitError
+	if errors.As(err, &exitErr) {
+		return &spec.ExitError{Code: exitErr.ExitStatus(), Stderr: string(stderr.data)}
+	}
+	if err != nil {
+		return fmt.Errorf("unable to run command in workspace: %v", err)
+	}
+	return nil
+}
+
+// execPod returns the pod and container commands on a workspace's volume
+// run in: the workspace's pod if it's running, otherwise its files pod. The
+// volume can only be mounted by one node, so no files pod is created while
+// the workspace's pod is starting or stopping, which would keep the pod
+// from mounting it.
+func (k *KubeController) execPod(ctx context.Context, workspace spec.Workspace) (string, string, error) {
+	pod, err := k.clientset.CoreV1().Pods(k.namespace(workspace)).Get(ctx, workspaceName(workspace), metav1.GetOptions{})
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return "", "", fmt.Errorf("unable to get workspace pod: %v", err)
+	}
+	if err == nil {
+		switch {
+		case pod.DeletionTimestamp != nil:
+			return "", "", &spec.NotReadyError{State: "stopping"}
+		case pod.Status.Phase == v1.PodRunning:
+			return pod.Name, "workspace", nil
+		case pod.Status.Phase == v1.PodPending || pod.Status.Phase == "":
+			return "", "", &spec.NotReadyError{State: "starting"}
+		}
+		// Pods that have exited no longer hold the volume
+	}
+
+	name, err := k.ensureFilesPod(ctx, workspace)
+	if err != nil {
+		return "", "", err
+	}
+	return name, "files", nil
+}
+
+// filesCommand wraps a command run in a files pod so that it leaves the marker
+// keeping the pod running, see filesPodScript.
+func filesCommand(command []string) []string {
+	return append([]string{"sh", "-c", `touch /tmp/exec.$$ && exec "$@"`, "sh"}, command...)
+}
+
+// ensureFilesPod returns the name of a workspace's files pod once it's
+// running, creating it if it doesn't exist and replacing it if it's too old
+// to be given new commands.
+func (k *KubeController) ensureFilesPod(ctx context.Context, workspace spec.Workspace) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, filesPodTimeout)
+	defer cancel()
+
+	pods := k.clientset.CoreV1().Pods(k.namespace(workspace))
+	name := filesPodName(workspace)
+
+	pod, err := pods.Get(ctx, name, metav1.GetOptions{})
+	exists := err == nil
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return "", fmt.Errorf("unable to get files pod: %v", err)
+	}
+
+	if exists && !filesPodUsable(pod) {
+		if err := k.deleteFilesPod(ctx, workspace); err != nil {
+			return "", err
+		}
+		exists = false
+	}
+	if !exists {
+		_, err := pods.Create(ctx, k.filesPod(workspace), metav1.CreateOptions{})
+		if err != nil && !k8serrors.IsAlreadyExists(err) {
+			return "", fmt.Errorf("unable to create files pod: %v", err)
+		}
+	}
+
+	err = wait.PollUntilContextCancel(ctx, podPollInterval, true, func(ctx context.Context) (bool, error) {
+		pod, err := pods.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		switch pod.Status.Phase {
+		case v1.PodRunning:
+			return true, nil
+		case v1.PodSucceeded, v1.PodFailed:
+			return false, fmt.Errorf("pod stopped: %v", pod.Status.Message)
+		}
+		return false, nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("unable to start files pod: %v", err)
+	}
+	return name, nil
+}
+
+// filesPodUsable reports whether a files pod can be given new commands.
+func filesPodUsable(pod *v1.Pod) bool {
+	if pod.DeletionTimestamp != nil || pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+		return false
+	}
+	return pod.Status.StartTime == nil || time.Since(pod.Status.StartTime.Time) < filesPodReuse
+}
+
+// deleteFilesPod removes a workspace's files pod, returning once it's gone
+// so that its volume is free. Removing a pod that doesn't exist succeeds.
+func (k *KubeController) deleteFilesPod(ctx context.Context, workspace spec.Workspace) error {
+	pods := k.clientset.CoreV1().Pods(k.namespace(workspace))
+	name := filesPodName(workspace)
+
+	err := pods.Delete(ctx, name, metav1.DeleteOptions{})
+	if k8serrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to delete files pod: %v", err)
+	}
+
+	err = wait.PollUntilContextCancel(ctx, podPollInterval, true, func(ctx context.Context) (bool, error) {
+		_, err := pods.Get(ctx, name, metav1.GetOptions{})
+		if k8serrors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	})
+	if err != nil {
+		return fmt.Errorf("unable to delete files pod: %v", err)
+	}
+	return nil
+}
+
+// filesPod returns the files pod of a workspace, mounting the workspace's
+// volume where the workspace's pod does. It isn't labeled as a pod of the
+// workspace, so that it's neither watched nor selected as the workspace's
+// pod, and has no access to the cluster's API.
+func (k *KubeController) filesPod(workspace spec.Workspace) *v1.Pod {
+	resources := v1.ResourceList{
+		v1.ResourceCPU:    resource.MustParse("100m"),
+		v1.ResourceMemory: resource.MustParse("64Mi"),
+	}
+	deadline := int64(filesPodDeadline / time.Second)
+	automount := false
+
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: filesPodName(workspace),
+			Labels: map[string]string{
+				"app.kubernetes.io/name":      "workspace-files",
+				"app.kubernetes.io/component": workspaceName(workspace),
+			},
+		},
+		Spec: v1.PodSpec{
+			RestartPolicy:                v1.RestartPolicyNever,
+			ActiveDeadlineSeconds:        &deadline,
+			AutomountServiceAccountToken: &automount,
+			Containers: []v1.Container{
+				{
+					Name:    "files",
+					Image:   k.CopyImage,
+					Command: []string{"sh", "-c", fmt.Sprintf(filesPodScript, int(filesPodIdle/time.Minute))},
+					Resources: v1.ResourceRequirements{
+						Requests: resources,
+						Limits:   resources.DeepCopy(),
+					},
+					VolumeMounts: []v1.VolumeMount{
+						{Name: "workspace", MountPath: "/workspace"},
+					},
+				},
+			},
+			Volumes: []v1.Volume{
+				{
+					Name: "workspace",
+					VolumeSource: v1.VolumeSource{
+						PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: workspaceName(workspace)},
+					},
+				},
+			},
+		},
+	}
+}
+
+// truncatedBuffer keeps the first max bytes written to it and discards the
+// rest.
+type truncatedBuffer struct {
+	data []byte
+	max  int
+}
+
+func (b *truncatedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - len(b.data); room > 0 {
+		b.data = append(b.data, p[:min(room, len(p))]...)
+	}
+	return len(p), nil
+}
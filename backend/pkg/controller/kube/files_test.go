@@ -0,0 +1,201 @@
+package kube
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/controller/spec"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	utilexec "k8s.io/client-go/util/exec"
+)
+
+// execCall is a command run by a fake execFunc.
+type execCall struct {
+	pod       string
+	container string
+	command   []string
+	stdin     string
+}
+
+// newExecController returns a controller whose pods run as soon as they're
+// created, and whose commands are recorded and echo their stdin.
+func newExecController() (*KubeController, *fake.Clientset, *[]execCall) {
+	clientset := fake.NewSimpleClientset()
+	clientset.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		pod := action.(k8stesting.CreateAction).GetObject().(*v1.Pod)
+		pod.Status.Phase = v1.PodRunning
+		return false, nil, nil
+	})
+
+	var calls []execCall
+	exec := func(ctx context.Context, namespace string, pod string, container string, command []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
+		call := execCall{pod: pod, container: container, command: command}
+		if stdin != nil {
+			data, err := io.ReadAll(stdin)
+			if err != nil {
+				return err
+			}
+			call.stdin = string(data)
+			stdout.Write(data)
+		}
+		calls = append(calls, call)
+
+		if command[0] == "false" {
+			io.WriteString(stderr, "no such file")
+			return utilexec.CodeExitError{Err: errors.New("command terminated with exit code 3"), Code: 3}
+		}
+		return nil
+	}
+
+	controller := &KubeController{clientset: clientset, Namespace: "default", Image: "foo/bar", CopyImage: defaultCopyImage, exec: exec}
+	return controller, clientset, &calls
+}
+
+func TestExecWorkspaceRunning(t *testing.T) {
+	controller, clientset, calls := newExecController()
+
+	workspace := spec.Workspace{ID: 1}
+	require.Nil(t, controller.CreateWorkspaceVolume(context.Background(), workspace))
+	require.Nil(t, controller.CreateWorkspacePod(context.Background(), workspace))
+
+	var stdout bytes.Buffer
+	err := controller.ExecWorkspace(context.Background(), workspace, []string{"cat"}, strings.NewReader("hello"), &stdout)
+	require.Nil(t, err)
+	require.Equal(t, "hello", stdout.String())
+	require.Equal(t, []execCall{{pod: workspaceName(workspace), container: "workspace", command: []string{"cat"}, stdin: "hello"}}, *calls)
+
+	// No files pod is needed while the workspace runs
+	_, err = clientset.CoreV1().Pods("default").Get(context.Background(), filesPodName(workspace), metav1.GetOptions{})
+	require.NotNil(t, err)
+}
+
+func TestExecWorkspaceStopped(t *testing.T) {
+	controller, clientset, calls := newExecController()
+
+	workspace := spec.Workspace{ID: 1, Namespace: "team-foo"}
+	require.Nil(t, controller.CreateWorkspaceVolume(context.Background(), workspace))
+
+	require.Nil(t, controller.ExecWorkspace(context.Background(), workspace, []string{"ls"}, nil, io.Discard))
+	require.Equal(t, filesPodName(workspace), (*calls)[0].pod)
+	require.Equal(t, "files", (*calls)[0].container)
+	require.Equal(t, []string{"sh", "-c", `touch /tmp/exec.$$ && exec "$@"`, "sh", "ls"}, (*calls)[0].command)
+
+	pod, err := clientset.CoreV1().Pods("team-foo").Get(context.Background(), filesPodName(workspace), metav1.GetOptions{})
+	require.Nil(t, err)
+	require.Equal(t, defaultCopyImage, pod.Spec.Containers[0].Image)
+	require.Equal(t, workspaceName(workspace), pod.Spec.Volumes[0].PersistentVolumeClaim.ClaimName)
+	require.Equal(t, "/workspace", pod.Spec.Containers[0].VolumeMounts[0].MountPath)
+	require.False(t, *pod.Spec.AutomountServiceAccountToken)
+	require.Equal(t, int64(filesPodDeadline/time.Second), *pod.Spec.ActiveDeadlineSeconds)
+	_, ok := workspaceStatus(pod, "")
+	require.False(t, ok, "The files pod should not be watched as the workspace's pod")
+
+	// The files pod is reused while it's young, and replaced once it's old
+	require.Nil(t, controller.ExecWorkspace(context.Background(), workspace, []string{"ls"}, nil, io.Discard))
+	require.Len(t, *calls, 2)
+	require.Equal(t, filesPodName(workspace), (*calls)[1].pod)
+
+	pod.Status.StartTime = &metav1.Time{Time: time.Now().Add(-filesPodReuse)}
+	_, err = clientset.CoreV1().Pods("team-foo").UpdateStatus(context.Background(), pod, metav1.UpdateOptions{})
+	require.Nil(t, err)
+
+	require.Nil(t, controller.ExecWorkspace(context.Background(), workspace, []string{"ls"}, nil, io.Discard))
+	pod, err = clientset.CoreV1().Pods("team-foo").Get(context.Background(), filesPodName(workspace), metav1.GetOptions{})
+	require.Nil(t, err)
+	require.Nil(t, pod.Status.StartTime)
+
+	// Starting the workspace removes its files pod
+	require.Nil(t, controller.CreateWorkspacePod(context.Background(), workspace))
+	_, err = clientset.CoreV1().Pods("team-foo").Get(context.Background(), filesPodName(workspace), metav1.GetOptions{})
+	require.NotNil(t, err)
+}
+
+func TestExecWorkspaceNotReady(t *testing.T) {
+	tests := []struct {
+		description string // Test description
+		deleting    bool   // Whether the workspace's pod is being deleted
+		phase       v1.PodPhase
+		wantState   string
+	}{
+		{"Starting", false, v1.PodPending, "starting"},
+		{"Stopping", true, v1.PodRunning, "stopping"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			controller, clientset, calls := newExecController()
+
+			workspace := spec.Workspace{ID: 1}
+			require.Nil(t, controller.CreateWorkspaceVolume(context.Background(), workspace))
+			require.Nil(t, controller.CreateWorkspacePod(context.Background(), workspace))
+
+			pod, err := clientset.CoreV1().Pods("default").Get(context.Background(), workspaceName(workspace), metav1.GetOptions{})
+			require.Nil(t, err)
+			pod.Status.Phase = test.phase
+			if test.deleting {
+				pod.SetDeletionTimestamp(&metav1.Time{Time: time.Now()})
+			}
+			_, err = clientset.CoreV1().Pods("default").Update(context.Background(), pod, metav1.UpdateOptions{})
+			require.Nil(t, err)
+
+			err = controller.ExecWorkspace(context.Background(), workspace, []string{"ls"}, nil, io.Discard)
+			var notReadyErr *spec.NotReadyError
+			require.ErrorAs(t, err, &notReadyErr)
+			require.Equal(t, test.wantState, notReadyErr.State)
+			require.Empty(t, *calls)
+
+			// The workspace's pod keeps the volume to itself
+			_, err = clientset.CoreV1().Pods("default").Get(context.Background(), filesPodName(workspace), metav1.GetOptions{})
+			require.NotNil(t, err)
+		})
+	}
+}
+
+func TestExecWorkspaceExitError(t *testing.T) {
+	controller, _, _ := newExecController()
+
+	workspace := spec.Workspace{ID: 1}
+	require.Nil(t, controller.CreateWorkspaceVolume(context.Background(), workspace))
+	require.Nil(t, controller.CreateWorkspacePod(context.Background(), workspace))
+
+	err := controller.ExecWorkspace(context.Background(), workspace, []string{"false"}, nil, io.Discard)
+	var exitErr *spec.ExitError
+	require.True(t, errors.As(err, &exitErr))
+	require.Equal(t, 3, exitErr.Code)
+	require.Equal(t, "no such file", exitErr.Stderr)
+}
+
+func TestDeleteWorkspaceFilesPod(t *testing.T) {
+	controller, clientset, _ := newExecController()
+
+	workspace := spec.Workspace{ID: 1}
+	require.Nil(t, controller.CreateWorkspaceVolume(context.Background(), workspace))
+	require.Nil(t, controller.ExecWorkspace(context.Background(), workspace, []string{"ls"}, nil, io.Discard))
+
+	require.Nil(t, controller.DeleteWorkspace(context.Background(), workspace))
+
+	pods, err := clientset.CoreV1().Pods("default").List(context.Background(), metav1.ListOptions{})
+	require.Nil(t, err)
+	require.Empty(t, pods.Items)
+}
+
+func TestTruncatedBuffer(t *testing.T) {
+	b := &truncatedBuffer{max: 4}
+	n, err := b.Write([]byte("abc"))
+	require.Nil(t, err)
+	require.Equal(t, 3, n)
+	n, err = b.Write([]byte("defg"))
+	require.Nil(t, err)
+	require.Equal(t, 4, n)
+	require.Equal(t, "abcd", string(b.data))
+}
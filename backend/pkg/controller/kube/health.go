@@ -0,0 +1,21 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Ping checks that the Kubernetes API is reachable and lets the server
+// see workspace pods, which it needs to watch them.
+func (k *KubeController) Ping(ctx context.Context) error {
+	_, err := k.clientset.CoreV1().Pods("").List(ctx, metav1.ListOptions{
+		LabelSelector: managedByLabel + "=" + managedByValue,
+		Limit:         1,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to reach Kubernetes API: %v", err)
+	}
+	return nil
+}
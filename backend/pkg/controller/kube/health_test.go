@@ -0,0 +1,24 @@
+package kube
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestPing(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	controller := &KubeController{clientset: clientset, Namespace: "default"}
+
+	require.Nil(t, controller.Ping(context.Background()))
+
+	clientset.PrependReactor("list", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("connection refused")
+	})
+	require.NotNil(t, controller.Ping(context.Background()))
+}
@@ -0,0 +1,108 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/controller/spec"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Annotation listing the keys of the workspace labels put on an object, so
+// that labels the workspace no longer has can be removed without touching
+// labels added by others.
+const workspaceLabelsKey = "kubernetes-web-client/labels"
+
+// podLabels returns the labels put on a workspace's pod and volume claim:
+// the workspace's own labels, so that the cluster can be queried by them,
+// and the labels of every resource of the workspace.
+func podLabels(workspace spec.Workspace) map[string]string {
+	labels := make(map[string]string, len(workspace.Labels))
+	for key, value := range workspace.Labels {
+		if !spec.ReservedLabel(key) {
+			labels[key] = value
+		}
+	}
+	maps.Copy(labels, workspaceLabels(workspace))
+	return labels
+}
+
+// podAnnotations returns the annotations put on a workspace's pod and
+// volume claim.
+func podAnnotations(workspace spec.Workspace) map[string]string {
+	return map[string]string{workspaceLabelsKey: labelKeys(workspace)}
+}
+
+// labelKeys returns the keys of a workspace's labels, sorted and separated
+// by commas.
+func labelKeys(workspace spec.Workspace) string {
+	keys := slices.Sorted(maps.Keys(workspace.Labels))
+	keys = slices.DeleteFunc(keys, spec.ReservedLabel)
+	return strings.Join(keys, ",")
+}
+
+// relabel puts a workspace's labels on the metadata of its pod or volume
+// claim, removing the workspace labels it had before. It returns whether
+// the metadata changed.
+func relabel(meta *metav1.ObjectMeta, workspace spec.Workspace) bool {
+	labels := maps.Clone(meta.Labels)
+	if labels == nil {
+		labels = make(map[string]string)
+	}
+	if previous := meta.Annotations[workspaceLabelsKey]; previous != "" {
+		for _, key := range strings.Split(previous, ",") {
+			delete(labels, key)
+		}
+	}
+	maps.Copy(labels, podLabels(workspace))
+
+	annotations := maps.Clone(meta.Annotations)
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	maps.Copy(annotations, podAnnotations(workspace))
+
+	if maps.Equal(labels, meta.Labels) && maps.Equal(annotations, meta.Annotations) {
+		return false
+	}
+	meta.Labels = labels
+	meta.Annotations = annotations
+	return true
+}
+
+// LabelWorkspace applies a workspace's labels to its existing pod and volume
+// claim. Objects that don't exist yet are created with the labels, so
+// they're skipped, and objects that already have them are left alone, so it
+// can be retried.
+func (k *KubeController) LabelWorkspace(ctx context.Context, workspace spec.Workspace) error {
+	namespace := k.namespace(workspace)
+	name := workspaceName(workspace)
+
+	claims := k.clientset.CoreV1().PersistentVolumeClaims(namespace)
+	pvc, err := claims.Get(ctx, name, metav1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("unable to get workspace volume: %v", err)
+	}
+	if err == nil && relabel(&pvc.ObjectMeta, workspace) {
+		if _, err := claims.Update(ctx, pvc, metav1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("unable to label workspace volume: %v", err)
+		}
+	}
+
+	pods := k.clientset.CoreV1().Pods(namespace)
+	pod, err := pods.Get(ctx, name, metav1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("unable to get workspace pod: %v", err)
+	}
+	if err == nil && relabel(&pod.ObjectMeta, workspace) {
+		if _, err := pods.Update(ctx, pod, metav1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("unable to label workspace pod: %v", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,61 @@
+package kube
+
+import (
+	"context"
+	"testing"
+
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/controller/spec"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestPodLabels(t *testing.T) {
+	workspace := spec.Workspace{ID: 1, Labels: map[string]string{"project": "thesis", "workspace": "2"}}
+
+	labels := podLabels(workspace)
+	require.Equal(t, "thesis", labels["project"])
+	require.Equal(t, "1", labels[workspaceLabel], "Workspace labels can't override the controller's labels")
+	require.Equal(t, managedByValue, labels[managedByLabel])
+	require.Equal(t, "project", labelKeys(workspace))
+}
+
+func TestLabelWorkspace(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	controller := &KubeController{clientset: clientset, Namespace: "default", Image: "foo/bar"}
+
+	workspace := spec.Workspace{ID: 1, Labels: map[string]string{"project": "thesis", "env": "dev"}}
+	require.Nil(t, controller.CreateWorkspaceVolume(context.Background(), workspace))
+	require.Nil(t, controller.CreateWorkspacePod(context.Background(), workspace))
+
+	name := workspaceName(workspace)
+	pods := clientset.CoreV1().Pods("default")
+
+	// Labels added by others are kept
+	pod, err := pods.Get(context.Background(), name, metav1.GetOptions{})
+	require.Nil(t, err)
+	require.Equal(t, "thesis", pod.Labels["project"])
+	pod.Labels["backup"] = "daily"
+	_, err = pods.Update(context.Background(), pod, metav1.UpdateOptions{})
+	require.Nil(t, err)
+
+	workspace.Labels = map[string]string{"project": "paper"}
+	require.Nil(t, controller.LabelWorkspace(context.Background(), workspace))
+	// Labeling again should succeed
+	require.Nil(t, controller.LabelWorkspace(context.Background(), workspace))
+
+	pod, err = pods.Get(context.Background(), name, metav1.GetOptions{})
+	require.Nil(t, err)
+	require.Equal(t, "paper", pod.Labels["project"])
+	require.NotContains(t, pod.Labels, "env")
+	require.Equal(t, "daily", pod.Labels["backup"])
+	require.Equal(t, "1", pod.Labels[workspaceLabel])
+	require.Equal(t, "project", pod.Annotations[workspaceLabelsKey])
+
+	pvc, err := clientset.CoreV1().PersistentVolumeClaims("default").Get(context.Background(), name, metav1.GetOptions{})
+	require.Nil(t, err)
+	require.Equal(t, podLabels(workspace), pvc.Labels)
+
+	// Missing resources are skipped
+	require.Nil(t, controller.LabelWorkspace(context.Background(), spec.Workspace{ID: 2}))
+}
@@ -0,0 +1,55 @@
+package kube
+
+import (
+	"context"
+	"time"
+
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/leader"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// Timing of Lease leader election.
+const (
+	leaseDuration = 15 * time.Second
+	leaseRenewal  = 10 * time.Second
+	leaseRetry    = 2 * time.Second
+)
+
+// leaseElector elects the replica holding a Lease in the controller's
+// namespace.
+type leaseElector struct {
+	lock *resourcelock.LeaseLock
+}
+
+// LeaderElector returns an Elector competing for the Lease with a given name
+// in the controller's namespace. identity must be unique to the replica.
+func (k *KubeController) LeaderElector(name string, identity string) leader.Elector {
+	return &leaseElector{
+		lock: &resourcelock.LeaseLock{
+			LeaseMeta:  metav1.ObjectMeta{Name: name, Namespace: k.Namespace},
+			Client:     k.clientset.CoordinationV1(),
+			LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+		},
+	}
+}
+
+// Run campaigns for the Lease until the context is canceled, releasing it
+// when canceled so another replica can take over immediately.
+func (e *leaseElector) Run(ctx context.Context, lead func(ctx context.Context)) {
+	for ctx.Err() == nil {
+		// RunOrDie returns once leadership is lost, so campaign again
+		leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+			Lock:            e.lock,
+			LeaseDuration:   leaseDuration,
+			RenewDeadline:   leaseRenewal,
+			RetryPeriod:     leaseRetry,
+			ReleaseOnCancel: true,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: lead,
+				OnStoppedLeading: func() {},
+			},
+		})
+	}
+}
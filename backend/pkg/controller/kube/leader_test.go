@@ -0,0 +1,57 @@
+package kube
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestLeaderElector(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	controller := &KubeController{clientset: clientset, Namespace: "web-client"}
+
+	leading := make(chan string, 2)
+	campaign := func(ctx context.Context, identity string) chan struct{} {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			controller.LeaderElector("leader", identity).Run(ctx, func(ctx context.Context) {
+				leading <- identity
+				<-ctx.Done()
+			})
+		}()
+		return done
+	}
+
+	ctxA, cancelA := context.WithCancel(context.Background())
+	doneA := campaign(ctxA, "a")
+	require.Equal(t, "a", <-leading)
+
+	lease, err := clientset.CoordinationV1().Leases("web-client").Get(context.Background(), "leader", metav1.GetOptions{})
+	require.Nil(t, err)
+	require.Equal(t, "a", *lease.Spec.HolderIdentity)
+
+	// A second replica waits while the first leads
+	ctxB, cancelB := context.WithCancel(context.Background())
+	defer cancelB()
+	campaign(ctxB, "b")
+	select {
+	case identity := <-leading:
+		t.Fatalf("%v leads alongside a", identity)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// and takes over once the first releases the lease
+	cancelA()
+	<-doneA
+	select {
+	case identity := <-leading:
+		require.Equal(t, "b", identity)
+	case <-time.After(10 * time.Second):
+		t.Fatal("b never took over")
+	}
+}
@@ -0,0 +1,277 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/controller/spec"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// Names of the objects the controller creates in a managed namespace.
+const (
+	quotaName         = "workspace-quota"
+	limitRangeName    = "workspace-limits"
+	defaultDenyPolicy = "default-deny"
+)
+
+// Labels and annotations put on managed namespaces and workspaces. Workspaces
+// can't be labeled with them, see spec.ReservedLabel.
+const (
+	managedByLabel       = "app.kubernetes.io/managed-by"
+	managedByValue       = "kubernetes-web-client"
+	userLabel            = "kubernetes-web-client/user"
+	componentLabel       = "kubernetes-web-client/component"
+	workspaceLabel       = "workspace"
+	parentNamespaceLabel = "kubernetes-web-client/parent-namespace"
+	descriptionKey       = "kubernetes-web-client/description"
+)
+
+// userNamespace returns the name of a user's namespace in NamespaceModeUser.
+// Names are prefixed with the controller's namespace so that several
+// installations can share a cluster.
+func (k *KubeController) userNamespace(user int32) string {
+	return fmt.Sprintf("%s-user-%d", k.Namespace, user)
+}
+
+// TeamNamespace returns the name of the dedicated namespace of a team,
+// given a slug of the team's name. Names are prefixed with the controller's
+// namespace, like userNamespace, and cut to the longest namespace name.
+func (k *KubeController) TeamNamespace(slug string) string {
+	name := fmt.Sprintf("%s-team-%s", k.Namespace, slug)
+	if len(name) > validation.DNS1123LabelMaxLength {
+		name = strings.TrimRight(name[:validation.DNS1123LabelMaxLength], "-")
+	}
+	return name
+}
+
+// userNamespaceSpec returns the spec of a user's namespace.
+func (k *KubeController) userNamespaceSpec(user int32) spec.Namespace {
+	return spec.Namespace{
+		Name: k.userNamespace(user),
+		Labels: map[string]string{
+			userLabel: fmt.Sprint(user),
+		},
+		Annotations: map[string]string{
+			descriptionKey: fmt.Sprintf("Workspaces of user %d", user),
+		},
+		Quota: k.UserQuota,
+	}
+}
+
+// EnsureNamespace creates a namespace if it does not exist and applies its
+// isolation policies: a default-deny NetworkPolicy, a LimitRange giving
+// containers default resources, and a ResourceQuota if the namespace has one.
+// Existing namespaces that the controller didn't create aren't adopted, and
+// return an AlreadyExists error.
+func (k *KubeController) EnsureNamespace(ctx context.Context, namespace spec.Namespace) error {
+	labels := map[string]string{
+		managedByLabel:       managedByValue,
+		parentNamespaceLabel: k.Namespace,
+	}
+	for key, value := range namespace.Labels {
+		labels[key] = value
+	}
+
+	ns := &v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        namespace.Name,
+			Labels:      labels,
+			Annotations: namespace.Annotations,
+		},
+	}
+
+	_, err := k.clientset.CoreV1().Namespaces().Create(ctx, ns, metav1.CreateOptions{})
+	if errors.IsAlreadyExists(err) {
+		existing, getErr := k.clientset.CoreV1().Namespaces().Get(ctx, namespace.Name, metav1.GetOptions{})
+		if getErr != nil {
+			return fmt.Errorf("unable to retrieve namespace %v: %v", namespace.Name, getErr)
+		}
+		if !k.managesNamespace(existing) {
+			return fmt.Errorf("namespace %v isn't managed by the controller: %w", namespace.Name, err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("unable to create namespace %v: %v", namespace.Name, err)
+	}
+
+	if err := k.ensureDefaultDenyPolicy(ctx, namespace.Name); err != nil {
+		return err
+	}
+
+	if err := k.ensureLimitRange(ctx, namespace.Name); err != nil {
+		return err
+	}
+
+	return k.ensureQuota(ctx, namespace.Name, namespace.Quota)
+}
+
+// managesNamespace returns whether a namespace was created by the
+// controller, rather than by someone else or another installation.
+func (k *KubeController) managesNamespace(namespace *v1.Namespace) bool {
+	return namespace.Labels[managedByLabel] == managedByValue && namespace.Labels[parentNamespaceLabel] == k.Namespace
+}
+
+// DeleteUserNamespace removes a user's namespace and everything in it. It
+// does nothing unless the controller is in NamespaceModeUser.
+func (k *KubeController) DeleteUserNamespace(ctx context.Context, user int32) error {
+	if k.NamespaceMode != NamespaceModeUser {
+		return nil
+	}
+
+	name := k.userNamespace(user)
+	err := k.clientset.CoreV1().Namespaces().Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("unable to delete namespace %v: %v", name, err)
+	}
+
+	return nil
+}
+
+// DeleteTeamNamespace removes a team's dedicated namespace and everything in
+// it. Namespaces the controller didn't create are left alone.
+func (k *KubeController) DeleteTeamNamespace(ctx context.Context, name string) error {
+	namespace, err := k.clientset.CoreV1().Namespaces().Get(ctx, name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to retrieve namespace %v: %v", name, err)
+	}
+
+	if !k.managesNamespace(namespace) {
+		return nil
+	}
+
+	err = k.clientset.CoreV1().Namespaces().Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("unable to delete namespace %v: %v", name, err)
+	}
+
+	return nil
+}
+
+// ensureDefaultDenyPolicy blocks all traffic to and from pods in a namespace
+// that isn't allowed by another NetworkPolicy.
+func (k *KubeController) ensureDefaultDenyPolicy(ctx context.Context, namespace string) error {
+	policy := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: defaultDenyPolicy},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress},
+		},
+	}
+
+	policies := k.clientset.NetworkingV1().NetworkPolicies(namespace)
+
+	_, err := policies.Create(ctx, policy, metav1.CreateOptions{})
+	if errors.IsAlreadyExists(err) {
+		_, err = policies.Update(ctx, policy, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("unable to apply network policy to namespace %v: %v", namespace, err)
+	}
+
+	return nil
+}
+
+// ensureLimitRange gives containers in a namespace default resources, so
+// that pods are admitted under the namespace's quota.
+func (k *KubeController) ensureLimitRange(ctx context.Context, namespace string) error {
+	defaults, err := podResources(spec.Workspace{})
+	if err != nil {
+		return err
+	}
+
+	limitRange := &v1.LimitRange{
+		ObjectMeta: metav1.ObjectMeta{Name: limitRangeName},
+		Spec: v1.LimitRangeSpec{
+			Limits: []v1.LimitRangeItem{
+				{
+					Type:           v1.LimitTypeContainer,
+					Default:        defaults,
+					DefaultRequest: defaults.DeepCopy(),
+				},
+			},
+		},
+	}
+
+	limitRanges := k.clientset.CoreV1().LimitRanges(namespace)
+
+	_, err = limitRanges.Create(ctx, limitRange, metav1.CreateOptions{})
+	if errors.IsAlreadyExists(err) {
+		_, err = limitRanges.Update(ctx, limitRange, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("unable to apply limit range to namespace %v: %v", namespace, err)
+	}
+
+	return nil
+}
+
+// ensureQuota applies a quota to a namespace, removing the namespace's
+// ResourceQuota if nothing is limited.
+func (k *KubeController) ensureQuota(ctx context.Context, namespace string, quota spec.Quota) error {
+	hard, err := quotaResources(quota)
+	if err != nil {
+		return err
+	}
+
+	quotas := k.clientset.CoreV1().ResourceQuotas(namespace)
+
+	if len(hard) == 0 {
+		err = quotas.Delete(ctx, quotaName, metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("unable to remove quota from namespace %v: %v", namespace, err)
+		}
+		return nil
+	}
+
+	resourceQuota := &v1.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{Name: quotaName},
+		Spec:       v1.ResourceQuotaSpec{Hard: hard},
+	}
+
+	_, err = quotas.Create(ctx, resourceQuota, metav1.CreateOptions{})
+	if errors.IsAlreadyExists(err) {
+		_, err = quotas.Update(ctx, resourceQuota, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("unable to apply quota to namespace %v: %v", namespace, err)
+	}
+
+	return nil
+}
+
+// quotaResources converts a spec.Quota to the hard limits of a ResourceQuota.
+func quotaResources(quota spec.Quota) (v1.ResourceList, error) {
+	hard := v1.ResourceList{}
+
+	limits := []struct {
+		name  v1.ResourceName
+		value string
+	}{
+		{v1.ResourceLimitsCPU, quota.CPU},
+		{v1.ResourceLimitsMemory, quota.Memory},
+		{v1.ResourceRequestsStorage, quota.Storage},
+	}
+
+	for _, limit := range limits {
+		if limit.value == "" {
+			continue
+		}
+
+		q, err := resource.ParseQuantity(limit.value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %v quota %q: %v", limit.name, limit.value, err)
+		}
+		hard[limit.name] = q
+	}
+
+	return hard, nil
+}
@@ -0,0 +1,148 @@
+package kube
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/controller/spec"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestEnsureNamespace(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	controller := &KubeController{clientset: clientset, Namespace: "default"}
+
+	namespace := spec.Namespace{Name: "team-foo", Quota: spec.Quota{CPU: "4", Memory: "8Gi"}}
+	require.Nil(t, controller.EnsureNamespace(context.Background(), namespace))
+
+	policy, err := clientset.NetworkingV1().NetworkPolicies("team-foo").Get(context.Background(), defaultDenyPolicy, metav1.GetOptions{})
+	require.Nil(t, err)
+	require.Empty(t, policy.Spec.PodSelector.MatchLabels)
+	require.Empty(t, policy.Spec.Ingress)
+	require.Empty(t, policy.Spec.Egress)
+	require.Len(t, policy.Spec.PolicyTypes, 2)
+
+	_, err = clientset.CoreV1().LimitRanges("team-foo").Get(context.Background(), limitRangeName, metav1.GetOptions{})
+	require.Nil(t, err)
+
+	quota, err := clientset.CoreV1().ResourceQuotas("team-foo").Get(context.Background(), quotaName, metav1.GetOptions{})
+	require.Nil(t, err)
+	require.True(t, resource.MustParse("4").Equal(quota.Spec.Hard["limits.cpu"]))
+	require.True(t, resource.MustParse("8Gi").Equal(quota.Spec.Hard["limits.memory"]))
+
+	// Updating the quota should replace the existing one
+	namespace.Quota = spec.Quota{Storage: "100Gi"}
+	require.Nil(t, controller.EnsureNamespace(context.Background(), namespace))
+
+	quota, err = clientset.CoreV1().ResourceQuotas("team-foo").Get(context.Background(), quotaName, metav1.GetOptions{})
+	require.Nil(t, err)
+	require.Len(t, quota.Spec.Hard, 1)
+
+	// Removing all limits should remove the quota
+	namespace.Quota = spec.Quota{}
+	require.Nil(t, controller.EnsureNamespace(context.Background(), namespace))
+
+	_, err = clientset.CoreV1().ResourceQuotas("team-foo").Get(context.Background(), quotaName, metav1.GetOptions{})
+	require.NotNil(t, err)
+
+	// Invalid quotas are rejected
+	namespace.Quota = spec.Quota{CPU: "lots"}
+	require.NotNil(t, controller.EnsureNamespace(context.Background(), namespace))
+}
+
+func TestEnsureNamespaceUnmanaged(t *testing.T) {
+	tests := []struct {
+		description string
+		labels      map[string]string
+		wantErr     bool
+	}{
+		{"Managed", map[string]string{managedByLabel: managedByValue, parentNamespaceLabel: "web-client"}, false},
+		{"Unmanaged", nil, true},
+		{"Another installation", map[string]string{managedByLabel: managedByValue, parentNamespaceLabel: "other"}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "web-client-team-foo", Labels: test.labels}})
+			controller := &KubeController{clientset: clientset, Namespace: "web-client"}
+
+			err := controller.EnsureNamespace(context.Background(), spec.Namespace{Name: "web-client-team-foo", Quota: spec.Quota{CPU: "4"}})
+			require.Equal(t, test.wantErr, err != nil, "%v", err)
+			if err != nil {
+				require.True(t, errors.IsAlreadyExists(err))
+			}
+
+			// Nothing is applied to namespaces that aren't adopted
+			_, err = clientset.CoreV1().ResourceQuotas("web-client-team-foo").Get(context.Background(), quotaName, metav1.GetOptions{})
+			require.Equal(t, test.wantErr, errors.IsNotFound(err))
+		})
+	}
+}
+
+func TestTeamNamespace(t *testing.T) {
+	controller := &KubeController{Namespace: "web-client"}
+
+	require.Equal(t, "web-client-team-research", controller.TeamNamespace("research"))
+
+	long := controller.TeamNamespace(strings.Repeat("a", 46) + "-bbbb")
+	require.Equal(t, "web-client-team-"+strings.Repeat("a", 46), long)
+}
+
+func TestDeleteUserNamespace(t *testing.T) {
+	tests := []struct {
+		description string // Test description
+		mode        string // Namespace mode
+		wantDeleted bool
+	}{
+		{"User namespace mode", NamespaceModeUser, true},
+		{"Shared namespace mode", NamespaceModeShared, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "web-client-user-7"}})
+			controller := &KubeController{clientset: clientset, Namespace: "web-client", NamespaceMode: test.mode}
+
+			require.Nil(t, controller.DeleteUserNamespace(context.Background(), 7))
+
+			_, err := clientset.CoreV1().Namespaces().Get(context.Background(), "web-client-user-7", metav1.GetOptions{})
+			require.Equal(t, test.wantDeleted, errors.IsNotFound(err))
+		})
+	}
+}
+
+func TestDeleteTeamNamespace(t *testing.T) {
+	tests := []struct {
+		description string            // Test description
+		labels      map[string]string // Labels of the existing namespace
+		wantDeleted bool
+	}{
+		{"Managed", map[string]string{managedByLabel: managedByValue, parentNamespaceLabel: "web-client"}, true},
+		{"Unmanaged", nil, false},
+		{"Another installation", map[string]string{managedByLabel: managedByValue, parentNamespaceLabel: "other"}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "web-client-team-foo", Labels: test.labels}})
+			controller := &KubeController{clientset: clientset, Namespace: "web-client"}
+
+			require.Nil(t, controller.DeleteTeamNamespace(context.Background(), "web-client-team-foo"))
+
+			_, err := clientset.CoreV1().Namespaces().Get(context.Background(), "web-client-team-foo", metav1.GetOptions{})
+			require.Equal(t, test.wantDeleted, errors.IsNotFound(err))
+		})
+	}
+
+	t.Run("Missing", func(t *testing.T) {
+		controller := &KubeController{clientset: fake.NewSimpleClientset(), Namespace: "web-client"}
+
+		require.Nil(t, controller.DeleteTeamNamespace(context.Background(), "web-client-team-foo"))
+	})
+}
@@ -0,0 +1,117 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/controller/spec"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// apiPodLabels select the API's pods, which proxy traffic to workspaces.
+// They match the labels in deploy/api.yaml, and include a key workspaces
+// can't be labeled with, see spec.ReservedLabel.
+var apiPodLabels = map[string]string{"k8s-app": "api", componentLabel: "api"}
+
+// privateNetworks are the address ranges cluster-internal traffic uses.
+var privateNetworks = []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16"}
+
+// workspaceNetworkPolicy generates the NetworkPolicy of a workspace. It only
+// admits traffic from the API's pods and restricts outbound traffic according
+// to the workspace's template. DNS lookups are always allowed.
+func (k *KubeController) workspaceNetworkPolicy(workspace spec.Workspace) (*networkingv1.NetworkPolicy, error) {
+	egress := []networkingv1.NetworkPolicyEgressRule{dnsEgressRule()}
+
+	switch workspace.Template.Egress {
+	case spec.EgressInternet, "":
+		egress = append(egress, networkingv1.NetworkPolicyEgressRule{
+			To: []networkingv1.NetworkPolicyPeer{
+				{IPBlock: &networkingv1.IPBlock{CIDR: "0.0.0.0/0", Except: privateNetworks}},
+			},
+		})
+	case spec.EgressInternal:
+		var peers []networkingv1.NetworkPolicyPeer
+		for _, cidr := range privateNetworks {
+			peers = append(peers, networkingv1.NetworkPolicyPeer{IPBlock: &networkingv1.IPBlock{CIDR: cidr}})
+		}
+		egress = append(egress, networkingv1.NetworkPolicyEgressRule{To: peers})
+	case spec.EgressNone:
+	default:
+		return nil, fmt.Errorf("invalid egress rule %q", workspace.Template.Egress)
+	}
+
+	policy := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   workspaceName(workspace),
+			Labels: workspaceLabels(workspace),
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{MatchLabels: workspaceSelector(workspace)},
+			Ingress: []networkingv1.NetworkPolicyIngressRule{
+				{
+					From: []networkingv1.NetworkPolicyPeer{
+						{
+							NamespaceSelector: &metav1.LabelSelector{
+								MatchLabels: map[string]string{v1.LabelMetadataName: k.Namespace},
+							},
+							PodSelector: &metav1.LabelSelector{MatchLabels: apiPodLabels},
+						},
+					},
+				},
+			},
+			Egress:      egress,
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress},
+		},
+	}
+
+	return policy, nil
+}
+
+// dnsEgressRule allows DNS lookups through the cluster's DNS service.
+func dnsEgressRule() networkingv1.NetworkPolicyEgressRule {
+	udp := v1.ProtocolUDP
+	tcp := v1.ProtocolTCP
+	port := intstr.FromInt32(53)
+
+	return networkingv1.NetworkPolicyEgressRule{
+		To: []networkingv1.NetworkPolicyPeer{
+			{
+				NamespaceSelector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{v1.LabelMetadataName: metav1.NamespaceSystem},
+				},
+				PodSelector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{"k8s-app": "kube-dns"},
+				},
+			},
+		},
+		Ports: []networkingv1.NetworkPolicyPort{
+			{Protocol: &udp, Port: &port},
+			{Protocol: &tcp, Port: &port},
+		},
+	}
+}
+
+// EnsureWorkspaceNetworkPolicy creates a workspace's NetworkPolicy, or
+// updates it to match the workspace's template if it already exists.
+func (k *KubeController) EnsureWorkspaceNetworkPolicy(ctx context.Context, workspace spec.Workspace) error {
+	policy, err := k.workspaceNetworkPolicy(workspace)
+	if err != nil {
+		return err
+	}
+
+	policies := k.clientset.NetworkingV1().NetworkPolicies(k.namespace(workspace))
+
+	_, err = policies.Create(ctx, policy, metav1.CreateOptions{})
+	if errors.IsAlreadyExists(err) {
+		_, err = policies.Update(ctx, policy, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("unable to apply workspace network policy: %v", err)
+	}
+
+	return nil
+}
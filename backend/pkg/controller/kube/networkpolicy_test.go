@@ -0,0 +1,137 @@
+package kube
+
+import (
+	"context"
+	"maps"
+	"testing"
+
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/controller/spec"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestEnsureWorkspaceNetworkPolicy(t *testing.T) {
+	dns := dnsEgressRule()
+
+	tests := []struct {
+		description string // Test description
+		egress      string // Egress rule of the workspace's template
+		wantEgress  []networkingv1.NetworkPolicyEgressRule
+		wantErr     bool
+	}{
+		{
+			"Internet egress",
+			spec.EgressInternet,
+			[]networkingv1.NetworkPolicyEgressRule{dns, {To: []networkingv1.NetworkPolicyPeer{
+				{IPBlock: &networkingv1.IPBlock{CIDR: "0.0.0.0/0", Except: []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16"}}},
+			}}},
+			false,
+		},
+		{
+			"Default egress is internet",
+			"",
+			[]networkingv1.NetworkPolicyEgressRule{dns, {To: []networkingv1.NetworkPolicyPeer{
+				{IPBlock: &networkingv1.IPBlock{CIDR: "0.0.0.0/0", Except: []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16"}}},
+			}}},
+			false,
+		},
+		{
+			"Internal egress",
+			spec.EgressInternal,
+			[]networkingv1.NetworkPolicyEgressRule{dns, {To: []networkingv1.NetworkPolicyPeer{
+				{IPBlock: &networkingv1.IPBlock{CIDR: "10.0.0.0/8"}},
+				{IPBlock: &networkingv1.IPBlock{CIDR: "172.16.0.0/12"}},
+				{IPBlock: &networkingv1.IPBlock{CIDR: "192.168.0.0/16"}},
+			}}},
+			false,
+		},
+		{"No egress", spec.EgressNone, []networkingv1.NetworkPolicyEgressRule{dns}, false},
+		{"Invalid egress", "everywhere", nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset()
+			controller := &KubeController{clientset: clientset, Namespace: "web-client"}
+
+			workspace := spec.Workspace{ID: 3, Template: spec.Template{Egress: test.egress}}
+			err := controller.EnsureWorkspaceNetworkPolicy(context.Background(), workspace)
+			if test.wantErr {
+				require.NotNil(t, err)
+				return
+			}
+			require.Nil(t, err)
+
+			policy, err := clientset.NetworkingV1().NetworkPolicies("web-client").Get(context.Background(), "workspace-3", metav1.GetOptions{})
+			require.Nil(t, err)
+
+			// Only the workspace's pod is selected
+			require.Equal(t, map[string]string{managedByLabel: managedByValue, workspaceLabel: "3"}, policy.Spec.PodSelector.MatchLabels)
+			require.Equal(t, []networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress}, policy.Spec.PolicyTypes)
+
+			// Only the API's pods may connect
+			require.Len(t, policy.Spec.Ingress, 1)
+			require.Len(t, policy.Spec.Ingress[0].From, 1)
+			from := policy.Spec.Ingress[0].From[0]
+			require.Equal(t, map[string]string{v1.LabelMetadataName: "web-client"}, from.NamespaceSelector.MatchLabels)
+			require.Equal(t, apiPodLabels, from.PodSelector.MatchLabels)
+			require.Empty(t, from.IPBlock)
+
+			require.Equal(t, test.wantEgress, policy.Spec.Egress)
+		})
+	}
+}
+
+func TestEnsureWorkspaceNetworkPolicyReconciles(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	controller := &KubeController{clientset: clientset, Namespace: "web-client"}
+
+	workspace := spec.Workspace{ID: 3, Template: spec.Template{Egress: spec.EgressInternet}}
+	require.Nil(t, controller.EnsureWorkspaceNetworkPolicy(context.Background(), workspace))
+
+	// Changing the template's egress rule updates the existing policy
+	workspace.Template.Egress = spec.EgressNone
+	require.Nil(t, controller.EnsureWorkspaceNetworkPolicy(context.Background(), workspace))
+
+	policies, err := clientset.NetworkingV1().NetworkPolicies("web-client").List(context.Background(), metav1.ListOptions{})
+	require.Nil(t, err)
+	require.Len(t, policies.Items, 1)
+	require.Equal(t, []networkingv1.NetworkPolicyEgressRule{dnsEgressRule()}, policies.Items[0].Spec.Egress)
+}
+
+func TestCreateWorkspacePodAppliesNetworkPolicy(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	controller := &KubeController{clientset: clientset, Namespace: "web-client", Image: "foo/bar"}
+
+	workspace := spec.Workspace{ID: 4, Namespace: "team-foo", Template: spec.Template{Image: "foo/baz", Egress: spec.EgressInternal}}
+	require.Nil(t, controller.CreateWorkspacePod(context.Background(), workspace))
+
+	policy, err := clientset.NetworkingV1().NetworkPolicies("team-foo").Get(context.Background(), "workspace-4", metav1.GetOptions{})
+	require.Nil(t, err)
+
+	// The policy selects the pod it protects
+	pod, err := clientset.CoreV1().Pods("team-foo").Get(context.Background(), "workspace-4", metav1.GetOptions{})
+	require.Nil(t, err)
+	for key, value := range policy.Spec.PodSelector.MatchLabels {
+		require.Equal(t, value, pod.Labels[key])
+	}
+	require.Equal(t, "foo/baz", pod.Spec.Containers[0].Image)
+}
+
+func TestWorkspacePodNotSelectedAsAPI(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	controller := &KubeController{clientset: clientset, Namespace: "web-client", Image: "foo/bar"}
+
+	// Labels selecting the API's pods can't be put on a workspace's pod
+	workspace := spec.Workspace{ID: 3, Labels: maps.Clone(apiPodLabels)}
+	require.Nil(t, controller.CreateWorkspaceVolume(context.Background(), workspace))
+	require.Nil(t, controller.CreateWorkspacePod(context.Background(), workspace))
+
+	pod, err := clientset.CoreV1().Pods("web-client").Get(context.Background(), "workspace-3", metav1.GetOptions{})
+	require.Nil(t, err)
+	require.False(t, labels.SelectorFromSet(apiPodLabels).Matches(labels.Set(pod.Labels)), "%v", pod.Labels)
+}
@@ -0,0 +1,303 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/controller/spec"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// Resources of the VolumeSnapshot API, served when the cluster runs the CSI
+// snapshot controller.
+var (
+	volumeSnapshotResource      = schema.GroupVersionResource{Group: "snapshot.storage.k8s.io", Version: "v1", Resource: "volumesnapshots"}
+	volumeSnapshotClassResource = schema.GroupVersionResource{Group: "snapshot.storage.k8s.io", Version: "v1", Resource: "volumesnapshotclasses"}
+)
+
+// Annotation marking the default VolumeSnapshotClass of a CSI driver.
+const defaultSnapshotClassKey = "snapshot.storage.kubernetes.io/is-default-class"
+
+// Interval at which snapshots are checked until they're ready, and volume
+// claims until they're removed.
+const snapshotPollInterval = 2 * time.Second
+
+// snapshotName returns the name of a snapshot of a workspace's volume.
+func snapshotName(workspace spec.Workspace, snapshot int32) string {
+	return fmt.Sprintf("%v-snapshot-%d", workspaceName(workspace), snapshot)
+}
+
+// SnapshotWorkspace takes a snapshot of a workspace's volume, returning once
+// it's ready to use. Snapshots are taken with the VolumeSnapshotClass of the
+// CSI driver provisioning the volume, so volumes of storage classes without
+// one can't be snapshotted. Taking a snapshot that exists waits for it, so it
+// can be retried.
+func (k *KubeController) SnapshotWorkspace(ctx context.Context, workspace spec.Workspace, snapshot int32) (spec.Snapshot, error) {
+	namespace := k.namespace(workspace)
+
+	pvc, err := k.clientset.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, workspaceName(workspace), metav1.GetOptions{})
+	if err != nil {
+		return spec.Snapshot{}, fmt.Errorf("unable to get workspace volume: %v", err)
+	}
+
+	class, err := k.snapshotClass(ctx, pvc)
+	if err != nil {
+		return spec.Snapshot{}, err
+	}
+	if class == "" {
+		return spec.Snapshot{}, fmt.Errorf("%w: the workspace's storage class doesn't support snapshots", spec.ErrUnsupported)
+	}
+
+	name := snapshotName(workspace, snapshot)
+	if err := k.createSnapshot(ctx, namespace, name, pvc.Name, class, workspaceSelector(workspace)); err != nil {
+		return spec.Snapshot{}, fmt.Errorf("unable to snapshot workspace volume: %v", err)
+	}
+
+	ready, err := k.waitForSnapshot(ctx, namespace, name)
+	if err != nil {
+		return spec.Snapshot{}, fmt.Errorf("unable to snapshot workspace volume: %v", err)
+	}
+
+	size, _, _ := unstructured.NestedString(ready.Object, "status", "restoreSize")
+	return spec.Snapshot{Size: size}, nil
+}
+
+// RestoreWorkspaceSnapshot replaces a workspace's volume with a volume
+// restored from one of its snapshots. The workspace's pod and files pod are
+// removed, since they hold the volume, and the workspace's pod must be
+// created again once the volume is restored. The volume keeps its storage class, and grows to the size of the
+// snapshot if it's smaller. The volume is only replaced if the snapshot is
+// ready to use and isn't being removed. Restoring again restores the snapshot anew, so it
+// can be retried.
+func (k *KubeController) RestoreWorkspaceSnapshot(ctx context.Context, workspace spec.Workspace, snapshot int32) error {
+	if k.dynamic == nil {
+		return fmt.Errorf("%w: the cluster doesn't serve the VolumeSnapshot API", spec.ErrUnsupported)
+	}
+
+	namespace := k.namespace(workspace)
+	name := workspaceName(workspace)
+	claims := k.clientset.CoreV1().PersistentVolumeClaims(namespace)
+
+	restored, err := k.dynamic.Resource(volumeSnapshotResource).Namespace(namespace).Get(ctx, snapshotName(workspace, snapshot), metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to get snapshot: %v", err)
+	}
+	if ready, _, _ := unstructured.NestedBool(restored.Object, "status", "readyToUse"); !ready {
+		return fmt.Errorf("snapshot isn't ready to use")
+	}
+	if restored.GetDeletionTimestamp() != nil {
+		return fmt.Errorf("snapshot is being removed")
+	}
+
+	size, err := volumeSize(workspace)
+	if err != nil {
+		return err
+	}
+	if restoreSize, found, _ := unstructured.NestedString(restored.Object, "status", "restoreSize"); found {
+		if snapshotSize, err := resource.ParseQuantity(restoreSize); err == nil && snapshotSize.Cmp(size) > 0 {
+			size = snapshotSize
+		}
+	}
+
+	current, err := claims.Get(ctx, name, metav1.GetOptions{})
+	exists := err == nil
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("unable to get workspace volume: %v", err)
+	}
+
+	err = k.clientset.CoreV1().Pods(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("unable to delete workspace pod: %v", err)
+	}
+	if err := k.deleteFilesPod(ctx, workspace); err != nil {
+		return err
+	}
+
+	var storageClass *string
+	if exists {
+		storageClass = current.Spec.StorageClassName
+
+		err = claims.Delete(ctx, name, metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("unable to delete workspace volume: %v", err)
+		}
+
+		// The claim is only removed once the pod using it is gone
+		err = wait.PollUntilContextCancel(ctx, snapshotPollInterval, true, func(ctx context.Context) (bool, error) {
+			_, err := claims.Get(ctx, name, metav1.GetOptions{})
+			if errors.IsNotFound(err) {
+				return true, nil
+			}
+			if err != nil {
+				return false, fmt.Errorf("unable to get workspace volume: %v", err)
+			}
+			return false, nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	pvc := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Labels:      podLabels(workspace),
+			Annotations: podAnnotations(workspace),
+		},
+		Spec: v1.PersistentVolumeClaimSpec{
+			AccessModes:      []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+			StorageClassName: storageClass,
+			Resources: v1.VolumeResourceRequirements{
+				Requests: v1.ResourceList{v1.ResourceStorage: size},
+			},
+			DataSource: &v1.TypedLocalObjectReference{
+				APIGroup: &volumeSnapshotResource.Group,
+				Kind:     "VolumeSnapshot",
+				Name:     snapshotName(workspace, snapshot),
+			},
+		},
+	}
+
+	if _, err := claims.Create(ctx, pvc, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("unable to create workspace volume: %v", err)
+	}
+	return nil
+}
+
+// DeleteWorkspaceSnapshot removes a snapshot of a workspace's volume.
+// Removing a snapshot that doesn't exist succeeds, so it can be retried.
+func (k *KubeController) DeleteWorkspaceSnapshot(ctx context.Context, workspace spec.Workspace, snapshot int32) error {
+	if k.dynamic == nil {
+		return nil
+	}
+
+	err := k.dynamic.Resource(volumeSnapshotResource).Namespace(k.namespace(workspace)).Delete(ctx, snapshotName(workspace, snapshot), metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("unable to delete snapshot: %v", err)
+	}
+	return nil
+}
+
+// deleteWorkspaceSnapshots removes every snapshot of a workspace's volume,
+// including the snapshot a clone is restored from.
+func (k *KubeController) deleteWorkspaceSnapshots(ctx context.Context, workspace spec.Workspace) error {
+	if k.dynamic == nil {
+		return nil
+	}
+
+	snapshots := k.dynamic.Resource(volumeSnapshotResource).Namespace(k.namespace(workspace))
+
+	list, err := snapshots.List(ctx, metav1.ListOptions{LabelSelector: labels.SelectorFromSet(workspaceSelector(workspace)).String()})
+	if errors.IsNotFound(err) {
+		// The cluster doesn't serve the VolumeSnapshot API
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to list workspace snapshots: %v", err)
+	}
+
+	for _, snapshot := range list.Items {
+		err := snapshots.Delete(ctx, snapshot.GetName(), metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("unable to delete workspace snapshot: %v", err)
+		}
+	}
+	return nil
+}
+
+// snapshotClass returns the VolumeSnapshotClass of the CSI driver
+// provisioning a volume claim, preferring the driver's default class, or ""
+// if the claim's volumes can't be snapshotted.
+func (k *KubeController) snapshotClass(ctx context.Context, pvc *v1.PersistentVolumeClaim) (string, error) {
+	if k.dynamic == nil || pvc.Spec.StorageClassName == nil {
+		return "", nil
+	}
+
+	storageClass, err := k.clientset.StorageV1().StorageClasses().Get(ctx, *pvc.Spec.StorageClassName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("unable to get storage class: %v", err)
+	}
+
+	classes, err := k.dynamic.Resource(volumeSnapshotClassResource).List(ctx, metav1.ListOptions{})
+	if errors.IsNotFound(err) {
+		// The cluster doesn't serve the VolumeSnapshot API
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("unable to list volume snapshot classes: %v", err)
+	}
+
+	var class string
+	for _, c := range classes.Items {
+		driver, _, _ := unstructured.NestedString(c.Object, "driver")
+		if driver != storageClass.Provisioner {
+			continue
+		}
+		if c.GetAnnotations()[defaultSnapshotClassKey] == "true" {
+			return c.GetName(), nil
+		}
+		if class == "" {
+			class = c.GetName()
+		}
+	}
+	return class, nil
+}
+
+// createSnapshot creates a VolumeSnapshot of a volume claim with a
+// VolumeSnapshotClass. Creating a snapshot that exists succeeds.
+func (k *KubeController) createSnapshot(ctx context.Context, namespace string, name string, claim string, class string, snapshotLabels map[string]string) error {
+	snapshot := &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": volumeSnapshotResource.GroupVersion().String(),
+		"kind":       "VolumeSnapshot",
+		"metadata": map[string]any{
+			"name": name,
+		},
+		"spec": map[string]any{
+			"volumeSnapshotClassName": class,
+			"source": map[string]any{
+				"persistentVolumeClaimName": claim,
+			},
+		},
+	}}
+	snapshot.SetLabels(snapshotLabels)
+
+	_, err := k.dynamic.Resource(volumeSnapshotResource).Namespace(namespace).Create(ctx, snapshot, metav1.CreateOptions{})
+	if err != nil && !errors.IsAlreadyExists(err) {
+		return err
+	}
+	return nil
+}
+
+// waitForSnapshot waits until a VolumeSnapshot is ready to use and returns
+// it, or returns the error the snapshot controller reported for it.
+func (k *KubeController) waitForSnapshot(ctx context.Context, namespace string, name string) (*unstructured.Unstructured, error) {
+	snapshots := k.dynamic.Resource(volumeSnapshotResource).Namespace(namespace)
+
+	var ready *unstructured.Unstructured
+	err := wait.PollUntilContextCancel(ctx, snapshotPollInterval, true, func(ctx context.Context) (bool, error) {
+		snapshot, err := snapshots.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		if message, found, _ := unstructured.NestedString(snapshot.Object, "status", "error", "message"); found {
+			return false, fmt.Errorf("%v", message)
+		}
+		if done, _, _ := unstructured.NestedBool(snapshot.Object, "status", "readyToUse"); done {
+			ready = snapshot
+			return true, nil
+		}
+		return false, nil
+	})
+	return ready, err
+}
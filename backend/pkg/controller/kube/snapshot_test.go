@@ -0,0 +1,205 @@
+package kube
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/controller/spec"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// Storage class of the volumes created by createSnapshotVolume, provisioned
+// by a CSI driver with VolumeSnapshotClasses.
+const snapshotStorageClass = "csi-hostpath"
+
+// newSnapshotClients returns a clientset with a storage class whose driver
+// has VolumeSnapshotClasses, and a dynamic client serving them. Snapshots
+// are ready as soon as they're created.
+func newSnapshotClients() (*fake.Clientset, *dynamicfake.FakeDynamicClient) {
+	clientset := fake.NewSimpleClientset(&storagev1.StorageClass{
+		ObjectMeta:  metav1.ObjectMeta{Name: snapshotStorageClass},
+		Provisioner: "hostpath.csi.k8s.io",
+	})
+
+	snapshotClass := func(name string, driver string, isDefault bool) *unstructured.Unstructured {
+		class := &unstructured.Unstructured{Object: map[string]any{
+			"apiVersion": "snapshot.storage.k8s.io/v1",
+			"kind":       "VolumeSnapshotClass",
+			"metadata":   map[string]any{"name": name},
+			"driver":     driver,
+		}}
+		if isDefault {
+			class.SetAnnotations(map[string]string{defaultSnapshotClassKey: "true"})
+		}
+		return class
+	}
+	dynamic := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{
+			volumeSnapshotResource:      "VolumeSnapshotList",
+			volumeSnapshotClassResource: "VolumeSnapshotClassList",
+		},
+		snapshotClass("other", "ebs.csi.aws.com", true),
+		snapshotClass("hostpath", "hostpath.csi.k8s.io", false),
+		snapshotClass("hostpath-default", "hostpath.csi.k8s.io", true),
+	)
+
+	dynamic.PrependReactor("create", "volumesnapshots", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		snapshot := action.(k8stesting.CreateAction).GetObject().(*unstructured.Unstructured)
+		unstructured.SetNestedField(snapshot.Object, true, "status", "readyToUse")
+		unstructured.SetNestedField(snapshot.Object, "20Gi", "status", "restoreSize")
+		return false, nil, nil
+	})
+
+	return clientset, dynamic
+}
+
+// createSnapshotVolume creates a workspace's volume claim with
+// snapshotStorageClass.
+func createSnapshotVolume(t *testing.T, clientset *fake.Clientset, workspace spec.Workspace) {
+	storageClass := snapshotStorageClass
+	_, err := clientset.CoreV1().PersistentVolumeClaims("default").Create(context.Background(), &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: workspaceName(workspace)},
+		Spec:       v1.PersistentVolumeClaimSpec{StorageClassName: &storageClass},
+	}, metav1.CreateOptions{})
+	require.Nil(t, err)
+}
+
+func TestSnapshotWorkspace(t *testing.T) {
+	clientset, dynamic := newSnapshotClients()
+	controller := &KubeController{clientset: clientset, dynamic: dynamic, Namespace: "default", Image: "foo/bar"}
+
+	workspace := spec.Workspace{ID: 1}
+	createSnapshotVolume(t, clientset, workspace)
+
+	snapshot, err := controller.SnapshotWorkspace(context.Background(), workspace, 3)
+	require.Nil(t, err)
+	require.Equal(t, "20Gi", snapshot.Size)
+
+	// Taking it again should succeed
+	_, err = controller.SnapshotWorkspace(context.Background(), workspace, 3)
+	require.Nil(t, err)
+
+	taken, err := dynamic.Resource(volumeSnapshotResource).Namespace("default").Get(context.Background(), snapshotName(workspace, 3), metav1.GetOptions{})
+	require.Nil(t, err)
+	class, _, _ := unstructured.NestedString(taken.Object, "spec", "volumeSnapshotClassName")
+	require.Equal(t, "hostpath-default", class)
+	require.Equal(t, workspaceSelector(workspace), taken.GetLabels())
+
+	require.Nil(t, controller.DeleteWorkspaceSnapshot(context.Background(), workspace, 3))
+	// Deleting it again should succeed
+	require.Nil(t, controller.DeleteWorkspaceSnapshot(context.Background(), workspace, 3))
+	_, err = dynamic.Resource(volumeSnapshotResource).Namespace("default").Get(context.Background(), snapshotName(workspace, 3), metav1.GetOptions{})
+	require.NotNil(t, err)
+}
+
+func TestSnapshotWorkspaceUnsupported(t *testing.T) {
+	clientset, dynamic := newSnapshotClients()
+	controller := &KubeController{clientset: clientset, dynamic: dynamic, Namespace: "default", Image: "foo/bar"}
+
+	// Volumes of the default storage class have no VolumeSnapshotClass
+	workspace := spec.Workspace{ID: 1}
+	require.Nil(t, controller.CreateWorkspaceVolume(context.Background(), workspace))
+
+	_, err := controller.SnapshotWorkspace(context.Background(), workspace, 1)
+	require.True(t, errors.Is(err, spec.ErrUnsupported))
+}
+
+func TestRestoreWorkspaceSnapshot(t *testing.T) {
+	clientset, dynamic := newSnapshotClients()
+	controller := &KubeController{clientset: clientset, dynamic: dynamic, Namespace: "default", Image: "foo/bar"}
+
+	workspace := spec.Workspace{ID: 1, Labels: map[string]string{"project": "thesis"}}
+	createSnapshotVolume(t, clientset, workspace)
+	require.Nil(t, controller.CreateWorkspacePod(context.Background(), workspace))
+
+	_, err := controller.SnapshotWorkspace(context.Background(), workspace, 3)
+	require.Nil(t, err)
+
+	require.Nil(t, controller.RestoreWorkspaceSnapshot(context.Background(), workspace, 3))
+	// Restoring again should succeed
+	require.Nil(t, controller.RestoreWorkspaceSnapshot(context.Background(), workspace, 3))
+
+	// The pod is removed until it's created again
+	_, err = clientset.CoreV1().Pods("default").Get(context.Background(), workspaceName(workspace), metav1.GetOptions{})
+	require.NotNil(t, err)
+
+	pvc, err := clientset.CoreV1().PersistentVolumeClaims("default").Get(context.Background(), workspaceName(workspace), metav1.GetOptions{})
+	require.Nil(t, err)
+	require.NotNil(t, pvc.Spec.DataSource)
+	require.Equal(t, snapshotName(workspace, 3), pvc.Spec.DataSource.Name)
+	require.Equal(t, snapshotStorageClass, *pvc.Spec.StorageClassName)
+	require.Equal(t, "thesis", pvc.Labels["project"])
+	require.True(t, pvc.Spec.Resources.Requests[v1.ResourceStorage].Equal(resource.MustParse("20Gi")), "The volume should grow to the snapshot's size")
+
+	// Snapshots that don't exist can't be restored
+	require.NotNil(t, controller.RestoreWorkspaceSnapshot(context.Background(), workspace, 4))
+}
+
+func TestRestoreWorkspaceSnapshotUnavailable(t *testing.T) {
+	clientset, dynamic := newSnapshotClients()
+	controller := &KubeController{clientset: clientset, dynamic: dynamic, Namespace: "default", Image: "foo/bar"}
+
+	workspace := spec.Workspace{ID: 1}
+	createSnapshotVolume(t, clientset, workspace)
+
+	snapshots := dynamic.Resource(volumeSnapshotResource).Namespace("default")
+	_, err := controller.SnapshotWorkspace(context.Background(), workspace, 3)
+	require.Nil(t, err)
+	notReady, err := snapshots.Get(context.Background(), snapshotName(workspace, 3), metav1.GetOptions{})
+	require.Nil(t, err)
+	unstructured.SetNestedField(notReady.Object, false, "status", "readyToUse")
+	_, err = snapshots.Update(context.Background(), notReady, metav1.UpdateOptions{})
+	require.Nil(t, err)
+
+	_, err = controller.SnapshotWorkspace(context.Background(), workspace, 4)
+	require.Nil(t, err)
+	deleting, err := snapshots.Get(context.Background(), snapshotName(workspace, 4), metav1.GetOptions{})
+	require.Nil(t, err)
+	now := metav1.Now()
+	deleting.SetDeletionTimestamp(&now)
+	_, err = snapshots.Update(context.Background(), deleting, metav1.UpdateOptions{})
+	require.Nil(t, err)
+
+	require.NotNil(t, controller.RestoreWorkspaceSnapshot(context.Background(), workspace, 3))
+	require.NotNil(t, controller.RestoreWorkspaceSnapshot(context.Background(), workspace, 4))
+
+	// The workspace's volume is left as it was
+	pvc, err := clientset.CoreV1().PersistentVolumeClaims("default").Get(context.Background(), workspaceName(workspace), metav1.GetOptions{})
+	require.Nil(t, err)
+	require.Nil(t, pvc.Spec.DataSource)
+}
+
+func TestDeleteWorkspaceSnapshots(t *testing.T) {
+	clientset, dynamic := newSnapshotClients()
+	controller := &KubeController{clientset: clientset, dynamic: dynamic, Namespace: "default", Image: "foo/bar"}
+
+	workspace := spec.Workspace{ID: 1}
+	other := spec.Workspace{ID: 2}
+	createSnapshotVolume(t, clientset, workspace)
+	createSnapshotVolume(t, clientset, other)
+
+	for _, snapshot := range []int32{1, 2} {
+		_, err := controller.SnapshotWorkspace(context.Background(), workspace, snapshot)
+		require.Nil(t, err)
+	}
+	_, err := controller.SnapshotWorkspace(context.Background(), other, 3)
+	require.Nil(t, err)
+
+	require.Nil(t, controller.DeleteWorkspace(context.Background(), workspace))
+
+	snapshots, err := dynamic.Resource(volumeSnapshotResource).Namespace("default").List(context.Background(), metav1.ListOptions{})
+	require.Nil(t, err)
+	require.Len(t, snapshots.Items, 1)
+	require.Equal(t, snapshotName(other, 3), snapshots.Items[0].GetName())
+}
@@ -0,0 +1,49 @@
+package kube
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/controller/spec"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// Path of the metrics API, served by metrics-server, reporting the resources
+// pods use.
+const metricsAPIPath = "/apis/metrics.k8s.io/v1beta1"
+
+// podMetrics is the part of a metrics API PodMetrics read for a pod's usage.
+type podMetrics struct {
+	Containers []struct {
+		Name  string          `json:"name"`
+		Usage v1.ResourceList `json:"usage"`
+	} `json:"containers"`
+}
+
+// podUsage returns the resources a pod's containers use, or nil if the
+// cluster has no metrics API or hasn't measured the pod yet. Usage is
+// informational, so failures aren't errors.
+func (k *KubeController) podUsage(ctx context.Context, namespace string, name string) *spec.Usage {
+	if k.metrics == nil {
+		return nil
+	}
+
+	data, err := k.metrics.Get().AbsPath(metricsAPIPath, "namespaces", namespace, "pods", name).DoRaw(ctx)
+	if err != nil {
+		return nil
+	}
+
+	var metrics podMetrics
+	if err := json.Unmarshal(data, &metrics); err != nil {
+		return nil
+	}
+
+	var cpu, memory resource.Quantity
+	for _, container := range metrics.Containers {
+		cpu.Add(*container.Usage.Cpu())
+		memory.Add(*container.Usage.Memory())
+	}
+
+	return &spec.Usage{CPU: cpu.String(), Memory: memory.String()}
+}
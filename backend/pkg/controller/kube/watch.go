@@ -0,0 +1,92 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/controller/spec"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Interval at which the informer replays every pod it knows.
+const watchResync = 10 * time.Minute
+
+// WatchWorkspaces calls handler whenever the phase of a workspace's pod
+// changes or the pod is removed, until the context is canceled. Pods in
+// every namespace are watched, since workspaces may live in user and team
+// namespaces.
+func (k *KubeController) WatchWorkspaces(ctx context.Context, handler func(spec.WorkspaceStatus)) error {
+	selector := labels.SelectorFromSet(labels.Set{managedByLabel: managedByValue}).String()
+
+	factory := informers.NewSharedInformerFactoryWithOptions(k.clientset, watchResync,
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = selector
+		}),
+	)
+	informer := factory.Core().V1().Pods().Informer()
+
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if status, ok := workspaceStatus(obj, ""); ok {
+				handler(status)
+			}
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			old, _ := oldObj.(*v1.Pod)
+			if old == nil {
+				return
+			}
+			if status, ok := workspaceStatus(newObj, ""); ok && string(old.Status.Phase) != status.Phase {
+				handler(status)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if status, ok := workspaceStatus(obj, spec.PhaseDeleted); ok {
+				handler(status)
+			}
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("unable to watch workspace pods: %v", err)
+	}
+
+	factory.Start(ctx.Done())
+	defer factory.Shutdown()
+
+	if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+		return ctx.Err()
+	}
+
+	<-ctx.Done()
+	return nil
+}
+
+// workspaceStatus returns the status of the workspace a pod belongs to,
+// overriding its phase if phase is set. It returns false if obj isn't a
+// workspace's pod.
+func workspaceStatus(obj interface{}, phase string) (spec.WorkspaceStatus, bool) {
+	pod, ok := obj.(*v1.Pod)
+	if !ok {
+		return spec.WorkspaceStatus{}, false
+	}
+
+	id, err := strconv.ParseInt(pod.Labels[workspaceLabel], 10, 32)
+	if err != nil {
+		return spec.WorkspaceStatus{}, false
+	}
+
+	if phase == "" {
+		phase = string(pod.Status.Phase)
+	}
+
+	return spec.WorkspaceStatus{ID: int32(id), Phase: phase}, true
+}
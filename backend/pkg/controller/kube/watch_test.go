@@ -0,0 +1,60 @@
+package kube
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/controller/spec"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestWatchWorkspaces(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	controller := &KubeController{clientset: clientset, Namespace: "web-client"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	statuses := make(chan spec.WorkspaceStatus, 10)
+	go controller.WatchWorkspaces(ctx, func(status spec.WorkspaceStatus) {
+		statuses <- status
+	})
+
+	next := func() spec.WorkspaceStatus {
+		select {
+		case status := <-statuses:
+			return status
+		case <-time.After(5 * time.Second):
+			t.Fatal("no workspace status received")
+			return spec.WorkspaceStatus{}
+		}
+	}
+
+	pods := clientset.CoreV1().Pods("team-foo")
+
+	// Pods that aren't workspaces are ignored
+	_, err := pods.Create(ctx, &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "other"}}, metav1.CreateOptions{})
+	require.Nil(t, err)
+
+	workspace := spec.Workspace{ID: 5}
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: workspaceName(workspace), Labels: workspaceLabels(workspace)},
+		Status:     v1.PodStatus{Phase: v1.PodPending},
+	}
+	_, err = pods.Create(ctx, pod, metav1.CreateOptions{})
+	require.Nil(t, err)
+	require.Equal(t, spec.WorkspaceStatus{ID: 5, Phase: "Pending"}, next())
+
+	pod.Status.Phase = v1.PodRunning
+	_, err = pods.UpdateStatus(ctx, pod, metav1.UpdateOptions{})
+	require.Nil(t, err)
+	require.Equal(t, spec.WorkspaceStatus{ID: 5, Phase: "Running"}, next())
+
+	require.Nil(t, pods.Delete(ctx, pod.Name, metav1.DeleteOptions{}))
+	require.Equal(t, spec.WorkspaceStatus{ID: 5, Phase: spec.PhaseDeleted}, next())
+	require.Empty(t, statuses)
+}
@@ -2,11 +2,20 @@ package kube
 
 import (
 	"context"
+	"fmt"
+	"time"
 
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/controller/spec"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
 )
 
+// Interval at which a workspace's pod is checked while it stops.
+const podPollInterval = time.Second
+
 func (k *KubeController) ListPods(ctx context.Context) ([]v1.Pod, error) {
 	pods, err := k.clientset.CoreV1().Pods(k.Namespace).List(ctx, metav1.ListOptions{})
 
@@ -16,3 +25,381 @@ func (k *KubeController) ListPods(ctx context.Context) ([]v1.Pod, error) {
 
 	return pods.Items, nil
 }
+
+// namespace returns the namespace holding a workspace's resources: its own
+// namespace if it has one, otherwise its owner's namespace in NamespaceModeUser
+// or the controller's namespace.
+func (k *KubeController) namespace(workspace spec.Workspace) string {
+	if workspace.Namespace != "" {
+		return workspace.Namespace
+	}
+	if k.NamespaceMode == NamespaceModeUser {
+		return k.userNamespace(workspace.Owner)
+	}
+	return k.Namespace
+}
+
+// ensureWorkspaceNamespace creates the namespace of a workspace's owner in
+// NamespaceModeUser if needed. Other namespaces are created along with the
+// team or controller they belong to.
+func (k *KubeController) ensureWorkspaceNamespace(ctx context.Context, workspace spec.Workspace) error {
+	if workspace.Namespace == "" && k.NamespaceMode == NamespaceModeUser {
+		return k.EnsureNamespace(ctx, k.userNamespaceSpec(workspace.Owner))
+	}
+	return nil
+}
+
+// workspaceName returns the name of a workspace's pod and volume.
+func workspaceName(workspace spec.Workspace) string {
+	return fmt.Sprintf("workspace-%d", workspace.ID)
+}
+
+// workspaceLabels returns the labels put on a workspace's resources.
+func workspaceLabels(workspace spec.Workspace) map[string]string {
+	return map[string]string{
+		"app.kubernetes.io/name": "workspace",
+		managedByLabel:           managedByValue,
+		workspaceLabel:           fmt.Sprint(workspace.ID),
+	}
+}
+
+// workspaceSelector returns the labels selecting a workspace's pod.
+func workspaceSelector(workspace spec.Workspace) map[string]string {
+	return map[string]string{
+		managedByLabel: managedByValue,
+		workspaceLabel: fmt.Sprint(workspace.ID),
+	}
+}
+
+// image returns the container image a workspace runs.
+func (k *KubeController) image(workspace spec.Workspace) string {
+	if workspace.Template.Image != "" {
+		return workspace.Template.Image
+	}
+	return k.Image
+}
+
+// podResources returns the CPU and memory a workspace's pod requests and is
+// limited to.
+func podResources(workspace spec.Workspace) (v1.ResourceList, error) {
+	resources := workspace.Resources.WithDefaults()
+
+	cpu, err := resource.ParseQuantity(resources.CPU)
+	if err != nil {
+		return nil, fmt.Errorf("invalid workspace CPU %v: %v", resources.CPU, err)
+	}
+	memory, err := resource.ParseQuantity(resources.Memory)
+	if err != nil {
+		return nil, fmt.Errorf("invalid workspace memory %v: %v", resources.Memory, err)
+	}
+
+	return v1.ResourceList{v1.ResourceCPU: cpu, v1.ResourceMemory: memory}, nil
+}
+
+// volumeSize returns the storage a workspace's volume claim requests.
+func volumeSize(workspace spec.Workspace) (resource.Quantity, error) {
+	storage := workspace.Resources.WithDefaults().Storage
+
+	size, err := resource.ParseQuantity(storage)
+	if err != nil {
+		return resource.Quantity{}, fmt.Errorf("invalid workspace storage %v: %v", storage, err)
+	}
+	return size, nil
+}
+
+// GetWorkspacePodStatus returns the phase of a workspace's pod.
+func (k *KubeController) GetWorkspacePodStatus(ctx context.Context, workspace spec.Workspace) (string, error) {
+	pod, err := k.clientset.CoreV1().Pods(k.namespace(workspace)).Get(ctx, workspaceName(workspace), metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("unable to get workspace pod: %v", err)
+	}
+
+	return string(pod.Status.Phase), nil
+}
+
+// GetWorkspaceVolumeStatus returns the phase of a workspace's volume claim.
+func (k *KubeController) GetWorkspaceVolumeStatus(ctx context.Context, workspace spec.Workspace) (string, error) {
+	pvc, err := k.clientset.CoreV1().PersistentVolumeClaims(k.namespace(workspace)).Get(ctx, workspaceName(workspace), metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("unable to get workspace volume: %v", err)
+	}
+
+	return string(pvc.Status.Phase), nil
+}
+
+// CreateWorkspacePod creates the pod running a workspace, mounting the
+// workspace's volume. The workspace's NetworkPolicy is applied first, so
+// the pod is never reachable without it. It does nothing if the pod already
+// exists. The workspace's files pod is removed first, so that it doesn't
+// hold the volume.
+func (k *KubeController) CreateWorkspacePod(ctx context.Context, workspace spec.Workspace) error {
+	if err := k.EnsureWorkspaceNetworkPolicy(ctx, workspace); err != nil {
+		return err
+	}
+	if err := k.deleteFilesPod(ctx, workspace); err != nil {
+		return err
+	}
+
+	resources, err := podResources(workspace)
+	if err != nil {
+		return err
+	}
+
+	name := workspaceName(workspace)
+
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Labels:      podLabels(workspace),
+			Annotations: podAnnotations(workspace),
+		},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{
+				{
+					Name:  "workspace",
+					Image: k.image(workspace),
+					Resources: v1.ResourceRequirements{
+						Requests: resources,
+						Limits:   resources.DeepCopy(),
+					},
+					VolumeMounts: []v1.VolumeMount{
+						{Name: "workspace", MountPath: "/workspace"},
+					},
+				},
+			},
+			Volumes: []v1.Volume{
+				{
+					Name: "workspace",
+					VolumeSource: v1.VolumeSource{
+						PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: name},
+					},
+				},
+			},
+		},
+	}
+
+	_, err = k.clientset.CoreV1().Pods(k.namespace(workspace)).Create(ctx, pod, metav1.CreateOptions{})
+	if err != nil && !errors.IsAlreadyExists(err) {
+		return fmt.Errorf("unable to create workspace pod: %v", err)
+	}
+
+	return nil
+}
+
+// CreateWorkspaceVolume creates the volume claim holding a workspace's
+// data. It does nothing if the claim already exists. In NamespaceModeUser,
+// the owner's namespace is created first if needed.
+func (k *KubeController) CreateWorkspaceVolume(ctx context.Context, workspace spec.Workspace) error {
+	if err := k.ensureWorkspaceNamespace(ctx, workspace); err != nil {
+		return err
+	}
+
+	size, err := volumeSize(workspace)
+	if err != nil {
+		return err
+	}
+
+	pvc := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        workspaceName(workspace),
+			Labels:      podLabels(workspace),
+			Annotations: podAnnotations(workspace),
+		},
+		Spec: v1.PersistentVolumeClaimSpec{
+			AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+			Resources: v1.VolumeResourceRequirements{
+				Requests: v1.ResourceList{v1.ResourceStorage: size},
+			},
+		},
+	}
+
+	_, err = k.clientset.CoreV1().PersistentVolumeClaims(k.namespace(workspace)).Create(ctx, pvc, metav1.CreateOptions{})
+	if err != nil && !errors.IsAlreadyExists(err) {
+		return fmt.Errorf("unable to create workspace volume: %v", err)
+	}
+
+	return nil
+}
+
+// ResizeWorkspace applies a workspace's resources to its existing pod and
+// volume claim. The claim is expanded, since volumes can't shrink, and the
+// pod is stopped and created again with its new resources. Resources that
+// already match the workspace are left alone, so it can be retried.
+func (k *KubeController) ResizeWorkspace(ctx context.Context, workspace spec.Workspace) error {
+	restart, err := k.expandWorkspaceVolume(ctx, workspace)
+	if err != nil {
+		return err
+	}
+
+	resources, err := podResources(workspace)
+	if err != nil {
+		return err
+	}
+
+	pods := k.clientset.CoreV1().Pods(k.namespace(workspace))
+	name := workspaceName(workspace)
+
+	pod, err := pods.Get(ctx, name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return k.CreateWorkspacePod(ctx, workspace)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to get workspace pod: %v", err)
+	}
+	if !restart && pod.DeletionTimestamp == nil && podHasResources(pod, resources) {
+		return nil
+	}
+
+	if pod.DeletionTimestamp == nil {
+		err = pods.Delete(ctx, name, metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("unable to stop workspace pod: %v", err)
+		}
+	}
+
+	// The new pod can't take the old one's name until it's gone
+	err = wait.PollUntilContextCancel(ctx, podPollInterval, true, func(ctx context.Context) (bool, error) {
+		_, err := pods.Get(ctx, name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	})
+	if err != nil {
+		return fmt.Errorf("unable to stop workspace pod: %v", err)
+	}
+
+	return k.CreateWorkspacePod(ctx, workspace)
+}
+
+// expandWorkspaceVolume grows a workspace's volume claim to the workspace's
+// storage. It returns whether the workspace's pod has to restart for the
+// claim's file system to grow. Claims that don't exist yet are created at
+// the new size, so they're skipped.
+func (k *KubeController) expandWorkspaceVolume(ctx context.Context, workspace spec.Workspace) (bool, error) {
+	size, err := volumeSize(workspace)
+	if err != nil {
+		return false, err
+	}
+
+	claims := k.clientset.CoreV1().PersistentVolumeClaims(k.namespace(workspace))
+
+	pvc, err := claims.Get(ctx, workspaceName(workspace), metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("unable to get workspace volume: %v", err)
+	}
+
+	if current := pvc.Spec.Resources.Requests[v1.ResourceStorage]; size.Cmp(current) <= 0 {
+		// A previous attempt may have expanded the claim without restarting the pod
+		return fileSystemResizePending(pvc), nil
+	}
+
+	if pvc.Spec.Resources.Requests == nil {
+		pvc.Spec.Resources.Requests = v1.ResourceList{}
+	}
+	pvc.Spec.Resources.Requests[v1.ResourceStorage] = size
+
+	if _, err := claims.Update(ctx, pvc, metav1.UpdateOptions{}); err != nil {
+		return false, fmt.Errorf("unable to expand workspace volume: %v", err)
+	}
+	return true, nil
+}
+
+// fileSystemResizePending reports whether a volume claim's file system waits
+// for its pod to restart to grow.
+func fileSystemResizePending(pvc *v1.PersistentVolumeClaim) bool {
+	for _, condition := range pvc.Status.Conditions {
+		if condition.Type == v1.PersistentVolumeClaimFileSystemResizePending && condition.Status == v1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+// podHasResources reports whether a workspace's pod is limited to resources.
+func podHasResources(pod *v1.Pod, resources v1.ResourceList) bool {
+	for _, container := range pod.Spec.Containers {
+		if container.Name != "workspace" {
+			continue
+		}
+		for name, quantity := range resources {
+			have, ok := container.Resources.Limits[name]
+			if !ok || have.Cmp(quantity) != 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// InspectWorkspace returns the observed state of a workspace's pod and
+// volume claim, with the pod's resource usage if the cluster reports it.
+func (k *KubeController) InspectWorkspace(ctx context.Context, workspace spec.Workspace) (spec.WorkspaceState, error) {
+	namespace := k.namespace(workspace)
+	name := workspaceName(workspace)
+
+	var state spec.WorkspaceState
+
+	pod, err := k.clientset.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return spec.WorkspaceState{}, fmt.Errorf("unable to get workspace pod: %v", err)
+	}
+	if err == nil {
+		state.Phase = string(pod.Status.Phase)
+	}
+
+	pvc, err := k.clientset.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return spec.WorkspaceState{}, fmt.Errorf("unable to get workspace volume: %v", err)
+	}
+	if err == nil {
+		state.VolumePhase = string(pvc.Status.Phase)
+		if capacity, ok := pvc.Status.Capacity[v1.ResourceStorage]; ok {
+			state.Capacity = capacity.String()
+		}
+	}
+
+	if state.Phase == string(v1.PodRunning) {
+		state.Usage = k.podUsage(ctx, namespace, name)
+	}
+
+	return state, nil
+}
+
+// DeleteWorkspace removes a workspace's pods, volume claim and NetworkPolicy.
+// Resources that are already gone are skipped, so it can be retried.
+func (k *KubeController) DeleteWorkspace(ctx context.Context, workspace spec.Workspace) error {
+	namespace := k.namespace(workspace)
+	name := workspaceName(workspace)
+
+	err := k.clientset.CoreV1().Pods(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("unable to delete workspace pod: %v", err)
+	}
+	if err := k.deleteFilesPod(ctx, workspace); err != nil {
+		return err
+	}
+
+	err = k.clientset.CoreV1().PersistentVolumeClaims(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("unable to delete workspace volume: %v", err)
+	}
+
+	err = k.clientset.NetworkingV1().NetworkPolicies(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("unable to delete workspace network policy: %v", err)
+	}
+
+	// A clone may be deleted while its volume is populated
+	propagation := metav1.DeletePropagationBackground
+	err = k.clientset.BatchV1().Jobs(namespace).Delete(ctx, cloneName(workspace), metav1.DeleteOptions{PropagationPolicy: &propagation})
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("unable to delete workspace copy job: %v", err)
+	}
+
+	return k.deleteWorkspaceSnapshots(ctx, workspace)
+}
@@ -0,0 +1,196 @@
+package kube
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/controller/spec"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	restfake "k8s.io/client-go/rest/fake"
+)
+
+func TestCreateWorkspace(t *testing.T) {
+	tests := []struct {
+		description   string // Test description
+		workspace     spec.Workspace
+		wantNamespace string
+	}{
+		{"Default namespace", spec.Workspace{ID: 1}, "default"},
+		{"Team namespace", spec.Workspace{ID: 2, Namespace: "team-foo"}, "team-foo"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset()
+			controller := &KubeController{clientset: clientset, Namespace: "default", Image: "foo/bar"}
+
+			require.Nil(t, controller.CreateWorkspaceVolume(context.Background(), test.workspace))
+			require.Nil(t, controller.CreateWorkspacePod(context.Background(), test.workspace))
+
+			// Creating resources again should succeed
+			require.Nil(t, controller.CreateWorkspaceVolume(context.Background(), test.workspace))
+			require.Nil(t, controller.CreateWorkspacePod(context.Background(), test.workspace))
+
+			name := workspaceName(test.workspace)
+
+			pvc, err := clientset.CoreV1().PersistentVolumeClaims(test.wantNamespace).Get(context.Background(), name, metav1.GetOptions{})
+			require.Nil(t, err)
+			require.Equal(t, workspaceLabels(test.workspace), pvc.Labels)
+
+			pod, err := clientset.CoreV1().Pods(test.wantNamespace).Get(context.Background(), name, metav1.GetOptions{})
+			require.Nil(t, err)
+			require.Equal(t, "foo/bar", pod.Spec.Containers[0].Image)
+			require.Equal(t, name, pod.Spec.Volumes[0].PersistentVolumeClaim.ClaimName)
+		})
+	}
+}
+
+func TestCreateWorkspaceInUserNamespace(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	controller := &KubeController{
+		clientset:     clientset,
+		Namespace:     "web-client",
+		Image:         "foo/bar",
+		NamespaceMode: NamespaceModeUser,
+		UserQuota:     spec.Quota{Storage: "50Gi"},
+	}
+
+	workspace := spec.Workspace{ID: 1, Owner: 7}
+	require.Nil(t, controller.CreateWorkspaceVolume(context.Background(), workspace))
+	require.Nil(t, controller.CreateWorkspacePod(context.Background(), workspace))
+
+	// The user's namespace is created with its isolation policies
+	ns, err := clientset.CoreV1().Namespaces().Get(context.Background(), "web-client-user-7", metav1.GetOptions{})
+	require.Nil(t, err)
+	require.Equal(t, "7", ns.Labels[userLabel])
+	require.Equal(t, "web-client", ns.Labels[parentNamespaceLabel])
+
+	_, err = clientset.NetworkingV1().NetworkPolicies("web-client-user-7").Get(context.Background(), defaultDenyPolicy, metav1.GetOptions{})
+	require.Nil(t, err)
+	_, err = clientset.CoreV1().LimitRanges("web-client-user-7").Get(context.Background(), limitRangeName, metav1.GetOptions{})
+	require.Nil(t, err)
+	_, err = clientset.CoreV1().ResourceQuotas("web-client-user-7").Get(context.Background(), quotaName, metav1.GetOptions{})
+	require.Nil(t, err)
+
+	_, err = clientset.CoreV1().Pods("web-client-user-7").Get(context.Background(), workspaceName(workspace), metav1.GetOptions{})
+	require.Nil(t, err)
+
+	// Team workspaces stay in the team's namespace
+	teamWorkspace := spec.Workspace{ID: 2, Owner: 7, Namespace: "team-foo"}
+	require.Nil(t, controller.CreateWorkspaceVolume(context.Background(), teamWorkspace))
+	_, err = clientset.CoreV1().PersistentVolumeClaims("team-foo").Get(context.Background(), workspaceName(teamWorkspace), metav1.GetOptions{})
+	require.Nil(t, err)
+}
+
+func TestDeleteWorkspace(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	controller := &KubeController{clientset: clientset, Namespace: "default", Image: "foo/bar"}
+
+	workspace := spec.Workspace{ID: 1, Namespace: "team-foo"}
+	require.Nil(t, controller.CreateWorkspaceVolume(context.Background(), workspace))
+	require.Nil(t, controller.CreateWorkspacePod(context.Background(), workspace))
+
+	require.Nil(t, controller.DeleteWorkspace(context.Background(), workspace))
+
+	// Deleting resources again should succeed
+	require.Nil(t, controller.DeleteWorkspace(context.Background(), workspace))
+
+	pods, err := clientset.CoreV1().Pods("team-foo").List(context.Background(), metav1.ListOptions{})
+	require.Nil(t, err)
+	require.Empty(t, pods.Items)
+
+	pvcs, err := clientset.CoreV1().PersistentVolumeClaims("team-foo").List(context.Background(), metav1.ListOptions{})
+	require.Nil(t, err)
+	require.Empty(t, pvcs.Items)
+
+	policies, err := clientset.NetworkingV1().NetworkPolicies("team-foo").List(context.Background(), metav1.ListOptions{})
+	require.Nil(t, err)
+	require.Empty(t, policies.Items)
+}
+
+func TestResizeWorkspace(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	controller := &KubeController{clientset: clientset, Namespace: "default", Image: "foo/bar"}
+
+	workspace := spec.Workspace{ID: 1}
+	require.Nil(t, controller.CreateWorkspaceVolume(context.Background(), workspace))
+	require.Nil(t, controller.CreateWorkspacePod(context.Background(), workspace))
+
+	pod, err := clientset.CoreV1().Pods("default").Get(context.Background(), workspaceName(workspace), metav1.GetOptions{})
+	require.Nil(t, err)
+	require.Equal(t, "2Gi", pod.Spec.Containers[0].Resources.Limits.Memory().String())
+
+	workspace.Resources = spec.Resources{CPU: "2", Memory: "4Gi", Storage: "20Gi"}
+	require.Nil(t, controller.ResizeWorkspace(context.Background(), workspace))
+
+	// Resizing again should succeed
+	require.Nil(t, controller.ResizeWorkspace(context.Background(), workspace))
+
+	pvc, err := clientset.CoreV1().PersistentVolumeClaims("default").Get(context.Background(), workspaceName(workspace), metav1.GetOptions{})
+	require.Nil(t, err)
+	require.Equal(t, "20Gi", pvc.Spec.Resources.Requests.Storage().String())
+
+	pod, err = clientset.CoreV1().Pods("default").Get(context.Background(), workspaceName(workspace), metav1.GetOptions{})
+	require.Nil(t, err)
+	require.Equal(t, "2", pod.Spec.Containers[0].Resources.Limits.Cpu().String())
+	require.Equal(t, "4Gi", pod.Spec.Containers[0].Resources.Requests.Memory().String())
+
+	// Volumes can't shrink
+	workspace.Resources.Storage = "5Gi"
+	require.Nil(t, controller.ResizeWorkspace(context.Background(), workspace))
+
+	pvc, err = clientset.CoreV1().PersistentVolumeClaims("default").Get(context.Background(), workspaceName(workspace), metav1.GetOptions{})
+	require.Nil(t, err)
+	require.Equal(t, "20Gi", pvc.Spec.Resources.Requests.Storage().String())
+}
+
+func TestInspectWorkspace(t *testing.T) {
+	workspace := spec.Workspace{ID: 1}
+
+	clientset := fake.NewSimpleClientset(
+		&v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: workspaceName(workspace), Namespace: "default"},
+			Status:     v1.PodStatus{Phase: v1.PodRunning},
+		},
+		&v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: workspaceName(workspace), Namespace: "default"},
+			Status: v1.PersistentVolumeClaimStatus{
+				Phase:    v1.ClaimBound,
+				Capacity: v1.ResourceList{v1.ResourceStorage: resource.MustParse("10Gi")},
+			},
+		},
+	)
+	metrics := &restfake.RESTClient{
+		NegotiatedSerializer: scheme.Codecs.WithoutConversion(),
+		Resp: &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{"application/json"}},
+			Body:       io.NopCloser(strings.NewReader(`{"containers":[{"name":"workspace","usage":{"cpu":"250m","memory":"512Mi"}}]}`)),
+		},
+	}
+	controller := &KubeController{clientset: clientset, Namespace: "default", metrics: metrics}
+
+	state, err := controller.InspectWorkspace(context.Background(), workspace)
+	require.Nil(t, err)
+	require.Equal(t, spec.WorkspaceState{
+		Phase:       "Running",
+		VolumePhase: "Bound",
+		Capacity:    "10Gi",
+		Usage:       &spec.Usage{CPU: "250m", Memory: "512Mi"},
+	}, state)
+	require.Equal(t, "/apis/metrics.k8s.io/v1beta1/namespaces/default/pods/workspace-1", metrics.Req.URL.Path)
+
+	// Missing resources and metrics aren't errors
+	controller = &KubeController{clientset: fake.NewSimpleClientset(), Namespace: "default"}
+	state, err = controller.InspectWorkspace(context.Background(), workspace)
+	require.Nil(t, err)
+	require.Equal(t, spec.WorkspaceState{}, state)
+}
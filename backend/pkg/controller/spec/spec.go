@@ -0,0 +1,152 @@
+package spec
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ErrUnsupported is returned for operations the cluster can't perform on a
+// workspace, which retrying won't fix.
+var ErrUnsupported = errors.New("unsupported by the cluster")
+
+// ExitError is returned when a command run in a workspace exits with a
+// non-zero status.
+type ExitError struct {
+	Code   int    // Exit status of the command
+	Stderr string // What the command wrote to its standard error, truncated
+}
+
+func (e *ExitError) Error() string {
+	if e.Stderr != "" {
+		return fmt.Sprintf("command exited with status %d: %v", e.Code, e.Stderr)
+	}
+	return fmt.Sprintf("command exited with status %d", e.Code)
+}
+
+// NotReadyError is returned for commands on a workspace whose pod can't run
+// them yet, such as while it's starting. Retrying once the pod is running
+// fixes it.
+type NotReadyError struct {
+	State string // What the workspace's pod is doing, such as starting
+}
+
+func (e *NotReadyError) Error() string {
+	return fmt.Sprintf("workspace is %v", e.State)
+}
+
+// Workspace describes the cluster resources of a workspace.
+type Workspace struct {
+	ID        int32             `json:"id"`        // Workspace ID, used to name the workspace's resources
+	Owner     int32             `json:"owner"`     // ID of the user owning the workspace
+	Namespace string            `json:"namespace"` // Namespace holding the resources, or "" for the controller's default namespace
+	Template  Template          `json:"template"`
+	Resources Resources         `json:"resources"`
+	Labels    map[string]string `json:"labels"` // Labels of the workspace, put on its pod and volume claim
+}
+
+// reservedKeys are the unprefixed label keys a workspace can't be labeled
+// with: the controller's workspace label, the keys the app's own pods are
+// selected with in deploy/, and the keys controllers select their pods with.
+// Workspace labels are put on workspace pods, so these would let a pod pose
+// as one of the app's pods to Services and NetworkPolicies.
+var reservedKeys = []string{"workspace", "k8s-app", "app", "pod-template-hash", "controller-revision-hash"}
+
+// ReservedLabel reports whether a label key is reserved for the labels the
+// controller puts on a workspace's resources itself, by the app's
+// deployment or by Kubernetes, so that a workspace can't be labeled with it.
+func ReservedLabel(key string) bool {
+	prefix, _, found := strings.Cut(key, "/")
+	if !found {
+		return slices.Contains(reservedKeys, key)
+	}
+	return prefix == "kubernetes-web-client" ||
+		prefix == "kubernetes.io" || strings.HasSuffix(prefix, ".kubernetes.io") ||
+		prefix == "k8s.io" || strings.HasSuffix(prefix, ".k8s.io")
+}
+
+// Resources a workspace runs with. Values are Kubernetes resource
+// quantities; empty values take DefaultResources.
+type Resources struct {
+	CPU     string `json:"cpu"`
+	Memory  string `json:"memory"`
+	Storage string `json:"storage"` // Size of the workspace's volume
+}
+
+// DefaultResources are the resources of workspaces that don't set their own.
+var DefaultResources = Resources{CPU: "1", Memory: "2Gi", Storage: "10Gi"}
+
+// WithDefaults returns the resources with empty values replaced by
+// DefaultResources.
+func (r Resources) WithDefaults() Resources {
+	if r.CPU == "" {
+		r.CPU = DefaultResources.CPU
+	}
+	if r.Memory == "" {
+		r.Memory = DefaultResources.Memory
+	}
+	if r.Storage == "" {
+		r.Storage = DefaultResources.Storage
+	}
+	return r
+}
+
+// WorkspaceStatus is an observed change to a workspace's pod.
+type WorkspaceStatus struct {
+	ID    int32  // Workspace ID
+	Phase string // Phase of the pod, or PhaseDeleted if the pod was removed
+}
+
+// PhaseDeleted is the phase reported once a workspace's pod is removed.
+const PhaseDeleted = "Deleted"
+
+// WorkspaceState is the observed state of a workspace's resources.
+type WorkspaceState struct {
+	Phase       string // Phase of the pod, or "" if it doesn't exist
+	VolumePhase string // Phase of the volume claim, or "" if it doesn't exist
+	Capacity    string // Storage provisioned for the volume, or "" until it's bound
+	Usage       *Usage // Resources the pod is using, or nil if they aren't reported
+}
+
+// Snapshot is a snapshot of a workspace's volume that is ready to use.
+type Snapshot struct {
+	Size string // Size of a volume restored from the snapshot, or "" if the cluster doesn't report it
+}
+
+// Usage is the resources a workspace's pod is using, as Kubernetes
+// resource quantities.
+type Usage struct {
+	CPU    string
+	Memory string
+}
+
+// Template holds the settings a workspace was created from.
+type Template struct {
+	Image  string `json:"image"`  // Container image, or "" for the controller's default image
+	Egress string `json:"egress"` // Outbound traffic allowed from the workspace: EgressInternet, EgressInternal or EgressNone
+}
+
+// Egress rules
+const (
+	EgressInternet = "internet" // Anywhere outside the cluster's private networks
+	EgressInternal = "internal" // Only the cluster's private networks
+	EgressNone     = "none"     // Nowhere but DNS
+)
+
+// Namespace describes a namespace managed by the controller, such
+// as the namespace of a team.
+type Namespace struct {
+	Name        string
+	Labels      map[string]string
+	Annotations map[string]string
+	Quota       Quota
+}
+
+// Quota limits the total resources used in a namespace. Values are
+// Kubernetes resource quantities; empty values are left unlimited.
+type Quota struct {
+	CPU     string
+	Memory  string
+	Storage string
+}
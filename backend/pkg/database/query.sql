@@ -1,20 +1,310 @@
+-- name: FindSchemaVersion :one
+SELECT max(version)::int FROM schema_version;
+
 -- name: ListUsers :many
 SELECT * FROM users;
 
 -- name: FindUserWithId :one
 SELECT * FROM users WHERE id = $1;
 
--- name: FindUserWithEmail :one
-SELECT * FROM users WHERE email = $1; 
+-- name: ListUsersWithEmail :many
+SELECT * FROM users WHERE email = $1 ORDER BY id;
+
+-- name: DeleteUserWithId :one
+DELETE FROM users WHERE id = $1 RETURNING *;
 
--- name: CreateUser :exec
-INSERT INTO users (email) VALUES ($1);
+-- name: UpsertUser :one
+INSERT INTO users (issuer, subject, email, name, picture) VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (issuer, subject) DO UPDATE SET email = EXCLUDED.email, name = EXCLUDED.name, picture = EXCLUDED.picture
+RETURNING *;
 
 -- name: CreateWorkspace :one
-INSERT INTO workspaces (name, owner) VALUES ($1, $2) RETURNING *;
+INSERT INTO workspaces (name, owner, team, template, labels) VALUES ($1, $2, $3, $4, $5) RETURNING *;
+
+-- name: CloneWorkspace :one
+INSERT INTO workspaces (name, owner, team, template, description, labels, cpu, memory, storage, state, source)
+SELECT sqlc.arg(name)::text, sqlc.arg(owner)::int, team, template, description, labels, cpu, memory, storage, 'cloning', id
+FROM workspaces WHERE id = sqlc.arg(source)::int
+RETURNING *;
+
+-- name: ImportWorkspace :one
+INSERT INTO workspaces (name, owner, team, template, description, labels, cpu, memory, storage, state)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, 'importing')
+RETURNING *;
 
 -- name: DeleteWorkspaceWithId :one
-DELETE FROM workspaces WHERE owner = $1 AND id = $2 RETURNING *;
+DELETE FROM workspaces WHERE id = $1 RETURNING *;
 
 -- name: ListUserWorkspaces :many
-SELECT * FROM workspaces WHERE owner = $1;
\ No newline at end of file
+SELECT w.id, w.name, w.owner, w.team, w.template, w.description, w.labels, w.cpu, w.memory, w.storage, w.state, w.state_message, w.source, w.created_at, w.last_active_at,
+    r.role, w.owner <> sqlc.arg(member)::int AS shared
+FROM workspaces w
+JOIN (
+    SELECT DISTINCT ON (workspace) workspace, role FROM workspace_roles
+    WHERE member = sqlc.arg(member)::int
+    ORDER BY workspace, CASE role WHEN 'owner' THEN 3 WHEN 'editor' THEN 2 ELSE 1 END DESC
+) r ON r.workspace = w.id
+WHERE (sqlc.narg(state)::text IS NULL OR w.state = sqlc.narg(state))
+    AND (sqlc.narg(template)::int IS NULL OR w.template = sqlc.narg(template))
+    AND (sqlc.narg(shared)::bool IS NULL OR (w.owner <> sqlc.arg(member)::int) = sqlc.narg(shared))
+    AND (sqlc.narg(search)::text IS NULL OR w.name ILIKE '%' || sqlc.narg(search) || '%')
+    -- Equality requirements of the label selector, which can use the labels index
+    AND w.labels @> sqlc.arg(labels)::jsonb
+    -- Every requirement of the label selector
+    AND NOT EXISTS (
+        SELECT 1 FROM jsonb_to_recordset(sqlc.arg(requirements)::jsonb) AS q(key text, operator text, allowed text[])
+        WHERE NOT CASE q.operator
+            WHEN 'in' THEN coalesce(w.labels ->> q.key = ANY (q.allowed), false)
+            WHEN 'notin' THEN coalesce(NOT w.labels ->> q.key = ANY (q.allowed), true)
+            WHEN 'exists' THEN w.labels ? q.key
+            ELSE NOT w.labels ? q.key
+        END
+    )
+    -- Workspaces after the cursor, in the order of the sort
+    AND (sqlc.narg(after_id)::int IS NULL OR CASE sqlc.arg(sort)::text
+        WHEN 'name' THEN (w.name, w.id) > (sqlc.narg(after_name)::text, sqlc.narg(after_id)::int)
+        WHEN '-name' THEN (w.name, w.id) < (sqlc.narg(after_name)::text, sqlc.narg(after_id)::int)
+        WHEN 'created_at' THEN (w.created_at, w.id) > (sqlc.narg(after_time)::timestamptz, sqlc.narg(after_id)::int)
+        WHEN '-created_at' THEN (w.created_at, w.id) < (sqlc.narg(after_time)::timestamptz, sqlc.narg(after_id)::int)
+        WHEN 'last_active' THEN (w.last_active_at, w.id) > (sqlc.narg(after_time)::timestamptz, sqlc.narg(after_id)::int)
+        ELSE (w.last_active_at, w.id) < (sqlc.narg(after_time)::timestamptz, sqlc.narg(after_id)::int)
+    END)
+ORDER BY
+    CASE WHEN sqlc.arg(sort)::text = 'name' THEN w.name END,
+    CASE WHEN sqlc.arg(sort)::text = '-name' THEN w.name END DESC,
+    CASE WHEN sqlc.arg(sort)::text = 'created_at' THEN w.created_at END,
+    CASE WHEN sqlc.arg(sort)::text = '-created_at' THEN w.created_at END DESC,
+    CASE WHEN sqlc.arg(sort)::text = 'last_active' THEN w.last_active_at END,
+    CASE WHEN sqlc.arg(sort)::text = '-last_active' THEN w.last_active_at END DESC,
+    CASE WHEN sqlc.arg(sort)::text LIKE '-%' THEN w.id END DESC,
+    w.id
+LIMIT sqlc.arg(max_workspaces);
+
+-- name: FindWorkspaceRole :one
+SELECT role FROM workspace_roles WHERE workspace = $1 AND member = $2
+ORDER BY CASE role WHEN 'owner' THEN 3 WHEN 'editor' THEN 2 ELSE 1 END DESC
+LIMIT 1;
+
+-- name: ListWorkspaceMembers :many
+SELECT u.id, u.email, u.name, u.picture, 'owner'::text AS role
+FROM workspaces w JOIN users u ON u.id = w.owner
+WHERE w.id = $1
+UNION ALL
+SELECT u.id, u.email, u.name, u.picture, m.role
+FROM workspace_members m JOIN users u ON u.id = m.member
+WHERE m.workspace = $1;
+
+-- name: ListWorkspaceUsers :many
+SELECT DISTINCT member FROM workspace_roles
+WHERE workspace = $1
+ORDER BY member;
+
+-- name: UpsertWorkspaceMember :one
+INSERT INTO workspace_members (workspace, member, role) VALUES ($1, $2, $3)
+ON CONFLICT (workspace, member) DO UPDATE SET role = EXCLUDED.role
+RETURNING *;
+
+-- name: DeleteWorkspaceMember :one
+DELETE FROM workspace_members WHERE workspace = $1 AND member = $2 RETURNING *;
+
+-- name: FindWorkspaceWithId :one
+SELECT * FROM workspaces WHERE id = $1;
+
+-- name: UpdateWorkspace :one
+UPDATE workspaces SET name = $2, description = $3, labels = $4, cpu = $5, memory = $6, storage = $7, last_active_at = now()
+WHERE id = $1 AND state IN ('ready', 'failed')
+RETURNING *;
+
+-- name: SetWorkspaceState :exec
+UPDATE workspaces SET state = $2, state_message = $3 WHERE id = $1;
+
+-- name: StartWorkspaceRestore :one
+UPDATE workspaces SET state = 'restoring', state_message = '' WHERE id = $1 AND state IN ('ready', 'failed') RETURNING id;
+
+-- name: TouchWorkspace :exec
+UPDATE workspaces SET last_active_at = now() WHERE id = $1;
+
+-- name: FailStaleImports :many
+UPDATE workspaces SET state = 'failed', state_message = $1
+WHERE state = 'importing' AND last_active_at < $2
+RETURNING id;
+
+-- name: CreateSnapshot :one
+INSERT INTO snapshots (workspace, name, scheduled) VALUES ($1, $2, $3) RETURNING *;
+
+-- name: FindSnapshotWithId :one
+SELECT * FROM snapshots WHERE id = $1;
+
+-- name: FindWorkspaceSnapshot :one
+SELECT * FROM snapshots WHERE id = $1 AND workspace = $2;
+
+-- name: ListWorkspaceSnapshots :many
+SELECT * FROM snapshots WHERE workspace = $1 ORDER BY created_at DESC, id DESC;
+
+-- name: SetSnapshotState :exec
+UPDATE snapshots SET state = $2, state_message = $3, size = $4 WHERE id = $1;
+
+-- name: StartSnapshotRestore :one
+UPDATE snapshots SET state = 'restoring' WHERE id = $1 AND workspace = $2 AND state = 'ready' RETURNING *;
+
+-- name: FinishSnapshotRestore :exec
+UPDATE snapshots SET state = 'ready' WHERE id = $1 AND state = 'restoring';
+
+-- name: DeleteWorkspaceSnapshot :one
+DELETE FROM snapshots WHERE id = $1 AND workspace = $2 AND state <> 'restoring' RETURNING *;
+
+-- name: DeleteExpiredSnapshots :many
+DELETE FROM snapshots WHERE id IN (
+    SELECT id FROM snapshots
+    WHERE workspace = sqlc.arg(workspace)::int AND scheduled AND state IN ('ready', 'restoring')
+    ORDER BY created_at DESC, id DESC
+    OFFSET sqlc.arg(retention)::int
+) AND state = 'ready'
+RETURNING *;
+
+-- name: DeleteFailedSnapshots :many
+DELETE FROM snapshots WHERE workspace = $1 AND scheduled AND state = 'failed' AND created_at < $2 RETURNING *;
+
+-- name: ListDueSnapshotWorkspaces :many
+SELECT w.id, w.owner FROM workspaces w JOIN templates t ON t.id = w.template
+WHERE t.snapshot_interval > 0 AND w.state = 'ready'
+    AND NOT EXISTS (
+        SELECT 1 FROM snapshots s
+        WHERE s.workspace = w.id AND s.scheduled
+            AND s.created_at > now() - make_interval(mins => t.snapshot_interval)
+    )
+ORDER BY w.id;
+
+-- name: CountTeamWorkspaces :one
+SELECT count(*) FROM workspaces WHERE team = $1;
+
+-- name: CreateTeam :one
+WITH t AS (
+    INSERT INTO teams (name, namespace, max_workspaces, cpu_quota, memory_quota, storage_quota)
+    VALUES ($1, $2, $3, $4, $5, $6)
+    RETURNING *
+), m AS (
+    INSERT INTO team_members (team, member, role) SELECT id, sqlc.arg(owner)::int, 'owner' FROM t
+)
+SELECT * FROM t;
+
+-- name: FindTeamWithId :one
+SELECT * FROM teams WHERE id = $1;
+
+-- name: LockTeam :one
+SELECT * FROM teams WHERE id = $1 FOR UPDATE;
+
+-- name: ListUserTeams :many
+SELECT t.id, t.name, t.namespace, t.max_workspaces, t.cpu_quota, t.memory_quota, t.storage_quota, m.role
+FROM teams t JOIN team_members m ON m.team = t.id
+WHERE m.member = $1
+ORDER BY t.id;
+
+-- name: UpdateTeam :one
+UPDATE teams SET name = $2, max_workspaces = $3, cpu_quota = $4, memory_quota = $5, storage_quota = $6
+WHERE id = $1
+RETURNING *;
+
+-- name: DeleteTeamWithId :one
+DELETE FROM teams WHERE id = $1 RETURNING *;
+
+-- name: FindTeamRole :one
+SELECT role FROM team_members WHERE team = $1 AND member = $2;
+
+-- name: ListTeamMembers :many
+SELECT u.id, u.email, u.name, u.picture, m.role
+FROM team_members m JOIN users u ON u.id = m.member
+WHERE m.team = $1
+ORDER BY u.id;
+
+-- name: UpsertTeamMember :one
+INSERT INTO team_members (team, member, role) VALUES ($1, $2, $3)
+ON CONFLICT (team, member) DO UPDATE SET role = EXCLUDED.role
+RETURNING *;
+
+-- name: DeleteTeamMember :one
+DELETE FROM team_members WHERE team = $1 AND member = $2 RETURNING *;
+
+-- name: ListTemplates :many
+SELECT * FROM templates ORDER BY id;
+
+-- name: FindTemplateWithId :one
+SELECT * FROM templates WHERE id = $1;
+
+-- name: FindTemplateWithName :one
+SELECT * FROM templates WHERE name = $1;
+
+-- name: Notify :exec
+SELECT pg_notify(sqlc.arg(channel)::text, sqlc.arg(payload)::text);
+
+-- name: CreatePubsubPayload :one
+INSERT INTO pubsub_payloads (channel, payload) VALUES ($1, $2) RETURNING id;
+
+-- name: FindPubsubPayload :one
+SELECT payload FROM pubsub_payloads WHERE id = $1;
+
+-- name: DeleteExpiredPubsubPayloads :exec
+DELETE FROM pubsub_payloads WHERE created_at < $1;
+
+-- name: TryAdvisoryLock :one
+SELECT pg_try_advisory_lock($1) AS acquired;
+
+-- name: CreateJob :one
+INSERT INTO jobs (kind, payload, owner, max_attempts, traceparent, target) VALUES ($1, $2, $3, $4, $5, $6) RETURNING *;
+
+-- name: ClaimJob :one
+UPDATE jobs SET status = 'running', attempts = attempts + 1, run_at = sqlc.arg(lock_until), updated_at = now()
+WHERE id = (
+    SELECT j.id FROM jobs j
+    WHERE j.status IN ('pending', 'running') AND j.run_at <= now()
+        AND NOT EXISTS (
+            SELECT 1 FROM jobs earlier
+            WHERE j.target <> '' AND earlier.target = j.target AND earlier.id < j.id
+                AND earlier.status IN ('pending', 'running')
+        )
+    ORDER BY j.run_at, j.id
+    LIMIT 1
+    FOR UPDATE OF j SKIP LOCKED
+)
+RETURNING *;
+
+-- name: CompleteJob :one
+UPDATE jobs SET status = 'succeeded', last_error = '', updated_at = now()
+WHERE id = $1 AND status = 'running' AND attempts = $2
+RETURNING id;
+
+-- name: RetryJob :one
+UPDATE jobs SET status = 'pending', run_at = $2, last_error = $3, updated_at = now()
+WHERE id = $1 AND status = 'running' AND attempts = $4
+RETURNING id;
+
+-- name: KillJob :one
+UPDATE jobs SET status = 'dead', last_error = $2, updated_at = now()
+WHERE id = $1 AND status = 'running' AND attempts = $3
+RETURNING id;
+
+-- name: FindUserJob :one
+SELECT id, kind, status, attempts, max_attempts, last_error, created_at, updated_at FROM jobs
+WHERE id = $1 AND owner = $2;
+
+-- name: DeleteFinishedJobs :exec
+DELETE FROM jobs WHERE status IN ('succeeded', 'dead') AND updated_at < $1;
+
+-- name: CountSessions :one
+SELECT count(*) FROM sessions WHERE expiry > now();
+
+-- name: CreateAuditEvent :exec
+INSERT INTO audit_events (actor, action, target, ip, user_agent, request_id, outcome, details)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8);
+
+-- name: ListAuditEvents :many
+SELECT * FROM audit_events
+WHERE (sqlc.narg(actor)::int IS NULL OR actor = sqlc.narg(actor))
+    AND (sqlc.narg(action)::text IS NULL OR action = sqlc.narg(action))
+    AND (sqlc.narg(target)::text IS NULL OR target = sqlc.narg(target))
+    AND (sqlc.narg(outcome)::text IS NULL OR outcome = sqlc.narg(outcome))
+    AND (sqlc.narg(since)::timestamptz IS NULL OR created_at >= sqlc.narg(since))
+    AND (sqlc.narg(until)::timestamptz IS NULL OR created_at < sqlc.narg(until))
+    AND (sqlc.narg(cursor)::bigint IS NULL OR id < sqlc.narg(cursor))
+ORDER BY id DESC
+LIMIT sqlc.arg(max_events);
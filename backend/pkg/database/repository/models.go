@@ -8,19 +8,124 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+type AuditEvent struct {
+	ID        int64              `json:"id"`
+	CreatedAt pgtype.Timestamptz `json:"created_at"`
+	Actor     pgtype.Int4        `json:"actor"`
+	Action    string             `json:"action"`
+	Target    string             `json:"target"`
+	Ip        string             `json:"ip"`
+	UserAgent string             `json:"user_agent"`
+	RequestID string             `json:"request_id"`
+	Outcome   string             `json:"outcome"`
+	Details   []byte             `json:"details"`
+}
+
+type Job struct {
+	ID          int64              `json:"id"`
+	Kind        string             `json:"kind"`
+	Payload     []byte             `json:"payload"`
+	Owner       pgtype.Int4        `json:"owner"`
+	Status      string             `json:"status"`
+	Attempts    int32              `json:"attempts"`
+	MaxAttempts int32              `json:"max_attempts"`
+	LastError   string             `json:"last_error"`
+	RunAt       pgtype.Timestamptz `json:"run_at"`
+	CreatedAt   pgtype.Timestamptz `json:"created_at"`
+	UpdatedAt   pgtype.Timestamptz `json:"updated_at"`
+	Traceparent string             `json:"traceparent"`
+	Target      string             `json:"target"`
+}
+
+type PubsubPayload struct {
+	ID        int64              `json:"id"`
+	Channel   string             `json:"channel"`
+	Payload   string             `json:"payload"`
+	CreatedAt pgtype.Timestamptz `json:"created_at"`
+}
+
+type SchemaVersion struct {
+	Version int32 `json:"version"`
+}
+
 type Session struct {
 	Token  string             `json:"token"`
 	Data   []byte             `json:"data"`
 	Expiry pgtype.Timestamptz `json:"expiry"`
 }
 
+type Snapshot struct {
+	ID           int32              `json:"id"`
+	Workspace    int32              `json:"workspace"`
+	Name         string             `json:"name"`
+	Scheduled    bool               `json:"scheduled"`
+	State        string             `json:"state"`
+	StateMessage string             `json:"state_message"`
+	Size         string             `json:"size"`
+	CreatedAt    pgtype.Timestamptz `json:"created_at"`
+}
+
+type Team struct {
+	ID            int32  `json:"id"`
+	Name          string `json:"name"`
+	Namespace     string `json:"namespace"`
+	MaxWorkspaces int32  `json:"max_workspaces"`
+	CpuQuota      string `json:"cpu_quota"`
+	MemoryQuota   string `json:"memory_quota"`
+	StorageQuota  string `json:"storage_quota"`
+}
+
+type TeamMember struct {
+	Team   int32  `json:"team"`
+	Member int32  `json:"member"`
+	Role   string `json:"role"`
+}
+
+type Template struct {
+	ID                int32  `json:"id"`
+	Name              string `json:"name"`
+	Image             string `json:"image"`
+	Egress            string `json:"egress"`
+	SnapshotInterval  int32  `json:"snapshot_interval"`
+	SnapshotRetention int32  `json:"snapshot_retention"`
+}
+
 type User struct {
-	ID    int32  `json:"id"`
-	Email string `json:"email"`
+	ID      int32  `json:"id"`
+	Issuer  string `json:"issuer"`
+	Subject string `json:"subject"`
+	Email   string `json:"email"`
+	Name    string `json:"name"`
+	Picture string `json:"picture"`
+	Admin   bool   `json:"admin"`
 }
 
 type Workspace struct {
-	ID    int32  `json:"id"`
-	Name  string `json:"name"`
-	Owner int32  `json:"owner"`
+	ID           int32              `json:"id"`
+	Name         string             `json:"name"`
+	Owner        int32              `json:"owner"`
+	Team         pgtype.Int4        `json:"team"`
+	Template     int32              `json:"template"`
+	Description  string             `json:"description"`
+	Labels       []byte             `json:"labels"`
+	Cpu          string             `json:"cpu"`
+	Memory       string             `json:"memory"`
+	Storage      string             `json:"storage"`
+	State        string             `json:"state"`
+	StateMessage string             `json:"state_message"`
+	Source       pgtype.Int4        `json:"source"`
+	CreatedAt    pgtype.Timestamptz `json:"created_at"`
+	LastActiveAt pgtype.Timestamptz `json:"last_active_at"`
+}
+
+type WorkspaceMember struct {
+	Workspace int32  `json:"workspace"`
+	Member    int32  `json:"member"`
+	Role      string `json:"role"`
+}
+
+type WorkspaceRole struct {
+	Workspace int32  `json:"workspace"`
+	Member    int32  `json:"member"`
+	Role      string `json:"role"`
 }
@@ -7,85 +7,1234 @@ package repository
 
 import (
 	"context"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const claimJob = `-- name: ClaimJob :one
+UPDATE jobs SET status = 'running', attempts = attempts + 1, run_at = $1, updated_at = now()
+WHERE id = (
+    SELECT j.id FROM jobs j
+    WHERE j.status IN ('pending', 'running') AND j.run_at <= now()
+        AND NOT EXISTS (
+            SELECT 1 FROM jobs earlier
+            WHERE j.target <> '' AND earlier.target = j.target AND earlier.id < j.id
+                AND earlier.status IN ('pending', 'running')
+        )
+    ORDER BY j.run_at, j.id
+    LIMIT 1
+    FOR UPDATE OF j SKIP LOCKED
 )
+RETURNING id, kind, payload, owner, status, attempts, max_attempts, last_error, run_at, created_at, updated_at, traceparent, target
+`
+
+func (q *Queries) ClaimJob(ctx context.Context, lockUntil pgtype.Timestamptz) (Job, error) {
+	row := q.db.QueryRow(ctx, claimJob, lockUntil)
+	var i Job
+	err := row.Scan(
+		&i.ID,
+		&i.Kind,
+		&i.Payload,
+		&i.Owner,
+		&i.Status,
+		&i.Attempts,
+		&i.MaxAttempts,
+		&i.LastError,
+		&i.RunAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Traceparent,
+		&i.Target,
+	)
+	return i, err
+}
+
+const cloneWorkspace = `-- name: CloneWorkspace :one
+INSERT INTO workspaces (name, owner, team, template, description, labels, cpu, memory, storage, state, source)
+SELECT $1::text, $2::int, team, template, description, labels, cpu, memory, storage, 'cloning', id
+FROM workspaces WHERE id = $3::int
+RETURNING id, name, owner, team, template, description, labels, cpu, memory, storage, state, state_message, source, created_at, last_active_at
+`
+
+type CloneWorkspaceParams struct {
+	Name   string `json:"name"`
+	Owner  int32  `json:"owner"`
+	Source int32  `json:"source"`
+}
+
+func (q *Queries) CloneWorkspace(ctx context.Context, arg CloneWorkspaceParams) (Workspace, error) {
+	row := q.db.QueryRow(ctx, cloneWorkspace, arg.Name, arg.Owner, arg.Source)
+	var i Workspace
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Owner,
+		&i.Team,
+		&i.Template,
+		&i.Description,
+		&i.Labels,
+		&i.Cpu,
+		&i.Memory,
+		&i.Storage,
+		&i.State,
+		&i.StateMessage,
+		&i.Source,
+		&i.CreatedAt,
+		&i.LastActiveAt,
+	)
+	return i, err
+}
 
-const createUser = `-- name: CreateUser :exec
-INSERT INTO users (email) VALUES ($1)
+const completeJob = `-- name: CompleteJob :one
+UPDATE jobs SET status = 'succeeded', last_error = '', updated_at = now()
+WHERE id = $1 AND status = 'running' AND attempts = $2
+RETURNING id
 `
 
-func (q *Queries) CreateUser(ctx context.Context, email string) error {
-	_, err := q.db.Exec(ctx, createUser, email)
+type CompleteJobParams struct {
+	ID       int64 `json:"id"`
+	Attempts int32 `json:"attempts"`
+}
+
+func (q *Queries) CompleteJob(ctx context.Context, arg CompleteJobParams) (int64, error) {
+	row := q.db.QueryRow(ctx, completeJob, arg.ID, arg.Attempts)
+	var id int64
+	err := row.Scan(&id)
+	return id, err
+}
+
+const countSessions = `-- name: CountSessions :one
+SELECT count(*) FROM sessions WHERE expiry > now()
+`
+
+func (q *Queries) CountSessions(ctx context.Context) (int64, error) {
+	row := q.db.QueryRow(ctx, countSessions)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countTeamWorkspaces = `-- name: CountTeamWorkspaces :one
+SELECT count(*) FROM workspaces WHERE team = $1
+`
+
+func (q *Queries) CountTeamWorkspaces(ctx context.Context, team pgtype.Int4) (int64, error) {
+	row := q.db.QueryRow(ctx, countTeamWorkspaces, team)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const createAuditEvent = `-- name: CreateAuditEvent :exec
+INSERT INTO audit_events (actor, action, target, ip, user_agent, request_id, outcome, details)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+`
+
+type CreateAuditEventParams struct {
+	Actor     pgtype.Int4 `json:"actor"`
+	Action    string      `json:"action"`
+	Target    string      `json:"target"`
+	Ip        string      `json:"ip"`
+	UserAgent string      `json:"user_agent"`
+	RequestID string      `json:"request_id"`
+	Outcome   string      `json:"outcome"`
+	Details   []byte      `json:"details"`
+}
+
+func (q *Queries) CreateAuditEvent(ctx context.Context, arg CreateAuditEventParams) error {
+	_, err := q.db.Exec(ctx, createAuditEvent,
+		arg.Actor,
+		arg.Action,
+		arg.Target,
+		arg.Ip,
+		arg.UserAgent,
+		arg.RequestID,
+		arg.Outcome,
+		arg.Details,
+	)
 	return err
 }
 
+const createJob = `-- name: CreateJob :one
+INSERT INTO jobs (kind, payload, owner, max_attempts, traceparent, target) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, kind, payload, owner, status, attempts, max_attempts, last_error, run_at, created_at, updated_at, traceparent, target
+`
+
+type CreateJobParams struct {
+	Kind        string      `json:"kind"`
+	Payload     []byte      `json:"payload"`
+	Owner       pgtype.Int4 `json:"owner"`
+	MaxAttempts int32       `json:"max_attempts"`
+	Traceparent string      `json:"traceparent"`
+	Target      string      `json:"target"`
+}
+
+func (q *Queries) CreateJob(ctx context.Context, arg CreateJobParams) (Job, error) {
+	row := q.db.QueryRow(ctx, createJob,
+		arg.Kind,
+		arg.Payload,
+		arg.Owner,
+		arg.MaxAttempts,
+		arg.Traceparent,
+		arg.Target,
+	)
+	var i Job
+	err := row.Scan(
+		&i.ID,
+		&i.Kind,
+		&i.Payload,
+		&i.Owner,
+		&i.Status,
+		&i.Attempts,
+		&i.MaxAttempts,
+		&i.LastError,
+		&i.RunAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Traceparent,
+		&i.Target,
+	)
+	return i, err
+}
+
+const createPubsubPayload = `-- name: CreatePubsubPayload :one
+INSERT INTO pubsub_payloads (channel, payload) VALUES ($1, $2) RETURNING id
+`
+
+type CreatePubsubPayloadParams struct {
+	Channel string `json:"channel"`
+	Payload string `json:"payload"`
+}
+
+func (q *Queries) CreatePubsubPayload(ctx context.Context, arg CreatePubsubPayloadParams) (int64, error) {
+	row := q.db.QueryRow(ctx, createPubsubPayload, arg.Channel, arg.Payload)
+	var id int64
+	err := row.Scan(&id)
+	return id, err
+}
+
+const createSnapshot = `-- name: CreateSnapshot :one
+INSERT INTO snapshots (workspace, name, scheduled) VALUES ($1, $2, $3) RETURNING id, workspace, name, scheduled, state, state_message, size, created_at
+`
+
+type CreateSnapshotParams struct {
+	Workspace int32  `json:"workspace"`
+	Name      string `json:"name"`
+	Scheduled bool   `json:"scheduled"`
+}
+
+func (q *Queries) CreateSnapshot(ctx context.Context, arg CreateSnapshotParams) (Snapshot, error) {
+	row := q.db.QueryRow(ctx, createSnapshot, arg.Workspace, arg.Name, arg.Scheduled)
+	var i Snapshot
+	err := row.Scan(
+		&i.ID,
+		&i.Workspace,
+		&i.Name,
+		&i.Scheduled,
+		&i.State,
+		&i.StateMessage,
+		&i.Size,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const createTeam = `-- name: CreateTeam :one
+WITH t AS (
+    INSERT INTO teams (name, namespace, max_workspaces, cpu_quota, memory_quota, storage_quota)
+    VALUES ($1, $2, $3, $4, $5, $6)
+    RETURNING id, name, namespace, max_workspaces, cpu_quota, memory_quota, storage_quota
+), m AS (
+    INSERT INTO team_members (team, member, role) SELECT id, $7::int, 'owner' FROM t
+)
+SELECT id, name, namespace, max_workspaces, cpu_quota, memory_quota, storage_quota FROM t
+`
+
+type CreateTeamParams struct {
+	Name          string `json:"name"`
+	Namespace     string `json:"namespace"`
+	MaxWorkspaces int32  `json:"max_workspaces"`
+	CpuQuota      string `json:"cpu_quota"`
+	MemoryQuota   string `json:"memory_quota"`
+	StorageQuota  string `json:"storage_quota"`
+	Owner         int32  `json:"owner"`
+}
+
+func (q *Queries) CreateTeam(ctx context.Context, arg CreateTeamParams) (Team, error) {
+	row := q.db.QueryRow(ctx, createTeam,
+		arg.Name,
+		arg.Namespace,
+		arg.MaxWorkspaces,
+		arg.CpuQuota,
+		arg.MemoryQuota,
+		arg.StorageQuota,
+		arg.Owner,
+	)
+	var i Team
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Namespace,
+		&i.MaxWorkspaces,
+		&i.CpuQuota,
+		&i.MemoryQuota,
+		&i.StorageQuota,
+	)
+	return i, err
+}
+
 const createWorkspace = `-- name: CreateWorkspace :one
-INSERT INTO workspaces (name, owner) VALUES ($1, $2) RETURNING id, name, owner
+INSERT INTO workspaces (name, owner, team, template, labels) VALUES ($1, $2, $3, $4, $5) RETURNING id, name, owner, team, template, description, labels, cpu, memory, storage, state, state_message, source, created_at, last_active_at
 `
 
 type CreateWorkspaceParams struct {
-	Name  string `json:"name"`
-	Owner int32  `json:"owner"`
+	Name     string      `json:"name"`
+	Owner    int32       `json:"owner"`
+	Team     pgtype.Int4 `json:"team"`
+	Template int32       `json:"template"`
+	Labels   []byte      `json:"labels"`
 }
 
 func (q *Queries) CreateWorkspace(ctx context.Context, arg CreateWorkspaceParams) (Workspace, error) {
-	row := q.db.QueryRow(ctx, createWorkspace, arg.Name, arg.Owner)
+	row := q.db.QueryRow(ctx, createWorkspace,
+		arg.Name,
+		arg.Owner,
+		arg.Team,
+		arg.Template,
+		arg.Labels,
+	)
 	var i Workspace
-	err := row.Scan(&i.ID, &i.Name, &i.Owner)
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Owner,
+		&i.Team,
+		&i.Template,
+		&i.Description,
+		&i.Labels,
+		&i.Cpu,
+		&i.Memory,
+		&i.Storage,
+		&i.State,
+		&i.StateMessage,
+		&i.Source,
+		&i.CreatedAt,
+		&i.LastActiveAt,
+	)
 	return i, err
 }
 
-const deleteWorkspaceWithId = `-- name: DeleteWorkspaceWithId :one
-DELETE FROM workspaces WHERE owner = $1 AND id = $2 RETURNING id, name, owner
+const deleteExpiredPubsubPayloads = `-- name: DeleteExpiredPubsubPayloads :exec
+DELETE FROM pubsub_payloads WHERE created_at < $1
 `
 
-type DeleteWorkspaceWithIdParams struct {
-	Owner int32 `json:"owner"`
-	ID    int32 `json:"id"`
+func (q *Queries) DeleteExpiredPubsubPayloads(ctx context.Context, createdAt pgtype.Timestamptz) error {
+	_, err := q.db.Exec(ctx, deleteExpiredPubsubPayloads, createdAt)
+	return err
 }
 
-func (q *Queries) DeleteWorkspaceWithId(ctx context.Context, arg DeleteWorkspaceWithIdParams) (Workspace, error) {
-	row := q.db.QueryRow(ctx, deleteWorkspaceWithId, arg.Owner, arg.ID)
-	var i Workspace
-	err := row.Scan(&i.ID, &i.Name, &i.Owner)
+const deleteExpiredSnapshots = `-- name: DeleteExpiredSnapshots :many
+DELETE FROM snapshots WHERE id IN (
+    SELECT id FROM snapshots
+    WHERE workspace = $1::int AND scheduled AND state IN ('ready', 'restoring')
+    ORDER BY created_at DESC, id DESC
+    OFFSET $2::int
+) AND state = 'ready'
+RETURNING id, workspace, name, scheduled, state, state_message, size, created_at
+`
+
+type DeleteExpiredSnapshotsParams struct {
+	Workspace int32 `json:"workspace"`
+	Retention int32 `json:"retention"`
+}
+
+func (q *Queries) DeleteExpiredSnapshots(ctx context.Context, arg DeleteExpiredSnapshotsParams) ([]Snapshot, error) {
+	rows, err := q.db.Query(ctx, deleteExpiredSnapshots, arg.Workspace, arg.Retention)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Snapshot
+	for rows.Next() {
+		var i Snapshot
+		if err := rows.Scan(
+			&i.ID,
+			&i.Workspace,
+			&i.Name,
+			&i.Scheduled,
+			&i.State,
+			&i.StateMessage,
+			&i.Size,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const deleteFailedSnapshots = `-- name: DeleteFailedSnapshots :many
+DELETE FROM snapshots WHERE workspace = $1 AND scheduled AND state = 'failed' AND created_at < $2 RETURNING id, workspace, name, scheduled, state, state_message, size, created_at
+`
+
+type DeleteFailedSnapshotsParams struct {
+	Workspace int32              `json:"workspace"`
+	CreatedAt pgtype.Timestamptz `json:"created_at"`
+}
+
+func (q *Queries) DeleteFailedSnapshots(ctx context.Context, arg DeleteFailedSnapshotsParams) ([]Snapshot, error) {
+	rows, err := q.db.Query(ctx, deleteFailedSnapshots, arg.Workspace, arg.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Snapshot
+	for rows.Next() {
+		var i Snapshot
+		if err := rows.Scan(
+			&i.ID,
+			&i.Workspace,
+			&i.Name,
+			&i.Scheduled,
+			&i.State,
+			&i.StateMessage,
+			&i.Size,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const deleteFinishedJobs = `-- name: DeleteFinishedJobs :exec
+DELETE FROM jobs WHERE status IN ('succeeded', 'dead') AND updated_at < $1
+`
+
+func (q *Queries) DeleteFinishedJobs(ctx context.Context, updatedAt pgtype.Timestamptz) error {
+	_, err := q.db.Exec(ctx, deleteFinishedJobs, updatedAt)
+	return err
+}
+
+const deleteTeamMember = `-- name: DeleteTeamMember :one
+DELETE FROM team_members WHERE team = $1 AND member = $2 RETURNING team, member, role
+`
+
+type DeleteTeamMemberParams struct {
+	Team   int32 `json:"team"`
+	Member int32 `json:"member"`
+}
+
+func (q *Queries) DeleteTeamMember(ctx context.Context, arg DeleteTeamMemberParams) (TeamMember, error) {
+	row := q.db.QueryRow(ctx, deleteTeamMember, arg.Team, arg.Member)
+	var i TeamMember
+	err := row.Scan(&i.Team, &i.Member, &i.Role)
+	return i, err
+}
+
+const deleteTeamWithId = `-- name: DeleteTeamWithId :one
+DELETE FROM teams WHERE id = $1 RETURNING id, name, namespace, max_workspaces, cpu_quota, memory_quota, storage_quota
+`
+
+func (q *Queries) DeleteTeamWithId(ctx context.Context, id int32) (Team, error) {
+	row := q.db.QueryRow(ctx, deleteTeamWithId, id)
+	var i Team
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Namespace,
+		&i.MaxWorkspaces,
+		&i.CpuQuota,
+		&i.MemoryQuota,
+		&i.StorageQuota,
+	)
 	return i, err
 }
 
-const findUserWithEmail = `-- name: FindUserWithEmail :one
-SELECT id, email FROM users WHERE email = $1
+const deleteUserWithId = `-- name: DeleteUserWithId :one
+DELETE FROM users WHERE id = $1 RETURNING id, issuer, subject, email, name, picture, admin
 `
 
-func (q *Queries) FindUserWithEmail(ctx context.Context, email string) (User, error) {
-	row := q.db.QueryRow(ctx, findUserWithEmail, email)
+func (q *Queries) DeleteUserWithId(ctx context.Context, id int32) (User, error) {
+	row := q.db.QueryRow(ctx, deleteUserWithId, id)
 	var i User
-	err := row.Scan(&i.ID, &i.Email)
+	err := row.Scan(
+		&i.ID,
+		&i.Issuer,
+		&i.Subject,
+		&i.Email,
+		&i.Name,
+		&i.Picture,
+		&i.Admin,
+	)
+	return i, err
+}
+
+const deleteWorkspaceMember = `-- name: DeleteWorkspaceMember :one
+DELETE FROM workspace_members WHERE workspace = $1 AND member = $2 RETURNING workspace, member, role
+`
+
+type DeleteWorkspaceMemberParams struct {
+	Workspace int32 `json:"workspace"`
+	Member    int32 `json:"member"`
+}
+
+func (q *Queries) DeleteWorkspaceMember(ctx context.Context, arg DeleteWorkspaceMemberParams) (WorkspaceMember, error) {
+	row := q.db.QueryRow(ctx, deleteWorkspaceMember, arg.Workspace, arg.Member)
+	var i WorkspaceMember
+	err := row.Scan(&i.Workspace, &i.Member, &i.Role)
+	return i, err
+}
+
+const deleteWorkspaceSnapshot = `-- name: DeleteWorkspaceSnapshot :one
+DELETE FROM snapshots WHERE id = $1 AND workspace = $2 AND state <> 'restoring' RETURNING id, workspace, name, scheduled, state, state_message, size, created_at
+`
+
+type DeleteWorkspaceSnapshotParams struct {
+	ID        int32 `json:"id"`
+	Workspace int32 `json:"workspace"`
+}
+
+func (q *Queries) DeleteWorkspaceSnapshot(ctx context.Context, arg DeleteWorkspaceSnapshotParams) (Snapshot, error) {
+	row := q.db.QueryRow(ctx, deleteWorkspaceSnapshot, arg.ID, arg.Workspace)
+	var i Snapshot
+	err := row.Scan(
+		&i.ID,
+		&i.Workspace,
+		&i.Name,
+		&i.Scheduled,
+		&i.State,
+		&i.StateMessage,
+		&i.Size,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const deleteWorkspaceWithId = `-- name: DeleteWorkspaceWithId :one
+DELETE FROM workspaces WHERE id = $1 RETURNING id, name, owner, team, template, description, labels, cpu, memory, storage, state, state_message, source, created_at, last_active_at
+`
+
+func (q *Queries) DeleteWorkspaceWithId(ctx context.Context, id int32) (Workspace, error) {
+	row := q.db.QueryRow(ctx, deleteWorkspaceWithId, id)
+	var i Workspace
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Owner,
+		&i.Team,
+		&i.Template,
+		&i.Description,
+		&i.Labels,
+		&i.Cpu,
+		&i.Memory,
+		&i.Storage,
+		&i.State,
+		&i.StateMessage,
+		&i.Source,
+		&i.CreatedAt,
+		&i.LastActiveAt,
+	)
+	return i, err
+}
+
+const failStaleImports = `-- name: FailStaleImports :many
+UPDATE workspaces SET state = 'failed', state_message = $1
+WHERE state = 'importing' AND last_active_at < $2
+RETURNING id
+`
+
+type FailStaleImportsParams struct {
+	StateMessage string             `json:"state_message"`
+	LastActiveAt pgtype.Timestamptz `json:"last_active_at"`
+}
+
+func (q *Queries) FailStaleImports(ctx context.Context, arg FailStaleImportsParams) ([]int32, error) {
+	rows, err := q.db.Query(ctx, failStaleImports, arg.StateMessage, arg.LastActiveAt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []int32
+	for rows.Next() {
+		var id int32
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const findPubsubPayload = `-- name: FindPubsubPayload :one
+SELECT payload FROM pubsub_payloads WHERE id = $1
+`
+
+func (q *Queries) FindPubsubPayload(ctx context.Context, id int64) (string, error) {
+	row := q.db.QueryRow(ctx, findPubsubPayload, id)
+	var payload string
+	err := row.Scan(&payload)
+	return payload, err
+}
+
+const findSchemaVersion = `-- name: FindSchemaVersion :one
+SELECT max(version)::int FROM schema_version
+`
+
+func (q *Queries) FindSchemaVersion(ctx context.Context) (int32, error) {
+	row := q.db.QueryRow(ctx, findSchemaVersion)
+	var version int32
+	err := row.Scan(&version)
+	return version, err
+}
+
+const findSnapshotWithId = `-- name: FindSnapshotWithId :one
+SELECT id, workspace, name, scheduled, state, state_message, size, created_at FROM snapshots WHERE id = $1
+`
+
+func (q *Queries) FindSnapshotWithId(ctx context.Context, id int32) (Snapshot, error) {
+	row := q.db.QueryRow(ctx, findSnapshotWithId, id)
+	var i Snapshot
+	err := row.Scan(
+		&i.ID,
+		&i.Workspace,
+		&i.Name,
+		&i.Scheduled,
+		&i.State,
+		&i.StateMessage,
+		&i.Size,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const findTeamRole = `-- name: FindTeamRole :one
+SELECT role FROM team_members WHERE team = $1 AND member = $2
+`
+
+type FindTeamRoleParams struct {
+	Team   int32 `json:"team"`
+	Member int32 `json:"member"`
+}
+
+func (q *Queries) FindTeamRole(ctx context.Context, arg FindTeamRoleParams) (string, error) {
+	row := q.db.QueryRow(ctx, findTeamRole, arg.Team, arg.Member)
+	var role string
+	err := row.Scan(&role)
+	return role, err
+}
+
+const findTeamWithId = `-- name: FindTeamWithId :one
+SELECT id, name, namespace, max_workspaces, cpu_quota, memory_quota, storage_quota FROM teams WHERE id = $1
+`
+
+func (q *Queries) FindTeamWithId(ctx context.Context, id int32) (Team, error) {
+	row := q.db.QueryRow(ctx, findTeamWithId, id)
+	var i Team
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Namespace,
+		&i.MaxWorkspaces,
+		&i.CpuQuota,
+		&i.MemoryQuota,
+		&i.StorageQuota,
+	)
+	return i, err
+}
+
+const findTemplateWithId = `-- name: FindTemplateWithId :one
+SELECT id, name, image, egress, snapshot_interval, snapshot_retention FROM templates WHERE id = $1
+`
+
+func (q *Queries) FindTemplateWithId(ctx context.Context, id int32) (Template, error) {
+	row := q.db.QueryRow(ctx, findTemplateWithId, id)
+	var i Template
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Image,
+		&i.Egress,
+		&i.SnapshotInterval,
+		&i.SnapshotRetention,
+	)
+	return i, err
+}
+
+const findTemplateWithName = `-- name: FindTemplateWithName :one
+SELECT id, name, image, egress, snapshot_interval, snapshot_retention FROM templates WHERE name = $1
+`
+
+func (q *Queries) FindTemplateWithName(ctx context.Context, name string) (Template, error) {
+	row := q.db.QueryRow(ctx, findTemplateWithName, name)
+	var i Template
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Image,
+		&i.Egress,
+		&i.SnapshotInterval,
+		&i.SnapshotRetention,
+	)
+	return i, err
+}
+
+const findUserJob = `-- name: FindUserJob :one
+SELECT id, kind, status, attempts, max_attempts, last_error, created_at, updated_at FROM jobs
+WHERE id = $1 AND owner = $2
+`
+
+type FindUserJobParams struct {
+	ID    int64       `json:"id"`
+	Owner pgtype.Int4 `json:"owner"`
+}
+
+type FindUserJobRow struct {
+	ID          int64              `json:"id"`
+	Kind        string             `json:"kind"`
+	Status      string             `json:"status"`
+	Attempts    int32              `json:"attempts"`
+	MaxAttempts int32              `json:"max_attempts"`
+	LastError   string             `json:"last_error"`
+	CreatedAt   pgtype.Timestamptz `json:"created_at"`
+	UpdatedAt   pgtype.Timestamptz `json:"updated_at"`
+}
+
+func (q *Queries) FindUserJob(ctx context.Context, arg FindUserJobParams) (FindUserJobRow, error) {
+	row := q.db.QueryRow(ctx, findUserJob, arg.ID, arg.Owner)
+	var i FindUserJobRow
+	err := row.Scan(
+		&i.ID,
+		&i.Kind,
+		&i.Status,
+		&i.Attempts,
+		&i.MaxAttempts,
+		&i.LastError,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
 	return i, err
 }
 
 const findUserWithId = `-- name: FindUserWithId :one
-SELECT id, email FROM users WHERE id = $1
+SELECT id, issuer, subject, email, name, picture, admin FROM users WHERE id = $1
 `
 
 func (q *Queries) FindUserWithId(ctx context.Context, id int32) (User, error) {
 	row := q.db.QueryRow(ctx, findUserWithId, id)
 	var i User
-	err := row.Scan(&i.ID, &i.Email)
+	err := row.Scan(
+		&i.ID,
+		&i.Issuer,
+		&i.Subject,
+		&i.Email,
+		&i.Name,
+		&i.Picture,
+		&i.Admin,
+	)
+	return i, err
+}
+
+const findWorkspaceRole = `-- name: FindWorkspaceRole :one
+SELECT role FROM workspace_roles WHERE workspace = $1 AND member = $2
+ORDER BY CASE role WHEN 'owner' THEN 3 WHEN 'editor' THEN 2 ELSE 1 END DESC
+LIMIT 1
+`
+
+type FindWorkspaceRoleParams struct {
+	Workspace int32 `json:"workspace"`
+	Member    int32 `json:"member"`
+}
+
+func (q *Queries) FindWorkspaceRole(ctx context.Context, arg FindWorkspaceRoleParams) (string, error) {
+	row := q.db.QueryRow(ctx, findWorkspaceRole, arg.Workspace, arg.Member)
+	var role string
+	err := row.Scan(&role)
+	return role, err
+}
+
+const findWorkspaceSnapshot = `-- name: FindWorkspaceSnapshot :one
+SELECT id, workspace, name, scheduled, state, state_message, size, created_at FROM snapshots WHERE id = $1 AND workspace = $2
+`
+
+type FindWorkspaceSnapshotParams struct {
+	ID        int32 `json:"id"`
+	Workspace int32 `json:"workspace"`
+}
+
+func (q *Queries) FindWorkspaceSnapshot(ctx context.Context, arg FindWorkspaceSnapshotParams) (Snapshot, error) {
+	row := q.db.QueryRow(ctx, findWorkspaceSnapshot, arg.ID, arg.Workspace)
+	var i Snapshot
+	err := row.Scan(
+		&i.ID,
+		&i.Workspace,
+		&i.Name,
+		&i.Scheduled,
+		&i.State,
+		&i.StateMessage,
+		&i.Size,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const findWorkspaceWithId = `-- name: FindWorkspaceWithId :one
+SELECT id, name, owner, team, template, description, labels, cpu, memory, storage, state, state_message, source, created_at, last_active_at FROM workspaces WHERE id = $1
+`
+
+func (q *Queries) FindWorkspaceWithId(ctx context.Context, id int32) (Workspace, error) {
+	row := q.db.QueryRow(ctx, findWorkspaceWithId, id)
+	var i Workspace
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Owner,
+		&i.Team,
+		&i.Template,
+		&i.Description,
+		&i.Labels,
+		&i.Cpu,
+		&i.Memory,
+		&i.Storage,
+		&i.State,
+		&i.StateMessage,
+		&i.Source,
+		&i.CreatedAt,
+		&i.LastActiveAt,
+	)
 	return i, err
 }
 
+const finishSnapshotRestore = `-- name: FinishSnapshotRestore :exec
+UPDATE snapshots SET state = 'ready' WHERE id = $1 AND state = 'restoring'
+`
+
+func (q *Queries) FinishSnapshotRestore(ctx context.Context, id int32) error {
+	_, err := q.db.Exec(ctx, finishSnapshotRestore, id)
+	return err
+}
+
+const importWorkspace = `-- name: ImportWorkspace :one
+INSERT INTO workspaces (name, owner, team, template, description, labels, cpu, memory, storage, state)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, 'importing')
+RETURNING id, name, owner, team, template, description, labels, cpu, memory, storage, state, state_message, source, created_at, last_active_at
+`
+
+type ImportWorkspaceParams struct {
+	Name        string      `json:"name"`
+	Owner       int32       `json:"owner"`
+	Team        pgtype.Int4 `json:"team"`
+	Template    int32       `json:"template"`
+	Description string      `json:"description"`
+	Labels      []byte      `json:"labels"`
+	Cpu         string      `json:"cpu"`
+	Memory      string      `json:"memory"`
+	Storage     string      `json:"storage"`
+}
+
+func (q *Queries) ImportWorkspace(ctx context.Context, arg ImportWorkspaceParams) (Workspace, error) {
+	row := q.db.QueryRow(ctx, importWorkspace,
+		arg.Name,
+		arg.Owner,
+		arg.Team,
+		arg.Template,
+		arg.Description,
+		arg.Labels,
+		arg.Cpu,
+		arg.Memory,
+		arg.Storage,
+	)
+	var i Workspace
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Owner,
+		&i.Team,
+		&i.Template,
+		&i.Description,
+		&i.Labels,
+		&i.Cpu,
+		&i.Memory,
+		&i.Storage,
+		&i.State,
+		&i.StateMessage,
+		&i.Source,
+		&i.CreatedAt,
+		&i.LastActiveAt,
+	)
+	return i, err
+}
+
+const killJob = `-- name: KillJob :one
+UPDATE jobs SET status = 'dead', last_error = $2, updated_at = now()
+WHERE id = $1 AND status = 'running' AND attempts = $3
+RETURNING id
+`
+
+type KillJobParams struct {
+	ID        int64  `json:"id"`
+	LastError string `json:"last_error"`
+	Attempts  int32  `json:"attempts"`
+}
+
+func (q *Queries) KillJob(ctx context.Context, arg KillJobParams) (int64, error) {
+	row := q.db.QueryRow(ctx, killJob, arg.ID, arg.LastError, arg.Attempts)
+	var id int64
+	err := row.Scan(&id)
+	return id, err
+}
+
+const listAuditEvents = `-- name: ListAuditEvents :many
+SELECT id, created_at, actor, action, target, ip, user_agent, request_id, outcome, details FROM audit_events
+WHERE ($1::int IS NULL OR actor = $1)
+    AND ($2::text IS NULL OR action = $2)
+    AND ($3::text IS NULL OR target = $3)
+    AND ($4::text IS NULL OR outcome = $4)
+    AND ($5::timestamptz IS NULL OR created_at >= $5)
+    AND ($6::timestamptz IS NULL OR created_at < $6)
+    AND ($7::bigint IS NULL OR id < $7)
+ORDER BY id DESC
+LIMIT $8
+`
+
+type ListAuditEventsParams struct {
+	Actor     pgtype.Int4        `json:"actor"`
+	Action    pgtype.Text        `json:"action"`
+	Target    pgtype.Text        `json:"target"`
+	Outcome   pgtype.Text        `json:"outcome"`
+	Since     pgtype.Timestamptz `json:"since"`
+	Until     pgtype.Timestamptz `json:"until"`
+	Cursor    pgtype.Int8        `json:"cursor"`
+	MaxEvents int32              `json:"max_events"`
+}
+
+func (q *Queries) ListAuditEvents(ctx context.Context, arg ListAuditEventsParams) ([]AuditEvent, error) {
+	rows, err := q.db.Query(ctx, listAuditEvents,
+		arg.Actor,
+		arg.Action,
+		arg.Target,
+		arg.Outcome,
+		arg.Since,
+		arg.Until,
+		arg.Cursor,
+		arg.MaxEvents,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []AuditEvent
+	for rows.Next() {
+		var i AuditEvent
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.Actor,
+			&i.Action,
+			&i.Target,
+			&i.Ip,
+			&i.UserAgent,
+			&i.RequestID,
+			&i.Outcome,
+			&i.Details,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listDueSnapshotWorkspaces = `-- name: ListDueSnapshotWorkspaces :many
+SELECT w.id, w.owner FROM workspaces w JOIN templates t ON t.id = w.template
+WHERE t.snapshot_interval > 0 AND w.state = 'ready'
+    AND NOT EXISTS (
+        SELECT 1 FROM snapshots s
+        WHERE s.workspace = w.id AND s.scheduled
+            AND s.created_at > now() - make_interval(mins => t.snapshot_interval)
+    )
+ORDER BY w.id
+`
+
+type ListDueSnapshotWorkspacesRow struct {
+	ID    int32 `json:"id"`
+	Owner int32 `json:"owner"`
+}
+
+func (q *Queries) ListDueSnapshotWorkspaces(ctx context.Context) ([]ListDueSnapshotWorkspacesRow, error) {
+	rows, err := q.db.Query(ctx, listDueSnapshotWorkspaces)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListDueSnapshotWorkspacesRow
+	for rows.Next() {
+		var i ListDueSnapshotWorkspacesRow
+		if err := rows.Scan(&i.ID, &i.Owner); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listTeamMembers = `-- name: ListTeamMembers :many
+SELECT u.id, u.email, u.name, u.picture, m.role
+FROM team_members m JOIN users u ON u.id = m.member
+WHERE m.team = $1
+ORDER BY u.id
+`
+
+type ListTeamMembersRow struct {
+	ID      int32  `json:"id"`
+	Email   string `json:"email"`
+	Name    string `json:"name"`
+	Picture string `json:"picture"`
+	Role    string `json:"role"`
+}
+
+func (q *Queries) ListTeamMembers(ctx context.Context, team int32) ([]ListTeamMembersRow, error) {
+	rows, err := q.db.Query(ctx, listTeamMembers, team)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListTeamMembersRow
+	for rows.Next() {
+		var i ListTeamMembersRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Email,
+			&i.Name,
+			&i.Picture,
+			&i.Role,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listTemplates = `-- name: ListTemplates :many
+SELECT id, name, image, egress, snapshot_interval, snapshot_retention FROM templates ORDER BY id
+`
+
+func (q *Queries) ListTemplates(ctx context.Context) ([]Template, error) {
+	rows, err := q.db.Query(ctx, listTemplates)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Template
+	for rows.Next() {
+		var i Template
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Image,
+			&i.Egress,
+			&i.SnapshotInterval,
+			&i.SnapshotRetention,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUserTeams = `-- name: ListUserTeams :many
+SELECT t.id, t.name, t.namespace, t.max_workspaces, t.cpu_quota, t.memory_quota, t.storage_quota, m.role
+FROM teams t JOIN team_members m ON m.team = t.id
+WHERE m.member = $1
+ORDER BY t.id
+`
+
+type ListUserTeamsRow struct {
+	ID            int32  `json:"id"`
+	Name          string `json:"name"`
+	Namespace     string `json:"namespace"`
+	MaxWorkspaces int32  `json:"max_workspaces"`
+	CpuQuota      string `json:"cpu_quota"`
+	MemoryQuota   string `json:"memory_quota"`
+	StorageQuota  string `json:"storage_quota"`
+	Role          string `json:"role"`
+}
+
+func (q *Queries) ListUserTeams(ctx context.Context, member int32) ([]ListUserTeamsRow, error) {
+	rows, err := q.db.Query(ctx, listUserTeams, member)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListUserTeamsRow
+	for rows.Next() {
+		var i ListUserTeamsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Namespace,
+			&i.MaxWorkspaces,
+			&i.CpuQuota,
+			&i.MemoryQuota,
+			&i.StorageQuota,
+			&i.Role,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const listUserWorkspaces = `-- name: ListUserWorkspaces :many
-SELECT id, name, owner FROM workspaces WHERE owner = $1
+SELECT w.id, w.name, w.owner, w.team, w.template, w.description, w.labels, w.cpu, w.memory, w.storage, w.state, w.state_message, w.source, w.created_at, w.last_active_at,
+    r.role, w.owner <> $1::int AS shared
+FROM workspaces w
+JOIN (
+    SELECT DISTINCT ON (workspace) workspace, role FROM workspace_roles
+    WHERE member = $1::int
+    ORDER BY workspace, CASE role WHEN 'owner' THEN 3 WHEN 'editor' THEN 2 ELSE 1 END DESC
+) r ON r.workspace = w.id
+WHERE ($2::text IS NULL OR w.state = $2)
+    AND ($3::int IS NULL OR w.template = $3)
+    AND ($4::bool IS NULL OR (w.owner <> $1::int) = $4)
+    AND ($5::text IS NULL OR w.name ILIKE '%' || $5 || '%')
+    -- Equality requirements of the label selector, which can use the labels index
+    AND w.labels @> $6::jsonb
+    -- Every requirement of the label selector
+    AND NOT EXISTS (
+        SELECT 1 FROM jsonb_to_recordset($7::jsonb) AS q(key text, operator text, allowed text[])
+        WHERE NOT CASE q.operator
+            WHEN 'in' THEN coalesce(w.labels ->> q.key = ANY (q.allowed), false)
+            WHEN 'notin' THEN coalesce(NOT w.labels ->> q.key = ANY (q.allowed), true)
+            WHEN 'exists' THEN w.labels ? q.key
+            ELSE NOT w.labels ? q.key
+        END
+    )
+    -- Workspaces after the cursor, in the order of the sort
+    AND ($8::int IS NULL OR CASE $9::text
+        WHEN 'name' THEN (w.name, w.id) > ($10::text, $8::int)
+        WHEN '-name' THEN (w.name, w.id) < ($10::text, $8::int)
+        WHEN 'created_at' THEN (w.created_at, w.id) > ($11::timestamptz, $8::int)
+        WHEN '-created_at' THEN (w.created_at, w.id) < ($11::timestamptz, $8::int)
+        WHEN 'last_active' THEN (w.last_active_at, w.id) > ($11::timestamptz, $8::int)
+        ELSE (w.last_active_at, w.id) < ($11::timestamptz, $8::int)
+    END)
+ORDER BY
+    CASE WHEN $9::text = 'name' THEN w.name END,
+    CASE WHEN $9::text = '-name' THEN w.name END DESC,
+    CASE WHEN $9::text = 'created_at' THEN w.created_at END,
+    CASE WHEN $9::text = '-created_at' THEN w.created_at END DESC,
+    CASE WHEN $9::text = 'last_active' THEN w.last_active_at END,
+    CASE WHEN $9::text = '-last_active' THEN w.last_active_at END DESC,
+    CASE WHEN $9::text LIKE '-%' THEN w.id END DESC,
+    w.id
+LIMIT $12
 `
 
-func (q *Queries) ListUserWorkspaces(ctx context.Context, owner int32) ([]Workspace, error) {
-	rows, err := q.db.Query(ctx, listUserWorkspaces, owner)
+type ListUserWorkspacesParams struct {
+	Member        int32              `json:"member"`
+	State         pgtype.Text        `json:"state"`
+	Template      pgtype.Int4        `json:"template"`
+	Shared        pgtype.Bool        `json:"shared"`
+	Search        pgtype.Text        `json:"search"`
+	Labels        []byte             `json:"labels"`
+	Requirements  []byte             `json:"requirements"`
+	AfterID       pgtype.Int4        `json:"after_id"`
+	Sort          string             `json:"sort"`
+	AfterName     pgtype.Text        `json:"after_name"`
+	AfterTime     pgtype.Timestamptz `json:"after_time"`
+	MaxWorkspaces int32              `json:"max_workspaces"`
+}
+
+type ListUserWorkspacesRow struct {
+	ID           int32              `json:"id"`
+	Name         string             `json:"name"`
+	Owner        int32              `json:"owner"`
+	Team         pgtype.Int4        `json:"team"`
+	Template     int32              `json:"template"`
+	Description  string             `json:"description"`
+	Labels       []byte             `json:"labels"`
+	Cpu          string             `json:"cpu"`
+	Memory       string             `json:"memory"`
+	Storage      string             `json:"storage"`
+	State        string             `json:"state"`
+	StateMessage string             `json:"state_message"`
+	Source       pgtype.Int4        `json:"source"`
+	CreatedAt    pgtype.Timestamptz `json:"created_at"`
+	LastActiveAt pgtype.Timestamptz `json:"last_active_at"`
+	Role         string             `json:"role"`
+	Shared       bool               `json:"shared"`
+}
+
+func (q *Queries) ListUserWorkspaces(ctx context.Context, arg ListUserWorkspacesParams) ([]ListUserWorkspacesRow, error) {
+	rows, err := q.db.Query(ctx, listUserWorkspaces,
+		arg.Member,
+		arg.State,
+		arg.Template,
+		arg.Shared,
+		arg.Search,
+		arg.Labels,
+		arg.Requirements,
+		arg.AfterID,
+		arg.Sort,
+		arg.AfterName,
+		arg.AfterTime,
+		arg.MaxWorkspaces,
+	)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []Workspace
+	var items []ListUserWorkspacesRow
 	for rows.Next() {
-		var i Workspace
-		if err := rows.Scan(&i.ID, &i.Name, &i.Owner); err != nil {
+		var i ListUserWorkspacesRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Owner,
+			&i.Team,
+			&i.Template,
+			&i.Description,
+			&i.Labels,
+			&i.Cpu,
+			&i.Memory,
+			&i.Storage,
+			&i.State,
+			&i.StateMessage,
+			&i.Source,
+			&i.CreatedAt,
+			&i.LastActiveAt,
+			&i.Role,
+			&i.Shared,
+		); err != nil {
 			return nil, err
 		}
 		items = append(items, i)
@@ -97,7 +1246,7 @@ func (q *Queries) ListUserWorkspaces(ctx context.Context, owner int32) ([]Worksp
 }
 
 const listUsers = `-- name: ListUsers :many
-SELECT id, email FROM users
+SELECT id, issuer, subject, email, name, picture, admin FROM users
 `
 
 func (q *Queries) ListUsers(ctx context.Context) ([]User, error) {
@@ -109,7 +1258,47 @@ func (q *Queries) ListUsers(ctx context.Context) ([]User, error) {
 	var items []User
 	for rows.Next() {
 		var i User
-		if err := rows.Scan(&i.ID, &i.Email); err != nil {
+		if err := rows.Scan(
+			&i.ID,
+			&i.Issuer,
+			&i.Subject,
+			&i.Email,
+			&i.Name,
+			&i.Picture,
+			&i.Admin,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUsersWithEmail = `-- name: ListUsersWithEmail :many
+SELECT id, issuer, subject, email, name, picture, admin FROM users WHERE email = $1 ORDER BY id
+`
+
+func (q *Queries) ListUsersWithEmail(ctx context.Context, email string) ([]User, error) {
+	rows, err := q.db.Query(ctx, listUsersWithEmail, email)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []User
+	for rows.Next() {
+		var i User
+		if err := rows.Scan(
+			&i.ID,
+			&i.Issuer,
+			&i.Subject,
+			&i.Email,
+			&i.Name,
+			&i.Picture,
+			&i.Admin,
+		); err != nil {
 			return nil, err
 		}
 		items = append(items, i)
@@ -119,3 +1308,412 @@ func (q *Queries) ListUsers(ctx context.Context) ([]User, error) {
 	}
 	return items, nil
 }
+
+const listWorkspaceMembers = `-- name: ListWorkspaceMembers :many
+SELECT u.id, u.email, u.name, u.picture, 'owner'::text AS role
+FROM workspaces w JOIN users u ON u.id = w.owner
+WHERE w.id = $1
+UNION ALL
+SELECT u.id, u.email, u.name, u.picture, m.role
+FROM workspace_members m JOIN users u ON u.id = m.member
+WHERE m.workspace = $1
+`
+
+type ListWorkspaceMembersRow struct {
+	ID      int32  `json:"id"`
+	Email   string `json:"email"`
+	Name    string `json:"name"`
+	Picture string `json:"picture"`
+	Role    string `json:"role"`
+}
+
+func (q *Queries) ListWorkspaceMembers(ctx context.Context, id int32) ([]ListWorkspaceMembersRow, error) {
+	rows, err := q.db.Query(ctx, listWorkspaceMembers, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListWorkspaceMembersRow
+	for rows.Next() {
+		var i ListWorkspaceMembersRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Email,
+			&i.Name,
+			&i.Picture,
+			&i.Role,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listWorkspaceSnapshots = `-- name: ListWorkspaceSnapshots :many
+SELECT id, workspace, name, scheduled, state, state_message, size, created_at FROM snapshots WHERE workspace = $1 ORDER BY created_at DESC, id DESC
+`
+
+func (q *Queries) ListWorkspaceSnapshots(ctx context.Context, workspace int32) ([]Snapshot, error) {
+	rows, err := q.db.Query(ctx, listWorkspaceSnapshots, workspace)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Snapshot
+	for rows.Next() {
+		var i Snapshot
+		if err := rows.Scan(
+			&i.ID,
+			&i.Workspace,
+			&i.Name,
+			&i.Scheduled,
+			&i.State,
+			&i.StateMessage,
+			&i.Size,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listWorkspaceUsers = `-- name: ListWorkspaceUsers :many
+SELECT DISTINCT member FROM workspace_roles
+WHERE workspace = $1
+ORDER BY member
+`
+
+func (q *Queries) ListWorkspaceUsers(ctx context.Context, workspace int32) ([]int32, error) {
+	rows, err := q.db.Query(ctx, listWorkspaceUsers, workspace)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []int32
+	for rows.Next() {
+		var member int32
+		if err := rows.Scan(&member); err != nil {
+			return nil, err
+		}
+		items = append(items, member)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const lockTeam = `-- name: LockTeam :one
+SELECT id, name, namespace, max_workspaces, cpu_quota, memory_quota, storage_quota FROM teams WHERE id = $1 FOR UPDATE
+`
+
+func (q *Queries) LockTeam(ctx context.Context, id int32) (Team, error) {
+	row := q.db.QueryRow(ctx, lockTeam, id)
+	var i Team
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Namespace,
+		&i.MaxWorkspaces,
+		&i.CpuQuota,
+		&i.MemoryQuota,
+		&i.StorageQuota,
+	)
+	return i, err
+}
+
+const notify = `-- name: Notify :exec
+SELECT pg_notify($1::text, $2::text)
+`
+
+type NotifyParams struct {
+	Channel string `json:"channel"`
+	Payload string `json:"payload"`
+}
+
+func (q *Queries) Notify(ctx context.Context, arg NotifyParams) error {
+	_, err := q.db.Exec(ctx, notify, arg.Channel, arg.Payload)
+	return err
+}
+
+const retryJob = `-- name: RetryJob :one
+UPDATE jobs SET status = 'pending', run_at = $2, last_error = $3, updated_at = now()
+WHERE id = $1 AND status = 'running' AND attempts = $4
+RETURNING id
+`
+
+type RetryJobParams struct {
+	ID        int64              `json:"id"`
+	RunAt     pgtype.Timestamptz `json:"run_at"`
+	LastError string             `json:"last_error"`
+	Attempts  int32              `json:"attempts"`
+}
+
+func (q *Queries) RetryJob(ctx context.Context, arg RetryJobParams) (int64, error) {
+	row := q.db.QueryRow(ctx, retryJob,
+		arg.ID,
+		arg.RunAt,
+		arg.LastError,
+		arg.Attempts,
+	)
+	var id int64
+	err := row.Scan(&id)
+	return id, err
+}
+
+const setSnapshotState = `-- name: SetSnapshotState :exec
+UPDATE snapshots SET state = $2, state_message = $3, size = $4 WHERE id = $1
+`
+
+type SetSnapshotStateParams struct {
+	ID           int32  `json:"id"`
+	State        string `json:"state"`
+	StateMessage string `json:"state_message"`
+	Size         string `json:"size"`
+}
+
+func (q *Queries) SetSnapshotState(ctx context.Context, arg SetSnapshotStateParams) error {
+	_, err := q.db.Exec(ctx, setSnapshotState,
+		arg.ID,
+		arg.State,
+		arg.StateMessage,
+		arg.Size,
+	)
+	return err
+}
+
+const setWorkspaceState = `-- name: SetWorkspaceState :exec
+UPDATE workspaces SET state = $2, state_message = $3 WHERE id = $1
+`
+
+type SetWorkspaceStateParams struct {
+	ID           int32  `json:"id"`
+	State        string `json:"state"`
+	StateMessage string `json:"state_message"`
+}
+
+func (q *Queries) SetWorkspaceState(ctx context.Context, arg SetWorkspaceStateParams) error {
+	_, err := q.db.Exec(ctx, setWorkspaceState, arg.ID, arg.State, arg.StateMessage)
+	return err
+}
+
+const startSnapshotRestore = `-- name: StartSnapshotRestore :one
+UPDATE snapshots SET state = 'restoring' WHERE id = $1 AND workspace = $2 AND state = 'ready' RETURNING id, workspace, name, scheduled, state, state_message, size, created_at
+`
+
+type StartSnapshotRestoreParams struct {
+	ID        int32 `json:"id"`
+	Workspace int32 `json:"workspace"`
+}
+
+func (q *Queries) StartSnapshotRestore(ctx context.Context, arg StartSnapshotRestoreParams) (Snapshot, error) {
+	row := q.db.QueryRow(ctx, startSnapshotRestore, arg.ID, arg.Workspace)
+	var i Snapshot
+	err := row.Scan(
+		&i.ID,
+		&i.Workspace,
+		&i.Name,
+		&i.Scheduled,
+		&i.State,
+		&i.StateMessage,
+		&i.Size,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const startWorkspaceRestore = `-- name: StartWorkspaceRestore :one
+UPDATE workspaces SET state = 'restoring', state_message = '' WHERE id = $1 AND state IN ('ready', 'failed') RETURNING id
+`
+
+func (q *Queries) StartWorkspaceRestore(ctx context.Context, id int32) (int32, error) {
+	row := q.db.QueryRow(ctx, startWorkspaceRestore, id)
+	err := row.Scan(&id)
+	return id, err
+}
+
+const touchWorkspace = `-- name: TouchWorkspace :exec
+UPDATE workspaces SET last_active_at = now() WHERE id = $1
+`
+
+func (q *Queries) TouchWorkspace(ctx context.Context, id int32) error {
+	_, err := q.db.Exec(ctx, touchWorkspace, id)
+	return err
+}
+
+const tryAdvisoryLock = `-- name: TryAdvisoryLock :one
+SELECT pg_try_advisory_lock($1) AS acquired
+`
+
+func (q *Queries) TryAdvisoryLock(ctx context.Context, key int64) (bool, error) {
+	row := q.db.QueryRow(ctx, tryAdvisoryLock, key)
+	var acquired bool
+	err := row.Scan(&acquired)
+	return acquired, err
+}
+
+const updateTeam = `-- name: UpdateTeam :one
+UPDATE teams SET name = $2, max_workspaces = $3, cpu_quota = $4, memory_quota = $5, storage_quota = $6
+WHERE id = $1
+RETURNING id, name, namespace, max_workspaces, cpu_quota, memory_quota, storage_quota
+`
+
+type UpdateTeamParams struct {
+	ID            int32  `json:"id"`
+	Name          string `json:"name"`
+	MaxWorkspaces int32  `json:"max_workspaces"`
+	CpuQuota      string `json:"cpu_quota"`
+	MemoryQuota   string `json:"memory_quota"`
+	StorageQuota  string `json:"storage_quota"`
+}
+
+func (q *Queries) UpdateTeam(ctx context.Context, arg UpdateTeamParams) (Team, error) {
+	row := q.db.QueryRow(ctx, updateTeam,
+		arg.ID,
+		arg.Name,
+		arg.MaxWorkspaces,
+		arg.CpuQuota,
+		arg.MemoryQuota,
+		arg.StorageQuota,
+	)
+	var i Team
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Namespace,
+		&i.MaxWorkspaces,
+		&i.CpuQuota,
+		&i.MemoryQuota,
+		&i.StorageQuota,
+	)
+	return i, err
+}
+
+const updateWorkspace = `-- name: UpdateWorkspace :one
+UPDATE workspaces SET name = $2, description = $3, labels = $4, cpu = $5, memory = $6, storage = $7, last_active_at = now()
+WHERE id = $1 AND state IN ('ready', 'failed')
+RETURNING id, name, owner, team, template, description, labels, cpu, memory, storage, state, state_message, source, created_at, last_active_at
+`
+
+type UpdateWorkspaceParams struct {
+	ID          int32  `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Labels      []byte `json:"labels"`
+	Cpu         string `json:"cpu"`
+	Memory      string `json:"memory"`
+	Storage     string `json:"storage"`
+}
+
+func (q *Queries) UpdateWorkspace(ctx context.Context, arg UpdateWorkspaceParams) (Workspace, error) {
+	row := q.db.QueryRow(ctx, updateWorkspace,
+		arg.ID,
+		arg.Name,
+		arg.Description,
+		arg.Labels,
+		arg.Cpu,
+		arg.Memory,
+		arg.Storage,
+	)
+	var i Workspace
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Owner,
+		&i.Team,
+		&i.Template,
+		&i.Description,
+		&i.Labels,
+		&i.Cpu,
+		&i.Memory,
+		&i.Storage,
+		&i.State,
+		&i.StateMessage,
+		&i.Source,
+		&i.CreatedAt,
+		&i.LastActiveAt,
+	)
+	return i, err
+}
+
+const upsertTeamMember = `-- name: UpsertTeamMember :one
+INSERT INTO team_members (team, member, role) VALUES ($1, $2, $3)
+ON CONFLICT (team, member) DO UPDATE SET role = EXCLUDED.role
+RETURNING team, member, role
+`
+
+type UpsertTeamMemberParams struct {
+	Team   int32  `json:"team"`
+	Member int32  `json:"member"`
+	Role   string `json:"role"`
+}
+
+func (q *Queries) UpsertTeamMember(ctx context.Context, arg UpsertTeamMemberParams) (TeamMember, error) {
+	row := q.db.QueryRow(ctx, upsertTeamMember, arg.Team, arg.Member, arg.Role)
+	var i TeamMember
+	err := row.Scan(&i.Team, &i.Member, &i.Role)
+	return i, err
+}
+
+const upsertUser = `-- name: UpsertUser :one
+INSERT INTO users (issuer, subject, email, name, picture) VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (issuer, subject) DO UPDATE SET email = EXCLUDED.email, name = EXCLUDED.name, picture = EXCLUDED.picture
+RETURNING id, issuer, subject, email, name, picture, admin
+`
+
+type UpsertUserParams struct {
+	Issuer  string `json:"issuer"`
+	Subject string `json:"subject"`
+	Email   string `json:"email"`
+	Name    string `json:"name"`
+	Picture string `json:"picture"`
+}
+
+func (q *Queries) UpsertUser(ctx context.Context, arg UpsertUserParams) (User, error) {
+	row := q.db.QueryRow(ctx, upsertUser,
+		arg.Issuer,
+		arg.Subject,
+		arg.Email,
+		arg.Name,
+		arg.Picture,
+	)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Issuer,
+		&i.Subject,
+		&i.Email,
+		&i.Name,
+		&i.Picture,
+		&i.Admin,
+	)
+	return i, err
+}
+
+const upsertWorkspaceMember = `-- name: UpsertWorkspaceMember :one
+INSERT INTO workspace_members (workspace, member, role) VALUES ($1, $2, $3)
+ON CONFLICT (workspace, member) DO UPDATE SET role = EXCLUDED.role
+RETURNING workspace, member, role
+`
+
+type UpsertWorkspaceMemberParams struct {
+	Workspace int32  `json:"workspace"`
+	Member    int32  `json:"member"`
+	Role      string `json:"role"`
+}
+
+func (q *Queries) UpsertWorkspaceMember(ctx context.Context, arg UpsertWorkspaceMemberParams) (WorkspaceMember, error) {
+	row := q.db.QueryRow(ctx, upsertWorkspaceMember, arg.Workspace, arg.Member, arg.Role)
+	var i WorkspaceMember
+	err := row.Scan(&i.Workspace, &i.Member, &i.Role)
+	return i, err
+}
@@ -1,6 +1,20 @@
+-- Version of this schema. Bump it, along with database.SchemaVersion, with
+-- every change to the schema.
+CREATE TABLE schema_version (
+    version INT NOT NULL
+);
+
+INSERT INTO schema_version (version) VALUES (11);
+
 CREATE TABLE users (
     id SERIAL PRIMARY KEY,
-    email TEXT NOT NULL
+    issuer TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    email TEXT NOT NULL,
+    name TEXT NOT NULL DEFAULT '',
+    picture TEXT NOT NULL DEFAULT '',
+    admin BOOLEAN NOT NULL DEFAULT false, -- Granted by operators with UPDATE users SET admin = true
+    UNIQUE (issuer, subject)
 );
 
 CREATE TABLE sessions (
@@ -11,9 +25,163 @@ CREATE TABLE sessions (
 
 CREATE INDEX sessions_expiry_idx ON sessions (expiry);
 
+CREATE TABLE teams (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(50) NOT NULL UNIQUE,
+    namespace TEXT NOT NULL DEFAULT '',
+    max_workspaces INT NOT NULL DEFAULT 0,
+    cpu_quota TEXT NOT NULL DEFAULT '',
+    memory_quota TEXT NOT NULL DEFAULT '',
+    storage_quota TEXT NOT NULL DEFAULT ''
+);
+
+CREATE UNIQUE INDEX teams_namespace_idx ON teams (namespace) WHERE namespace <> '';
+
+CREATE TABLE team_members (
+    team INT REFERENCES teams (id) ON DELETE CASCADE NOT NULL,
+    member INT REFERENCES users (id) ON DELETE CASCADE NOT NULL,
+    role TEXT NOT NULL CHECK (role IN ('viewer', 'editor', 'owner')),
+    PRIMARY KEY (team, member)
+);
+
+CREATE INDEX team_members_member_idx ON team_members (member);
+
+CREATE TABLE templates (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(50) NOT NULL UNIQUE,
+    image TEXT NOT NULL DEFAULT '',
+    egress TEXT NOT NULL DEFAULT 'internet' CHECK (egress IN ('internet', 'internal', 'none')),
+    -- Snapshot policy of the template's workspaces: a snapshot every
+    -- snapshot_interval minutes, or none if 0, keeping the latest
+    -- snapshot_retention scheduled snapshots
+    snapshot_interval INT NOT NULL DEFAULT 0 CHECK (snapshot_interval >= 0),
+    snapshot_retention INT NOT NULL DEFAULT 7 CHECK (snapshot_retention >= 1)
+);
+
+INSERT INTO templates (name) VALUES ('default');
+
 CREATE TABLE workspaces (
     id SERIAL PRIMARY KEY,
     name VARCHAR(50) NOT NULL,
     owner INT REFERENCES users (id) NOT NULL,
-    PRIMARY KEY (owner, name)
-);
\ No newline at end of file
+    team INT REFERENCES teams (id),
+    template INT REFERENCES templates (id) NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    labels JSONB NOT NULL DEFAULT '{}',
+    -- Resources of the workspace as Kubernetes resource quantities, or ''
+    -- for the defaults
+    cpu TEXT NOT NULL DEFAULT '',
+    memory TEXT NOT NULL DEFAULT '',
+    storage TEXT NOT NULL DEFAULT '',
+    -- Progress of the operations on the workspace's cluster resources
+    state TEXT NOT NULL DEFAULT 'creating' CHECK (state IN ('creating', 'cloning', 'ready', 'resizing', 'restoring', 'importing', 'failed')),
+    state_message TEXT NOT NULL DEFAULT '', -- Details of the state, such as the error the workspace failed with
+    source INT REFERENCES workspaces (id) ON DELETE SET NULL, -- Workspace it was cloned from, if any
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_active_at TIMESTAMPTZ NOT NULL DEFAULT now(), -- Last time the workspace was opened or changed
+    UNIQUE (owner, name)
+);
+
+-- Workspaces are listed through the roles users hold in them, filtered by
+-- their labels, and paged through in the order of a sort key and their ID.
+-- Descending sorts scan the sort indexes backwards.
+CREATE INDEX workspaces_team_idx ON workspaces (team);
+CREATE INDEX workspaces_labels_idx ON workspaces USING gin (labels jsonb_path_ops);
+CREATE INDEX workspaces_name_idx ON workspaces (name, id);
+CREATE INDEX workspaces_created_at_idx ON workspaces (created_at, id);
+CREATE INDEX workspaces_last_active_at_idx ON workspaces (last_active_at, id);
+
+CREATE TABLE workspace_members (
+    workspace INT REFERENCES workspaces (id) ON DELETE CASCADE NOT NULL,
+    member INT REFERENCES users (id) ON DELETE CASCADE NOT NULL,
+    role TEXT NOT NULL CHECK (role IN ('viewer', 'editor')),
+    PRIMARY KEY (workspace, member)
+);
+
+CREATE INDEX workspace_members_member_idx ON workspace_members (member);
+
+-- Snapshots of workspaces' volumes. The snapshots are kept by the cluster,
+-- and listed from here so that listing them doesn't reach the cluster.
+CREATE TABLE snapshots (
+    id SERIAL PRIMARY KEY,
+    workspace INT REFERENCES workspaces (id) ON DELETE CASCADE NOT NULL,
+    name VARCHAR(50) NOT NULL,
+    scheduled BOOLEAN NOT NULL DEFAULT false, -- Taken by the snapshot policy of the workspace's template
+    state TEXT NOT NULL DEFAULT 'pending' CHECK (state IN ('pending', 'ready', 'restoring', 'failed')), -- Restoring snapshots can't be removed
+    state_message TEXT NOT NULL DEFAULT '', -- Error the snapshot failed with, if it did
+    size TEXT NOT NULL DEFAULT '', -- Size of a volume restored from the snapshot, once it's ready
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX snapshots_workspace_idx ON snapshots (workspace, created_at);
+
+-- Roles users hold in workspaces, through ownership, direct membership,
+-- or membership of the team owning the workspace
+CREATE VIEW workspace_roles AS
+    SELECT id AS workspace, owner AS member, 'owner'::text AS role FROM workspaces
+    UNION ALL
+    SELECT workspace, member, role FROM workspace_members
+    UNION ALL
+    SELECT w.id, t.member, t.role FROM workspaces w JOIN team_members t ON t.team = w.team;
+
+-- Payloads of pub/sub messages too large for a NOTIFY, which carries
+-- their ID instead
+CREATE TABLE pubsub_payloads (
+    id BIGSERIAL PRIMARY KEY,
+    channel TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- Queue of long-running cluster operations. A job is claimed by moving
+-- run_at past the time a worker needs to finish it, so jobs of workers
+-- that die are picked up again. Of the unfinished jobs with the same
+-- target, only the oldest is claimed, and a job's outcome is only recorded
+-- by the worker holding its latest claim. Jobs outlive the users who queued
+-- them, so that deleting an account doesn't strand the work it started.
+CREATE TABLE jobs (
+    id BIGSERIAL PRIMARY KEY,
+    kind TEXT NOT NULL,
+    payload JSONB NOT NULL,
+    owner INT REFERENCES users (id) ON DELETE SET NULL, -- User who queued the job, until they are deleted
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'running', 'succeeded', 'dead')),
+    attempts INT NOT NULL DEFAULT 0,
+    max_attempts INT NOT NULL,
+    last_error TEXT NOT NULL DEFAULT '',
+    run_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    traceparent TEXT NOT NULL DEFAULT '', -- W3C trace context of the request that queued the job
+    target TEXT NOT NULL DEFAULT '' -- What the job acts on, such as a workspace, if jobs on it must run in order
+);
+
+CREATE INDEX jobs_run_at_idx ON jobs (run_at) WHERE status IN ('pending', 'running');
+CREATE INDEX jobs_target_idx ON jobs (target, id) WHERE status IN ('pending', 'running') AND target <> '';
+
+-- Record of user and admin actions. Rows can't be changed or removed, and
+-- actors aren't foreign keys so that events outlive deleted users.
+CREATE TABLE audit_events (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    actor INT, -- User performing the action, if known
+    action TEXT NOT NULL,
+    target TEXT NOT NULL DEFAULT '',
+    ip TEXT NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    request_id TEXT NOT NULL DEFAULT '',
+    outcome TEXT NOT NULL CHECK (outcome IN ('success', 'failure', 'denied')),
+    details JSONB NOT NULL DEFAULT '{}'
+);
+
+CREATE INDEX audit_events_actor_idx ON audit_events (actor, id);
+CREATE INDEX audit_events_action_idx ON audit_events (action, id);
+
+CREATE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_events_append_only
+    BEFORE UPDATE OR DELETE ON audit_events
+    FOR EACH ROW EXECUTE FUNCTION audit_events_append_only();
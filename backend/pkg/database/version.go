@@ -0,0 +1,40 @@
+// Package database holds the server's schema and the queries generated
+// from it.
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/alexliesenfeld/health"
+)
+
+// SchemaVersion is the version of schema.sql the server is built for.
+const SchemaVersion = 11
+
+// VersionFinder finds the version of the database's schema.
+type VersionFinder interface {
+	FindSchemaVersion(ctx context.Context) (int32, error)
+}
+
+// HealthCheck checks that the database's schema is compatible with the
+// server. Schema changes are applied before the servers using them roll
+// out, so a newer schema than the server's is compatible but an older one
+// isn't.
+func HealthCheck(db VersionFinder) health.Check {
+	return health.Check{
+		Name:    "schema",
+		Timeout: 2 * time.Second,
+		Check: func(ctx context.Context) error {
+			version, err := db.FindSchemaVersion(ctx)
+			if err != nil {
+				return fmt.Errorf("unable to find schema version: %v", err)
+			}
+			if version < SchemaVersion {
+				return fmt.Errorf("schema version %v is older than the required version %v", version, SchemaVersion)
+			}
+			return nil
+		},
+	}
+}
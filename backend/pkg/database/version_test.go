@@ -0,0 +1,43 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeVersion finds a fixed schema version, or fails.
+type fakeVersion struct {
+	version int32
+	err     error
+}
+
+func (f fakeVersion) FindSchemaVersion(ctx context.Context) (int32, error) {
+	return f.version, f.err
+}
+
+func TestHealthCheck(t *testing.T) {
+	tests := []struct {
+		description string
+		db          fakeVersion
+		wantErr     bool
+	}{
+		{"Same version", fakeVersion{version: SchemaVersion}, false},
+		{"Newer schema", fakeVersion{version: SchemaVersion + 1}, false},
+		{"Older schema", fakeVersion{version: SchemaVersion - 1}, true},
+		{"Missing version", fakeVersion{err: errors.New(`relation "schema_version" does not exist`)}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			err := HealthCheck(test.db).Check(context.Background())
+			if test.wantErr {
+				require.NotNil(t, err)
+			} else {
+				require.Nil(t, err)
+			}
+		})
+	}
+}
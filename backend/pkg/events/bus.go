@@ -0,0 +1,48 @@
+package events
+
+import (
+	"context"
+
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/pubsub"
+)
+
+// Topic workspace events are sent between replicas on.
+var topic = pubsub.NewTopic[notification]("workspace_events")
+
+// notification is an Event as it is sent between replicas.
+type notification struct {
+	Type      string  `json:"type"`
+	Workspace int32   `json:"workspace"`
+	Status    string  `json:"status,omitempty"`
+	Users     []int32 `json:"users"`
+}
+
+// BusNotifier sends events between API replicas over a pubsub.Bus.
+type BusNotifier struct {
+	bus pubsub.Bus
+}
+
+// NewBusNotifier returns a BusNotifier delivering the events it receives to
+// a Broker.
+func NewBusNotifier(bus pubsub.Bus, broker *Broker) *BusNotifier {
+	topic.Subscribe(bus, func(n notification) {
+		broker.Deliver(Event{
+			Type:      n.Type,
+			Workspace: n.Workspace,
+			Status:    n.Status,
+			Users:     n.Users,
+		})
+	})
+
+	return &BusNotifier{bus: bus}
+}
+
+// Notify sends an event to every replica.
+func (n *BusNotifier) Notify(event Event) error {
+	return topic.Publish(context.Background(), n.bus, notification{
+		Type:      event.Type,
+		Workspace: event.Workspace,
+		Status:    event.Status,
+		Users:     event.Users,
+	})
+}
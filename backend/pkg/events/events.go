@@ -0,0 +1,230 @@
+package events
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// Types of workspace events.
+const (
+	WorkspaceCreated = "workspace.created"
+	WorkspaceUpdated = "workspace.updated"
+	WorkspaceDeleted = "workspace.deleted"
+	WorkspaceStatus  = "workspace.status"
+)
+
+// Size of each subscription's buffer. A subscriber that falls this far
+// behind is dropped and has to reconnect.
+const subscriptionBuffer = 64
+
+// Event is a change to a workspace pushed to the users who can see it.
+type Event struct {
+	ID        string  `json:"-"`                // ID clients resume from, assigned by the Broker
+	Type      string  `json:"type"`             // Type of event
+	Workspace int32   `json:"workspace"`        // ID of the workspace that changed
+	Status    string  `json:"status,omitempty"` // Phase of the workspace's pod, for WorkspaceStatus events
+	Users     []int32 `json:"-"`                // Users the event is delivered to
+}
+
+// Notifier sends events to every API replica. Events it receives, including
+// the ones it sent, are passed back to Broker.Deliver.
+type Notifier interface {
+	Notify(event Event) error
+}
+
+// Broker fans events out to the subscriptions of the users they concern. It
+// keeps a history of recent events so that clients can resume a stream, and
+// the latest status of each workspace for clients whose last event has
+// fallen out of the history.
+type Broker struct {
+	mu          sync.Mutex
+	instance    string                               // Prefix of the IDs this broker assigns
+	sequence    uint64                               // Number of events delivered
+	history     []Event                              // Recent events, oldest first
+	historySize int                                  // Maximum length of history
+	latest      map[int32]Event                      // Latest status event of each workspace
+	subscribers map[int32]map[*Subscription]struct{} // Subscriptions by user
+
+	notifier Notifier // Sends events to other replicas, if set
+}
+
+// NewBroker returns a Broker remembering up to historySize events.
+func NewBroker(historySize int) *Broker {
+	instance := make([]byte, 4)
+	rand.Read(instance)
+
+	return &Broker{
+		instance:    hex.EncodeToString(instance),
+		historySize: historySize,
+		latest:      make(map[int32]Event),
+		subscribers: make(map[int32]map[*Subscription]struct{}),
+	}
+}
+
+// SetNotifier makes Publish send events through a Notifier instead of
+// delivering them directly.
+func (b *Broker) SetNotifier(notifier Notifier) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.notifier = notifier
+}
+
+// Publish sends an event to the subscribers of every replica. Without a
+// Notifier, the event is only delivered locally.
+func (b *Broker) Publish(event Event) error {
+	b.mu.Lock()
+	notifier := b.notifier
+	b.mu.Unlock()
+
+	if notifier == nil {
+		b.Deliver(event)
+		return nil
+	}
+
+	if err := notifier.Notify(event); err != nil {
+		return fmt.Errorf("unable to publish %v event of workspace %v: %v", event.Type, event.Workspace, err)
+	}
+	return nil
+}
+
+// Deliver sends an event to this replica's subscribers only. It is used for
+// events every replica observes on its own, such as pod status changes.
+func (b *Broker) Deliver(event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.sequence++
+	event.ID = fmt.Sprintf("%s-%d", b.instance, b.sequence)
+
+	b.history = append(b.history, event)
+	if len(b.history) > b.historySize {
+		b.history = b.history[len(b.history)-b.historySize:]
+	}
+
+	switch event.Type {
+	case WorkspaceStatus:
+		b.latest[event.Workspace] = event
+	case WorkspaceDeleted:
+		delete(b.latest, event.Workspace)
+	}
+
+	for _, user := range event.Users {
+		for sub := range b.subscribers[user] {
+			select {
+			case sub.events <- event:
+			default:
+				// Drop subscribers that can't keep up rather than block everyone else
+				b.remove(sub)
+			}
+		}
+	}
+}
+
+// Subscribe returns a subscription to a user's events. If lastEventID is set,
+// the events the user missed since then are queued first. If that event is
+// no longer known, the latest status of each of the user's workspaces is
+// queued instead. The subscription's buffer holds all of those on top of
+// subscriptionBuffer, so queuing them never blocks the broker.
+func (b *Broker) Subscribe(user int32, lastEventID string) *Subscription {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var replay []Event
+	if lastEventID != "" {
+		replay = b.missed(user, lastEventID)
+	}
+
+	sub := &Subscription{
+		broker: b,
+		user:   user,
+		events: make(chan Event, subscriptionBuffer+max(b.historySize, len(replay))),
+	}
+	for _, event := range replay {
+		sub.events <- event
+	}
+
+	if b.subscribers[user] == nil {
+		b.subscribers[user] = make(map[*Subscription]struct{})
+	}
+	b.subscribers[user][sub] = struct{}{}
+
+	return sub
+}
+
+// missed returns the events of a user after lastEventID.
+func (b *Broker) missed(user int32, lastEventID string) []Event {
+	var events []Event
+
+	i := slices.IndexFunc(b.history, func(event Event) bool { return event.ID == lastEventID })
+	if i >= 0 {
+		for _, event := range b.history[i+1:] {
+			if slices.Contains(event.Users, user) {
+				events = append(events, event)
+			}
+		}
+		return events
+	}
+
+	for _, event := range b.latest {
+		if slices.Contains(event.Users, user) {
+			events = append(events, event)
+		}
+	}
+	slices.SortFunc(events, func(a, b Event) int { return int(a.Workspace - b.Workspace) })
+	return events
+}
+
+// StatusCounts returns the number of workspaces whose latest status is
+// each phase.
+func (b *Broker) StatusCounts() map[string]int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	counts := make(map[string]int)
+	for _, event := range b.latest {
+		counts[event.Status]++
+	}
+	return counts
+}
+
+// remove closes a subscription. The caller must hold b.mu.
+func (b *Broker) remove(sub *Subscription) {
+	subs, ok := b.subscribers[sub.user]
+	if !ok {
+		return
+	}
+	if _, ok := subs[sub]; !ok {
+		return
+	}
+
+	delete(subs, sub)
+	if len(subs) == 0 {
+		delete(b.subscribers, sub.user)
+	}
+	close(sub.events)
+}
+
+// Subscription receives the events of one user.
+type Subscription struct {
+	broker *Broker
+	user   int32
+	events chan Event
+}
+
+// Events returns the channel events are received on. It is closed when
+// the subscription is closed or falls too far behind.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Close stops the subscription.
+func (s *Subscription) Close() {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+
+	s.broker.remove(s)
+}
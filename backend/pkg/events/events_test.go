@@ -0,0 +1,189 @@
+package events
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/pubsub"
+	"github.com/stretchr/testify/require"
+)
+
+// received returns the events queued on a subscription.
+func received(sub *Subscription) []Event {
+	var events []Event
+	for {
+		select {
+		case event, ok := <-sub.Events():
+			if !ok {
+				return events
+			}
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func TestBrokerDeliver(t *testing.T) {
+	broker := NewBroker(16)
+
+	alice := broker.Subscribe(1, "")
+	bob := broker.Subscribe(2, "")
+
+	broker.Deliver(Event{Type: WorkspaceCreated, Workspace: 7, Users: []int32{1}})
+	broker.Deliver(Event{Type: WorkspaceStatus, Workspace: 8, Status: "Running", Users: []int32{1, 2}})
+
+	have := received(alice)
+	require.Len(t, have, 2)
+	require.Equal(t, int32(7), have[0].Workspace)
+	require.Equal(t, int32(8), have[1].Workspace)
+	require.NotEqual(t, have[0].ID, have[1].ID)
+
+	have = received(bob)
+	require.Len(t, have, 1)
+	require.Equal(t, "Running", have[0].Status)
+
+	// Closed subscriptions receive nothing more
+	bob.Close()
+	broker.Deliver(Event{Type: WorkspaceStatus, Workspace: 8, Status: "Failed", Users: []int32{2}})
+	require.Empty(t, received(bob))
+}
+
+func TestBrokerResume(t *testing.T) {
+	broker := NewBroker(3)
+
+	sub := broker.Subscribe(1, "")
+	broker.Deliver(Event{Type: WorkspaceStatus, Workspace: 1, Status: "Pending", Users: []int32{1}})
+	first := received(sub)[0]
+	sub.Close()
+
+	broker.Deliver(Event{Type: WorkspaceStatus, Workspace: 2, Status: "Pending", Users: []int32{2}})
+	broker.Deliver(Event{Type: WorkspaceStatus, Workspace: 1, Status: "Running", Users: []int32{1}})
+
+	// Missed events of the user are replayed
+	sub = broker.Subscribe(1, first.ID)
+	have := received(sub)
+	require.Len(t, have, 1)
+	require.Equal(t, "Running", have[0].Status)
+	sub.Close()
+
+	broker.Deliver(Event{Type: WorkspaceStatus, Workspace: 3, Status: "Pending", Users: []int32{1}})
+	broker.Deliver(Event{Type: WorkspaceDeleted, Workspace: 2, Users: []int32{2}})
+
+	// Once the last event is forgotten, the latest status of each workspace is sent
+	sub = broker.Subscribe(1, first.ID)
+	have = received(sub)
+	require.Len(t, have, 2)
+	require.Equal(t, int32(1), have[0].Workspace)
+	require.Equal(t, "Running", have[0].Status)
+	require.Equal(t, int32(3), have[1].Workspace)
+
+	// Deleted workspaces are forgotten
+	sub = broker.Subscribe(2, "unknown")
+	require.Empty(t, received(sub))
+}
+
+func TestBrokerDropsSlowSubscribers(t *testing.T) {
+	broker := NewBroker(1)
+	sub := broker.Subscribe(1, "")
+
+	for i := 0; i <= subscriptionBuffer+1; i++ {
+		broker.Deliver(Event{Type: WorkspaceStatus, Workspace: 1, Users: []int32{1}})
+	}
+
+	// The subscription's channel is closed once it is full
+	require.Len(t, received(sub), subscriptionBuffer+1)
+	_, ok := <-sub.Events()
+	require.False(t, ok)
+
+	// Closing a dropped subscription is harmless
+	sub.Close()
+}
+
+type notifierFunc func(event Event) error
+
+func (f notifierFunc) Notify(event Event) error {
+	return f(event)
+}
+
+func TestBrokerStatusCounts(t *testing.T) {
+	broker := NewBroker(16)
+
+	broker.Deliver(Event{Type: WorkspaceStatus, Workspace: 1, Status: "Pending"})
+	broker.Deliver(Event{Type: WorkspaceStatus, Workspace: 2, Status: "Running"})
+	broker.Deliver(Event{Type: WorkspaceStatus, Workspace: 3, Status: "Running"})
+	broker.Deliver(Event{Type: WorkspaceStatus, Workspace: 1, Status: "Running"})
+	broker.Deliver(Event{Type: WorkspaceDeleted, Workspace: 3})
+
+	require.Equal(t, map[string]int{"Running": 2}, broker.StatusCounts())
+}
+
+func TestBrokerPublish(t *testing.T) {
+	broker := NewBroker(16)
+	sub := broker.Subscribe(1, "")
+
+	// Without a notifier, events are delivered directly
+	require.Nil(t, broker.Publish(Event{Type: WorkspaceCreated, Workspace: 1, Users: []int32{1}}))
+	require.Len(t, received(sub), 1)
+
+	// With a notifier, events are only delivered once they come back
+	var sent []Event
+	broker.SetNotifier(notifierFunc(func(event Event) error {
+		sent = append(sent, event)
+		return nil
+	}))
+	require.Nil(t, broker.Publish(Event{Type: WorkspaceCreated, Workspace: 2, Users: []int32{1}}))
+	require.Empty(t, received(sub))
+	require.Len(t, sent, 1)
+
+	broker.SetNotifier(notifierFunc(func(event Event) error {
+		return errors.New("connection lost")
+	}))
+	require.NotNil(t, broker.Publish(Event{Type: WorkspaceCreated, Workspace: 3, Users: []int32{1}}))
+}
+
+func TestBusNotifier(t *testing.T) {
+	bus := pubsub.NewMemory()
+
+	// Two replicas sharing a bus
+	brokers := []*Broker{NewBroker(16), NewBroker(16)}
+	for _, broker := range brokers {
+		broker.SetNotifier(NewBusNotifier(bus, broker))
+	}
+
+	subs := []*Subscription{brokers[0].Subscribe(1, ""), brokers[1].Subscribe(1, "")}
+
+	require.Nil(t, brokers[0].Publish(Event{Type: WorkspaceCreated, Workspace: 2, Users: []int32{1}}))
+
+	for _, sub := range subs {
+		have := received(sub)
+		require.Len(t, have, 1)
+		require.Equal(t, WorkspaceCreated, have[0].Type)
+		require.Equal(t, int32(2), have[0].Workspace)
+		require.Equal(t, []int32{1}, have[0].Users)
+	}
+}
+
+func TestBrokerResumeManyWorkspaces(t *testing.T) {
+	broker := NewBroker(1)
+
+	// The user has more workspaces than a subscription buffers
+	workspaces := subscriptionBuffer * 2
+	for i := 1; i <= workspaces; i++ {
+		broker.Deliver(Event{Type: WorkspaceStatus, Workspace: int32(i), Status: "Running", Users: []int32{1}})
+	}
+
+	done := make(chan *Subscription)
+	go func() { done <- broker.Subscribe(1, "unknown") }()
+
+	select {
+	case sub := <-done:
+		require.Len(t, received(sub), workspaces)
+	case <-time.After(time.Second):
+		t.Fatal("Subscribing blocked on queuing the latest statuses")
+	}
+
+	// The broker still delivers events
+	broker.Deliver(Event{Type: WorkspaceStatus, Workspace: 1, Status: "Succeeded", Users: []int32{1}})
+}
@@ -0,0 +1,379 @@
+// Package jobs runs long-running operations from a queue stored in Postgres,
+// retrying them with exponential backoff.
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/database/repository"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/logging"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Job statuses.
+const (
+	StatusPending   = "pending"
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusDead      = "dead" // Failed permanently or ran out of attempts
+)
+
+// Defaults of a Queue.
+const (
+	defaultMaxAttempts  = 5
+	defaultTimeout      = 5 * time.Minute
+	defaultPollInterval = time.Second
+	minBackoff          = 5 * time.Second
+	maxBackoff          = 10 * time.Minute
+)
+
+// Name of the tracer of job runs.
+const tracerName = "github.com/johngerving/kubernetes-web-client/backend/pkg/jobs"
+
+// Propagates the trace of the request queuing a job to its runs.
+var traceContext = propagation.TraceContext{}
+
+// Time a job's lock outlasts its timeout, so a job is never claimed again
+// while its handler is still returning.
+const lockGrace = 30 * time.Second
+
+// Time recording the outcome of an attempt may take, even once the worker
+// is stopping.
+const finishTimeout = 10 * time.Second
+
+// Handler runs a job with its payload. Returning an error retries the job
+// unless the error is Permanent.
+type Handler func(ctx context.Context, payload []byte) error
+
+// DeadHandler is told that a job is dead, with its payload and the error of
+// its last attempt, so that what the job acted on can be marked as failed.
+type DeadHandler func(ctx context.Context, payload []byte, jobErr error)
+
+// Targeted is implemented by payloads of jobs acting on a target, such as a
+// workspace. Of the unfinished jobs with the same target, only the oldest
+// is claimed, so they run one at a time in the order they were enqueued.
+type Targeted interface {
+	JobTarget() string
+}
+
+// permanentError is an error that retrying won't fix.
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent marks an error so that the job failing with it is dead-lettered
+// instead of retried.
+func Permanent(err error) error {
+	return permanentError{err: err}
+}
+
+// Creator adds jobs. A *repository.Queries made with WithTx adds them in a
+// transaction.
+type Creator interface {
+	CreateJob(ctx context.Context, arg repository.CreateJobParams) (repository.Job, error)
+}
+
+// store holds the jobs of a Queue.
+type store interface {
+	Creator
+	ClaimJob(ctx context.Context, lockUntil pgtype.Timestamptz) (repository.Job, error)
+	CompleteJob(ctx context.Context, arg repository.CompleteJobParams) (int64, error)
+	RetryJob(ctx context.Context, arg repository.RetryJobParams) (int64, error)
+	KillJob(ctx context.Context, arg repository.KillJobParams) (int64, error)
+	DeleteFinishedJobs(ctx context.Context, updatedAt pgtype.Timestamptz) error
+}
+
+// Queue enqueues jobs and runs them. Workers on several replicas can run
+// the same queue, since jobs are claimed with SELECT ... FOR UPDATE SKIP
+// LOCKED.
+//
+// Jobs enqueued with EnqueueTx are written in the same transaction as the
+// change they act on, so the jobs table doubles as a transactional outbox:
+// a change is never committed without the job applying it to the cluster.
+// A job whose worker dies is claimed again once its lock expires, so jobs
+// run at least once and their handlers must be idempotent. Only the worker
+// holding a job's latest claim records its outcome.
+//
+// Jobs whose payloads are Targeted run one at a time per target, so a job
+// removing a workspace never runs alongside one creating it.
+type Queue struct {
+	repository store
+	now        func() time.Time // Clock used for locks and backoff
+
+	MaxAttempts  int32         // Attempts before a job is dead-lettered
+	Timeout      time.Duration // Time a job may run before it is canceled
+	PollInterval time.Duration // Time between checks for jobs when the queue is empty
+
+	mu           sync.Mutex
+	handlers     map[string]Handler
+	deadHandlers map[string]DeadHandler
+}
+
+// NewQueue returns a Queue storing jobs with a repository.
+func NewQueue(repo *repository.Queries) *Queue {
+	return &Queue{
+		repository:   repo,
+		now:          time.Now,
+		MaxAttempts:  defaultMaxAttempts,
+		Timeout:      defaultTimeout,
+		PollInterval: defaultPollInterval,
+		handlers:     make(map[string]Handler),
+		deadHandlers: make(map[string]DeadHandler),
+	}
+}
+
+// Handle registers the handler of a kind of job.
+func (q *Queue) Handle(kind string, handler Handler) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.handlers[kind] = handler
+}
+
+// handler returns the handler of a kind of job.
+func (q *Queue) handler(kind string) (Handler, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	handler, ok := q.handlers[kind]
+	return handler, ok
+}
+
+// HandleDead registers the handler told when a kind of job is dead.
+func (q *Queue) HandleDead(kind string, handler DeadHandler) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.deadHandlers[kind] = handler
+}
+
+// deadHandler returns the handler told when a kind of job is dead.
+func (q *Queue) deadHandler(kind string) (DeadHandler, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	handler, ok := q.deadHandlers[kind]
+	return handler, ok
+}
+
+// Enqueue adds a job on behalf of a user, encoding its payload as JSON.
+func (q *Queue) Enqueue(ctx context.Context, kind string, owner int32, payload any) (repository.Job, error) {
+	return q.EnqueueTx(ctx, q.repository, kind, owner, payload)
+}
+
+// EnqueueTx adds a job with a Creator, such as repository queries in a
+// transaction. The job only runs if the transaction commits.
+func (q *Queue) EnqueueTx(ctx context.Context, tx Creator, kind string, owner int32, payload any) (repository.Job, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return repository.Job{}, fmt.Errorf("unable to encode %v job: %v", kind, err)
+	}
+
+	// Runs of the job continue the trace of the request queuing it
+	carrier := propagation.MapCarrier{}
+	traceContext.Inject(ctx, carrier)
+
+	var target string
+	if targeted, ok := payload.(Targeted); ok {
+		target = targeted.JobTarget()
+	}
+
+	job, err := tx.CreateJob(ctx, repository.CreateJobParams{
+		Kind:        kind,
+		Payload:     data,
+		Owner:       pgtype.Int4{Int32: owner, Valid: true},
+		MaxAttempts: q.MaxAttempts,
+		Traceparent: carrier.Get("traceparent"),
+		Target:      target,
+	})
+	if err != nil {
+		return repository.Job{}, fmt.Errorf("unable to enqueue %v job: %v", kind, err)
+	}
+
+	return job, nil
+}
+
+// Work runs jobs until the context is canceled.
+func (q *Queue) Work(ctx context.Context) {
+	for {
+		ran, err := q.runNext(ctx)
+		if err != nil && ctx.Err() == nil {
+			slog.ErrorContext(ctx, "error running job", "err", err)
+		}
+
+		// Keep going while there is work
+		if ran && err == nil {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(q.PollInterval):
+		}
+	}
+}
+
+// runNext claims and runs a job, returning false if none is due.
+func (q *Queue) runNext(ctx context.Context) (bool, error) {
+	lockUntil := q.now().Add(q.Timeout + lockGrace)
+	job, err := q.repository.ClaimJob(ctx, pgtype.Timestamptz{Time: lockUntil, Valid: true})
+	if err == pgx.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("unable to claim job: %v", err)
+	}
+
+	// A worker may have died running the job's last attempt
+	if job.Attempts > job.MaxAttempts {
+		return true, q.finish(ctx, job, errors.New("worker stopped during the last attempt"))
+	}
+
+	handler, ok := q.handler(job.Kind)
+	if !ok {
+		return true, q.finish(ctx, job, Permanent(fmt.Errorf("unknown job kind %v", job.Kind)))
+	}
+
+	// Log the job's lines on behalf of the user who queued it, unless
+	// they have since been deleted
+	if job.Owner.Valid {
+		ctx = logging.WithUser(ctx, job.Owner.Int32)
+	}
+	ctx, span := startSpan(ctx, job)
+	defer span.End()
+
+	jobCtx, cancel := context.WithTimeout(ctx, q.Timeout)
+	defer cancel()
+
+	jobErr := handler(jobCtx, job.Payload)
+	if jobErr != nil {
+		span.RecordError(jobErr)
+		span.SetStatus(codes.Error, jobErr.Error())
+	}
+	return true, q.finish(ctx, job, jobErr)
+}
+
+// startSpan starts the span of an attempt at a job, as part of the trace
+// of the request that queued it.
+func startSpan(ctx context.Context, job repository.Job) (context.Context, trace.Span) {
+	ctx = traceContext.Extract(ctx, propagation.MapCarrier{"traceparent": job.Traceparent})
+	return otel.Tracer(tracerName).Start(ctx, "job "+job.Kind,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.Int64("job.id", job.ID),
+			attribute.String("job.kind", job.Kind),
+			attribute.Int("job.attempt", int(job.Attempts)),
+		),
+	)
+}
+
+// finish records the outcome of an attempt at a job, unless the job was
+// claimed again since the attempt started. It runs on its own deadline, so
+// outcomes are recorded while the worker stops.
+func (q *Queue) finish(ctx context.Context, job repository.Job, jobErr error) error {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), finishTimeout)
+	defer cancel()
+
+	var err error
+	status, runAt := outcome(job, jobErr, q.now())
+	switch status {
+	case StatusSucceeded:
+		_, err = q.repository.CompleteJob(ctx, repository.CompleteJobParams{ID: job.ID, Attempts: job.Attempts})
+	case StatusPending:
+		slog.WarnContext(ctx, "job failed, retrying", "job", job.ID, "kind", job.Kind, "attempt", job.Attempts, "run_at", runAt, "err", jobErr)
+		_, err = q.repository.RetryJob(ctx, repository.RetryJobParams{
+			ID:        job.ID,
+			RunAt:     pgtype.Timestamptz{Time: runAt, Valid: true},
+			LastError: jobErr.Error(),
+			Attempts:  job.Attempts,
+		})
+	default:
+		slog.ErrorContext(ctx, "job failed permanently", "job", job.ID, "kind", job.Kind, "attempt", job.Attempts, "err", jobErr)
+		_, err = q.repository.KillJob(ctx, repository.KillJobParams{ID: job.ID, LastError: jobErr.Error(), Attempts: job.Attempts})
+	}
+
+	if err == pgx.ErrNoRows {
+		// The lock expired and another worker claimed the job, whose
+		// attempt's outcome counts instead
+		slog.WarnContext(ctx, "job was claimed again before its attempt finished", "job", job.ID, "kind", job.Kind, "attempt", job.Attempts)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to record outcome of job %v: %v", job.ID, err)
+	}
+
+	if handler, ok := q.deadHandler(job.Kind); ok && status == StatusDead {
+		handler(ctx, job.Payload, jobErr)
+	}
+	return nil
+}
+
+// outcome returns the status of a job after an attempt ending with jobErr,
+// and when it is retried if it is.
+func outcome(job repository.Job, jobErr error, now time.Time) (string, time.Time) {
+	if jobErr == nil {
+		return StatusSucceeded, time.Time{}
+	}
+
+	var permanent permanentError
+	if errors.As(jobErr, &permanent) || job.Attempts >= job.MaxAttempts {
+		return StatusDead, time.Time{}
+	}
+
+	return StatusPending, now.Add(backoff(job.Attempts))
+}
+
+// backoff returns the time to wait before retrying a job after a number of
+// attempts, doubling with each attempt.
+func backoff(attempts int32) time.Duration {
+	delay := float64(minBackoff) * math.Pow(2, float64(attempts-1))
+	if delay > float64(maxBackoff) {
+		return maxBackoff
+	}
+	return time.Duration(delay)
+}
+
+// RemoveFinished periodically deletes jobs that finished longer than a
+// retention period ago, until the context is canceled. It only needs to
+// run on one replica.
+func (q *Queue) RemoveFinished(retention time.Duration) func(ctx context.Context) {
+	return func(ctx context.Context) {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+
+		for {
+			before := pgtype.Timestamptz{Time: q.now().Add(-retention), Valid: true}
+			if err := q.repository.DeleteFinishedJobs(ctx, before); err != nil && ctx.Err() == nil {
+				slog.ErrorContext(ctx, "error removing finished jobs", "err", err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/database/repository"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int32
+		want     time.Duration
+	}{
+		{1, 5 * time.Second},
+		{2, 10 * time.Second},
+		{3, 20 * time.Second},
+		{7, 320 * time.Second},
+		{8, 10 * time.Minute},
+		{100, 10 * time.Minute},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%v attempts", test.attempts), func(t *testing.T) {
+			require.Equal(t, test.want, backoff(test.attempts))
+		})
+	}
+}
+
+func TestOutcome(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	failure := errors.New("connection refused")
+
+	tests := []struct {
+		description string // Test description
+		attempts    int32  // Attempts made, including this one
+		err         error  // Error the attempt ended with
+		wantStatus  string
+		wantRunAt   time.Time
+	}{
+		{"Success", 1, nil, StatusSucceeded, time.Time{}},
+		{"First failure", 1, failure, StatusPending, now.Add(5 * time.Second)},
+		{"Later failure", 3, failure, StatusPending, now.Add(20 * time.Second)},
+		{"Last attempt", 5, failure, StatusDead, time.Time{}},
+		{"Permanent failure", 1, Permanent(failure), StatusDead, time.Time{}},
+		{"Wrapped permanent failure", 1, fmt.Errorf("creating pod: %w", Permanent(failure)), StatusDead, time.Time{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			job := repository.Job{Attempts: test.attempts, MaxAttempts: 5}
+
+			status, runAt := outcome(job, test.err, now)
+			require.Equal(t, test.wantStatus, status)
+			require.Equal(t, test.wantRunAt, runAt)
+		})
+	}
+}
+
+func TestPermanent(t *testing.T) {
+	failure := errors.New("invalid payload")
+	err := Permanent(failure)
+
+	require.Equal(t, "invalid payload", err.Error())
+	require.ErrorIs(t, err, failure)
+}
+
+func TestHandle(t *testing.T) {
+	queue := NewQueue(nil)
+
+	_, ok := queue.handler("foo")
+	require.False(t, ok)
+
+	queue.Handle("foo", func(ctx context.Context, payload []byte) error { return nil })
+	_, ok = queue.handler("foo")
+	require.True(t, ok)
+}
+
+// fakeStore records the outcomes of jobs.
+type fakeStore struct {
+	store
+	completed []int64
+	retried   []int64
+	killed    []int64
+}
+
+func (s *fakeStore) CompleteJob(ctx context.Context, arg repository.CompleteJobParams) (int64, error) {
+	s.completed = append(s.completed, arg.ID)
+	return arg.ID, nil
+}
+
+func (s *fakeStore) RetryJob(ctx context.Context, arg repository.RetryJobParams) (int64, error) {
+	s.retried = append(s.retried, arg.ID)
+	return arg.ID, nil
+}
+
+func (s *fakeStore) KillJob(ctx context.Context, arg repository.KillJobParams) (int64, error) {
+	s.killed = append(s.killed, arg.ID)
+	return arg.ID, nil
+}
+
+func TestHandleDead(t *testing.T) {
+	failure := errors.New("connection refused")
+
+	tests := []struct {
+		description string // Test description
+		attempts    int32  // Attempts made, including this one
+		err         error  // Error the attempt ended with
+		wantDead    bool   // Whether the dead handler is told
+	}{
+		{"Success", 1, nil, false},
+		{"Retried failure", 1, failure, false},
+		{"Last attempt", 5, failure, true},
+		{"Permanent failure", 1, Permanent(failure), true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			store := &fakeStore{}
+			queue := NewQueue(nil)
+			queue.repository = store
+
+			var gotPayload []byte
+			var gotErr error
+			queue.HandleDead("foo", func(ctx context.Context, payload []byte, jobErr error) {
+				gotPayload = payload
+				gotErr = jobErr
+			})
+
+			job := repository.Job{ID: 1, Kind: "foo", Payload: []byte(`{}`), Attempts: test.attempts, MaxAttempts: 5}
+			require.NoError(t, queue.finish(context.Background(), job, test.err))
+
+			if test.wantDead {
+				require.Equal(t, []int64{1}, store.killed)
+				require.Equal(t, []byte(`{}`), gotPayload)
+				require.Equal(t, test.err, gotErr)
+			} else {
+				require.Empty(t, store.killed)
+				require.Nil(t, gotPayload)
+			}
+		})
+	}
+}
@@ -0,0 +1,457 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/database/repository"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// errCrash stands in for a worker dying.
+var errCrash = errors.New("worker crashed")
+
+// memoryStore keeps jobs in memory, claiming them the way ClaimJob does.
+// Its methods can be made to fail once to simulate crashes.
+type memoryStore struct {
+	mu   sync.Mutex
+	now  time.Time
+	jobs map[int64]*repository.Job
+	next int64
+
+	crashOnComplete bool // Fail the next CompleteJob
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		now:  time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		jobs: make(map[int64]*repository.Job),
+	}
+}
+
+func (m *memoryStore) clock() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.now
+}
+
+func (m *memoryStore) advance(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.now = m.now.Add(d)
+}
+
+func (m *memoryStore) job(id int64) repository.Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return *m.jobs[id]
+}
+
+func (m *memoryStore) CreateJob(ctx context.Context, arg repository.CreateJobParams) (repository.Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.next++
+	job := &repository.Job{
+		ID:          m.next,
+		Kind:        arg.Kind,
+		Payload:     arg.Payload,
+		Owner:       arg.Owner,
+		Status:      StatusPending,
+		MaxAttempts: arg.MaxAttempts,
+		Traceparent: arg.Traceparent,
+		Target:      arg.Target,
+		RunAt:       pgtype.Timestamptz{Time: m.now, Valid: true},
+	}
+	m.jobs[job.ID] = job
+	return *job, nil
+}
+
+func (m *memoryStore) ClaimJob(ctx context.Context, lockUntil pgtype.Timestamptz) (repository.Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	unfinished := func(job *repository.Job) bool {
+		return job.Status == StatusPending || job.Status == StatusRunning
+	}
+
+	var due []*repository.Job
+	for _, job := range m.jobs {
+		if !unfinished(job) || job.RunAt.Time.After(m.now) {
+			continue
+		}
+
+		// Only the oldest unfinished job of a target is claimed
+		blocked := false
+		for _, earlier := range m.jobs {
+			if job.Target != "" && earlier.Target == job.Target && earlier.ID < job.ID && unfinished(earlier) {
+				blocked = true
+			}
+		}
+		if !blocked {
+			due = append(due, job)
+		}
+	}
+	if len(due) == 0 {
+		return repository.Job{}, pgx.ErrNoRows
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].ID < due[j].ID })
+
+	job := due[0]
+	job.Status = StatusRunning
+	job.Attempts++
+	job.RunAt = lockUntil
+	return *job, nil
+}
+
+// claimed returns a job if the attempt that claimed it is its latest, the
+// way the outcome queries check it.
+func (m *memoryStore) claimed(id int64, attempts int32) (*repository.Job, error) {
+	job := m.jobs[id]
+	if job.Status != StatusRunning || job.Attempts != attempts {
+		return nil, pgx.ErrNoRows
+	}
+	return job, nil
+}
+
+func (m *memoryStore) CompleteJob(ctx context.Context, arg repository.CompleteJobParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if m.crashOnComplete {
+		m.crashOnComplete = false
+		return 0, errCrash
+	}
+	job, err := m.claimed(arg.ID, arg.Attempts)
+	if err != nil {
+		return 0, err
+	}
+	job.Status = StatusSucceeded
+	job.LastError = ""
+	return job.ID, nil
+}
+
+func (m *memoryStore) RetryJob(ctx context.Context, arg repository.RetryJobParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job, err := m.claimed(arg.ID, arg.Attempts)
+	if err != nil {
+		return 0, err
+	}
+	job.Status = StatusPending
+	job.RunAt = arg.RunAt
+	job.LastError = arg.LastError
+	return job.ID, nil
+}
+
+func (m *memoryStore) KillJob(ctx context.Context, arg repository.KillJobParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job, err := m.claimed(arg.ID, arg.Attempts)
+	if err != nil {
+		return 0, err
+	}
+	job.Status = StatusDead
+	job.LastError = arg.LastError
+	return job.ID, nil
+}
+
+func (m *memoryStore) DeleteFinishedJobs(ctx context.Context, updatedAt pgtype.Timestamptz) error {
+	return nil
+}
+
+// cluster is an idempotent stand-in for the controller, counting how often
+// each resource was created.
+type cluster struct {
+	mu       sync.Mutex
+	created  map[string]int
+	failures int // Number of calls to fail before succeeding
+}
+
+func (c *cluster) create(name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.failures > 0 {
+		c.failures--
+		return errors.New("connection refused")
+	}
+	c.created[name]++
+	return nil
+}
+
+func newTestQueue() (*Queue, *memoryStore, *cluster) {
+	store := newMemoryStore()
+	queue := &Queue{
+		repository:  store,
+		now:         store.clock,
+		MaxAttempts: 3,
+		Timeout:     time.Minute,
+		handlers:    make(map[string]Handler),
+	}
+
+	c := &cluster{created: make(map[string]int)}
+	queue.Handle("create", func(ctx context.Context, payload []byte) error {
+		return c.create(string(payload))
+	})
+
+	return queue, store, c
+}
+
+func TestJobRetriedAfterCrashBeforeCompletion(t *testing.T) {
+	queue, store, c := newTestQueue()
+
+	job, err := queue.Enqueue(context.Background(), "create", 1, "workspace-1")
+	require.Nil(t, err)
+
+	// The worker applies the action but dies before recording it
+	store.crashOnComplete = true
+	ran, err := queue.runNext(context.Background())
+	require.True(t, ran)
+	require.NotNil(t, err)
+	require.Equal(t, StatusRunning, store.job(job.ID).Status)
+
+	// No one claims the job while its lock holds
+	ran, err = queue.runNext(context.Background())
+	require.False(t, ran)
+	require.Nil(t, err)
+
+	// Once the lock expires, another worker applies the action again
+	store.advance(queue.Timeout + lockGrace)
+	ran, err = queue.runNext(context.Background())
+	require.True(t, ran)
+	require.Nil(t, err)
+
+	have := store.job(job.ID)
+	require.Equal(t, StatusSucceeded, have.Status)
+	require.Equal(t, int32(2), have.Attempts)
+	require.Equal(t, 2, c.created[`"workspace-1"`])
+}
+
+func TestJobRetriedAfterCrashBeforeRunning(t *testing.T) {
+	queue, store, c := newTestQueue()
+
+	job, err := queue.Enqueue(context.Background(), "create", 1, "workspace-1")
+	require.Nil(t, err)
+
+	// A worker claims the job and dies before running it
+	_, err = store.ClaimJob(context.Background(), pgtype.Timestamptz{Time: store.clock().Add(queue.Timeout + lockGrace), Valid: true})
+	require.Nil(t, err)
+
+	store.advance(queue.Timeout + lockGrace)
+	ran, err := queue.runNext(context.Background())
+	require.True(t, ran)
+	require.Nil(t, err)
+
+	require.Equal(t, StatusSucceeded, store.job(job.ID).Status)
+	require.Equal(t, 1, c.created[`"workspace-1"`])
+}
+
+func TestJobDeadAfterCrashOnLastAttempt(t *testing.T) {
+	queue, store, c := newTestQueue()
+
+	job, err := queue.Enqueue(context.Background(), "create", 1, "workspace-1")
+	require.Nil(t, err)
+
+	// Workers die during every attempt
+	for i := int32(0); i < queue.MaxAttempts; i++ {
+		_, err := store.ClaimJob(context.Background(), pgtype.Timestamptz{Time: store.clock().Add(time.Minute), Valid: true})
+		require.Nil(t, err)
+		store.advance(time.Minute)
+	}
+
+	ran, err := queue.runNext(context.Background())
+	require.True(t, ran)
+	require.Nil(t, err)
+
+	have := store.job(job.ID)
+	require.Equal(t, StatusDead, have.Status)
+	require.Equal(t, "worker stopped during the last attempt", have.LastError)
+	require.Empty(t, c.created)
+}
+
+func TestJobRetriedWithBackoff(t *testing.T) {
+	queue, store, c := newTestQueue()
+	c.failures = 1
+
+	job, err := queue.Enqueue(context.Background(), "create", 1, "workspace-1")
+	require.Nil(t, err)
+
+	ran, err := queue.runNext(context.Background())
+	require.True(t, ran)
+	require.Nil(t, err)
+
+	have := store.job(job.ID)
+	require.Equal(t, StatusPending, have.Status)
+	require.Equal(t, "connection refused", have.LastError)
+	require.Equal(t, store.clock().Add(backoff(1)), have.RunAt.Time)
+
+	// The job waits out its backoff
+	ran, _ = queue.runNext(context.Background())
+	require.False(t, ran)
+
+	store.advance(backoff(1))
+	ran, err = queue.runNext(context.Background())
+	require.True(t, ran)
+	require.Nil(t, err)
+	require.Equal(t, StatusSucceeded, store.job(job.ID).Status)
+}
+
+func TestJobDeadLettered(t *testing.T) {
+	queue, store, c := newTestQueue()
+	c.failures = 10
+
+	job, err := queue.Enqueue(context.Background(), "create", 1, "workspace-1")
+	require.Nil(t, err)
+
+	for i := int32(0); i < queue.MaxAttempts; i++ {
+		ran, err := queue.runNext(context.Background())
+		require.True(t, ran)
+		require.Nil(t, err)
+		store.advance(maxBackoff)
+	}
+
+	have := store.job(job.ID)
+	require.Equal(t, StatusDead, have.Status)
+	require.Equal(t, queue.MaxAttempts, have.Attempts)
+
+	// Dead jobs aren't claimed again
+	ran, _ := queue.runNext(context.Background())
+	require.False(t, ran)
+}
+
+func TestJobOfUnknownKind(t *testing.T) {
+	queue, store, _ := newTestQueue()
+
+	job, err := queue.Enqueue(context.Background(), "unknown", 1, nil)
+	require.Nil(t, err)
+
+	ran, err := queue.runNext(context.Background())
+	require.True(t, ran)
+	require.Nil(t, err)
+	require.Equal(t, StatusDead, store.job(job.ID).Status)
+}
+
+func TestJobContinuesTrace(t *testing.T) {
+	queue, store, _ := newTestQueue()
+
+	var runTrace trace.TraceID
+	queue.Handle("traced", func(ctx context.Context, payload []byte) error {
+		runTrace = trace.SpanContextFromContext(ctx).TraceID()
+		return nil
+	})
+
+	request := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1, 2, 3},
+		SpanID:     trace.SpanID{4, 5, 6},
+		TraceFlags: trace.FlagsSampled,
+	})
+	job, err := queue.Enqueue(trace.ContextWithSpanContext(context.Background(), request), "traced", 1, nil)
+	require.Nil(t, err)
+	require.Equal(t, "00-01020300000000000000000000000000-0405060000000000-01", store.job(job.ID).Traceparent)
+
+	ran, err := queue.runNext(context.Background())
+	require.True(t, ran)
+	require.Nil(t, err)
+	require.Equal(t, request.TraceID(), runTrace)
+}
+
+// targetedPayload is the payload of a job acting on a target.
+type targetedPayload struct {
+	Name   string `json:"name"`
+	Target string `json:"target"`
+}
+
+func (p targetedPayload) JobTarget() string {
+	return p.Target
+}
+
+func TestJobsOfTargetRunInOrder(t *testing.T) {
+	queue, store, c := newTestQueue()
+	c.failures = 1
+
+	first, err := queue.Enqueue(context.Background(), "create", 1, targetedPayload{Name: "first", Target: "workspace/1"})
+	require.Nil(t, err)
+	second, err := queue.Enqueue(context.Background(), "create", 1, targetedPayload{Name: "second", Target: "workspace/1"})
+	require.Nil(t, err)
+	other, err := queue.Enqueue(context.Background(), "create", 1, targetedPayload{Name: "other", Target: "workspace/2"})
+	require.Nil(t, err)
+	require.Equal(t, "workspace/1", store.job(first.ID).Target)
+
+	// The first job fails, and the second waits for it to be retried
+	ran, err := queue.runNext(context.Background())
+	require.True(t, ran)
+	require.Nil(t, err)
+	require.Equal(t, StatusPending, store.job(first.ID).Status)
+
+	ran, err = queue.runNext(context.Background())
+	require.True(t, ran)
+	require.Nil(t, err)
+	require.Equal(t, StatusSucceeded, store.job(other.ID).Status)
+
+	ran, _ = queue.runNext(context.Background())
+	require.False(t, ran)
+	require.Equal(t, StatusPending, store.job(second.ID).Status)
+
+	store.advance(backoff(1))
+	for _, want := range []int64{first.ID, second.ID} {
+		ran, err = queue.runNext(context.Background())
+		require.True(t, ran)
+		require.Nil(t, err)
+		require.Equal(t, StatusSucceeded, store.job(want).Status)
+	}
+}
+
+func TestJobOutcomeOfExpiredClaimIgnored(t *testing.T) {
+	queue, store, _ := newTestQueue()
+
+	job, err := queue.Enqueue(context.Background(), "create", 1, "workspace-1")
+	require.Nil(t, err)
+
+	// A worker's claim expires while it runs the job, and another worker
+	// claims and completes it
+	stale, err := store.ClaimJob(context.Background(), pgtype.Timestamptz{Time: store.clock().Add(queue.Timeout + lockGrace), Valid: true})
+	require.Nil(t, err)
+	store.advance(queue.Timeout + lockGrace)
+	ran, err := queue.runNext(context.Background())
+	require.True(t, ran)
+	require.Nil(t, err)
+
+	// The first worker's failure doesn't overwrite the outcome
+	require.Nil(t, queue.finish(context.Background(), stale, errors.New("connection refused")))
+	have := store.job(job.ID)
+	require.Equal(t, StatusSucceeded, have.Status)
+	require.Empty(t, have.LastError)
+}
+
+func TestJobOutcomeRecordedWhileStopping(t *testing.T) {
+	queue, store, _ := newTestQueue()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	queue.Handle("stop", func(_ context.Context, payload []byte) error {
+		// The worker is told to stop as the job finishes
+		cancel()
+		return nil
+	})
+
+	job, err := queue.Enqueue(context.Background(), "stop", 1, nil)
+	require.Nil(t, err)
+
+	ran, err := queue.runNext(ctx)
+	require.True(t, ran)
+	require.Nil(t, err)
+	require.Equal(t, StatusSucceeded, store.job(job.ID).Status)
+}
@@ -0,0 +1,109 @@
+// Package leader runs background workers on a single API replica at a time.
+package leader
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// Elector elects one leader among the API replicas.
+type Elector interface {
+	// Run campaigns for leadership until the context is canceled. Each time
+	// leadership is acquired, lead is called with a context canceled once
+	// leadership is lost, and must return soon after.
+	Run(ctx context.Context, lead func(ctx context.Context))
+}
+
+// Always is an Elector for a single replica, which always leads.
+type Always struct{}
+
+// Run calls lead with the context until it is canceled.
+func (Always) Run(ctx context.Context, lead func(ctx context.Context)) {
+	lead(ctx)
+}
+
+// worker is a background task that only runs on the leader.
+type worker struct {
+	name string
+	run  func(ctx context.Context)
+}
+
+// Manager starts the workers registered with it when its replica becomes
+// the leader and stops them when leadership is lost.
+type Manager struct {
+	elector Elector
+
+	mu      sync.Mutex
+	workers []worker
+
+	term    sync.Mutex // Held while workers run, so terms never overlap
+	leading atomic.Bool
+}
+
+// NewManager returns a Manager using an Elector.
+func NewManager(elector Elector) *Manager {
+	return &Manager{elector: elector}
+}
+
+// Register adds a worker started with each term of leadership. run must
+// return once its context is canceled. Workers registered during a term are
+// started with the next one.
+func (m *Manager) Register(name string, run func(ctx context.Context)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.workers = append(m.workers, worker{name: name, run: run})
+}
+
+// Workers returns the names of the registered workers.
+func (m *Manager) Workers() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := make([]string, 0, len(m.workers))
+	for _, w := range m.workers {
+		names = append(names, w.name)
+	}
+	return slices.Clip(names)
+}
+
+// IsLeader reports whether this replica currently leads.
+func (m *Manager) IsLeader() bool {
+	return m.leading.Load()
+}
+
+// Run campaigns for leadership until the context is canceled.
+func (m *Manager) Run(ctx context.Context) {
+	m.elector.Run(ctx, m.lead)
+}
+
+// lead runs the workers until the context is canceled.
+func (m *Manager) lead(ctx context.Context) {
+	m.term.Lock()
+	defer m.term.Unlock()
+
+	m.mu.Lock()
+	workers := slices.Clone(m.workers)
+	m.mu.Unlock()
+
+	slog.InfoContext(ctx, "acquired leadership, starting workers", "workers", len(workers))
+	m.leading.Store(true)
+
+	var wg sync.WaitGroup
+	for _, w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.run(ctx)
+		}()
+	}
+
+	<-ctx.Done()
+	m.leading.Store(false)
+	slog.InfoContext(ctx, "lost leadership, stopping workers")
+
+	wg.Wait()
+}
@@ -0,0 +1,113 @@
+package leader
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testElector grants leadership when told to.
+type testElector struct {
+	terms chan context.Context
+}
+
+func (e *testElector) Run(ctx context.Context, lead func(ctx context.Context)) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case term := <-e.terms:
+			lead(term)
+		}
+	}
+}
+
+func TestManager(t *testing.T) {
+	elector := &testElector{terms: make(chan context.Context)}
+	manager := NewManager(elector)
+
+	var mu sync.Mutex
+	running := map[string]bool{}
+	worker := func(name string) func(ctx context.Context) {
+		return func(ctx context.Context) {
+			mu.Lock()
+			running[name] = true
+			mu.Unlock()
+
+			<-ctx.Done()
+
+			mu.Lock()
+			running[name] = false
+			mu.Unlock()
+		}
+	}
+	isRunning := func(name string) func() bool {
+		return func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return running[name]
+		}
+	}
+
+	manager.Register("foo", worker("foo"))
+	manager.Register("bar", worker("bar"))
+	require.Equal(t, []string{"foo", "bar"}, manager.Workers())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go manager.Run(ctx)
+
+	require.False(t, manager.IsLeader())
+
+	// Workers start with leadership
+	term, lose := context.WithCancel(ctx)
+	elector.terms <- term
+	require.Eventually(t, isRunning("foo"), time.Second, time.Millisecond)
+	require.Eventually(t, isRunning("bar"), time.Second, time.Millisecond)
+	require.True(t, manager.IsLeader())
+
+	// and stop when it is lost
+	lose()
+	require.Eventually(t, func() bool { return !isRunning("foo")() && !isRunning("bar")() }, time.Second, time.Millisecond)
+	require.Eventually(t, func() bool { return !manager.IsLeader() }, time.Second, time.Millisecond)
+
+	// Leadership can be acquired again
+	term, lose = context.WithCancel(ctx)
+	defer lose()
+	elector.terms <- term
+	require.Eventually(t, isRunning("foo"), time.Second, time.Millisecond)
+	require.True(t, manager.IsLeader())
+}
+
+func TestAlways(t *testing.T) {
+	manager := NewManager(Always{})
+
+	started := make(chan struct{})
+	manager.Register("foo", func(ctx context.Context) {
+		close(started)
+		<-ctx.Done()
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		manager.Run(ctx)
+		close(done)
+	}()
+
+	<-started
+	require.True(t, manager.IsLeader())
+
+	cancel()
+	<-done
+	require.False(t, manager.IsLeader())
+}
+
+func TestLockKey(t *testing.T) {
+	// Replicas agree on the key of a name
+	require.Equal(t, lockKey("foo"), lockKey("foo"))
+	require.NotEqual(t, lockKey("foo"), lockKey("bar"))
+}
@@ -0,0 +1,108 @@
+package leader
+
+import (
+	"context"
+	"hash/fnv"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/database/repository"
+)
+
+// Interval between attempts to take the lock, and between checks that the
+// connection holding it is still alive.
+const advisoryLockRetry = 2 * time.Second
+
+// PostgresElector elects the replica holding a Postgres session-level
+// advisory lock. The lock is released by Postgres when the connection
+// holding it closes, so a replica that dies gives up leadership.
+type PostgresElector struct {
+	pool *pgxpool.Pool
+	key  int64 // Key of the advisory lock
+}
+
+// NewPostgresElector returns a PostgresElector for an election name.
+// Replicas using the same name compete for the same lock.
+func NewPostgresElector(pool *pgxpool.Pool, name string) *PostgresElector {
+	return &PostgresElector{pool: pool, key: lockKey(name)}
+}
+
+// lockKey derives an advisory lock key from an election name.
+func lockKey(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return int64(h.Sum64())
+}
+
+// Run campaigns for the lock until the context is canceled.
+func (e *PostgresElector) Run(ctx context.Context, lead func(ctx context.Context)) {
+	for {
+		err := e.campaign(ctx, lead)
+		if ctx.Err() != nil {
+			return
+		}
+		slog.ErrorContext(ctx, "error holding leader lock", "err", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(advisoryLockRetry):
+		}
+	}
+}
+
+// campaign holds a connection until it takes the lock, then leads until the
+// connection is lost.
+func (e *PostgresElector) campaign(ctx context.Context, lead func(ctx context.Context)) error {
+	pooled, err := e.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	// Closing the connection releases the lock, so it isn't returned to the pool
+	conn := pooled.Hijack()
+	defer conn.Close(context.Background())
+
+	queries := repository.New(conn)
+	ticker := time.NewTicker(advisoryLockRetry)
+	defer ticker.Stop()
+
+	for {
+		acquired, err := queries.TryAdvisoryLock(ctx, e.key)
+		if err != nil {
+			return err
+		}
+		if acquired {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	leadCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		lead(leadCtx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		if err := conn.Ping(ctx); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,116 @@
+// Package logging sets up structured JSON logging. Request-scoped IDs put
+// in a context with WithRequestID, WithUser and WithWorkspace are added to
+// every line logged with that context, as is the context's trace.
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	userKey
+	workspaceKey
+)
+
+// Logged in place of the values of attributes that may hold secrets.
+const redacted = "[REDACTED]"
+
+// Attribute keys that may hold secrets, matched case-insensitively
+// anywhere in the key.
+var secretKeys = []string{"token", "secret", "password", "cookie", "authorization", "session"}
+
+// WithRequestID returns a copy of ctx logging the given request ID.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestID returns the request ID in ctx, or "" if there is none.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// WithUser returns a copy of ctx logging the given user ID.
+func WithUser(ctx context.Context, id int32) context.Context {
+	return context.WithValue(ctx, userKey, id)
+}
+
+// WithWorkspace returns a copy of ctx logging the given workspace ID.
+func WithWorkspace(ctx context.Context, id int32) context.Context {
+	return context.WithValue(ctx, workspaceKey, id)
+}
+
+// Handler is a slog.Handler adding the IDs and trace in a record's context
+// to it.
+type Handler struct {
+	slog.Handler
+}
+
+// NewHandler returns a Handler writing JSON lines at or above level to w.
+// Attributes whose keys suggest secrets are redacted.
+func NewHandler(w io.Writer, level slog.Leveler) *Handler {
+	return &Handler{slog.NewJSONHandler(w, &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: redact,
+	})}
+}
+
+// New returns a logger writing JSON lines at or above level to w.
+func New(w io.Writer, level slog.Leveler) *slog.Logger {
+	return slog.New(NewHandler(w, level))
+}
+
+// Handle adds the IDs in ctx to r and passes it on.
+func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	if id, ok := ctx.Value(userKey).(int32); ok {
+		r.AddAttrs(slog.Any("user", id))
+	}
+	if id, ok := ctx.Value(workspaceKey).(int32); ok {
+		r.AddAttrs(slog.Any("workspace", id))
+	}
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		r.AddAttrs(slog.String("trace_id", span.TraceID().String()), slog.String("span_id", span.SpanID().String()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &Handler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	return &Handler{h.Handler.WithGroup(name)}
+}
+
+// redact replaces the values of attributes that may hold secrets.
+func redact(groups []string, a slog.Attr) slog.Attr {
+	if a.Value.Kind() == slog.KindGroup {
+		return a
+	}
+
+	key := strings.ToLower(a.Key)
+	for _, secret := range secretKeys {
+		if strings.Contains(key, secret) {
+			return slog.String(a.Key, redacted)
+		}
+	}
+	return a
+}
+
+// ParseLevel parses a level name like "debug" or "warn", case-insensitively.
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(s))
+	return level, err
+}
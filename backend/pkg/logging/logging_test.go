@@ -0,0 +1,98 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestHandler(t *testing.T) {
+	tests := []struct {
+		name  string
+		ctx   context.Context
+		attrs []any
+		want  map[string]any
+	}{
+		{
+			name: "no IDs",
+			ctx:  context.Background(),
+			want: map[string]any{},
+		},
+		{
+			name: "IDs from context",
+			ctx:  WithWorkspace(WithUser(WithRequestID(context.Background(), "abc"), 2), 3),
+			want: map[string]any{"request_id": "abc", "user": float64(2), "workspace": float64(3)},
+		},
+		{
+			name: "trace from context",
+			ctx: trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+				TraceID: trace.TraceID{1},
+				SpanID:  trace.SpanID{2},
+			})),
+			want: map[string]any{"trace_id": "01000000000000000000000000000000", "span_id": "0200000000000000"},
+		},
+		{
+			name:  "secrets redacted",
+			ctx:   context.Background(),
+			attrs: []any{"id_token", "eyJ", "Cookie", "session=1", "refreshToken", "x", "email", "a@b.c"},
+			want:  map[string]any{"id_token": redacted, "Cookie": redacted, "refreshToken": redacted, "email": "a@b.c"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			New(&buf, slog.LevelInfo).InfoContext(tt.ctx, "hello", tt.attrs...)
+
+			var line map[string]any
+			require.Nil(t, json.Unmarshal(buf.Bytes(), &line))
+			require.Equal(t, "hello", line["msg"])
+			require.Equal(t, "INFO", line["level"])
+
+			delete(line, "msg")
+			delete(line, "level")
+			delete(line, "time")
+			require.Equal(t, tt.want, line)
+		})
+	}
+}
+
+func TestHandlerLevel(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, slog.LevelWarn)
+
+	logger.Info("dropped")
+	require.Empty(t, buf.String())
+
+	logger.Warn("kept")
+	require.Contains(t, buf.String(), "kept")
+}
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    slog.Level
+		wantErr bool
+	}{
+		{"debug", slog.LevelDebug, false},
+		{"INFO", slog.LevelInfo, false},
+		{"warn", slog.LevelWarn, false},
+		{"error", slog.LevelError, false},
+		{"verbose", 0, true},
+	}
+
+	for _, tt := range tests {
+		level, err := ParseLevel(tt.s)
+		if tt.wantErr {
+			require.NotNil(t, err)
+			continue
+		}
+		require.Nil(t, err)
+		require.Equal(t, tt.want, level)
+	}
+}
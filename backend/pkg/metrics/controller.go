@@ -0,0 +1,117 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/controller"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/controller/spec"
+)
+
+// instrumentedController is a controller.Controller recording the latency
+// and errors of its operations. Watching and leader election run for the
+// life of the server, so they aren't timed.
+type instrumentedController struct {
+	controller.Controller
+}
+
+// InstrumentController wraps c to record the latency and errors of its
+// operations.
+func InstrumentController(c controller.Controller) controller.Controller {
+	return instrumentedController{c}
+}
+
+// observe records an operation that started at start and failed if err
+// isn't nil.
+func observe(operation string, start time.Time, err error) {
+	controllerDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		controllerErrors.WithLabelValues(operation).Inc()
+	}
+}
+
+func (c instrumentedController) GetWorkspacePodStatus(ctx context.Context, workspace spec.Workspace) (status string, err error) {
+	defer func(start time.Time) { observe("get_workspace_pod_status", start, err) }(time.Now())
+	return c.Controller.GetWorkspacePodStatus(ctx, workspace)
+}
+
+func (c instrumentedController) GetWorkspaceVolumeStatus(ctx context.Context, workspace spec.Workspace) (status string, err error) {
+	defer func(start time.Time) { observe("get_workspace_volume_status", start, err) }(time.Now())
+	return c.Controller.GetWorkspaceVolumeStatus(ctx, workspace)
+}
+
+func (c instrumentedController) CreateWorkspacePod(ctx context.Context, workspace spec.Workspace) (err error) {
+	defer func(start time.Time) { observe("create_workspace_pod", start, err) }(time.Now())
+	return c.Controller.CreateWorkspacePod(ctx, workspace)
+}
+
+func (c instrumentedController) CreateWorkspaceVolume(ctx context.Context, workspace spec.Workspace) (err error) {
+	defer func(start time.Time) { observe("create_workspace_volume", start, err) }(time.Now())
+	return c.Controller.CreateWorkspaceVolume(ctx, workspace)
+}
+
+func (c instrumentedController) ResizeWorkspace(ctx context.Context, workspace spec.Workspace) (err error) {
+	defer func(start time.Time) { observe("resize_workspace", start, err) }(time.Now())
+	return c.Controller.ResizeWorkspace(ctx, workspace)
+}
+
+func (c instrumentedController) CloneWorkspaceVolume(ctx context.Context, source spec.Workspace, workspace spec.Workspace) (err error) {
+	defer func(start time.Time) { observe("clone_workspace_volume", start, err) }(time.Now())
+	return c.Controller.CloneWorkspaceVolume(ctx, source, workspace)
+}
+
+func (c instrumentedController) LabelWorkspace(ctx context.Context, workspace spec.Workspace) (err error) {
+	defer func(start time.Time) { observe("label_workspace", start, err) }(time.Now())
+	return c.Controller.LabelWorkspace(ctx, workspace)
+}
+
+func (c instrumentedController) SnapshotWorkspace(ctx context.Context, workspace spec.Workspace, snapshot int32) (s spec.Snapshot, err error) {
+	defer func(start time.Time) { observe("snapshot_workspace", start, err) }(time.Now())
+	return c.Controller.SnapshotWorkspace(ctx, workspace, snapshot)
+}
+
+func (c instrumentedController) RestoreWorkspaceSnapshot(ctx context.Context, workspace spec.Workspace, snapshot int32) (err error) {
+	defer func(start time.Time) { observe("restore_workspace_snapshot", start, err) }(time.Now())
+	return c.Controller.RestoreWorkspaceSnapshot(ctx, workspace, snapshot)
+}
+
+func (c instrumentedController) DeleteWorkspaceSnapshot(ctx context.Context, workspace spec.Workspace, snapshot int32) (err error) {
+	defer func(start time.Time) { observe("delete_workspace_snapshot", start, err) }(time.Now())
+	return c.Controller.DeleteWorkspaceSnapshot(ctx, workspace, snapshot)
+}
+
+func (c instrumentedController) InspectWorkspace(ctx context.Context, workspace spec.Workspace) (state spec.WorkspaceState, err error) {
+	defer func(start time.Time) { observe("inspect_workspace", start, err) }(time.Now())
+	return c.Controller.InspectWorkspace(ctx, workspace)
+}
+
+func (c instrumentedController) ExecWorkspace(ctx context.Context, workspace spec.Workspace, command []string, stdin io.Reader, stdout io.Writer) (err error) {
+	defer func(start time.Time) { observe("exec_workspace", start, err) }(time.Now())
+	return c.Controller.ExecWorkspace(ctx, workspace, command, stdin, stdout)
+}
+
+func (c instrumentedController) DeleteWorkspace(ctx context.Context, workspace spec.Workspace) (err error) {
+	defer func(start time.Time) { observe("delete_workspace", start, err) }(time.Now())
+	return c.Controller.DeleteWorkspace(ctx, workspace)
+}
+
+func (c instrumentedController) EnsureWorkspaceNetworkPolicy(ctx context.Context, workspace spec.Workspace) (err error) {
+	defer func(start time.Time) { observe("ensure_workspace_network_policy", start, err) }(time.Now())
+	return c.Controller.EnsureWorkspaceNetworkPolicy(ctx, workspace)
+}
+
+func (c instrumentedController) EnsureNamespace(ctx context.Context, namespace spec.Namespace) (err error) {
+	defer func(start time.Time) { observe("ensure_namespace", start, err) }(time.Now())
+	return c.Controller.EnsureNamespace(ctx, namespace)
+}
+
+func (c instrumentedController) DeleteUserNamespace(ctx context.Context, user int32) (err error) {
+	defer func(start time.Time) { observe("delete_user_namespace", start, err) }(time.Now())
+	return c.Controller.DeleteUserNamespace(ctx, user)
+}
+
+func (c instrumentedController) DeleteTeamNamespace(ctx context.Context, name string) (err error) {
+	defer func(start time.Time) { observe("delete_team_namespace", start, err) }(time.Now())
+	return c.Controller.DeleteTeamNamespace(ctx, name)
+}
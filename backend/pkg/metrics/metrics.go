@@ -0,0 +1,191 @@
+// Package metrics exposes Prometheus metrics of the server, its database
+// pool, its cluster operations, and the workspaces and sessions it manages.
+package metrics
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Prefix of every metric name.
+const namespace = "web_client"
+
+// Time a scrape waits for the database.
+const scrapeTimeout = 2 * time.Second
+
+// Registry holds the server's metrics, along with Go runtime and process
+// metrics.
+var Registry = prometheus.NewRegistry()
+
+var (
+	// RequestsTotal counts HTTP requests by method, route template, and
+	// status.
+	RequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests handled, by method, route template, and status.",
+	}, []string{"method", "route", "status"})
+
+	// RequestDuration observes the latency of HTTP requests by method,
+	// route template, and status.
+	RequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of HTTP requests, by method, route template, and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	// LoginsTotal counts logins by outcome.
+	LoginsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "logins_total",
+		Help:      "Logins, by outcome.",
+	}, []string{"outcome"})
+
+	controllerDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "controller_operation_duration_seconds",
+		Help:      "Latency of cluster operations, by operation.",
+		Buckets:   []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"operation"})
+
+	controllerErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "controller_operation_errors_total",
+		Help:      "Failed cluster operations, by operation.",
+	}, []string{"operation"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		RequestsTotal,
+		RequestDuration,
+		LoginsTotal,
+		controllerDuration,
+		controllerErrors,
+	)
+}
+
+// Handler serves the metrics in Registry.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// Descriptions of the database pool's statistics.
+var (
+	poolAcquiredConns = poolDesc("acquired_connections", "Connections currently in use.")
+	poolIdleConns     = poolDesc("idle_connections", "Connections currently idle.")
+	poolTotalConns    = poolDesc("total_connections", "Connections currently open.")
+	poolMaxConns      = poolDesc("max_connections", "Most connections the pool opens.")
+	poolAcquires      = poolDesc("acquires_total", "Connections acquired from the pool.")
+	poolEmptyAcquires = poolDesc("empty_acquires_total", "Acquires that had to wait for a connection.")
+	poolCanceled      = poolDesc("canceled_acquires_total", "Acquires canceled before getting a connection.")
+	poolAcquireTime   = poolDesc("acquire_duration_seconds_total", "Time spent acquiring connections.")
+)
+
+func poolDesc(name string, help string) *prometheus.Desc {
+	return prometheus.NewDesc(prometheus.BuildFQName(namespace, "db_pool", name), help, nil, nil)
+}
+
+// PoolCollector reports the statistics of a database pool.
+type PoolCollector struct {
+	pool *pgxpool.Pool
+}
+
+// NewPoolCollector returns a PoolCollector reporting the statistics of
+// pool.
+func NewPoolCollector(pool *pgxpool.Pool) *PoolCollector {
+	return &PoolCollector{pool: pool}
+}
+
+func (c *PoolCollector) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(c, ch)
+}
+
+func (c *PoolCollector) Collect(ch chan<- prometheus.Metric) {
+	stat := c.pool.Stat()
+
+	ch <- prometheus.MustNewConstMetric(poolAcquiredConns, prometheus.GaugeValue, float64(stat.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(poolIdleConns, prometheus.GaugeValue, float64(stat.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(poolTotalConns, prometheus.GaugeValue, float64(stat.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(poolMaxConns, prometheus.GaugeValue, float64(stat.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(poolAcquires, prometheus.CounterValue, float64(stat.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolEmptyAcquires, prometheus.CounterValue, float64(stat.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolCanceled, prometheus.CounterValue, float64(stat.CanceledAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolAcquireTime, prometheus.CounterValue, stat.AcquireDuration().Seconds())
+}
+
+var workspacesDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "", "workspaces"),
+	"Workspaces with pods, by the phase of their pod.",
+	[]string{"phase"}, nil,
+)
+
+// WorkspaceCollector reports the number of workspaces in each phase.
+type WorkspaceCollector struct {
+	counts func() map[string]int
+}
+
+// NewWorkspaceCollector returns a WorkspaceCollector reporting the
+// workspace counts returned by counts, keyed by phase.
+func NewWorkspaceCollector(counts func() map[string]int) *WorkspaceCollector {
+	return &WorkspaceCollector{counts: counts}
+}
+
+func (c *WorkspaceCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- workspacesDesc
+}
+
+func (c *WorkspaceCollector) Collect(ch chan<- prometheus.Metric) {
+	for phase, count := range c.counts() {
+		ch <- prometheus.MustNewConstMetric(workspacesDesc, prometheus.GaugeValue, float64(count), phase)
+	}
+}
+
+var sessionsDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "", "sessions"),
+	"Unexpired sessions in the session store.",
+	nil, nil,
+)
+
+// SessionCounter counts the sessions in the session store.
+type SessionCounter interface {
+	CountSessions(ctx context.Context) (int64, error)
+}
+
+// SessionCollector reports the size of the session store.
+type SessionCollector struct {
+	sessions SessionCounter
+}
+
+// NewSessionCollector returns a SessionCollector reporting the number of
+// sessions counted by sessions.
+func NewSessionCollector(sessions SessionCounter) *SessionCollector {
+	return &SessionCollector{sessions: sessions}
+}
+
+func (c *SessionCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- sessionsDesc
+}
+
+func (c *SessionCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), scrapeTimeout)
+	defer cancel()
+
+	count, err := c.sessions.CountSessions(ctx)
+	if err != nil {
+		slog.Error("error counting sessions", "err", err)
+		ch <- prometheus.NewInvalidMetric(sessionsDesc, err)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(sessionsDesc, prometheus.GaugeValue, float64(count))
+}
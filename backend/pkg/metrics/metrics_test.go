@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/controller"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/controller/spec"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeController fails to create pods and succeeds at everything else it
+// implements.
+type fakeController struct {
+	controller.Controller
+}
+
+func (fakeController) CreateWorkspacePod(ctx context.Context, workspace spec.Workspace) error {
+	return errors.New("quota exceeded")
+}
+
+func (fakeController) CreateWorkspaceVolume(ctx context.Context, workspace spec.Workspace) error {
+	return nil
+}
+
+func TestInstrumentController(t *testing.T) {
+	c := InstrumentController(fakeController{})
+
+	require.Nil(t, c.CreateWorkspaceVolume(context.Background(), spec.Workspace{}))
+	require.NotNil(t, c.CreateWorkspacePod(context.Background(), spec.Workspace{}))
+	require.NotNil(t, c.CreateWorkspacePod(context.Background(), spec.Workspace{}))
+
+	require.Equal(t, 2, testutil.CollectAndCount(controllerDuration, namespace+"_controller_operation_duration_seconds"))
+	require.Equal(t, float64(2), testutil.ToFloat64(controllerErrors.WithLabelValues("create_workspace_pod")))
+	require.Equal(t, float64(0), testutil.ToFloat64(controllerErrors.WithLabelValues("create_workspace_volume")))
+}
+
+func TestWorkspaceCollector(t *testing.T) {
+	collector := NewWorkspaceCollector(func() map[string]int {
+		return map[string]int{"Running": 3, "Failed": 1}
+	})
+
+	want := `
+# HELP web_client_workspaces Workspaces with pods, by the phase of their pod.
+# TYPE web_client_workspaces gauge
+web_client_workspaces{phase="Failed"} 1
+web_client_workspaces{phase="Running"} 3
+`
+	require.Nil(t, testutil.CollectAndCompare(collector, strings.NewReader(want)))
+}
+
+// fakeSessions counts a fixed number of sessions, or fails.
+type fakeSessions struct {
+	count int64
+	err   error
+}
+
+func (s fakeSessions) CountSessions(ctx context.Context) (int64, error) {
+	return s.count, s.err
+}
+
+func TestSessionCollector(t *testing.T) {
+	want := `
+# HELP web_client_sessions Unexpired sessions in the session store.
+# TYPE web_client_sessions gauge
+web_client_sessions 12
+`
+	require.Nil(t, testutil.CollectAndCompare(NewSessionCollector(fakeSessions{count: 12}), strings.NewReader(want)))
+
+	require.NotNil(t, testutil.CollectAndCompare(NewSessionCollector(fakeSessions{err: errors.New("connection refused")}), strings.NewReader(want)))
+}
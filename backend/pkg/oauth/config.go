@@ -44,7 +44,7 @@ func NewConfigAndProviderFromEnv() (*oauth2.Config, *oidc.Provider, error) {
 		RedirectURL:  callback,
 		ClientID:     clientId,
 		ClientSecret: clientSecret,
-		Scopes:       []string{oidc.ScopeOpenID, "email"}, // "openid" is a required scope for OpenID Connect flows
+		Scopes:       []string{oidc.ScopeOpenID, "email", "profile"}, // "openid" is a required scope for OpenID Connect flows
 		Endpoint:     provider.Endpoint(),
 	}
 
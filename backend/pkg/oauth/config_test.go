@@ -16,7 +16,7 @@ func TestNewConfigAndProviderFromEnv(t *testing.T) {
 		RedirectURL:  "https://foo.com/callback",
 		ClientID:     "oidc12345",
 		ClientSecret: "secret123",
-		Scopes:       []string{oidc.ScopeOpenID, "email"},
+		Scopes:       []string{oidc.ScopeOpenID, "email", "profile"},
 		Endpoint:     normalProvider.Endpoint(),
 	}
 
@@ -0,0 +1,44 @@
+package oauth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/alexliesenfeld/health"
+	"github.com/coreos/go-oidc/v3/oidc"
+)
+
+// HealthCheck checks that the OIDC provider's discovery document is
+// reachable, since users can't log in without it.
+func HealthCheck(provider *oidc.Provider) health.Check {
+	var discovery struct {
+		Issuer string `json:"issuer"`
+	}
+	provider.Claims(&discovery)
+	url := strings.TrimSuffix(discovery.Issuer, "/") + "/.well-known/openid-configuration"
+
+	return health.Check{
+		Name:    "oidc",
+		Timeout: 2 * time.Second,
+		Check: func(ctx context.Context) error {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+			if err != nil {
+				return err
+			}
+
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				return fmt.Errorf("unable to reach OIDC discovery: %v", err)
+			}
+			defer res.Body.Close()
+
+			if res.StatusCode != http.StatusOK {
+				return fmt.Errorf("OIDC discovery returned %v", res.Status)
+			}
+			return nil
+		},
+	}
+}
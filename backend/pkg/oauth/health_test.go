@@ -0,0 +1,37 @@
+package oauth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthCheck(t *testing.T) {
+	var up atomic.Bool
+	up.Store(true)
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !up.Load() || r.URL.Path != "/.well-known/openid-configuration" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintf(w, `{"issuer": %q, "authorization_endpoint": %q, "token_endpoint": %q, "jwks_uri": %q}`,
+			srv.URL, srv.URL+"/auth", srv.URL+"/token", srv.URL+"/keys")
+	}))
+	defer srv.Close()
+
+	provider, err := oidc.NewProvider(context.Background(), srv.URL)
+	require.Nil(t, err)
+	check := HealthCheck(provider)
+
+	require.Nil(t, check.Check(context.Background()))
+
+	up.Store(false)
+	require.NotNil(t, check.Check(context.Background()))
+}
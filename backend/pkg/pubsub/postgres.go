@@ -0,0 +1,253 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/johngerving/kubernetes-web-client/backend/pkg/database/repository"
+)
+
+// Largest payload sent in a NOTIFY. Postgres rejects payloads of 8000 bytes
+// or more; larger ones are stored in pubsub_payloads and referenced by ID.
+const maxNotifyPayload = 7900
+
+// Prefixes telling inline payloads from references to stored ones.
+const (
+	inlinePrefix = "="
+	storedPrefix = "@"
+)
+
+// Time stored payloads are kept for replicas to read them.
+const payloadRetention = time.Hour
+
+// Delays before listening again after losing the connection.
+const (
+	minReconnectDelay = 100 * time.Millisecond
+	maxReconnectDelay = 30 * time.Second
+)
+
+// Postgres is a Bus using Postgres LISTEN/NOTIFY. Subscriptions only
+// receive messages while Run is running.
+type Postgres struct {
+	pool       *pgxpool.Pool
+	repository *repository.Queries
+	subs       subscriptions
+
+	mu     sync.Mutex
+	wake   context.CancelFunc // Interrupts the wait for notifications so new channels are listened to
+	listen map[string]bool    // Channels listened to on the current connection
+}
+
+// NewPostgres returns a Postgres bus using a connection pool.
+func NewPostgres(pool *pgxpool.Pool) *Postgres {
+	return &Postgres{
+		pool:       pool,
+		repository: repository.New(pool),
+	}
+}
+
+// Publish notifies a channel's listeners on every replica. Payloads too
+// large for a NOTIFY are stored and their ID is sent instead.
+func (p *Postgres) Publish(ctx context.Context, channel string, payload []byte) error {
+	message := inlinePrefix + string(payload)
+
+	if len(message) > maxNotifyPayload {
+		id, err := p.repository.CreatePubsubPayload(ctx, repository.CreatePubsubPayloadParams{
+			Channel: channel,
+			Payload: string(payload),
+		})
+		if err != nil {
+			return fmt.Errorf("unable to store message on %v: %v", channel, err)
+		}
+		message = storedPrefix + strconv.FormatInt(id, 10)
+	}
+
+	err := p.repository.Notify(ctx, repository.NotifyParams{Channel: channel, Payload: message})
+	if err != nil {
+		return fmt.Errorf("unable to publish message on %v: %v", channel, err)
+	}
+
+	return nil
+}
+
+// Subscribe calls handler with every payload published on a channel. The
+// channel is listened to on the current connection and every connection
+// after it.
+func (p *Postgres) Subscribe(channel string, handler Handler) func() {
+	first, remove := p.subs.add(channel, handler)
+
+	if first {
+		p.mu.Lock()
+		if p.wake != nil {
+			p.wake()
+		}
+		p.mu.Unlock()
+	}
+
+	return remove
+}
+
+// Run listens for notifications until the context is canceled, reconnecting
+// with exponential backoff and listening to every subscribed channel again
+// if the connection is lost.
+func (p *Postgres) Run(ctx context.Context) {
+	delay := minReconnectDelay
+	for {
+		start := time.Now()
+		err := p.run(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		slog.ErrorContext(ctx, "error listening for notifications", "err", err)
+
+		// Start over from the shortest delay once a connection has lasted
+		if time.Since(start) > maxReconnectDelay {
+			delay = minReconnectDelay
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxReconnectDelay)
+	}
+}
+
+// run holds a connection listening for notifications until an error occurs.
+func (p *Postgres) run(ctx context.Context) error {
+	pooled, err := p.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	// The connection may be listening to channels, so it isn't returned to the pool
+	conn := pooled.Hijack()
+	defer conn.Close(context.Background())
+
+	p.mu.Lock()
+	p.listen = make(map[string]bool)
+	p.mu.Unlock()
+
+	for {
+		if err := p.listenAll(ctx, conn); err != nil {
+			return err
+		}
+
+		waitCtx, wake := context.WithCancel(ctx)
+		p.mu.Lock()
+		p.wake = wake
+		p.mu.Unlock()
+
+		// Catch channels subscribed to before wake was set
+		if len(p.unlistened()) > 0 {
+			wake()
+		}
+
+		notification, err := conn.WaitForNotification(waitCtx)
+		wake()
+
+		if err != nil {
+			if ctx.Err() == nil && waitCtx.Err() != nil {
+				// Woken up to listen to a new channel
+				continue
+			}
+			return err
+		}
+
+		p.deliver(ctx, notification.Channel, notification.Payload)
+	}
+}
+
+// unlistened returns the subscribed channels not listened to yet.
+func (p *Postgres) unlistened() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var channels []string
+	for _, channel := range p.subs.channels() {
+		if !p.listen[channel] {
+			channels = append(channels, channel)
+		}
+	}
+	return channels
+}
+
+// listenAll listens to every subscribed channel not listened to yet.
+func (p *Postgres) listenAll(ctx context.Context, conn *pgx.Conn) error {
+	for _, channel := range p.unlistened() {
+		if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+			return fmt.Errorf("unable to listen to %v: %v", channel, err)
+		}
+
+		p.mu.Lock()
+		p.listen[channel] = true
+		p.mu.Unlock()
+	}
+	return nil
+}
+
+// deliver passes a notification's payload to the handlers of its channel,
+// reading it from pubsub_payloads if it was stored.
+func (p *Postgres) deliver(ctx context.Context, channel string, message string) {
+	payload, err := p.payload(ctx, message)
+	if err != nil {
+		slog.Error("invalid message", "channel", channel, "err", err)
+		return
+	}
+
+	p.subs.dispatch(channel, payload)
+}
+
+// payload returns the payload a notification carries or references.
+func (p *Postgres) payload(ctx context.Context, message string) ([]byte, error) {
+	if payload, ok := strings.CutPrefix(message, inlinePrefix); ok {
+		return []byte(payload), nil
+	}
+
+	ref, ok := strings.CutPrefix(message, storedPrefix)
+	if !ok {
+		return nil, errors.New("unknown message format")
+	}
+
+	id, err := strconv.ParseInt(ref, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid payload ID %q: %v", ref, err)
+	}
+
+	payload, err := p.repository.FindPubsubPayload(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read payload %v: %v", id, err)
+	}
+
+	return []byte(payload), nil
+}
+
+// RemoveExpiredPayloads periodically deletes stored payloads every replica
+// has had time to read, until the context is canceled. It only needs to run
+// on one replica.
+func (p *Postgres) RemoveExpiredPayloads(ctx context.Context) {
+	ticker := time.NewTicker(payloadRetention / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		expiry := pgtype.Timestamptz{Time: time.Now().Add(-payloadRetention), Valid: true}
+		if err := p.repository.DeleteExpiredPubsubPayloads(ctx, expiry); err != nil {
+			slog.ErrorContext(ctx, "error removing expired message payloads", "err", err)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+// Package pubsub sends messages between API replicas.
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+)
+
+// Handler receives the payloads of messages published on a channel.
+type Handler func(payload []byte)
+
+// Bus delivers messages to the subscribers of every API replica, including
+// the replica publishing them.
+type Bus interface {
+	// Publish sends a payload to the subscribers of a channel.
+	Publish(ctx context.Context, channel string, payload []byte) error
+	// Subscribe calls handler with every payload published on a channel until
+	// the returned function is called.
+	Subscribe(channel string, handler Handler) (unsubscribe func())
+}
+
+// Topic is a channel carrying messages of type T, encoded as JSON.
+type Topic[T any] struct {
+	Channel string
+}
+
+// NewTopic returns a Topic on a channel.
+func NewTopic[T any](channel string) Topic[T] {
+	return Topic[T]{Channel: channel}
+}
+
+// Publish sends a message to the topic's subscribers.
+func (t Topic[T]) Publish(ctx context.Context, bus Bus, message T) error {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("unable to encode message on %v: %v", t.Channel, err)
+	}
+
+	return bus.Publish(ctx, t.Channel, payload)
+}
+
+// Subscribe calls handler with every message published on the topic until
+// the returned function is called. Messages that can't be decoded are
+// logged and dropped.
+func (t Topic[T]) Subscribe(bus Bus, handler func(message T)) (unsubscribe func()) {
+	return bus.Subscribe(t.Channel, func(payload []byte) {
+		var message T
+		if err := json.Unmarshal(payload, &message); err != nil {
+			slog.Error("invalid message", "channel", t.Channel, "err", err)
+			return
+		}
+		handler(message)
+	})
+}
+
+// subscriptions holds the handlers of each channel.
+type subscriptions struct {
+	mu       sync.Mutex
+	next     int
+	handlers map[string]map[int]Handler
+}
+
+// add registers a handler, returning whether it is the channel's first and
+// a function removing it.
+func (s *subscriptions) add(channel string, handler Handler) (first bool, remove func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.handlers == nil {
+		s.handlers = make(map[string]map[int]Handler)
+	}
+	if s.handlers[channel] == nil {
+		s.handlers[channel] = make(map[int]Handler)
+		first = true
+	}
+
+	id := s.next
+	s.next++
+	s.handlers[channel][id] = handler
+
+	return first, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		delete(s.handlers[channel], id)
+		if len(s.handlers[channel]) == 0 {
+			delete(s.handlers, channel)
+		}
+	}
+}
+
+// channels returns the channels with at least one handler.
+func (s *subscriptions) channels() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	channels := make([]string, 0, len(s.handlers))
+	for channel := range s.handlers {
+		channels = append(channels, channel)
+	}
+	return channels
+}
+
+// dispatch calls the handlers of a channel with a payload.
+func (s *subscriptions) dispatch(channel string, payload []byte) {
+	s.mu.Lock()
+	handlers := make([]Handler, 0, len(s.handlers[channel]))
+	for _, handler := range s.handlers[channel] {
+		handlers = append(handlers, handler)
+	}
+	s.mu.Unlock()
+
+	for _, handler := range handlers {
+		handler(payload)
+	}
+}
+
+// Memory is a Bus delivering messages within a single process, for tests
+// and single-replica deployments.
+type Memory struct {
+	subs subscriptions
+}
+
+// NewMemory returns an in-memory Bus.
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+// Publish calls the channel's handlers before returning.
+func (m *Memory) Publish(ctx context.Context, channel string, payload []byte) error {
+	m.subs.dispatch(channel, payload)
+	return nil
+}
+
+// Subscribe calls handler with every payload published on a channel.
+func (m *Memory) Subscribe(channel string, handler Handler) func() {
+	_, remove := m.subs.add(channel, handler)
+	return remove
+}
@@ -0,0 +1,88 @@
+package pubsub
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type message struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+func TestTopic(t *testing.T) {
+	bus := NewMemory()
+	topic := NewTopic[message]("messages")
+
+	var received []message
+	unsubscribe := topic.Subscribe(bus, func(m message) {
+		received = append(received, m)
+	})
+
+	// Other channels aren't delivered
+	var other int
+	NewTopic[message]("other").Subscribe(bus, func(m message) { other++ })
+
+	require.Nil(t, topic.Publish(context.Background(), bus, message{"foo", 1}))
+	require.Nil(t, topic.Publish(context.Background(), bus, message{"bar", 2}))
+	require.Equal(t, []message{{"foo", 1}, {"bar", 2}}, received)
+	require.Equal(t, 0, other)
+
+	// Payloads that aren't messages are dropped
+	require.Nil(t, bus.Publish(context.Background(), "messages", []byte("not json")))
+	require.Len(t, received, 2)
+
+	unsubscribe()
+	require.Nil(t, topic.Publish(context.Background(), bus, message{"baz", 3}))
+	require.Len(t, received, 2)
+}
+
+func TestSubscriptions(t *testing.T) {
+	var subs subscriptions
+
+	first, removeA := subs.add("a", func([]byte) {})
+	require.True(t, first)
+	first, removeB := subs.add("a", func([]byte) {})
+	require.False(t, first)
+	require.Equal(t, []string{"a"}, subs.channels())
+
+	// A channel is dropped with its last handler
+	removeA()
+	require.Equal(t, []string{"a"}, subs.channels())
+	removeB()
+	require.Empty(t, subs.channels())
+}
+
+func TestPostgresPayload(t *testing.T) {
+	p := &Postgres{}
+
+	tests := []struct {
+		description string // Test description
+		message     string // Payload of the notification
+		want        string // Payload delivered
+		wantErr     bool
+	}{
+		{"Inline payload", `={"name":"foo"}`, `{"name":"foo"}`, false},
+		{"Empty payload", "=", "", false},
+		{"Invalid payload ID", "@foo", "", true},
+		{"Unknown format", `{"name":"foo"}`, "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			have, err := p.payload(context.Background(), test.message)
+			if test.wantErr {
+				require.NotNil(t, err)
+				return
+			}
+			require.Nil(t, err)
+			require.Equal(t, test.want, string(have))
+		})
+	}
+
+	// Payloads at the limit still fit in a NOTIFY
+	require.LessOrEqual(t, len(inlinePrefix+strings.Repeat("a", maxNotifyPayload-1)), maxNotifyPayload)
+}
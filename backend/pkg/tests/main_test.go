@@ -67,7 +67,7 @@ func TestGETHealth(t *testing.T) {
 }
 
 func TestAuthEndpoints(t *testing.T) {
-	tests := []string{"/user"}
+	tests := []string{"/api/v1/user"}
 	// tests := struct{}
 
 	godotenv.Load(".backend.env")
@@ -95,13 +95,14 @@ func TestAuthEndpoints(t *testing.T) {
 			}
 
 			// Unmarshal response
-			var data map[string]string
+			var data map[string]any
 			err = json.Unmarshal([]byte(body), &data)
 			if err != nil {
 				t.Fatalf("unable to unmarshal request response: %v", err)
 			}
 
-			require.Equal(t, "unauthorized", data["message"], "Response 'message' field should be 'unauthorized'.")
+			require.Equal(t, "application/problem+json", res.Header.Get("Content-Type"), "Response should be a problem.")
+			require.Equal(t, "unauthorized", data["code"], "Response 'code' field should be 'unauthorized'.")
 		})
 	}
 }
@@ -129,7 +130,7 @@ func TestGETUser(t *testing.T) {
 
 		// Make a GET request to the /user endpoint and populate a User struct
 		var haveUser repository.User
-		statusCode, err := doJSONRequest(client, "GET", apiUrl+"/user", "", &haveUser)
+		statusCode, err := doJSONRequest(client, "GET", apiUrl+"/api/v1/user", "", &haveUser)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -168,13 +169,16 @@ func TestPOSTWorkspace(t *testing.T) {
 		}
 
 		// Make a request to the user/workspaces endpoint, with the session cookie in the request
-		var haveWorkspace repository.Workspace
-		statusCode, err := doJSONRequest(client, "POST", apiUrl+"/user/workspaces", `{"name": "test"}`, &haveWorkspace)
+		var haveWorkspace struct {
+			ID   int32  `json:"id"`
+			Name string `json:"name"`
+		}
+		statusCode, err := doJSONRequest(client, "POST", apiUrl+"/api/v1/user/workspaces", `{"name": "test"}`, &haveWorkspace)
 		if err != nil {
 			t.Fatal(err)
 		}
 
-		require.Equal(t, http.StatusOK, statusCode, "Workspace data response status code should be 200.")
+		require.Equal(t, http.StatusAccepted, statusCode, "Workspace data response status code should be 202.")
 		require.Equal(t, "test", haveWorkspace.Name, "User data response 'email' field should be 'test'.")
 
 		// Check if workspace exists in database
@@ -235,43 +239,47 @@ func TestGETWorkspaces(t *testing.T) {
 		// Make requests to the user/workspaces endpoint to populate the users' workspaces
 		resp, err := clients[0].R().
 			SetBody(`{"name": "user1workspace"}`).
-			Post(apiUrl + "/user/workspaces")
+			Post(apiUrl + "/api/v1/user/workspaces")
 		require.Equal(t, nil, err)
-		require.Equal(t, http.StatusOK, resp.StatusCode())
+		require.Equal(t, http.StatusAccepted, resp.StatusCode())
 
-		_, err = clients[1].R().
+		resp2, err := clients[1].R().
 			SetBody(`{"name": "user2workspace1"}`).
-			Post(apiUrl + "/user/workspaces")
+			Post(apiUrl + "/api/v1/user/workspaces")
 		require.Equal(t, nil, err)
-		require.Equal(t, http.StatusOK, resp.StatusCode())
+		require.Equal(t, http.StatusAccepted, resp2.StatusCode())
 
-		_, err = clients[1].R().
+		resp3, err := clients[1].R().
 			SetBody(`{"name": "user2workspace2"}`).
-			Post(apiUrl + "/user/workspaces")
+			Post(apiUrl + "/api/v1/user/workspaces")
 		require.Equal(t, nil, err)
-		require.Equal(t, http.StatusOK, resp.StatusCode())
+		require.Equal(t, http.StatusAccepted, resp3.StatusCode())
 
 		/******* Test Get Requests *******/
-		var body []map[string]string
+		var body struct {
+			Workspaces []struct {
+				Name string `json:"name"`
+			} `json:"workspaces"`
+		}
 
 		resp, err = clients[0].R().
-			Get(apiUrl + "/user/workspaces")
+			Get(apiUrl + "/api/v1/user/workspaces")
 		require.Equal(t, nil, err)
 		require.Equal(t, http.StatusOK, resp.StatusCode())
 
 		json.Unmarshal(resp.Body(), &body)
-		require.Equal(t, 1, len(body), "User 1 should have 1 workspace")
-		require.Equal(t, "user1workspace", body[0]["name"], "User 1 should be able to access workspace they created")
+		require.Equal(t, 1, len(body.Workspaces), "User 1 should have 1 workspace")
+		require.Equal(t, "user1workspace", body.Workspaces[0].Name, "User 1 should be able to access workspace they created")
 
 		resp, err = clients[1].R().
-			Get(apiUrl + "/user/workspaces")
+			Get(apiUrl + "/api/v1/user/workspaces?sort=-name")
 		require.Equal(t, nil, err)
 		require.Equal(t, http.StatusOK, resp.StatusCode())
 
 		json.Unmarshal(resp.Body(), &body)
-		require.Equal(t, 2, len(body), "User 2 should have 2 workspaces")
-		require.Equal(t, "user2workspace1", body[0]["name"])
-		require.Equal(t, "user2workspace2", body[1]["name"])
+		require.Equal(t, 2, len(body.Workspaces), "User 2 should have 2 workspaces")
+		require.Equal(t, "user2workspace2", body.Workspaces[0].Name)
+		require.Equal(t, "user2workspace1", body.Workspaces[1].Name)
 
 		// Clear the table once done
 		_, err = pool.Exec(context.Background(), "TRUNCATE TABLE sessions, users, workspaces CASCADE")
@@ -339,7 +347,7 @@ func loginUser(pool *pgxpool.Pool, domain string, email string) (*http.Client, e
 
 	var userId int32
 	// Insert user into database
-	err = pool.QueryRow(context.Background(), "INSERT INTO users (email) VALUES ($1) RETURNING id", wantUser.Email).Scan(&userId)
+	err = pool.QueryRow(context.Background(), "INSERT INTO users (issuer, subject, email) VALUES ($1, $2, $3) RETURNING id", "test", wantUser.Email, wantUser.Email).Scan(&userId)
 	if err != nil {
 		return nil, err
 	}
@@ -71,6 +71,7 @@ spec:
     metadata:
       labels:
         k8s-app: api
+        kubernetes-web-client/component: api
     spec:
       containers:
       - name: api
@@ -0,0 +1,72 @@
+// Package tracing sets up OpenTelemetry tracing. Spans are exported with
+// OTLP, printed to stdout for local use, or dropped, as chosen by the
+// standard OTEL_TRACES_EXPORTER environment variable. W3C trace context is
+// propagated whether or not spans are exported.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// ServiceName names the server in traces unless OTEL_SERVICE_NAME is set.
+const ServiceName = "kubernetes-web-client-api"
+
+// Exporters that may be chosen with OTEL_TRACES_EXPORTER.
+const (
+	ExporterNone   = "none"
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+)
+
+// NewProviderFromEnv installs a global tracer provider exporting spans
+// with the exporter named by OTEL_TRACES_EXPORTER, and the W3C trace
+// context propagator. OTLP exporters are configured by the standard
+// OTEL_EXPORTER_OTLP_* environment variables. The returned function
+// flushes and stops the exporter.
+func NewProviderFromEnv(ctx context.Context) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch name := strings.ToLower(os.Getenv("OTEL_TRACES_EXPORTER")); name {
+	case "", ExporterNone:
+		// Keep the default no-op provider
+		return func(context.Context) error { return nil }, nil
+	case ExporterOTLP:
+		exporter, err = otlptracegrpc.New(ctx)
+	case ExporterStdout:
+		exporter, err = stdouttrace.New()
+	default:
+		return nil, fmt.Errorf("traces exporter must be none, otlp, or stdout, not %v", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to create traces exporter: %v", err)
+	}
+
+	res, err := resource.Merge(
+		resource.NewSchemaless(semconv.ServiceName(ServiceName)),
+		resource.Environment(), // OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES take precedence
+	)
+	if err != nil {
+		return nil, fmt.Errorf("unable to describe traced resource: %v", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
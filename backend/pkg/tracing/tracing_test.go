@@ -0,0 +1,43 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+func TestNewProviderFromEnv(t *testing.T) {
+	tests := []struct {
+		exporter string
+		wantSDK  bool
+		wantErr  bool
+	}{
+		{"", false, false},
+		{"none", false, false},
+		{"stdout", true, false},
+		{"OTLP", true, false},
+		{"zipkin", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.exporter, func(t *testing.T) {
+			defer otel.SetTracerProvider(otel.GetTracerProvider())
+			t.Setenv("OTEL_TRACES_EXPORTER", tt.exporter)
+
+			shutdown, err := NewProviderFromEnv(context.Background())
+			if tt.wantErr {
+				require.NotNil(t, err)
+				return
+			}
+			require.Nil(t, err)
+			defer shutdown(context.Background())
+
+			_, isSDK := otel.GetTracerProvider().(*sdktrace.TracerProvider)
+			require.Equal(t, tt.wantSDK, isSDK)
+			require.ElementsMatch(t, []string{"traceparent", "tracestate", "baggage"}, otel.GetTextMapPropagator().Fields())
+		})
+	}
+}
@@ -3,7 +3,9 @@
 declare global {
 	namespace App {
 		// interface Error {}
-		// interface Locals {}
+		interface Locals {
+			trace: import('$lib/server/tracing').TraceContext;
+		}
 		// interface PageData {}
 		// interface PageState {}
 		// interface Platform {}
@@ -1,4 +1,13 @@
 import { env } from "$env/dynamic/public"
+import { parseTraceparent, traceparent } from "$lib/server/tracing.js"
+
+/** @type {import('@sveltejs/kit').Handle} */
+export async function handle({ event, resolve }) {
+    // Continue the browser's trace, if it sent one, in requests to the backend
+    event.locals.trace = parseTraceparent(event.request.headers.get('traceparent'))
+
+    return resolve(event)
+}
 
 /** @type {import('@sveltejs/kit').HandleFetch} */
 export async function handleFetch({ event, request, fetch }) {
@@ -16,6 +25,8 @@ export async function handleFetch({ event, request, fetch }) {
                 .join('; ')
         );
 
+        // Propagate W3C trace context
+        request.headers.set('traceparent', traceparent(event.locals.trace))
     }
     return fetch(request);
-}
\ No newline at end of file
+}
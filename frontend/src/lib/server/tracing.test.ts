@@ -0,0 +1,27 @@
+import { describe, it, expect } from 'vitest';
+import { parseTraceparent, traceparent } from './tracing';
+
+describe('parseTraceparent', () => {
+    it('continues an incoming trace', () => {
+        const trace = parseTraceparent('00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01');
+        expect(trace).toEqual({ traceId: '4bf92f3577b34da6a3ce929d0e0e4736', flags: '01' });
+    });
+
+    it('starts a trace without a valid header', () => {
+        for (const header of [null, 'garbage', '00-00000000000000000000000000000000-00f067aa0ba902b7-01']) {
+            const trace = parseTraceparent(header);
+            expect(trace.traceId).toMatch(/^[0-9a-f]{32}$/);
+            expect(trace.traceId).not.toMatch(/^0+$/);
+            expect(trace.flags).toBe('01');
+        }
+    });
+});
+
+describe('traceparent', () => {
+    it('keeps the trace with a new span', () => {
+        const trace = { traceId: '4bf92f3577b34da6a3ce929d0e0e4736', flags: '01' };
+        const header = traceparent(trace);
+        expect(header).toMatch(/^00-4bf92f3577b34da6a3ce929d0e0e4736-[0-9a-f]{16}-01$/);
+        expect(header).not.toBe(traceparent(trace));
+    });
+});
@@ -0,0 +1,30 @@
+// W3C trace context (https://www.w3.org/TR/trace-context/) for requests to
+// the backend, so that its traces start at the page load that caused them.
+
+const traceparentPattern = /^00-([0-9a-f]{32})-([0-9a-f]{16})-([0-9a-f]{2})$/;
+
+export type TraceContext = {
+    traceId : string,
+    flags : string,
+}
+
+function randomHex(bytes: number): string {
+    const b = crypto.getRandomValues(new Uint8Array(bytes));
+    return Array.from(b, (x) => x.toString(16).padStart(2, '0')).join('');
+}
+
+// parseTraceparent continues the trace in an incoming traceparent header, or
+// starts a new sampled trace if there is none or it is invalid.
+export function parseTraceparent(header: string | null): TraceContext {
+    const match = header?.match(traceparentPattern);
+    if (match && !/^0+$/.test(match[1]) && !/^0+$/.test(match[2])) {
+        return { traceId: match[1], flags: match[3] };
+    }
+    return { traceId: randomHex(16), flags: '01' };
+}
+
+// traceparent returns the header of an outgoing request in a trace, with a
+// new ID standing in for the request's span.
+export function traceparent(trace: TraceContext): string {
+    return `00-${trace.traceId}-${randomHex(8)}-${trace.flags}`;
+}
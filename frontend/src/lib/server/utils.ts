@@ -2,20 +2,28 @@ import { env } from "$env/dynamic/public";
 import { error } from "@sveltejs/kit";
 
 export async function getUserWorkspaces(fetch: (input: RequestInfo | URL, init?: RequestInit) => Promise<Response>): Promise<Workspace[]|never> {
-    // Use fetch function passed from form
-    const response = await fetch(`${env.PUBLIC_API_CLUSTER_URL}/user/workspaces`)
+    let workspaces: Workspace[] = [];
 
-    // If there was an error, return a rejected promise
-    if (!response.ok) {
-        const promise = Promise.reject(new Error("unable to retrieve workspaces"));
-        return promise;
-    }
+    // Follow the pages of workspaces until the last one
+    let cursor: string | null = null;
+    do {
+        const params = new URLSearchParams({ limit: "200" });
+        if (cursor != null)
+            params.set("cursor", cursor);
 
-    const json = await response.json();
+        // Use fetch function passed from form
+        const response = await fetch(`${env.PUBLIC_API_CLUSTER_URL}/api/v1/user/workspaces?${params}`)
 
-    let workspaces: Workspace[] = [];
-    if(json != null)
-        workspaces = json;
+        // If there was an error, return a rejected promise
+        if (!response.ok) {
+            const promise = Promise.reject(new Error("unable to retrieve workspaces"));
+            return promise;
+        }
+
+        const page: WorkspacePage = await response.json();
+        workspaces = workspaces.concat(page.workspaces);
+        cursor = page.next_cursor;
+    } while (cursor != null);
 
     return workspaces;
-}
\ No newline at end of file
+}
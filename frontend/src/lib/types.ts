@@ -1,12 +1,124 @@
 type User = {
     id : number,
     email : string,
+    name : string,
+    picture : string,
+    admin : boolean,
 }
 
 type Workspace = {
     id : number,
     name : string,
     owner : number,
+    team : number | null,
+    template : number,
+    description : string,
+    labels : Record<string, string>,
+    resources : WorkspaceResources,
+    state : "creating" | "ready" | "resizing" | "cloning" | "restoring" | "importing" | "failed",
+    state_message : string,
+    source : number | null,
+    created_at : string,
+    last_active_at : string,
+    role : "viewer" | "editor" | "owner",
+    shared : boolean,
+}
+
+type WorkspacePage = {
+    workspaces : Workspace[],
+    next_cursor : string | null,
+}
+
+type WorkspaceResources = {
+    cpu : string,
+    memory : string,
+    storage : string,
+}
+
+type WorkspaceDetail = Workspace & {
+    status : {
+        phase : string,
+        volume : string,
+        capacity : string,
+        usage : { cpu : string, memory : string } | null,
+    } | null,
+    urls : Record<string, string>,
+}
+
+type Team = {
+    id : number,
+    name : string,
+    namespace : string,
+    max_workspaces : number,
+    cpu_quota : string,
+    memory_quota : string,
+    storage_quota : string,
+    role : "viewer" | "editor" | "owner",
+}
+
+type Template = {
+    id : number,
+    name : string,
+    image : string,
+    egress : "internet" | "internal" | "none",
+    snapshot_policy : SnapshotPolicy | null,
+}
+
+type SnapshotPolicy = {
+    interval : number,
+    retention : number,
+}
+
+type Snapshot = {
+    id : number,
+    workspace : number,
+    name : string,
+    scheduled : boolean,
+    state : "pending" | "ready" | "restoring" | "failed",
+    state_message : string,
+    size : string,
+    created_at : string,
+}
+
+type WorkspaceFile = {
+    name : string,
+    path : string,
+    type : "file" | "directory" | "symlink" | "other",
+    size : number,
+    modified_at : string,
+}
+
+type FileListing = {
+    path : string,
+    files : WorkspaceFile[],
+    truncated : boolean,
+}
+
+type Operation = {
+    id : number,
+    kind : string,
+    status : "pending" | "running" | "succeeded" | "dead",
+    attempts : number,
+    max_attempts : number,
+    last_error : string,
+    created_at : string,
+    updated_at : string,
+}
+
+type WorkspaceEvent = {
+    type : "workspace.created" | "workspace.updated" | "workspace.deleted" | "workspace.status",
+    workspace : number,
+    status? : string,
+}
+
+type Problem = {
+    type : string,
+    title : string,
+    status : number,
+    detail : string,
+    code : string,
+    request_id? : string,
+    errors? : { field : string, message : string }[],
 }
 
 type PostWorkspaceFormErrors = {
@@ -18,7 +18,7 @@ export const actions = {
         
         // Make a POST request to create a new workspace
         const res = await fetch(
-            `${env.PUBLIC_API_CLUSTER_URL}/user/workspaces`,
+            `${env.PUBLIC_API_CLUSTER_URL}/api/v1/user/workspaces`,
             {
                 method: "POST",
                 headers: {
@@ -30,20 +30,21 @@ export const actions = {
             }
         )
 
-        const body = await res.json()
-
         // Throw an error if the response was unsuccessful
-        if(res.status == 400) {
-            if(body.message) {
-                throw new Error(`Response status: ${res.status}\nMessage: ${body.message}`)
+        if(!res.ok) {
+            const problem: Problem = await res.json()
+
+            const name = problem.errors?.find((e) => e.field == "name")
+            if(!name) {
+                throw new Error(`Response status: ${res.status}\nMessage: ${problem.detail}`)
             }
 
             // Send errors and form data to client
             const errors: PostWorkspaceFormErrors = {
-                name: body.name
+                name: name.message
             };
 
-            return fail(400, {
+            return fail(res.status, {
                 name: data.get("name"),
                 errors: errors
             })
@@ -3,7 +3,7 @@ import { redirect } from "@sveltejs/kit"
 import type { LayoutServerLoad } from "./$types.js"
 
 export const load: LayoutServerLoad = async ({ fetch }) => {
-    const response = await fetch(`${env.PUBLIC_API_CLUSTER_URL}/user`)
+    const response = await fetch(`${env.PUBLIC_API_CLUSTER_URL}/api/v1/user`)
 
     // Redirect if not logged in
     if(response.status == 401)